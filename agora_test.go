@@ -1,19 +1,22 @@
 package agora
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/bobg/agora/agoratest"
+	"github.com/bobg/agora/bytecode"
 	"github.com/bobg/agora/compiler"
+	"github.com/bobg/agora/compiler/emitter"
+	"github.com/bobg/agora/compiler/parser"
 	"github.com/bobg/agora/runtime"
 	"github.com/bobg/agora/runtime/stdlib"
 )
@@ -21,13 +24,9 @@ import (
 // This test runs all source files in ../testdata/src/*.agora and checks if
 // the results are as expected.
 //
-// The header of each source code file can define a YAML front-matter block
-// with the following fields:
-// * output: the expected output (may contain \n for newlines)
-// * result: the expected result value
-// * long: if true, this test is skipped if the -short flag is set
-// * args: the command-line arguments to pass to the test file
-// * error: the expected error message (omit if no error is expected)
+// The header of each source code file can define a YAML front-matter block,
+// as described by agoratest.Script. The files with the `long` field are
+// skipped if the -short flag is set.
 
 const (
 	srcDir = "./testdata/src"
@@ -53,25 +52,18 @@ func testFile(t *testing.T, fi os.FileInfo) {
 		panic(e)
 	}
 	defer f.Close()
-	s := bufio.NewScanner(f)
-	m := readFrontMatter(s)
-	if len(m) == 0 {
+	scr, e := agoratest.ReadScript(strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name())), f)
+	if e != nil {
+		panic(e)
+	}
+	if scr == nil {
 		if testing.Verbose() {
 			fmt.Printf("no front matter, skipping file %s...\n", fi.Name())
 		}
 		return
 	}
-	// Keep the rest of the file in a reader
-	buf := bytes.NewBuffer(nil)
-	for s.Scan() {
-		buf.WriteString(s.Text())
-		buf.WriteString("\n")
-	}
-	if s.Err() != nil {
-		panic(s.Err())
-	}
 	// And actually run and test the file
-	if _, ok := m["long"]; ok {
+	if _, ok := scr.FrontMatter["long"]; ok {
 		if testing.Short() {
 			if testing.Verbose() {
 				fmt.Printf("skipping long test file %s...\n", fi.Name())
@@ -82,270 +74,584 @@ func testFile(t *testing.T, fi os.FileInfo) {
 	if testing.Verbose() {
 		fmt.Printf("testing file %s...\n", fi.Name())
 	}
-	runAndAssertFile(t, strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name())), bytes.NewReader(buf.Bytes()), m)
+	ktx := runtime.NewKtx(new(runtime.FileResolver), new(compiler.Compiler))
+	ktx.RegisterNativeModule(new(stdlib.FilepathMod))
+	ktx.RegisterNativeModule(new(stdlib.FmtMod))
+	ktx.RegisterNativeModule(new(stdlib.MathMod))
+	ktx.RegisterNativeModule(new(stdlib.OsMod))
+	ktx.RegisterNativeModule(new(stdlib.StringsMod))
+	ktx.RegisterNativeModule(new(stdlib.TimeMod))
+	for _, d := range scr.Check(context.Background(), ktx) {
+		t.Errorf("[%s] - %s", scr.ID, d)
+	}
 }
 
-type testResolver struct {
-	r  io.Reader
-	mr runtime.ModuleResolver
-}
+type mapResolver map[string]string
 
-func (t *testResolver) Resolve(id string) (io.Reader, error) {
-	if t.r != nil {
-		r := t.r
-		t.r = nil
-		return r, nil
+func (m mapResolver) Resolve(id string) (io.Reader, error) {
+	if src, ok := m[id]; ok {
+		return strings.NewReader(src), nil
 	}
-	return t.mr.Resolve(id)
+	return nil, runtime.NewModuleNotFoundError(id)
 }
 
-func runAndAssertFile(t *testing.T, id string, r io.Reader, m map[string]string) {
+func TestErrorFrames(t *testing.T) {
 	ctx := context.Background()
-
-	// Use the custom test resolver to return the reader
-	buf := bytes.NewBuffer(nil)
-	ktx := runtime.NewKtx(&testResolver{
-		r,
-		new(runtime.FileResolver),
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `a := import("a")
+return a.Run()`,
+		"a": `b := import("b")
+return {Run: func() {
+	return b.Check(-1)
+}}`,
+		"b": `func Check(n) {
+	if n < 0 {
+		panic("negative")
+	}
+	return n
+}
+return {Check: Check}`,
 	}, new(compiler.Compiler))
-	ktx.Stdout = buf
-	ktx.RegisterNativeModule(new(stdlib.FilepathMod))
-	ktx.RegisterNativeModule(new(stdlib.FmtMod))
-	ktx.RegisterNativeModule(new(stdlib.MathMod))
-	ktx.RegisterNativeModule(new(stdlib.OsMod))
-	ktx.RegisterNativeModule(new(stdlib.StringsMod))
-	ktx.RegisterNativeModule(new(stdlib.TimeMod))
 
-	mod, err := ktx.Load(id)
-	var ret runtime.Val
-	if err == nil {
-		var args []runtime.Val
-		if v, ok := m["args"]; ok {
-			s := strings.Split(v, " ")
-			args = make([]runtime.Val, len(s))
-			for i, arg := range s {
-				args[i] = runtime.String(arg)
-			}
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mod.Run(ctx)
+	var e *runtime.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected a *runtime.Error, got %T", err)
+	}
+	if e.Message != "negative" {
+		t.Errorf("expected message `negative`, got `%s`", e.Message)
+	}
+	exp := []runtime.Frame{
+		{Name: "panic"},
+		{Name: "Check", Module: "b", Line: 3},
+		{Module: "a", Line: 3},
+		{Name: "main", Module: "main", Line: 2},
+	}
+	if len(e.Frames) != len(exp) {
+		t.Fatalf("expected %d frames, got %d:\n%s", len(exp), len(e.Frames), e.StackTrace())
+	}
+	for i, f := range exp {
+		if e.Frames[i] != f {
+			t.Errorf("[%d] - expected frame %s, got %s", i, f, e.Frames[i])
 		}
-		ret, err = mod.Run(ctx, args...)
 	}
+}
 
-	assert := false
-	if v, ok := m["error"]; ok {
-		assert = true
-		if err == nil {
-			t.Errorf("[%s] - expected error '%s', got none", id, v)
-		} else if err.Error() != v {
-			t.Errorf("[%s] - expected error '%s', got '%s'", id, v, err)
+func TestCancel(t *testing.T) {
+	cases := map[string]string{
+		"loop": `for {}`,
+		"defer": `func f() {
+	for {}
+}
+defer f()`,
+		"sleep": `time := import("time")
+time.Sleep(60000)`,
+	}
+	for id, src := range cases {
+		ktx := runtime.NewKtx(mapResolver{id: src}, new(compiler.Compiler))
+		ktx.RegisterNativeModule(new(stdlib.TimeMod))
+		mod, err := ktx.Load(id)
+		if err != nil {
+			t.Fatal(err)
 		}
-	} else if err != nil {
-		t.Errorf("[%s] - expected no error, got '%s'", id, err)
-	}
-	if v, ok := m["result"]; ok {
-		assert = true
-		v = strings.Replace(v, "\\n", "\n", -1)
-		v = strings.Replace(v, "\\t", "\t", -1)
-		switch retv := ret.(type) {
-		// compare runtime.Object with special function
-		case runtime.Object:
-			if !objectsAreEqual(retv.String(ctx), v) {
-				str := fmt.Sprintf("%s", retv)
-				t.Errorf("[%s] - expected result '%s', got '%s'", id, v, str)
-			}
-		case runtime.Func:
-			str := fmt.Sprintf("%s", retv)
-			if str != v {
-				t.Errorf("[%s] - expected result '%s', got '%s'", id, v, str)
-			}
-		default:
-			if retv.String(ctx) != v {
-				t.Errorf("[%s] - expected result '%s', got '%s'", id, v, retv)
-			}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err = mod.Run(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("[%s] - expected a deadline exceeded error, got %v", id, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("[%s] - expected to stop on deadline, took %s", id, d)
 		}
 	}
-	if v, ok := m["output"]; ok {
-		assert = true
-		v = strings.Replace(v, "\\n", "\n", -1)
-		v = strings.Replace(v, "\\t", "\t", -1)
-		// compare output with special function
-		if got := buf.String(); !outputIsEqual(got, v) {
-			t.Errorf("[%s] - expected output '%s', got '%s'", id, v, got)
+}
+
+func TestBudgets(t *testing.T) {
+	cases := []struct {
+		src    string
+		set    func(*runtime.Kontext)
+		budget runtime.Budget
+	}{
+		0: {
+			src:    `for {}`,
+			set:    func(k *runtime.Kontext) { k.MaxInstructions = 1000 },
+			budget: runtime.BudgetInstructions,
+		},
+		1: {
+			// The budget error cannot be recovered
+			src: `for {
+	recover(func() {
+		for {}
+	})
+}`,
+			set:    func(k *runtime.Kontext) { k.MaxInstructions = 1000 },
+			budget: runtime.BudgetInstructions,
+		},
+		2: {
+			src: `func f(n) {
+	return f(n + 1)
+}
+f(0)`,
+			set:    func(k *runtime.Kontext) { k.MaxCallDepth = 100 },
+			budget: runtime.BudgetCallDepth,
+		},
+		3: {
+			// The call depth is limited by default
+			src: `func f(n) {
+	return f(n + 1)
+}
+f(0)`,
+			set:    func(k *runtime.Kontext) {},
+			budget: runtime.BudgetCallDepth,
+		},
+		4: {
+			src: `o := {}
+i := 0
+for {
+	o[i] = i
+	i++
+}`,
+			set:    func(k *runtime.Kontext) { k.MaxAllocBytes = 10000 },
+			budget: runtime.BudgetAllocBytes,
+		},
+		5: {
+			src: `s := "a"
+for {
+	s += s
+}`,
+			set:    func(k *runtime.Kontext) { k.MaxAllocBytes = 1 << 20 },
+			budget: runtime.BudgetAllocBytes,
+		},
+	}
+	ctx := context.Background()
+	for i, c := range cases {
+		ktx := runtime.NewKtx(mapResolver{"main": c.src}, new(compiler.Compiler))
+		c.set(ktx)
+		mod, err := ktx.Load("main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = mod.Run(ctx)
+		var be runtime.BudgetExceededError
+		if !errors.As(err, &be) {
+			t.Errorf("[%d] - expected a BudgetExceededError, got %v", i, err)
+			continue
+		}
+		if be.Budget != c.budget {
+			t.Errorf("[%d] - expected budget %s, got %s", i, c.budget, be.Budget)
 		}
 	}
-	if !assert {
-		t.Errorf("[%s] - no assert", id)
-	}
-}
-
-// res = result of script (runtime.Object.String())
-// fmr  = front matter result
-// return: true if equal, otherwise false
-func objectsAreEqual(res string, fmr string) bool {
-	type item struct {
-		key, value string
-	}
-
-	// TODO : should simplify that, sort keys and stringify instead of parsing
-	// because that won't work if values have commas and such.
-	strToObj := func(out string) ([]item, bool) {
-		// out: "{i:i-string,_:_-string,4:4-string}"
-		out = strings.TrimPrefix(out, "{")
-		// out: "i:i-string,_:_-string,4:4-string}"
-		out = strings.TrimSuffix(out, "}")
-		// out: "i:i-string,_:_-string,4:4-string"
-		outs := strings.FieldsFunc(out, func(r rune) bool {
-			return r == ','
-		})
-		// outs: [ "i:i-string", "_:_-string", "4:4-string" ]
-		out_obj := make([]item, 0, len(outs))
-		// fill out_obj
-		for _, o := range outs {
-			// o: "i:i-string"
-			okv := strings.FieldsFunc(o, func(r rune) bool {
-				return r == ':'
-			})
-			// okv: [ "i", "i-string" ]
-			// check okv length
-			if len(okv) != 2 {
-				return nil, false
-			}
-			out_obj = append(out_obj, item{okv[0], okv[1]})
-			// out_obj: [item{"i", "i-string"}, item{"4","4-string"}]
+}
+
+func TestBudgetsPerRun(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"a": `for i := 0; i < 10; i++ {}`,
+		"b": `for i := 0; i < 10; i++ {}`,
+	}, new(compiler.Compiler))
+	ktx.MaxInstructions = 150
+	for _, id := range []string{"a", "b"} {
+		mod, err := ktx.Load(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mod.Run(ctx); err != nil {
+			t.Errorf("[%s] - expected no error, got %v", id, err)
 		}
-		return out_obj, true
 	}
+}
 
-	// front matter
-	fm, ok := strToObj(fmr)
-	if !ok {
-		if testing.Verbose() {
-			fmt.Println("unrecognized front matter object")
+type testGreeter struct {
+	Greeting string `agora:"greeting"`
+}
+
+func (g *testGreeter) Greet(name string, times int) string {
+	return strings.TrimSpace(strings.Repeat(g.Greeting+" "+name+" ", times))
+}
+
+func TestGoModule(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `g := import("greeter")
+return g.greeting + ": " + g.Greet("you", 2)`,
+		"fail": `g := import("greeter")
+return g.Greet("you", "twice")`,
+	}, new(compiler.Compiler))
+	ktx.RegisterNativeModule(runtime.NewGoModule("greeter", &testGreeter{"hi"}))
+
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := mod.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "hi: hi you hi you"; v.String(ctx) != exp {
+		t.Errorf("expected `%s`, got `%s`", exp, v.String(ctx))
+	}
+
+	mod, err = ktx.Load("fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mod.Run(ctx)
+	var ce runtime.ConversionError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a ConversionError, got %v", err)
+	}
+	if ce.Path != "argument 2" {
+		t.Errorf("expected path `argument 2`, got `%s`", ce.Path)
+	}
+}
+
+func TestCall(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"hooks": `count := 0
+func check(n) {
+	if n < 0 {
+		panic("negative")
+	}
+	return n
+}
+return {
+	handlers: {
+		onSave: func(name, n) {
+			count++
+			return name + ":" + string(check(n)) + ":" + string(count)
+		},
+	},
+	limit: 3,
+}`,
+	}, new(compiler.Compiler))
+
+	fn, err := ktx.Lookup(ctx, "hooks", "handlers.onSave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		v, err := ktx.Call(ctx, fn, "a", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := fmt.Sprintf("a:%d:%d", i, i); v.String(ctx) != exp {
+			t.Errorf("expected `%s`, got `%s`", exp, v.String(ctx))
 		}
-		return false
 	}
 
-	// script result
-	sr, ok := strToObj(res)
-	if !ok {
-		if testing.Verbose() {
-			fmt.Println("unrecognized script object")
+	_, err = ktx.CallExport(ctx, "hooks", "handlers.onSave", "a", -1)
+	var e *runtime.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected a *runtime.Error, got %T", err)
+	}
+	exp := []runtime.Frame{
+		{Name: "panic"},
+		{Name: "check", Module: "hooks", Line: 4},
+		{Module: "hooks", Line: 12},
+	}
+	if len(e.Frames) != len(exp) {
+		t.Fatalf("expected %d frames, got %d:\n%s", len(exp), len(e.Frames), e.StackTrace())
+	}
+	for i, f := range exp {
+		if e.Frames[i] != f {
+			t.Errorf("[%d] - expected frame %v, got %v", i, f, e.Frames[i])
 		}
-		return false
 	}
 
-	if len(fm) != len(sr) {
-		if testing.Verbose() {
-			fmt.Printf(
-				"improper length of the object, expected: %d, got: %d\n",
-				len(fm),
-				len(sr),
-			)
+	_, err = ktx.Lookup(ctx, "hooks", "handlers.onLoad")
+	if _, ok := err.(runtime.ExportNotFoundError); !ok {
+		t.Errorf("expected an ExportNotFoundError, got %v", err)
+	}
+	_, err = ktx.Lookup(ctx, "hooks", "limit")
+	if _, ok := err.(runtime.TypeError); !ok {
+		t.Errorf("expected a TypeError, got %v", err)
+	}
+	_, err = ktx.Call(ctx, fn, make(chan int))
+	if err == nil {
+		t.Errorf("expected an error for an argument that cannot be converted")
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `return prefix + string(double(len(lib)))`,
+		"lib":  `return "abc"`,
+		"imp":  `return import("lib")`,
+	}, new(compiler.Compiler))
+	ktx.SetGlobal("prefix", "n=")
+	ktx.SetGlobal("double", func(n int) int { return n * 2 })
+	// Replace the len built-in, and hide import
+	ktx.SetGlobal("len", func(s string) int { return len(s) + 1 })
+	ktx.SetGlobal("lib", "xyz")
+	ktx.RemoveGlobal("import")
+
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := mod.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "n=8"; v.String(ctx) != exp {
+		t.Errorf("expected `%s`, got `%s`", exp, v.String(ctx))
+	}
+	if _, err := ktx.Load("imp"); err == nil || !strings.Contains(err.Error(), "undefined") {
+		t.Errorf("expected `import` to be undefined, got %v", err)
+	}
+	for _, nm := range ktx.Globals() {
+		if nm == "import" {
+			t.Errorf("expected `import` not to be a global")
 		}
-		return false
 	}
+}
 
-	// compare objects
-	for _, fmi := range fm {
-		for i := 0; i < len(sr); i++ {
-			if sr[i].key == fmi.key && sr[i].value == fmi.value {
-				// delete item from sr slice
-				sr = append(sr[:i], sr[i+1:]...)
-				break
-			}
+// A globalVarsCompiler compiles the top-level variables of the modules as
+// global variables of the execution context, so that they are visible to the
+// modules compiled after them.
+type globalVarsCompiler struct {
+	ktx      *runtime.Kontext
+	builtins map[string]bool
+}
+
+func (c *globalVarsCompiler) Compile(id string, r io.Reader) (*bytecode.File, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New()
+	for _, nm := range c.ktx.Globals() {
+		if !c.builtins[nm] {
+			p.Vars = append(p.Vars, nm)
 		}
 	}
-	return len(sr) == 0
+	file, err := p.ParseFile(id, b)
+	if err != nil {
+		return nil, err
+	}
+	e := new(emitter.Emitter)
+	e.GlobalVars = true
+	return e.Emit(id, file)
 }
 
-// out = ouput of script
-// fm  = front matter output
-// return: true if equal, otherwise false
-func outputIsEqual(out, fm string) bool {
-	type slices struct {
-		even bool
-		body []string
+func TestGlobalVars(t *testing.T) {
+	ctx := context.Background()
+	c := new(globalVarsCompiler)
+	ktx := runtime.NewKtx(mapResolver{
+		"a": `
+		n := 1
+		func inc() {
+			n++
+			return n
+		}`,
+		"b": `
+		inc()
+		n = n * 10
+		return n`,
+		"c": `
+		inc := func() {
+			return "s"
+		}
+		return inc() + string(n)`,
+	}, c)
+	c.ktx = ktx
+	c.builtins = make(map[string]bool)
+	for _, nm := range ktx.Globals() {
+		c.builtins[nm] = true
 	}
-	// object regexp: {a:value,b:value-of-b!!!}
-	rxp := regexp.MustCompile(`\{([^:}]+\:[^,}]+,)*([^:}]+\:[^,}]+)\}`)
-	// func example: http://play.golang.org/p/fqzoSzaZqb
-	cut := func(s string) (*slices, bool) {
-		ix := rxp.FindAllStringIndex(s, -1)
-		if ix == nil {
-			return nil, false
+
+	exp := []string{"nil", "20", "s20"}
+	for i, id := range []string{"a", "b", "c"} {
+		mod, err := ktx.Load(id)
+		if err != nil {
+			t.Fatal(err)
 		}
-		var ss []string
-		var cur int
-		for _, ixx := range ix {
-			if cur != ixx[0] {
-				ss = append(ss, s[cur:ixx[0]])
-			}
-			ss = append(ss, s[ixx[0]:ixx[1]])
-			cur = ixx[1]
+		v, err := mod.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if cur != len(s) {
-			ss = append(ss, s[cur:len(s)])
+		if v.String(ctx) != exp[i] {
+			t.Errorf("%s: expected `%s`, got `%s`", id, exp[i], v.String(ctx))
 		}
-		even := ix[0][0] != 0
-		return &slices{
-			even: even,
-			body: ss,
-		}, true
-	}
-	// cut out and fm
-	outs, cuted := cut(out)
-	if !cuted {
-		// outs not cuted
-		return out == fm // simple string cmp
-	}
-	fms, cuted := cut(fm)
-	// check all
-	if !cuted || outs.even != fms.even || len(outs.body) != len(fms.body) {
-		return false
-	}
-	// loop
-	for i := 0; i < len(outs.body); i++ {
-		// if (ous.even and even) or (not outs.even and not even)
-		if (outs.even && i%2 != 0) || (!outs.even && i%2 == 0) {
-			if !objectsAreEqual(outs.body[i], fms.body[i]) {
-				return false
-			}
-		} else {
-			if outs.body[i] != fms.body[i] {
-				return false
-			}
+	}
+	var vars []string
+	for _, nm := range ktx.Globals() {
+		if !c.builtins[nm] {
+			vars = append(vars, nm)
 		}
 	}
-	return true
-}
-
-func readFrontMatter(s *bufio.Scanner) map[string]string {
-	m := make(map[string]string)
-	infm := false
-	for s.Scan() {
-		l := strings.Trim(s.Text(), " ")
-		if l == "/*---" || l == "---*/" { // The front matter is delimited by 3 dashes and in a block comment
-			if infm {
-				// This signals the end of the front matter
-				return m
-			} else {
-				// This is the start of the front matter
-				infm = true
-			}
-		} else if infm {
-			sections := strings.SplitN(l, ":", 2)
-			if len(sections) != 2 {
-				// Invalid front matter line
-				return nil
-			}
-			m[sections[0]] = strings.Trim(sections[1], " ")
-		} else if l != "" {
-			// No front matter, quit
-			return nil
+	if got := strings.Join(vars, ","); got != "inc,n" {
+		t.Errorf("expected the global variables `inc,n`, got `%s`", got)
+	}
+}
+
+func TestPopUnknownVar(t *testing.T) {
+	// Only the modules compiled with global variables can assign them
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `
+[f]
+main
+1
+0
+0
+0
+0
+[k]
+sn
+i1
+[l]
+[i]
+PUSH K 1
+POP V 0
+PUSH N 0
+RET _ 0
+[p]
+`,
+	}, new(compiler.Asm))
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mod.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "unknown variable: n") {
+		t.Errorf("expected an unknown variable error, got %v", err)
+	}
+	for _, nm := range ktx.Globals() {
+		if nm == "n" {
+			t.Errorf("expected no global variable `n`")
 		}
 	}
-	if err := s.Err(); err != nil {
-		// The scanner stopped because of an error
-		return nil
+}
+
+// A testHook records the events of the execution.
+type testHook struct {
+	runtime.NopHook
+	events []string
+}
+
+func (h *testHook) Enter(ctx context.Context, f *runtime.DebugFrame) {
+	h.events = append(h.events, fmt.Sprintf("enter %s %d", f.Name(), f.Depth()))
+}
+
+func (h *testHook) Exit(ctx context.Context, f *runtime.DebugFrame, v runtime.Val) {
+	h.events = append(h.events, fmt.Sprintf("exit %s %s", f.Name(), v.String(ctx)))
+}
+
+func (h *testHook) Line(ctx context.Context, f *runtime.DebugFrame, i bytecode.Instr) {
+	ev := fmt.Sprintf("line %d", f.Line())
+	for _, nm := range f.Locals() {
+		v, _ := f.Local(nm)
+		s := v.String(ctx)
+		if _, ok := v.(runtime.Func); ok {
+			s = "func"
+		}
+		ev += fmt.Sprintf(" %s=%s", nm, s)
+	}
+	h.events = append(h.events, ev)
+}
+
+func (h *testHook) Panic(ctx context.Context, f *runtime.DebugFrame, err *runtime.Error) {
+	h.events = append(h.events, fmt.Sprintf("panic %s %s", f.Name(), err.Message))
+}
+
+func TestHook(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `func add(a, b) {
+	if a < 0 {
+		panic("negative")
+	}
+	return a + b
+}
+x := add(1, 2)
+return add(-1, x)`,
+	}, new(compiler.Compiler))
+	h := new(testHook)
+	ktx.Hook = h
+
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mod.Run(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+	exp := []string{
+		"enter main 0",
+		"line 1 add=nil x=nil",
+		"line 7 add=func x=nil",
+		"enter add 1",
+		"line 2 a=1 b=2",
+		"line 5 a=1 b=2",
+		"exit add 3",
+		"line 8 add=func x=3",
+		"enter add 1",
+		"line 2 a=-1 b=3",
+		"line 3 a=-1 b=3",
+		"panic add negative",
+		"panic main negative",
+	}
+	if len(h.events) != len(exp) {
+		t.Fatalf("expected %d events, got %d:\n%s", len(exp), len(h.events), strings.Join(h.events, "\n"))
+	}
+	for i, ev := range exp {
+		if h.events[i] != ev {
+			t.Errorf("[%d] - expected `%s`, got `%s`", i, ev, h.events[i])
+		}
+	}
+}
+
+// An evalHook evaluates an expression and sets a local variable at a line.
+type evalHook struct {
+	runtime.NopHook
+	line int64
+	res  runtime.Val
+	err  error
+}
+
+func (h *evalHook) Line(ctx context.Context, f *runtime.DebugFrame, i bytecode.Instr) {
+	if f.Line() == h.line {
+		h.res, h.err = f.Eval(ctx, "a * 10 + n")
+		f.SetLocal("b", runtime.Number(5))
+	}
+}
+
+func TestDebugFrameEval(t *testing.T) {
+	ctx := context.Background()
+	ktx := runtime.NewKtx(mapResolver{
+		"main": `n := 3
+func f(a) {
+	b := a
+	return b
+}
+return f(4)`,
+	}, new(compiler.Compiler))
+	h := &evalHook{line: 4}
+	ktx.Hook = h
+
+	mod, err := ktx.Load("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := mod.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.err != nil {
+		t.Fatal(h.err)
+	}
+	if h.res != runtime.Number(43) {
+		t.Errorf("expected the expression to be 43, got %v", h.res)
+	}
+	if v != runtime.Number(5) {
+		t.Errorf("expected the local variable to be set to 5, got %v", v)
 	}
-	return nil
 }
@@ -0,0 +1,205 @@
+package agoratest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunDir(t *testing.T) {
+	type result struct {
+		status Status
+		msg    string
+		output string
+	}
+	cases := map[string]struct {
+		err     string
+		output  string
+		results map[string]result
+	}{
+		"calc_test.agora": {
+			output: "top-level\n",
+			results: map[string]result{
+				"TestAdd":     {Pass, "", ""},
+				"TestObjects": {Pass, "", ""},
+				"TestPanics":  {Pass, "", ""},
+				"TestFail":    {Fail, "calc_test.agora:28: 1 + 2: expected 4, got 3", "before\n"},
+				"TestError":   {Error, "calc_test.agora:34: type error", ""},
+			},
+		},
+		"return_test.agora": {
+			err: "return_test:3:1: a test file must not return a value",
+		},
+		"scripts/hello_test.agora": {
+			results: map[string]result{
+				"hello_test": {Pass, "", "hello\n"},
+			},
+		},
+		"scripts/wrong_test.agora": {
+			results: map[string]result{
+				"wrong_test": {Fail, "expected result '1', got '2'", ""},
+			},
+		},
+	}
+
+	r := new(Runner)
+	res, err := r.RunDir(context.Background(), "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(cases) {
+		t.Fatalf("expected %d test files, got %d", len(cases), len(res))
+	}
+	for _, fr := range res {
+		nm := filepath.ToSlash(strings.TrimPrefix(fr.Path, "testdata"+string(filepath.Separator)))
+		c, ok := cases[nm]
+		if !ok {
+			t.Errorf("unexpected test file %s", fr.Path)
+			continue
+		}
+		if (fr.Err != nil) != (c.err != "") || (fr.Err != nil && fr.Err.Error() != c.err) {
+			t.Errorf("[%s] - expected error '%s', got '%v'", nm, c.err, fr.Err)
+		}
+		if fr.Output != c.output {
+			t.Errorf("[%s] - expected output '%s', got '%s'", nm, c.output, fr.Output)
+		}
+		if fr.Failed() != (c.err != "" || nm != "scripts/hello_test.agora") {
+			t.Errorf("[%s] - unexpected failed status %v", nm, fr.Failed())
+		}
+		if len(fr.Results) != len(c.results) {
+			t.Errorf("[%s] - expected %d results, got %d", nm, len(c.results), len(fr.Results))
+		}
+		for _, r := range fr.Results {
+			exp, ok := c.results[r.Name]
+			if !ok {
+				t.Errorf("[%s] - unexpected test %s", nm, r.Name)
+				continue
+			}
+			if r.Status != exp.status {
+				t.Errorf("[%s] - %s: expected status %s, got %s", nm, r.Name, exp.status, r.Status)
+			}
+			if !strings.HasPrefix(r.Message, exp.msg) {
+				t.Errorf("[%s] - %s: expected message '%s', got '%s'", nm, r.Name, exp.msg, r.Message)
+			}
+			if r.Output != exp.output {
+				t.Errorf("[%s] - %s: expected output '%s', got '%s'", nm, r.Name, exp.output, r.Output)
+			}
+		}
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	r := &Runner{
+		Run: regexp.MustCompile(`^Test(Add|Fail)$`),
+	}
+	fr := r.RunFile(context.Background(), filepath.Join("testdata", "calc_test.agora"))
+	if fr.Err != nil {
+		t.Fatal(fr.Err)
+	}
+	var got []string
+	for _, r := range fr.Results {
+		got = append(got, r.Name)
+	}
+	if exp := "TestAdd,TestFail"; strings.Join(got, ",") != exp {
+		t.Errorf("expected tests %s, got %s", exp, strings.Join(got, ","))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	res := []*FileResult{
+		{
+			Path: "a_test.agora",
+			Results: []*Result{
+				{Name: "TestOk", Status: Pass, Output: "ok\n", Duration: time.Millisecond},
+				{Name: "TestKo", Status: Fail, Message: "a_test.agora:3: failed", Output: "ko\n", Duration: 2 * time.Millisecond},
+				{Name: "TestErr", Status: Error, Message: "a_test.agora:7: boom\n\tTestErr (a_test:7)"},
+			},
+			Duration: 3 * time.Millisecond,
+		},
+		{
+			Path:     "b_test.agora",
+			Err:      errors.New("b_test:1:1: syntax error"),
+			Duration: time.Millisecond,
+		},
+		{
+			Path:     "c_test.agora",
+			Results:  []*Result{{Name: "c_test"}},
+			Duration: time.Millisecond,
+		},
+	}
+
+	cases := []struct {
+		verbose bool
+		exp     string
+	}{
+		{false, `--- FAIL: TestKo (0.002s)
+	a_test.agora:3: failed
+	ko
+--- ERROR: TestErr (0.000s)
+	a_test.agora:7: boom
+		TestErr (a_test:7)
+FAIL	a_test.agora	0.003s
+FAIL	b_test.agora [b_test:1:1: syntax error]
+ok  	c_test.agora	0.001s
+`},
+		{true, `--- PASS: TestOk (0.001s)
+	ok
+--- FAIL: TestKo (0.002s)
+	a_test.agora:3: failed
+	ko
+--- ERROR: TestErr (0.000s)
+	a_test.agora:7: boom
+		TestErr (a_test:7)
+FAIL	a_test.agora	0.003s
+FAIL	b_test.agora [b_test:1:1: syntax error]
+--- PASS: c_test (0.000s)
+ok  	c_test.agora	0.001s
+`},
+	}
+	for i, c := range cases {
+		buf := bytes.NewBuffer(nil)
+		if err := WritePlain(buf, res, c.verbose); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != c.exp {
+			t.Errorf("%d: expected\n%s\ngot\n%s", i, c.exp, got)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteJUnit(buf, res); err != nil {
+		t.Fatal(err)
+	}
+	exp := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="a_test.agora" tests="3" failures="1" errors="1" time="0.003">
+    <testcase name="TestOk" classname="a_test.agora" time="0.001">
+      <system-out>ok&#xA;</system-out>
+    </testcase>
+    <testcase name="TestKo" classname="a_test.agora" time="0.002">
+      <failure message="a_test.agora:3: failed">a_test.agora:3: failed</failure>
+      <system-out>ko&#xA;</system-out>
+    </testcase>
+    <testcase name="TestErr" classname="a_test.agora" time="0.000">
+      <error message="a_test.agora:7: boom">a_test.agora:7: boom&#xA;&#x9;TestErr (a_test:7)</error>
+    </testcase>
+  </testsuite>
+  <testsuite name="b_test.agora" tests="1" failures="0" errors="1" time="0.001">
+    <testcase name="b_test.agora" classname="b_test.agora" time="0.001">
+      <error message="b_test:1:1: syntax error"></error>
+    </testcase>
+  </testsuite>
+  <testsuite name="c_test.agora" tests="1" failures="0" errors="0" time="0.001">
+    <testcase name="c_test" classname="c_test.agora" time="0.000"></testcase>
+  </testsuite>
+</testsuites>
+`
+	if got := buf.String(); got != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, got)
+	}
+}
@@ -0,0 +1,189 @@
+package agoratest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/agora/runtime"
+)
+
+// Maximum depth of the objects compared by assert.Equal, so that cyclic
+// objects do not recurse forever.
+const maxEqualDepth = 100
+
+// An AssertionError is raised by the functions of the assert module when
+// the assertion fails.
+type AssertionError string
+
+// Error interface implementation.
+func (e AssertionError) Error() string {
+	return string(e)
+}
+
+// Create a new AssertionError with the message msg, prefixed by the
+// optional message provided by the caller in args, if any.
+func NewAssertionError(ctx context.Context, msg string, args []runtime.Val) AssertionError {
+	if len(args) > 0 {
+		strs := make([]string, len(args))
+		for i, v := range args {
+			strs[i] = v.String(ctx)
+		}
+		msg = strings.Join(strs, " ") + ": " + msg
+	}
+	return AssertionError(msg)
+}
+
+// The assert module, imported by the test files with `import("assert")`. Its
+// functions raise an AssertionError if the assertion fails, which stops the
+// test. The last arguments of the functions are optional, they are printed
+// before the message of the error, i.e. `assert.Equal(3, n, "count")`.
+//
+//   - True(val, msgs...) : asserts that val is true
+//   - False(val, msgs...) : asserts that val is false
+//   - Equal(exp, val, msgs...) : asserts that val is equal to exp, objects are
+//     equal if they have the same keys and their values are equal
+//   - NotEqual(exp, val, msgs...) : asserts that val is not equal to exp
+//   - Nil(val, msgs...) : asserts that val is nil
+//   - NotNil(val, msgs...) : asserts that val is not nil
+//   - Panics(fn, args...) : asserts that calling fn with args raises an error,
+//     and returns the error
+//   - Fail(msgs...) : fails unconditionally
+type AssertMod struct {
+	ktx *runtime.Kontext
+	ob  runtime.Object
+}
+
+func (a *AssertMod) ID() string {
+	return "assert"
+}
+
+func (a *AssertMod) Run(_ context.Context, _ ...runtime.Val) (v runtime.Val, err error) {
+	defer runtime.PanicToError(&err)
+	if a.ob == nil {
+		// Prepare the object
+		a.ob = runtime.NewObject()
+		a.ob.Set(runtime.String("True"), runtime.NewNativeFunc(a.ktx, "assert.True", a.assert_True))
+		a.ob.Set(runtime.String("False"), runtime.NewNativeFunc(a.ktx, "assert.False", a.assert_False))
+		a.ob.Set(runtime.String("Equal"), runtime.NewNativeFunc(a.ktx, "assert.Equal", a.assert_Equal))
+		a.ob.Set(runtime.String("NotEqual"), runtime.NewNativeFunc(a.ktx, "assert.NotEqual", a.assert_NotEqual))
+		a.ob.Set(runtime.String("Nil"), runtime.NewNativeFunc(a.ktx, "assert.Nil", a.assert_Nil))
+		a.ob.Set(runtime.String("NotNil"), runtime.NewNativeFunc(a.ktx, "assert.NotNil", a.assert_NotNil))
+		a.ob.Set(runtime.String("Panics"), runtime.NewNativeFunc(a.ktx, "assert.Panics", a.assert_Panics))
+		a.ob.Set(runtime.String("Fail"), runtime.NewNativeFunc(a.ktx, "assert.Fail", a.assert_Fail))
+	}
+	return a.ob, nil
+}
+
+func (a *AssertMod) SetKtx(c *runtime.Kontext) {
+	a.ktx = c
+}
+
+func (a *AssertMod) assert_True(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(1, args)
+	if !args[0].Bool(ctx) {
+		panic(NewAssertionError(ctx, fmt.Sprintf("expected true, got %s", show(ctx, args[0])), args[1:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_False(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(1, args)
+	if args[0].Bool(ctx) {
+		panic(NewAssertionError(ctx, fmt.Sprintf("expected false, got %s", show(ctx, args[0])), args[1:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_Equal(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(2, args)
+	if !a.equal(ctx, args[0], args[1], 0) {
+		panic(NewAssertionError(ctx, fmt.Sprintf("expected %s, got %s", show(ctx, args[0]), show(ctx, args[1])), args[2:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_NotEqual(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(2, args)
+	if a.equal(ctx, args[0], args[1], 0) {
+		panic(NewAssertionError(ctx, fmt.Sprintf("expected a value other than %s", show(ctx, args[0])), args[2:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_Nil(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(1, args)
+	if args[0] != runtime.Nil {
+		panic(NewAssertionError(ctx, fmt.Sprintf("expected nil, got %s", show(ctx, args[0])), args[1:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_NotNil(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(1, args)
+	if args[0] == runtime.Nil {
+		panic(NewAssertionError(ctx, "expected a value other than nil", args[1:]))
+	}
+	return runtime.Nil
+}
+
+func (a *AssertMod) assert_Panics(ctx context.Context, args ...runtime.Val) runtime.Val {
+	runtime.ExpectAtLeastNArgs(1, args)
+	fn, ok := args[0].(runtime.Func)
+	if !ok {
+		panic(runtime.NewTypeError(runtime.Type(args[0]), "", "call"))
+	}
+	vals := make([]interface{}, len(args)-1)
+	for i, v := range args[1:] {
+		vals[i] = v
+	}
+	_, err := a.ktx.Call(ctx, fn, vals...)
+	if err == nil {
+		panic(NewAssertionError(ctx, "expected an error, got none", nil))
+	}
+	if e, ok := err.(*runtime.Error); ok {
+		return e
+	}
+	return runtime.String(err.Error())
+}
+
+func (a *AssertMod) assert_Fail(ctx context.Context, args ...runtime.Val) runtime.Val {
+	panic(NewAssertionError(ctx, "failed", args))
+}
+
+// Returns true if x and y are equal according to the comparer of the
+// execution context, or if they are objects with the same keys and equal
+// values.
+func (a *AssertMod) equal(ctx context.Context, x, y runtime.Val, depth int) bool {
+	if runtime.Type(x) != runtime.Type(y) {
+		return false
+	}
+	if a.ktx.Comparer.Cmp(ctx, x, y) == 0 {
+		return true
+	}
+	xo, ok := x.(runtime.Object)
+	if !ok || depth >= maxEqualDepth {
+		return false
+	}
+	yo := y.(runtime.Object)
+	if xo.Len(ctx).Int(ctx) != yo.Len(ctx).Int(ctx) {
+		return false
+	}
+	ks := xo.Keys(ctx).(runtime.Object)
+	for i, n := int64(0), ks.Len(ctx).Int(ctx); i < n; i++ {
+		k := ks.Get(runtime.Number(i))
+		if !a.equal(ctx, xo.Get(k), yo.Get(k), depth+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the representation of v in a message, strings are quoted.
+func show(ctx context.Context, v runtime.Val) string {
+	if s, ok := v.(runtime.String); ok {
+		return strconv.Quote(string(s))
+	}
+	return v.String(ctx)
+}
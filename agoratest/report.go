@@ -0,0 +1,158 @@
+package agoratest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WritePlain writes the results res to w in plain text, in the style of
+// `go test`. The passing tests are listed only if verbose is true, the
+// failures and errors are always listed with their message and output.
+func WritePlain(w io.Writer, res []*FileResult, verbose bool) error {
+	for _, fr := range res {
+		if fr.Err != nil {
+			if _, err := fmt.Fprintf(w, "FAIL\t%s [%s]\n", fr.Path, fr.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, r := range fr.Results {
+			if r.Status == Pass && !verbose {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "--- %s: %s (%s)\n", r.Status, r.Name, seconds(r.Duration)); err != nil {
+				return err
+			}
+			if r.Message != "" {
+				if _, err := io.WriteString(w, indent(r.Message)); err != nil {
+					return err
+				}
+			}
+			if r.Output != "" {
+				if _, err := io.WriteString(w, indent(r.Output)); err != nil {
+					return err
+				}
+			}
+		}
+		status := "ok  "
+		if fr.Failed() {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", status, fr.Path, seconds(fr.Duration)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Indent each line of s with a tab, and terminate it with a newline.
+func indent(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return "\t" + strings.Replace(s, "\n", "\n\t", -1) + "\n"
+}
+
+// Format the duration d in seconds, i.e. "0.012s".
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+// The XML elements of the JUnit report.
+type (
+	junitSuites struct {
+		XMLName xml.Name      `xml:"testsuites"`
+		Suites  []*junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Errors   int          `xml:"errors,attr"`
+		Time     string       `xml:"time,attr"`
+		Cases    []*junitCase `xml:"testcase"`
+	}
+
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Error     *junitFailure `xml:"error,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Body    string `xml:",chardata"`
+	}
+)
+
+// WriteJUnit writes the results res to w in the JUnit XML format, with a test
+// suite per test file. A file that could not be tested is reported as a
+// suite with a single erroneous test case.
+func WriteJUnit(w io.Writer, res []*FileResult) error {
+	var doc junitSuites
+	for _, fr := range res {
+		s := &junitSuite{
+			Name: fr.Path,
+			Time: junitTime(fr.Duration),
+		}
+		if fr.Err != nil {
+			s.Tests, s.Errors = 1, 1
+			s.Cases = append(s.Cases, &junitCase{
+				Name:      fr.Path,
+				Classname: fr.Path,
+				Time:      s.Time,
+				Error:     &junitFailure{Message: fr.Err.Error()},
+				SystemOut: fr.Output,
+			})
+			doc.Suites = append(doc.Suites, s)
+			continue
+		}
+		for _, r := range fr.Results {
+			c := &junitCase{
+				Name:      r.Name,
+				Classname: fr.Path,
+				Time:      junitTime(r.Duration),
+				SystemOut: r.Output,
+			}
+			switch r.Status {
+			case Fail:
+				s.Failures++
+				c.Failure = &junitFailure{Message: firstLine(r.Message), Body: r.Message}
+			case Error:
+				s.Errors++
+				c.Error = &junitFailure{Message: firstLine(r.Message), Body: r.Message}
+			}
+			s.Tests++
+			s.Cases = append(s.Cases, c)
+		}
+		doc.Suites = append(doc.Suites, s)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Format the duration d in seconds for the JUnit report, i.e. "0.012".
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// Get the first line of s.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,301 @@
+package agoratest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bobg/agora/compiler"
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/runtime"
+	"github.com/bobg/agora/runtime/stdlib"
+)
+
+// The suffix of the names of the test files.
+const testSuffix = "_test.agora"
+
+// The Status of a test.
+type Status int
+
+const (
+	Pass  Status = iota // The test passed
+	Fail                // An assertion failed, or the script has another outcome
+	Error               // The test raised an error, other than an assertion
+)
+
+var statusNames = [...]string{
+	Pass:  "PASS",
+	Fail:  "FAIL",
+	Error: "ERROR",
+}
+
+// String returns the literal representation of a Status.
+func (s Status) String() string {
+	return statusNames[s]
+}
+
+// A Result is the result of a test, a Test function or a script.
+type Result struct {
+	Name     string        // the name of the Test function, or the module ID of the script
+	Status   Status        // the status of the test
+	Message  string        // the failure or error message, with its position if it is known
+	Output   string        // the output of the test
+	Duration time.Duration // the duration of the test
+}
+
+// A FileResult holds the results of the tests of a test file.
+type FileResult struct {
+	Path     string        // the path of the test file
+	Results  []*Result     // the results of the tests, in order
+	Err      error         // the error that prevents the tests from running, if any
+	Output   string        // the output of the top-level code of the test file
+	Duration time.Duration // the duration of the tests of the file
+}
+
+// Failed returns true if the file could not be tested, or if a test did not
+// pass.
+func (f *FileResult) Failed() bool {
+	if f.Err != nil {
+		return true
+	}
+	for _, r := range f.Results {
+		if r.Status != Pass {
+			return true
+		}
+	}
+	return false
+}
+
+// A Runner executes the test files.
+//
+// Each test file is executed in its own execution context, in which the other
+// modules are resolved relative to the directory of the file. If the file
+// starts with a front matter, it is checked as a Script. Otherwise, its
+// top-level code is executed, then its top-level functions whose name starts
+// with `Test`, followed by a character that is not a lowercase letter, are
+// called in order, without arguments. A test file without front matter must
+// not return a value.
+type Runner struct {
+	// Run is the regular expression that the names of the Test functions and
+	// of the scripts must match to be executed. If it is nil, all tests are
+	// executed.
+	Run *regexp.Regexp
+
+	// Timeout is the maximum duration of a test, 0 means no limit.
+	Timeout time.Duration
+
+	// Setup is called with the execution context of each test file, to
+	// register the native modules and the global values. If it is nil, the
+	// stdlib modules are registered. The assert module is always registered.
+	Setup func(*runtime.Kontext)
+}
+
+// RunDir executes the test files of the directory dir and of its
+// sub-directories, and returns their results.
+func (r *Runner) RunDir(ctx context.Context, dir string) ([]*FileResult, error) {
+	var res []*FileResult
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(path, testSuffix) {
+			res = append(res, r.RunFile(ctx, path))
+		}
+		return nil
+	})
+	return res, err
+}
+
+// RunFile executes the test file at path and returns its results.
+func (r *Runner) RunFile(ctx context.Context, path string) *FileResult {
+	fr := &FileResult{
+		Path: path,
+	}
+	start := time.Now()
+	defer func() {
+		fr.Duration = time.Since(start)
+	}()
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fr.Err = err
+		return fr
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		fr.Err = err
+		return fr
+	}
+	ktx := runtime.NewKtx(dirResolver(dir), new(compiler.Compiler))
+	ktx.RegisterNativeModule(new(AssertMod))
+	if r.Setup != nil {
+		r.Setup(ktx)
+	} else {
+		ktx.RegisterNativeModule(new(stdlib.FilepathMod))
+		ktx.RegisterNativeModule(new(stdlib.FmtMod))
+		ktx.RegisterNativeModule(new(stdlib.MathMod))
+		ktx.RegisterNativeModule(new(stdlib.OsMod))
+		ktx.RegisterNativeModule(new(stdlib.StringsMod))
+		ktx.RegisterNativeModule(new(stdlib.TimeMod))
+	}
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	scr, err := ReadScript(id, bytes.NewReader(src))
+	if err != nil {
+		fr.Err = err
+		return fr
+	}
+	if scr != nil {
+		if r.Run == nil || r.Run.MatchString(id) {
+			fr.Results = append(fr.Results, r.runScript(ctx, ktx, scr))
+		}
+		return fr
+	}
+	r.runTests(ctx, ktx, fr, id, src)
+	return fr
+}
+
+// Check the script scr in ktx.
+func (r *Runner) runScript(ctx context.Context, ktx *runtime.Kontext, scr *Script) *Result {
+	res := &Result{
+		Name: scr.ID,
+	}
+	ctx, cancel := r.context(ctx)
+	defer cancel()
+	start := time.Now()
+	diffs := scr.Check(ctx, ktx)
+	res.Duration = time.Since(start)
+	if buf, ok := ktx.Stdout.(*bytes.Buffer); ok {
+		res.Output = buf.String()
+	}
+	if len(diffs) > 0 {
+		res.Status = Fail
+		res.Message = strings.Join(diffs, "\n")
+	}
+	return res
+}
+
+// Execute the top-level code of the test module id, with the source code src,
+// and then its Test functions.
+func (r *Runner) runTests(ctx context.Context, ktx *runtime.Kontext, fr *FileResult, id string, src []byte) {
+	// Find the Test functions, and export them from the module
+	p := parser.New()
+	p.Globals = ktx.Globals()
+	syms, _, err := p.Parse(id, src)
+	if err != nil {
+		fr.Err = err
+		return
+	}
+	var names []string
+	for _, s := range syms {
+		if s.Id == "func" && isTest(s.Name) {
+			names = append(names, s.Name)
+		} else if s.Id == "return" && !s.Implicit() {
+			fr.Err = fmt.Errorf("%s: a test file must not return a value", s.Pos())
+			return
+		}
+	}
+	exports := make([]string, len(names))
+	for i, nm := range names {
+		exports[i] = nm + ": " + nm
+	}
+	src = append(src, fmt.Sprintf("\nreturn {%s}\n", strings.Join(exports, ", "))...)
+
+	buf := bytes.NewBuffer(nil)
+	ktx.Stdout = buf
+	ktx.Resolver = &srcResolver{id, src, ktx.Resolver}
+	var v runtime.Val
+	m, err := ktx.Load(id)
+	if err == nil {
+		v, err = m.Run(ctx)
+	}
+	fr.Output = buf.String()
+	if err != nil {
+		fr.Err = err
+		return
+	}
+	ob := v.(runtime.Object)
+	for _, nm := range names {
+		if r.Run != nil && !r.Run.MatchString(nm) {
+			continue
+		}
+		buf.Reset()
+		fr.Results = append(fr.Results, r.runTest(ctx, ktx, fr.Path, id, nm, ob.Get(runtime.String(nm)).(runtime.Func)))
+		fr.Results[len(fr.Results)-1].Output = buf.String()
+	}
+}
+
+// Call the Test function fn, named nm, of the module id at path.
+func (r *Runner) runTest(ctx context.Context, ktx *runtime.Kontext, path, id, nm string, fn runtime.Func) *Result {
+	res := &Result{
+		Name: nm,
+	}
+	ctx, cancel := r.context(ctx)
+	defer cancel()
+	start := time.Now()
+	_, err := ktx.Call(ctx, fn)
+	res.Duration = time.Since(start)
+	if err == nil {
+		return res
+	}
+	res.Status = Error
+	var ae AssertionError
+	if errors.As(err, &ae) {
+		res.Status = Fail
+	}
+	res.Message = err.Error()
+	if e, ok := err.(*runtime.Error); ok {
+		if res.Status == Error {
+			res.Message = e.StackTrace()
+		}
+		// Prefix the message with the position in the test file
+		for _, f := range e.Frames {
+			if f.Module == id {
+				res.Message = fmt.Sprintf("%s:%d: %s", filepath.Base(path), f.Line, res.Message)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Get the context of a test, with the timeout of the runner if any.
+func (r *Runner) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.Timeout > 0 {
+		return context.WithTimeout(ctx, r.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Returns true if nm is the name of a Test function, i.e. `Test` or `TestAdd`,
+// but not `Testify`.
+func isTest(nm string) bool {
+	if !strings.HasPrefix(nm, "Test") {
+		return false
+	}
+	if len(nm) == 4 {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(nm[4:])
+	return !unicode.IsLower(r)
+}
+
+// A dirResolver resolves the modules relative to a directory, instead of the
+// working directory.
+type dirResolver string
+
+func (d dirResolver) Resolve(id string) (io.Reader, error) {
+	if !filepath.IsAbs(id) {
+		id = filepath.Join(string(d), id)
+	}
+	return runtime.FileResolver{}.Resolve(id)
+}
@@ -0,0 +1,317 @@
+// Package agoratest provides the testing of agora code, as done by the
+// `agora test` command.
+//
+// A Script is a source file with a YAML front matter that describes the
+// expected outcome of its execution, as the test files of the agora
+// repository. A Runner executes the test files, named `*_test.agora`, either
+// as scripts if they have a front matter, or by calling their top-level
+// functions whose name starts with `Test`, which use the `assert` module to
+// check the behaviour of the code under test.
+package agoratest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bobg/agora/runtime"
+)
+
+// A Script is the source code of a module with a front matter. The front
+// matter is a block comment at the start of the file, delimited by lines of
+// three dashes, that holds the following fields:
+//
+//   - output: the expected output (may contain \n for newlines)
+//   - result: the expected result value
+//   - error: the expected error message (omit if no error is expected)
+//   - args: the space-separated arguments to pass to the module
+//   - long: if true, the script takes a long time to execute
+//
+// For example:
+//
+//	/*---
+//	output: Hello, world!\n
+//	---*/
+//	fmt := import("fmt")
+//	fmt.Println("Hello, world!")
+type Script struct {
+	ID          string            // the ID of the module
+	Src         []byte            // the source code, after the front matter
+	FrontMatter map[string]string // the fields of the front matter
+}
+
+// ReadScript reads the script of the module id from r. It returns nil if the
+// source code does not start with a front matter.
+func ReadScript(id string, r io.Reader) (*Script, error) {
+	s := bufio.NewScanner(r)
+	m := readFrontMatter(s)
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	// Keep the rest of the file
+	buf := bytes.NewBuffer(nil)
+	for s.Scan() {
+		buf.WriteString(s.Text())
+		buf.WriteString("\n")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &Script{id, buf.Bytes(), m}, nil
+}
+
+// Check executes the script in the execution context ktx, and returns the
+// differences between the outcome and the expected one, if any. The resolver
+// of ktx resolves the other modules imported by the script, and its output is
+// captured.
+func (s *Script) Check(ctx context.Context, ktx *runtime.Kontext) []string {
+	var diffs []string
+	buf := bytes.NewBuffer(nil)
+	ktx.Stdout = buf
+	ktx.Resolver = &srcResolver{s.ID, s.Src, ktx.Resolver}
+
+	mod, err := ktx.Load(s.ID)
+	var ret runtime.Val
+	if err == nil {
+		var args []runtime.Val
+		if v, ok := s.FrontMatter["args"]; ok {
+			for _, arg := range strings.Split(v, " ") {
+				args = append(args, runtime.String(arg))
+			}
+		}
+		ret, err = mod.Run(ctx, args...)
+	}
+
+	assert := false
+	if v, ok := s.FrontMatter["error"]; ok {
+		assert = true
+		if err == nil {
+			diffs = append(diffs, fmt.Sprintf("expected error '%s', got none", v))
+		} else if err.Error() != v {
+			diffs = append(diffs, fmt.Sprintf("expected error '%s', got '%s'", v, err))
+		}
+	} else if err != nil {
+		diffs = append(diffs, fmt.Sprintf("expected no error, got '%s'", err))
+	}
+	if v, ok := s.FrontMatter["result"]; ok {
+		assert = true
+		v = unescape(v)
+		switch retv := ret.(type) {
+		case nil:
+			// Error, already reported
+		// compare runtime.Object with special function
+		case runtime.Object:
+			if str := retv.String(ctx); !objectsAreEqual(str, v) {
+				diffs = append(diffs, fmt.Sprintf("expected result '%s', got '%s'", v, str))
+			}
+		case runtime.Func:
+			if str := fmt.Sprintf("%s", retv); str != v {
+				diffs = append(diffs, fmt.Sprintf("expected result '%s', got '%s'", v, str))
+			}
+		default:
+			if str := retv.String(ctx); str != v {
+				diffs = append(diffs, fmt.Sprintf("expected result '%s', got '%s'", v, str))
+			}
+		}
+	}
+	if v, ok := s.FrontMatter["output"]; ok {
+		assert = true
+		v = unescape(v)
+		// compare output with special function
+		if got := buf.String(); !outputIsEqual(got, v) {
+			diffs = append(diffs, fmt.Sprintf("expected output '%s', got '%s'", v, got))
+		}
+	}
+	if !assert {
+		diffs = append(diffs, "no assert")
+	}
+	return diffs
+}
+
+// Replace the \n and \t escape sequences of a front matter value.
+func unescape(v string) string {
+	v = strings.Replace(v, "\\n", "\n", -1)
+	return strings.Replace(v, "\\t", "\t", -1)
+}
+
+// A srcResolver resolves the module id to its source code, and the other
+// modules with the resolver r.
+type srcResolver struct {
+	id  string
+	src []byte
+	r   runtime.ModuleResolver
+}
+
+func (s *srcResolver) Resolve(id string) (io.Reader, error) {
+	if id == s.id {
+		return bytes.NewReader(s.src), nil
+	}
+	return s.r.Resolve(id)
+}
+
+// res = result of script (runtime.Object.String())
+// fmr  = front matter result
+// return: true if equal, otherwise false
+func objectsAreEqual(res string, fmr string) bool {
+	type item struct {
+		key, value string
+	}
+
+	// TODO : should simplify that, sort keys and stringify instead of parsing
+	// because that won't work if values have commas and such.
+	strToObj := func(out string) ([]item, bool) {
+		// out: "{i:i-string,_:_-string,4:4-string}"
+		out = strings.TrimPrefix(out, "{")
+		// out: "i:i-string,_:_-string,4:4-string}"
+		out = strings.TrimSuffix(out, "}")
+		// out: "i:i-string,_:_-string,4:4-string"
+		outs := strings.FieldsFunc(out, func(r rune) bool {
+			return r == ','
+		})
+		// outs: [ "i:i-string", "_:_-string", "4:4-string" ]
+		out_obj := make([]item, 0, len(outs))
+		// fill out_obj
+		for _, o := range outs {
+			// o: "i:i-string"
+			okv := strings.FieldsFunc(o, func(r rune) bool {
+				return r == ':'
+			})
+			// okv: [ "i", "i-string" ]
+			// check okv length
+			if len(okv) != 2 {
+				return nil, false
+			}
+			out_obj = append(out_obj, item{okv[0], okv[1]})
+			// out_obj: [item{"i", "i-string"}, item{"4","4-string"}]
+		}
+		return out_obj, true
+	}
+
+	// front matter
+	fm, ok := strToObj(fmr)
+	if !ok {
+		return false
+	}
+
+	// script result
+	sr, ok := strToObj(res)
+	if !ok {
+		return false
+	}
+
+	if len(fm) != len(sr) {
+		return false
+	}
+
+	// compare objects
+	for _, fmi := range fm {
+		for i := 0; i < len(sr); i++ {
+			if sr[i].key == fmi.key && sr[i].value == fmi.value {
+				// delete item from sr slice
+				sr = append(sr[:i], sr[i+1:]...)
+				break
+			}
+		}
+	}
+	return len(sr) == 0
+}
+
+// out = ouput of script
+// fm  = front matter output
+// return: true if equal, otherwise false
+func outputIsEqual(out, fm string) bool {
+	type slices struct {
+		even bool
+		body []string
+	}
+	// object regexp: {a:value,b:value-of-b!!!}
+	rxp := regexp.MustCompile(`\{([^:}]+\:[^,}]+,)*([^:}]+\:[^,}]+)\}`)
+	// func example: http://play.golang.org/p/fqzoSzaZqb
+	cut := func(s string) (*slices, bool) {
+		ix := rxp.FindAllStringIndex(s, -1)
+		if ix == nil {
+			return nil, false
+		}
+		var ss []string
+		var cur int
+		for _, ixx := range ix {
+			if cur != ixx[0] {
+				ss = append(ss, s[cur:ixx[0]])
+			}
+			ss = append(ss, s[ixx[0]:ixx[1]])
+			cur = ixx[1]
+		}
+		if cur != len(s) {
+			ss = append(ss, s[cur:len(s)])
+		}
+		even := ix[0][0] != 0
+		return &slices{
+			even: even,
+			body: ss,
+		}, true
+	}
+	// cut out and fm
+	outs, cuted := cut(out)
+	if !cuted {
+		// outs not cuted
+		return out == fm // simple string cmp
+	}
+	fms, cuted := cut(fm)
+	// check all
+	if !cuted || outs.even != fms.even || len(outs.body) != len(fms.body) {
+		return false
+	}
+	// loop
+	for i := 0; i < len(outs.body); i++ {
+		// if (ous.even and even) or (not outs.even and not even)
+		if (outs.even && i%2 != 0) || (!outs.even && i%2 == 0) {
+			if !objectsAreEqual(outs.body[i], fms.body[i]) {
+				return false
+			}
+		} else {
+			if outs.body[i] != fms.body[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func readFrontMatter(s *bufio.Scanner) map[string]string {
+	m := make(map[string]string)
+	infm := false
+	for s.Scan() {
+		l := strings.Trim(s.Text(), " ")
+		if l == "/*---" || l == "---*/" { // The front matter is delimited by 3 dashes and in a block comment
+			if infm {
+				// This signals the end of the front matter
+				return m
+			} else {
+				// This is the start of the front matter
+				infm = true
+			}
+		} else if infm {
+			sections := strings.SplitN(l, ":", 2)
+			if len(sections) != 2 {
+				// Invalid front matter line
+				return nil
+			}
+			m[sections[0]] = strings.Trim(sections[1], " ")
+		} else if l != "" {
+			// No front matter, quit
+			return nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		// The scanner stopped because of an error
+		return nil
+	}
+	return nil
+}
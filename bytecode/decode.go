@@ -48,8 +48,9 @@ func (dec *Decoder) Decode() (*File, error) {
 	// 3- Create the File structure
 	f := new(File)
 	f.MajorVersion, f.MinorVersion = decodeVersionByte(ver)
+	hasPs := hasPSection(f.MajorVersion, f.MinorVersion)
 	for {
-		fn, ok := dec.readFunc()
+		fn, ok := dec.readFunc(hasPs)
 		if !ok {
 			break
 		}
@@ -83,7 +84,7 @@ func (dec *Decoder) assertSignature(sig int32) {
 
 func (dec *Decoder) assertVersion(ver byte) {
 	dec.guard(func() {
-		if ver != encodeVersionByte(_MAJOR_VERSION, _MINOR_VERSION) {
+		if !supportedVersion(decodeVersionByte(ver)) {
 			dec.err = ErrVersionMismatch
 		}
 	})
@@ -105,7 +106,7 @@ func (dec *Decoder) assertOpcode(ins Instr) {
 	})
 }
 
-func (dec *Decoder) readFunc() (*Fn, bool) {
+func (dec *Decoder) readFunc(hasPs bool) (*Fn, bool) {
 	nm := dec.readString()
 	if dec.err != nil {
 		return nil, false
@@ -147,6 +148,18 @@ func (dec *Decoder) readFunc() (*Fn, bool) {
 			dec.assertOpcode(fn.Is[i])
 		}
 	}
+
+	// P section, absent from older versions
+	if hasPs {
+		ps := dec.readInt64()
+		if ps > 0 {
+			fn.Ps = make([]Pos, ps)
+			for i := int64(0); i < ps; i++ {
+				fn.Ps[i].Ix = dec.readInt64()
+				fn.Ps[i].Line = dec.readInt64()
+			}
+		}
+	}
 	return fn, true
 }
 
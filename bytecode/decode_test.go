@@ -37,8 +37,8 @@ var (
 			src: AppendAny(SigVer(defMaj, defMin), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				Int64ToByteSlice(2), Int64ToByteSlice(3), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(6),
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 			exp: &File{
 				MajorVersion: defMaj,
 				MinorVersion: defMin,
@@ -62,8 +62,8 @@ var (
 			src: AppendAny(ExpSig, encodeVersionByte(2, 3), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				Int64ToByteSlice(2), Int64ToByteSlice(3), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(6),
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 			err: ErrVersionMismatch,
 		},
 		3: {
@@ -73,8 +73,8 @@ var (
 			src: AppendAny(SigVer(defMaj, defMin), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 			exp: &File{
 				MajorVersion: defMaj,
 				MinorVersion: defMin,
@@ -86,8 +86,8 @@ var (
 			src: AppendAny(SigVer(defMaj, defMin), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				Int64ToByteSlice(2), Int64ToByteSlice(3), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(6),
-				// Ks - Ls - Is
-				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 			exp: &File{
 				MajorVersion: defMaj,
 				MinorVersion: defMin,
@@ -138,7 +138,9 @@ var (
 				// Ks - Ls - Is
 				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, Int64ToByteSlice(2),
 				// 2 Ops
-				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP)),
+				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP),
+				// Ps
+				Int64ToByteSlice(2), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(1), Int64ToByteSlice(6)),
 			exp: &File{
 				MajorVersion: defMaj,
 				MinorVersion: defMin,
@@ -162,6 +164,10 @@ var (
 							NewInstr(OP_ADD, FLG_K, 12),
 							NewInstr(OP_DUMP, FLG_Sn, 0),
 						},
+						Ps: []Pos{
+							Pos{Ix: 0, Line: 5},
+							Pos{Ix: 1, Line: 6},
+						},
 					},
 				}},
 		},
@@ -189,6 +195,8 @@ var (
 				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, Int64ToByteSlice(2),
 				// 2 ops
 				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP),
+				// Ps
+				ExpZeroInt64,
 				// 2nd Fn
 				Int64ToByteSlice(2), 'f', '2',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
@@ -196,7 +204,9 @@ var (
 				// Ks - Ls - Is
 				Int64ToByteSlice(1), byte(KtString), Int64ToByteSlice(5), 'c', 'o', 'n', 's', 't', ExpZeroInt64, Int64ToByteSlice(1),
 				// 1 op
-				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				// Ps
+				ExpZeroInt64),
 			exp: &File{
 				MajorVersion: defMaj,
 				MinorVersion: defMin,
@@ -242,6 +252,29 @@ var (
 					},
 				}},
 		},
+		10: {
+			// Older minor version, without the P section
+			maj: defMaj,
+			min: defMin,
+			src: AppendAny(SigVer(defMaj, _MIN_MINOR_VERSION), Int64ToByteSlice(4), 't', 'e', 's', 't',
+				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
+				// Ks - Ls - Is
+				ExpZeroInt64, ExpZeroInt64, Int64ToByteSlice(1),
+				// 1 op
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
+			exp: &File{
+				MajorVersion: defMaj,
+				MinorVersion: _MIN_MINOR_VERSION,
+				Name:         "test", Fns: []*Fn{
+					&Fn{
+						Header: H{Name: "test"},
+						Is: []Instr{
+							NewInstr(OP_RET, FLG__, 0),
+						},
+					},
+				}},
+		},
 	}
 
 	isolateDecCase = -1
@@ -349,6 +382,14 @@ func equal(f1, f2 *File) bool {
 				return false
 			}
 		}
+		if len(fn1.Ps) != len(fn2.Ps) {
+			return false
+		}
+		for j := 0; j < len(fn1.Ps); j++ {
+			if fn1.Ps[j] != fn2.Ps[j] {
+				return false
+			}
+		}
 	}
 	return true
 }
@@ -34,7 +34,7 @@ func (enc *Encoder) Encode(f *File) (err error) {
 	enc.err = nil
 	// 1- Signature
 	enc.write(_SIGNATURE)
-	// 2- Version (must match exactly that of the compiler)
+	// 2- Version (must be supported by the compiler)
 	enc.assertVersion(f)
 	enc.write(encodeVersionByte(f.MajorVersion, f.MinorVersion))
 	// 3- Each function
@@ -70,6 +70,15 @@ func (enc *Encoder) Encode(f *File) (err error) {
 			enc.assertOpcode(ins)
 			enc.write(uint64(ins))
 		}
+
+		// 8- The P section, if supported by the version
+		if hasPSection(f.MajorVersion, f.MinorVersion) {
+			enc.write(int64(len(fn.Ps)))
+			for _, p := range fn.Ps {
+				enc.write(p.Ix)
+				enc.write(p.Line)
+			}
+		}
 	}
 	return enc.err
 }
@@ -99,7 +108,7 @@ func (enc *Encoder) assertKType(kt KType) {
 
 func (enc *Encoder) assertVersion(f *File) {
 	enc.guard(func() {
-		if f.MajorVersion != _MAJOR_VERSION || f.MinorVersion != _MINOR_VERSION {
+		if !supportedVersion(f.MajorVersion, f.MinorVersion) {
 			enc.err = ErrVersionMismatch
 		}
 	})
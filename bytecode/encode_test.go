@@ -51,8 +51,8 @@ var (
 			exp: AppendAny(SigVer(_MAJOR_VERSION, _MINOR_VERSION), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 		},
 		4: {
 			maj: defMaj,
@@ -80,8 +80,8 @@ var (
 			exp: AppendAny(SigVer(_MAJOR_VERSION, _MINOR_VERSION), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				Int64ToByteSlice(2), Int64ToByteSlice(3), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(6),
-				// Ks - Ls - Is
-				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 		},
 		5: {
 			// Invalid KType
@@ -159,6 +159,10 @@ var (
 							NewInstr(OP_ADD, FLG_K, 12),
 							NewInstr(OP_DUMP, FLG_Sn, 0),
 						},
+						Ps: []Pos{
+							Pos{Ix: 0, Line: 5},
+							Pos{Ix: 1, Line: 6},
+						},
 					},
 				}},
 			exp: AppendAny(SigVer(_MAJOR_VERSION, _MINOR_VERSION), Int64ToByteSlice(4), 't', 'e', 's', 't',
@@ -167,7 +171,9 @@ var (
 				// Ks - Ls - Is
 				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, Int64ToByteSlice(2),
 				// 2 ops
-				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP)),
+				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP),
+				// Ps
+				Int64ToByteSlice(2), ExpZeroInt64, Int64ToByteSlice(5), Int64ToByteSlice(1), Int64ToByteSlice(6)),
 		},
 		// Invalid opcode
 		8: {
@@ -251,6 +257,8 @@ var (
 				Int64ToByteSlice(1), byte(KtInteger), Int64ToByteSlice(7), ExpZeroInt64, Int64ToByteSlice(2),
 				// 2 ops
 				0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_K), byte(OP_ADD), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(FLG_Sn), byte(OP_DUMP),
+				// Ps
+				ExpZeroInt64,
 				// Fn 2
 				Int64ToByteSlice(2), 'f', '2',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
@@ -258,6 +266,33 @@ var (
 				// Ks - Ls - Is
 				Int64ToByteSlice(1), byte(KtString), Int64ToByteSlice(5), 'c', 'o', 'n', 's', 't', ExpZeroInt64, Int64ToByteSlice(1),
 				// 1 op
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				// Ps
+				ExpZeroInt64),
+		},
+		10: {
+			// Older minor version, without the P section
+			maj: defMaj,
+			min: defMin,
+			f: &File{
+				MajorVersion: defMaj,
+				MinorVersion: _MIN_MINOR_VERSION,
+				Name:         "test", Fns: []*Fn{
+					&Fn{
+						Is: []Instr{
+							NewInstr(OP_RET, FLG__, 0),
+						},
+						Ps: []Pos{
+							Pos{Ix: 0, Line: 1},
+						},
+					},
+				}},
+			exp: AppendAny(SigVer(defMaj, _MIN_MINOR_VERSION), Int64ToByteSlice(4), 't', 'e', 's', 't',
+				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
+				// Ks - Ls - Is
+				ExpZeroInt64, ExpZeroInt64, Int64ToByteSlice(1),
+				// 1 op
 				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
 		},
 	}
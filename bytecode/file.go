@@ -1,5 +1,9 @@
 package bytecode
 
+import (
+	"sort"
+)
+
 // The binary signature that must be present at the start of
 // each compiled bytecode file.
 const (
@@ -9,7 +13,14 @@ const (
 var (
 	// Vars only to allow for testing, but are really constants
 	_MAJOR_VERSION = 0
-	_MINOR_VERSION = 2
+	_MINOR_VERSION = 4
+	// The oldest minor version of the current major version that can be decoded
+	_MIN_MINOR_VERSION = 2
+)
+
+const (
+	// The minor version that introduced the P section (the line table)
+	_P_SECTION_MINOR_VERSION = 3
 )
 
 // Version returns the major and minor version of the bytecode format.
@@ -17,6 +28,18 @@ func Version() (int, int) {
 	return _MAJOR_VERSION, _MINOR_VERSION
 }
 
+// Check if the specified version can be encoded and decoded with the current
+// version of the bytecode format. Older minor versions of the same major
+// version are supported.
+func supportedVersion(maj, min int) bool {
+	return maj == _MAJOR_VERSION && min <= _MINOR_VERSION && min >= _MIN_MINOR_VERSION
+}
+
+// Check if the specified version has the P section in each function.
+func hasPSection(maj, min int) bool {
+	return maj > 0 || min >= _P_SECTION_MINOR_VERSION
+}
+
 func encodeVersionByte(maj, min int) byte {
 	return byte(maj)<<4 | byte(min)
 }
@@ -52,6 +75,10 @@ type File struct {
 	MajorVersion int
 	MinorVersion int
 	Fns          []*Fn
+
+	// If set, the top-level code may assign global variables of the execution
+	// context, see emitter.Emitter.GlobalVars. It is not encoded.
+	GlobalVars bool
 }
 
 // NewFile returns a File structure initialized with the specified name and
@@ -70,6 +97,33 @@ type Fn struct {
 	Ks     []*K
 	Ls     []int64 // locals, as indexes into the K table
 	Is     []Instr
+	Ps     []Pos // line table, ordered by instruction index
+}
+
+// A Pos maps the instructions of a function, starting at index Ix up to the
+// index of the next Pos in the line table, to a line in the source code.
+type Pos struct {
+	Ix   int64
+	Line int64
+}
+
+// Line returns the line in the source code of the instruction at index ix,
+// or 0 if it is unknown.
+func (fn *Fn) Line(ix int64) int64 {
+	return LineOf(fn.Ps, ix)
+}
+
+// LineOf returns the line in the source code of the instruction at index ix,
+// using the provided line table, or 0 if it is unknown.
+func LineOf(ps []Pos, ix int64) int64 {
+	// Find the first Pos after ix, the line is the one of the previous Pos
+	i := sort.Search(len(ps), func(i int) bool {
+		return ps[i].Ix > ix
+	})
+	if i == 0 {
+		return 0
+	}
+	return ps[i-1].Line
 }
 
 // An H is the function header representation.
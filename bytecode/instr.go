@@ -21,6 +21,10 @@ const (
 	FLG_Jb               // Jump back over n instructions
 	FLG_Sn               // Dump n frames
 	FLG_Fn               // Set n fields
+	FLG_Rn               // Number of values returned or expected
+	FLG_Mn               // Method call with n args
+	FLG_L                // Local variable slot
+	FLG_U                // Upvalue, i.e. local variable slot of an enclosing function
 	FLG_INVL Flag = 0xFF // Invalid flag
 )
 
@@ -39,6 +43,10 @@ var (
 		FLG_Jb: "Jb",
 		FLG_Sn: "Sn",
 		FLG_Fn: "Fn",
+		FLG_Rn: "Rn",
+		FLG_Mn: "Mn",
+		FLG_L:  "L",
+		FLG_U:  "U",
 	}
 
 	// The lookup table of literal flag names to Flag values
@@ -55,6 +63,10 @@ var (
 		"Jb": FLG_Jb,
 		"Sn": FLG_Sn,
 		"Fn": FLG_Fn,
+		"Rn": FLG_Rn,
+		"Mn": FLG_Mn,
+		"L":  FLG_L,
+		"U":  FLG_U,
 	}
 )
 
@@ -112,3 +124,16 @@ func (i Instr) String() string {
 	op, f, ix := i.Opcode(), i.Flag(), i.Index()
 	return fmt.Sprintf("%-4s %-2s %3d", op, f, ix)
 }
+
+// UpvalIndex returns the index of an instruction with the FLG_U flag, referring
+// to the local variable at the specified slot of the function that is depth
+// levels up the chain of enclosing functions (1 is the parent function).
+func UpvalIndex(depth, slot uint64) uint64 {
+	return depth<<32 | slot
+}
+
+// Upval returns the depth and the slot of the upvalue referred to by the index
+// of an instruction with the FLG_U flag.
+func Upval(ix uint64) (depth, slot uint64) {
+	return ix >> 32, ix & 0xFFFFFFFF
+}
@@ -36,6 +36,9 @@ const (
 	OP_RNGS               // range start
 	OP_RNGP               // range push
 	OP_RNGE               // range end
+	OP_NEWA               // create and initialize a new array, push the result, using n values from the stack
+	OP_UNPK               // unpack the values returned by the last call into n values on the stack
+	OP_DFR                // defer a function or method call until the function returns, using n args
 	op_dbgstart
 	OP_DUMP               // print the execution context, if the Ktx is in debug mode
 	op_max                // Indicates the maximum legal opcode
@@ -72,6 +75,9 @@ var (
 		OP_RNGS: "RNGS",
 		OP_RNGP: "RNGP",
 		OP_RNGE: "RNGE",
+		OP_NEWA: "NEWA",
+		OP_UNPK: "UNPK",
+		OP_DFR:  "DFR",
 		OP_DUMP: "DUMP",
 	}
 
@@ -104,6 +110,9 @@ var (
 		"RNGS": OP_RNGS,
 		"RNGP": OP_RNGP,
 		"RNGE": OP_RNGE,
+		"NEWA": OP_NEWA,
+		"UNPK": OP_UNPK,
+		"DFR":  OP_DFR,
 		"DUMP": OP_DUMP,
 	}
 )
@@ -0,0 +1,60 @@
+package bytecode
+
+// ResolveVars resolves the variables referenced by name in the instructions of
+// the functions of the file, i.e. with the FLG_V flag and the index of the
+// name in the K table. A variable defined in the L table of the function
+// becomes a local variable slot (FLG_L), and a variable defined in the L
+// table of an enclosing function becomes an upvalue (FLG_U). Other variables
+// are left unchanged, they are looked up by name in the built-ins at runtime.
+//
+// Bytecode generated by the compiler is already resolved, this is required only
+// for older versions of the format and for hand-written assembly code.
+func (f *File) ResolveVars() {
+	// The slots of the local variables, by name, for each function
+	slots := make([]map[string]uint64, len(f.Fns))
+	for i, fn := range f.Fns {
+		slots[i] = make(map[string]uint64, len(fn.Ls))
+		for j, l := range fn.Ls {
+			if nm, ok := fn.kName(l); ok {
+				slots[i][nm] = uint64(j)
+			}
+		}
+	}
+	for i, fn := range f.Fns {
+		for j, ins := range fn.Is {
+			if ins.Flag() != FLG_V {
+				continue
+			}
+			nm, ok := fn.kName(int64(ins.Index()))
+			if !ok {
+				continue
+			}
+			if slot, ok := slots[i][nm]; ok {
+				fn.Is[j] = NewInstr(ins.Opcode(), FLG_L, slot)
+				continue
+			}
+			// Look up the chain of enclosing functions, the top-level function
+			// has no parent. A parent is always defined before its children.
+			for depth, ix := uint64(1), i; ix > 0; depth++ {
+				p := int(f.Fns[ix].Header.ParentFnIx)
+				if p < 0 || p >= ix {
+					break
+				}
+				ix = p
+				if slot, ok := slots[ix][nm]; ok {
+					fn.Is[j] = NewInstr(ins.Opcode(), FLG_U, UpvalIndex(depth, slot))
+					break
+				}
+			}
+		}
+	}
+}
+
+// Get the name stored in the K table at index ix, if it is a string.
+func (fn *Fn) kName(ix int64) (string, bool) {
+	if ix < 0 || ix >= int64(len(fn.Ks)) {
+		return "", false
+	}
+	s, ok := fn.Ks[ix].Val.(string)
+	return s, ok
+}
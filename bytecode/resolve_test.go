@@ -0,0 +1,91 @@
+package bytecode
+
+import (
+	"testing"
+)
+
+func TestResolveVars(t *testing.T) {
+	// top: a := 1; func f(x) { b := x + a; func g() { return b + a + len } }
+	f := &File{
+		Fns: []*Fn{
+			&Fn{
+				Ks: []*K{
+					&K{Type: KtInteger, Val: int64(1)},
+					&K{Type: KtString, Val: "a"},
+				},
+				Ls: []int64{1},
+				Is: []Instr{
+					NewInstr(OP_PUSH, FLG_K, 0),
+					NewInstr(OP_POP, FLG_V, 1),
+				},
+			},
+			&Fn{
+				Header: H{ExpArgs: 1},
+				Ks: []*K{
+					&K{Type: KtString, Val: "x"},
+					&K{Type: KtString, Val: "a"},
+					&K{Type: KtString, Val: "b"},
+				},
+				Ls: []int64{0, 2},
+				Is: []Instr{
+					NewInstr(OP_PUSH, FLG_V, 0),
+					NewInstr(OP_PUSH, FLG_V, 1),
+					NewInstr(OP_ADD, FLG__, 0),
+					NewInstr(OP_POP, FLG_V, 2),
+				},
+			},
+			&Fn{
+				Header: H{ParentFnIx: 1},
+				Ks: []*K{
+					&K{Type: KtString, Val: "b"},
+					&K{Type: KtString, Val: "a"},
+					&K{Type: KtString, Val: "len"},
+				},
+				Is: []Instr{
+					NewInstr(OP_PUSH, FLG_V, 0),
+					NewInstr(OP_PUSH, FLG_V, 1),
+					NewInstr(OP_PUSH, FLG_V, 2),
+				},
+			},
+		},
+	}
+	exp := [][]Instr{
+		{
+			NewInstr(OP_PUSH, FLG_K, 0),
+			NewInstr(OP_POP, FLG_L, 0),
+		},
+		{
+			NewInstr(OP_PUSH, FLG_L, 0),
+			NewInstr(OP_PUSH, FLG_U, UpvalIndex(1, 0)),
+			NewInstr(OP_ADD, FLG__, 0),
+			NewInstr(OP_POP, FLG_L, 1),
+		},
+		{
+			NewInstr(OP_PUSH, FLG_U, UpvalIndex(1, 1)),
+			NewInstr(OP_PUSH, FLG_U, UpvalIndex(2, 0)),
+			NewInstr(OP_PUSH, FLG_V, 2),
+		},
+	}
+
+	// Resolving twice must give the same result
+	for run := 0; run < 2; run++ {
+		f.ResolveVars()
+		for i, fn := range f.Fns {
+			if len(fn.Is) != len(exp[i]) {
+				t.Fatalf("[%d] - expected %d instructions, got %d", i, len(exp[i]), len(fn.Is))
+			}
+			for j, ins := range fn.Is {
+				if ins != exp[i][j] {
+					t.Errorf("[%d:%d] - expected %s, got %s", i, j, exp[i][j], ins)
+				}
+			}
+		}
+	}
+}
+
+func TestUpval(t *testing.T) {
+	depth, slot := Upval(UpvalIndex(3, 12))
+	if depth != 3 || slot != 12 {
+		t.Errorf("expected depth 3 and slot 12, got %d and %d", depth, slot)
+	}
+}
@@ -6,6 +6,12 @@
 // - agora asm : compile an agora assembly code file.
 // - agora dasm : disassemble an agora bytecode into assembly source.
 // - agora ast : generate the abstract syntax tree for an agora source code file.
+// - agora debug : run an agora source code file in the interactive debugger.
+// - agora repl : execute agora statements and expressions interactively.
+// - agora fmt : format agora source code files.
+// - agora vet : report the likely bugs of agora source code files.
+// - agora test : execute the tests of agora source code files.
+// - agora lsp : run the language server of agora source code for editors.
 //
 // See `agora -h` and `agora <cmd> -h` for available options.
 package main
@@ -21,6 +27,7 @@ import (
 
 	"github.com/bobg/agora/bytecode"
 	"github.com/bobg/agora/compiler"
+	syntax "github.com/bobg/agora/compiler/ast"
 	"github.com/bobg/agora/compiler/parser"
 	"github.com/bobg/agora/runtime"
 	"github.com/bobg/agora/runtime/stdlib"
@@ -134,16 +141,7 @@ func (r *run) Execute(ctx context.Context, args []string) error {
 	} else {
 		c = new(compiler.Compiler)
 	}
-	ktx := runtime.NewKtx(new(runtime.FileResolver), c)
-	if !r.NoStdlib {
-		// Register the standard lib's Fmt package
-		ktx.RegisterNativeModule(new(stdlib.FmtMod))
-		ktx.RegisterNativeModule(new(stdlib.FilepathMod))
-		ktx.RegisterNativeModule(new(stdlib.StringsMod))
-		ktx.RegisterNativeModule(new(stdlib.MathMod))
-		ktx.RegisterNativeModule(new(stdlib.OsMod))
-		ktx.RegisterNativeModule(new(stdlib.TimeMod))
-	}
+	ktx := newKtx(c, !r.NoStdlib)
 	ktx.Debug = r.Debug
 	m, err := ktx.Load(args[0])
 	if err != nil {
@@ -168,19 +166,49 @@ func (r *run) Execute(ctx context.Context, args []string) error {
 	if err == nil && !r.NoResult {
 		fmt.Fprintf(outf, "\n= %s (%T)\n", res, res)
 	}
+	if e, ok := err.(*runtime.Error); ok {
+		fmt.Fprintln(os.Stderr, e.StackTrace())
+	}
 	return err
 }
 
+// Create an execution context that resolves modules in the file system, and
+// registers the stdlib if required.
+func newKtx(c runtime.Compiler, withStdlib bool) *runtime.Kontext {
+	ktx := runtime.NewKtx(new(runtime.FileResolver), c)
+	if withStdlib {
+		// Register the standard lib's Fmt package
+		ktx.RegisterNativeModule(new(stdlib.FmtMod))
+		ktx.RegisterNativeModule(new(stdlib.FilepathMod))
+		ktx.RegisterNativeModule(new(stdlib.StringsMod))
+		ktx.RegisterNativeModule(new(stdlib.MathMod))
+		ktx.RegisterNativeModule(new(stdlib.OsMod))
+		ktx.RegisterNativeModule(new(stdlib.TimeMod))
+	}
+	return ktx
+}
+
 // The ast command struct
 type ast struct {
 	Output    string `short:"o" long:"output" description:"output file"`
 	AllErrors bool   `short:"e" long:"all-errors" description:"print all errors"`
+	Format    string `short:"f" long:"format" description:"format of the tree, text, json or sexpr" default:"text"`
 }
 
 func (a *ast) Execute(args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("expected an input file")
 	}
+	var write func(io.Writer, syntax.Node) error
+	switch a.Format {
+	case "text":
+	case "json":
+		write = syntax.FprintJSON
+	case "sexpr":
+		write = syntax.FprintSexpr
+	default:
+		return fmt.Errorf("unknown tree format: %s", a.Format)
+	}
 	f, err := os.Open(args[0])
 	if err != nil {
 		return err
@@ -191,7 +219,15 @@ func (a *ast) Execute(args []string) error {
 		return err
 	}
 	p := parser.New()
-	syms, _, err := p.Parse(args[0], b)
+	var (
+		syms []*parser.Symbol
+		file *syntax.File
+	)
+	if write == nil {
+		syms, _, err = p.Parse(args[0], b)
+	} else {
+		file, err = p.ParseFile(args[0], b)
+	}
 	if err != nil {
 		if a.AllErrors {
 			scanner.PrintError(stdout, err)
@@ -200,13 +236,16 @@ func (a *ast) Execute(args []string) error {
 	}
 	out := stdout
 	if a.Output != "" {
-		outf, err := os.Open(a.Output)
+		outf, err := os.Create(a.Output)
 		if err != nil {
 			return err
 		}
 		defer outf.Close()
 		out = outf
 	}
+	if write != nil {
+		return write(out, file)
+	}
 	for _, sym := range syms {
 		fmt.Fprintln(out, sym)
 	}
@@ -264,15 +303,27 @@ func (v *version) Execute(args []string) error {
 }
 
 func main() {
-	a, d, r, s, b, v := new(asm), new(dasm), new(run), new(ast), new(build), new(version)
+	a, d, r, s, b, v, g, l, f, t, x, n := new(asm), new(dasm), new(run), new(ast), new(build), new(version), new(debug), new(repl), new(formatter), new(vetter), new(tester), new(langServer)
 	p := flags.NewParser(nil, flags.Default)
 	p.AddCommand("asm", "assembler", "compile assembly to bytecode", a)
 	p.AddCommand("dasm", "disassembler", "disassemble bytecode to assembly", d)
 	p.AddCommand("run", "run", "execute a source program", r)
 	p.AddCommand("ast", "abstract syntax tree", "print the AST of a source program", s)
 	p.AddCommand("build", "compiler", "compile a source program", b)
+	p.AddCommand("debug", "debugger", "execute a source program in the interactive debugger", g)
+	p.AddCommand("repl", "read-eval-print loop", "execute statements and expressions interactively", l)
+	p.AddCommand("fmt", "source formatter", "format agora source code files in the canonical style", f)
+	p.AddCommand("vet", "static analyzer", "report the likely bugs of agora source code files", t)
+	p.AddCommand("test", "test runner", "execute the tests of agora source code files", x)
+	p.AddCommand("lsp", "language server", "run the language server protocol over stdin and stdout", n)
 	p.AddCommand("version", "print the current version", "print the current version", v)
 	// In case of errors, usage text is automatically displayed. In case of
 	// success, the Execute() method of the matching command is called.
-	p.Parse()
+	if _, err := p.Parse(); err != nil {
+		// The error is already printed, exit with a failure status so that
+		// scripts can detect it, i.e. the issues found by vet.
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/agora/bytecode"
+	"github.com/bobg/agora/compiler"
+	"github.com/bobg/agora/runtime"
+)
+
+var (
+	// For test purpose
+	stdin io.Reader = os.Stdin
+
+	// Raised to stop the execution when the user quits the debugger
+	errQuit = errors.New("debugger: quit")
+)
+
+// The debug command struct
+type debug struct {
+	NoStdlib bool     `short:"S" long:"no-stdlib" description:"do not import the stdlib"`
+	Break    []string `short:"b" long:"break" description:"set a breakpoint, as file:line or function name"`
+}
+
+// Execute the debug command
+func (d *debug) Execute(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expected an input file")
+	}
+	ktx := newKtx(new(compiler.Compiler), !d.NoStdlib)
+	dbg := newDebugger(ktx, stdin, stdout)
+	for _, b := range d.Break {
+		dbg.addBreakpoint(b)
+	}
+	// Stop at the first line, so that breakpoints can be set
+	dbg.mode = stepIn
+	ktx.Hook = dbg
+
+	m, err := ktx.Load(args[0])
+	if err != nil {
+		return err
+	}
+	vals := make([]runtime.Val, len(args)-1)
+	for i, s := range args[1:] {
+		vals[i] = runtime.String(s)
+	}
+	ctx := context.Background()
+	res, err := m.Run(ctx, vals...)
+	if errors.Is(err, errQuit) {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintf(stdout, "\n= %s\n", dumpVal(res))
+	}
+	return err
+}
+
+// The stepping modes of the debugger.
+type stepMode int
+
+const (
+	stepNone stepMode = iota // run until a breakpoint
+	stepIn                   // stop at the next line
+	stepOver                 // stop at the next line of the same function or a caller
+	stepOut                  // stop at the next line of a caller
+)
+
+// A breakpoint stops the execution at a line of a module, or when a function
+// is entered.
+type breakpoint struct {
+	file string
+	line int64
+	fn   string
+}
+
+// String returns the representation of the breakpoint, as it is set.
+func (b breakpoint) String() string {
+	if b.fn != "" {
+		return b.fn
+	}
+	return fmt.Sprintf("%s:%d", b.file, b.line)
+}
+
+// Check if the breakpoint is on the line of the module mod.
+func (b breakpoint) matchLine(mod string, line int64) bool {
+	if b.fn != "" || b.line != line {
+		return false
+	}
+	mod = strings.TrimSuffix(filepath.ToSlash(mod), ".agora")
+	file := strings.TrimSuffix(filepath.ToSlash(b.file), ".agora")
+	return mod == file || strings.HasSuffix(mod, "/"+file) || strings.HasSuffix(file, "/"+mod)
+}
+
+// A debugger is a runtime.Hook that stops the execution at breakpoints or
+// while stepping, and runs an interactive prompt.
+type debugger struct {
+	ktx *runtime.Kontext
+	in  *bufio.Scanner
+	out io.Writer
+
+	bps      []breakpoint
+	mode     stepMode
+	depth    int            // depth of the frame when stepping over or out
+	stopNext bool           // stop at the next line, a function breakpoint was hit
+	panicErr *runtime.Error // last error reported
+	busy     bool           // ignore events while evaluating an expression
+	last     string         // last command, repeated on an empty line
+	sources  map[string][]string
+}
+
+// Create a debugger for the execution context, reading commands from in
+// and writing to out.
+func newDebugger(ktx *runtime.Kontext, in io.Reader, out io.Writer) *debugger {
+	return &debugger{
+		ktx:     ktx,
+		in:      bufio.NewScanner(in),
+		out:     out,
+		sources: make(map[string][]string),
+	}
+}
+
+// Enter stops at the first line of the function if it has a breakpoint.
+func (d *debugger) Enter(ctx context.Context, f *runtime.DebugFrame) {
+	if d.busy {
+		return
+	}
+	for _, b := range d.bps {
+		if b.fn != "" && b.fn == f.Name() {
+			d.stopNext = true
+		}
+	}
+}
+
+// Exit does nothing.
+func (d *debugger) Exit(ctx context.Context, f *runtime.DebugFrame, v runtime.Val) {}
+
+// Line stops the execution if a breakpoint is on this line or if stepping.
+func (d *debugger) Line(ctx context.Context, f *runtime.DebugFrame, i bytecode.Instr) {
+	if d.busy {
+		return
+	}
+	stop := d.stopNext
+	switch d.mode {
+	case stepIn:
+		stop = true
+	case stepOver:
+		stop = stop || f.Depth() <= d.depth
+	case stepOut:
+		stop = stop || f.Depth() < d.depth
+	}
+	for _, b := range d.bps {
+		if b.matchLine(f.Module(), f.Line()) {
+			stop = true
+		}
+	}
+	if stop {
+		d.prompt(ctx, f)
+	}
+}
+
+// Panic stops the execution in the function that raised the error, so that
+// its state can be inspected.
+func (d *debugger) Panic(ctx context.Context, f *runtime.DebugFrame, err *runtime.Error) {
+	if d.busy || err == d.panicErr || errors.Is(err, errQuit) {
+		return
+	}
+	d.panicErr = err
+	fmt.Fprintf(d.out, "panic: %s\n", err.Message)
+	d.prompt(ctx, f)
+}
+
+// Run the interactive prompt until a command resumes the execution.
+func (d *debugger) prompt(ctx context.Context, f *runtime.DebugFrame) {
+	d.stopNext = false
+	d.mode = stepNone
+	fmt.Fprintf(d.out, "%s:%d (%s)\n", f.Module(), f.Line(), f.Name())
+	d.printLine(f, f.Line())
+	for {
+		fmt.Fprint(d.out, "(agora) ")
+		if !d.in.Scan() {
+			panic(errQuit)
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		cmd, arg := line, ""
+		if ix := strings.IndexAny(line, " \t"); ix >= 0 {
+			cmd, arg = line[:ix], strings.TrimSpace(line[ix+1:])
+		}
+		switch cmd {
+		case "":
+		case "c", "continue":
+			return
+		case "s", "step":
+			d.mode = stepIn
+			return
+		case "n", "next":
+			d.mode, d.depth = stepOver, f.Depth()
+			return
+		case "o", "out":
+			d.mode, d.depth = stepOut, f.Depth()
+			return
+		case "b", "break":
+			if arg == "" {
+				for i, b := range d.bps {
+					fmt.Fprintf(d.out, "%d: %s\n", i+1, b)
+				}
+			} else {
+				d.addBreakpoint(arg)
+			}
+		case "d", "delete":
+			d.deleteBreakpoint(arg)
+		case "bt", "backtrace":
+			for i, frm := range d.ktx.CallStack() {
+				fmt.Fprintf(d.out, "#%d %s\n", i, frm)
+			}
+		case "l", "list":
+			for l := f.Line() - 5; l <= f.Line()+5; l++ {
+				d.printLine(f, l)
+			}
+		case "locals":
+			for _, nm := range f.Locals() {
+				v, _ := f.Local(nm)
+				fmt.Fprintf(d.out, "%s = %s\n", nm, dumpVal(v))
+			}
+		case "p", "print":
+			if v, err := d.eval(ctx, f, arg); err != nil {
+				fmt.Fprintf(d.out, "error: %s\n", err)
+			} else {
+				fmt.Fprintln(d.out, dumpVal(v))
+			}
+		case "set":
+			d.set(ctx, f, arg)
+		case "q", "quit":
+			panic(errQuit)
+		case "h", "help":
+			fmt.Fprint(d.out, debugHelp)
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, type help for the list of commands\n", cmd)
+		}
+	}
+}
+
+// The help of the debugger's prompt.
+const debugHelp = `Commands:
+  c, continue       run until the next breakpoint
+  s, step           stop at the next line, entering function calls
+  n, next           stop at the next line of the current function
+  o, out            stop at the next line of the calling function
+  b, break [BP]     set a breakpoint at file:line or at a function name,
+                    or list the breakpoints
+  d, delete [N]     delete the breakpoint N, or all breakpoints
+  bt, backtrace     print the call stack
+  l, list           print the source code around the current line
+  locals            print the local variables of the current function
+  p, print EXPR     evaluate and print an expression in the current function
+  set NAME = EXPR   set a local variable to the value of an expression
+  q, quit           stop the execution and exit
+  h, help           print this help
+An empty line repeats the last command.
+`
+
+// Add the breakpoint described by s, either file:line or a function name.
+func (d *debugger) addBreakpoint(s string) {
+	var b breakpoint
+	if ix := strings.LastIndex(s, ":"); ix >= 0 {
+		l, err := strconv.ParseInt(s[ix+1:], 10, 64)
+		if err != nil || l <= 0 {
+			fmt.Fprintf(d.out, "invalid line number in breakpoint %s\n", s)
+			return
+		}
+		b.file, b.line = s[:ix], l
+	} else {
+		b.fn = s
+	}
+	d.bps = append(d.bps, b)
+	fmt.Fprintf(d.out, "breakpoint %d at %s\n", len(d.bps), b)
+}
+
+// Delete the breakpoint identified by its number s, or all breakpoints if
+// s is empty.
+func (d *debugger) deleteBreakpoint(s string) {
+	if s == "" {
+		d.bps = nil
+		return
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(d.bps) {
+		fmt.Fprintf(d.out, "no breakpoint %s\n", s)
+		return
+	}
+	d.bps = append(d.bps[:n-1], d.bps[n:]...)
+}
+
+// Evaluate the expression in the frame, ignoring the events of its execution.
+func (d *debugger) eval(ctx context.Context, f *runtime.DebugFrame, expr string) (runtime.Val, error) {
+	if expr == "" {
+		return nil, errors.New("expected an expression")
+	}
+	d.busy = true
+	defer func() {
+		d.busy = false
+	}()
+	return f.Eval(ctx, expr)
+}
+
+// Set the local variable described by s, as `name = expr`.
+func (d *debugger) set(ctx context.Context, f *runtime.DebugFrame, s string) {
+	ix := strings.Index(s, "=")
+	if ix < 0 {
+		fmt.Fprintln(d.out, "expected name = expression")
+		return
+	}
+	nm := strings.TrimSpace(s[:ix])
+	if _, ok := f.Local(nm); !ok {
+		fmt.Fprintf(d.out, "no local variable %s\n", nm)
+		return
+	}
+	v, err := d.eval(ctx, f, s[ix+1:])
+	if err != nil {
+		fmt.Fprintf(d.out, "error: %s\n", err)
+		return
+	}
+	f.SetLocal(nm, v)
+	fmt.Fprintf(d.out, "%s = %s\n", nm, dumpVal(v))
+}
+
+// Print the line l of the source code of the frame's module, if it exists,
+// marking the current line.
+func (d *debugger) printLine(f *runtime.DebugFrame, l int64) {
+	src := d.source(f.Module())
+	if l < 1 || l > int64(len(src)) {
+		return
+	}
+	mark := " "
+	if l == f.Line() {
+		mark = ">"
+	}
+	fmt.Fprintf(d.out, "%s %4d  %s\n", mark, l, src[l-1])
+}
+
+// Get the lines of the source code of the module, if it can be resolved.
+func (d *debugger) source(mod string) []string {
+	if src, ok := d.sources[mod]; ok {
+		return src
+	}
+	var src []string
+	if r, err := d.ktx.Resolver.Resolve(mod); err == nil {
+		if b, err := ioutil.ReadAll(r); err == nil {
+			src = strings.Split(string(b), "\n")
+		}
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+	}
+	d.sources[mod] = src
+	return src
+}
+
+// Get the debugging representation of v.
+func dumpVal(v runtime.Val) string {
+	if dmp, ok := v.(runtime.Dumper); ok {
+		return dmp.Dump()
+	}
+	return fmt.Sprintf("%v", v)
+}
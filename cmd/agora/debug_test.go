@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The first line of testdata/debug.agora, where the debugger stops at start.
+const debugStart = "testdata/debug.agora:1 (testdata/debug.agora)\n>    1  func add(a, b) {\n"
+
+var debugCases = []struct {
+	bps    []string
+	script string
+	exp    string
+	err    string
+}{
+	0: {
+		// Function breakpoint, locals, set, next and print
+		bps:    []string{"add"},
+		script: "c\nlocals\nset a = 10\nn\np c\nc\n",
+		exp: "breakpoint 1 at add\n" + debugStart +
+			"(agora) testdata/debug.agora:2 (add)\n>    2  \tc := a + b\n" +
+			"(agora) a = 1 (Number)\nb = 2 (Number)\nc = [Nil]\n" +
+			"(agora) a = 10 (Number)\n" +
+			"(agora) testdata/debug.agora:3 (add)\n>    3  \treturn c\n" +
+			"(agora) 12 (Number)\n" +
+			"(agora) \n= 24 (Number)\n",
+	},
+	1: {
+		// Step into the function, backtrace and step out
+		script: "s\ns\ns\nbt\no\nc\n",
+		exp: debugStart +
+			"(agora) testdata/debug.agora:5 (testdata/debug.agora)\n>    5  x := 1\n" +
+			"(agora) testdata/debug.agora:6 (testdata/debug.agora)\n>    6  y := add(x, 2)\n" +
+			"(agora) testdata/debug.agora:2 (add)\n>    2  \tc := a + b\n" +
+			"(agora) #0 add (testdata/debug.agora:2)\n#1 testdata/debug.agora (testdata/debug.agora:6)\n" +
+			"(agora) testdata/debug.agora:7 (testdata/debug.agora)\n>    7  if y > 100 {\n" +
+			"(agora) \n= 6 (Number)\n",
+	},
+	2: {
+		// Line breakpoint, and stop on the panic
+		script: "b testdata/debug.agora:6\nc\nset x = 200\nc\np y\nc\n",
+		exp: debugStart +
+			"(agora) breakpoint 1 at testdata/debug.agora:6\n" +
+			"(agora) testdata/debug.agora:6 (testdata/debug.agora)\n>    6  y := add(x, 2)\n" +
+			"(agora) x = 200 (Number)\n" +
+			"(agora) panic: too big\ntestdata/debug.agora:8 (testdata/debug.agora)\n>    8  \tpanic(\"too big\")\n" +
+			"(agora) 202 (Number)\n" +
+			"(agora) ",
+		err: "too big",
+	},
+	3: {
+		// Quit
+		script: "q\nc\n",
+		exp:    debugStart + "(agora) ",
+	},
+	4: {
+		// The end of the input quits
+		exp: debugStart + "(agora) ",
+	},
+	5: {
+		// List and delete the breakpoints, an empty line repeats the command
+		script: "b add\nb debug:3\nb\nd 1\nb\nfoo\nc\n\n",
+		exp: debugStart +
+			"(agora) breakpoint 1 at add\n" +
+			"(agora) breakpoint 2 at debug:3\n" +
+			"(agora) 1: add\n2: debug:3\n" +
+			"(agora) (agora) 1: debug:3\n" +
+			"(agora) unknown command \"foo\", type help for the list of commands\n" +
+			"(agora) testdata/debug.agora:3 (add)\n>    3  \treturn c\n" +
+			"(agora) \n= 6 (Number)\n",
+	},
+}
+
+// Execute the debug command on the source file with the commands of script
+// as input, and return its output.
+func debugSession(d *debug, file, script string) (string, error) {
+	var out bytes.Buffer
+	oldIn, oldOut := stdin, stdout
+	stdin, stdout = strings.NewReader(script), &out
+	defer func() {
+		stdin, stdout = oldIn, oldOut
+	}()
+	err := d.Execute([]string{file})
+	return out.String(), err
+}
+
+func TestDebug(t *testing.T) {
+	for i, c := range debugCases {
+		out, err := debugSession(&debug{Break: c.bps}, "testdata/debug.agora", c.script)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("[%d] - expected error %s, got %v", i, c.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("[%d] - unexpected error: %s", i, err)
+		}
+		if out != c.exp {
+			t.Errorf("[%d] - expected\n%s\ngot\n%s", i, c.exp, out)
+		}
+	}
+}
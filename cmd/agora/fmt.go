@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/bobg/agora/compiler/format"
+	"github.com/bobg/agora/compiler/parser"
+)
+
+// The fmt command struct
+type formatter struct {
+	Write   bool     `short:"w" long:"write" description:"write the result to the source file instead of stdout"`
+	Diff    bool     `short:"d" long:"diff" description:"print the diffs instead of the formatted source"`
+	Globals []string `short:"g" long:"global" description:"name of a global value defined by the host program"`
+}
+
+// Execute the fmt command. The arguments are files or directories, all .agora
+// files of a directory are formatted. Without arguments, the standard input
+// is formatted.
+func (f *formatter) Execute(args []string) error {
+	if len(args) == 0 {
+		if f.Write {
+			return fmt.Errorf("cannot use -w with the standard input")
+		}
+		return f.format("<stdin>", stdin)
+	}
+	return walkSources(args, f.format)
+}
+
+// Call fn for each source file of args, files or directories, in which case
+// fn is called for all .agora files of the directory and its sub-directories.
+func walkSources(args []string, fn func(string, io.Reader) error) error {
+	for _, arg := range args {
+		err := filepath.Walk(arg, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || (path != arg && filepath.Ext(path) != ".agora") {
+				return nil
+			}
+			inf, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer inf.Close()
+			return fn(path, inf)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format the source code read from r, identified by path.
+func (f *formatter) format(path string, r io.Reader) error {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	res, err := format.SourceWithGlobals(src, f.globals())
+	if err != nil {
+		return fmt.Errorf("%s:%s", path, err)
+	}
+	if f.Diff {
+		if !bytes.Equal(src, res) {
+			d, err := diff(path, src, res)
+			if err != nil {
+				return err
+			}
+			stdout.Write(d)
+		}
+	}
+	if f.Write {
+		if !bytes.Equal(src, res) {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(path, res, fi.Mode().Perm())
+		}
+	}
+	if !f.Diff && !f.Write {
+		stdout.Write(res)
+	}
+	return nil
+}
+
+// Get the globals for the parser, nil for the default built-ins, otherwise
+// the default built-ins and the ones provided.
+func (f *formatter) globals() []string {
+	if len(f.Globals) == 0 {
+		return nil
+	}
+	return append(append([]string(nil), parser.DefaultGlobals...), f.Globals...)
+}
+
+// Get the unified diff between the source code a and b of path, using the
+// diff command.
+func diff(path string, a, b []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "agora-fmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	fa, fb := filepath.Join(dir, "orig"), filepath.Join(dir, "formatted")
+	if err := ioutil.WriteFile(fa, a, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(fb, b, 0600); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("diff", "-u", "--label", path+".orig", "--label", path, fa, fb).CombinedOutput()
+	if len(out) > 0 {
+		// diff exits with status 1 when the files differ
+		err = nil
+	}
+	return out, err
+}
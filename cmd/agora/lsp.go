@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bobg/agora/lsp"
+)
+
+// The lsp command struct
+type langServer struct {
+	Globals []string `short:"g" long:"global" description:"name of a global value defined by the host program"`
+}
+
+// Execute the lsp command, a language server that communicates with the
+// editor over the standard input and output.
+func (l *langServer) Execute(args []string) error {
+	s := &lsp.Server{
+		Globals: l.Globals,
+	}
+	return s.Serve(context.Background(), stdin, stdout)
+}
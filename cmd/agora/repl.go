@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/bobg/agora/bytecode"
+	"github.com/bobg/agora/compiler"
+	"github.com/bobg/agora/compiler/emitter"
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/scanner"
+	"github.com/bobg/agora/compiler/token"
+	"github.com/bobg/agora/runtime"
+)
+
+// The repl command struct
+type repl struct {
+	NoStdlib bool `short:"S" long:"no-stdlib" description:"do not import the stdlib"`
+}
+
+// Execute the repl command
+func (r *repl) Execute(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments")
+	}
+	s := newReplSession(newKtx(nil, !r.NoStdlib), stdout)
+	s.loop(context.Background(), stdin)
+	return nil
+}
+
+// A replSession executes the inputs of the REPL in the same execution context.
+// The top-level variables of the inputs are global variables of the execution
+// context, so that they are kept from one input to the next.
+//
+// It is the module resolver and the compiler of the execution context, so
+// that the inputs are compiled with their top-level variables as globals,
+// while imported modules are compiled as usual.
+type replSession struct {
+	ktx      *runtime.Kontext
+	out      io.Writer
+	builtins []string          // global values defined before the session
+	inputs   map[string]string // source code of the inputs, by module ID
+	n        int               // number of inputs
+}
+
+// Create a REPL session in the execution context, writing to out.
+func newReplSession(ktx *runtime.Kontext, out io.Writer) *replSession {
+	s := &replSession{
+		ktx:      ktx,
+		out:      out,
+		builtins: ktx.Globals(),
+		inputs:   make(map[string]string),
+	}
+	ktx.Resolver = s
+	ktx.Compiler = s
+	return s
+}
+
+// The help of the REPL.
+const replHelp = `Enter agora statements or expressions, their value is printed if it is not nil.
+The input continues on the next line while a brace, bracket or parenthesis is open.
+Commands:
+  :load FILE   execute the source code file in the session
+  :quit        exit the REPL
+  :help        print this help
+`
+
+// Read and execute the inputs from in until the end of the input or the
+// :quit command.
+func (s *replSession) loop(ctx context.Context, in io.Reader) {
+	sc := bufio.NewScanner(in)
+	var buf []string
+	for {
+		if len(buf) == 0 {
+			fmt.Fprint(s.out, "> ")
+		} else {
+			fmt.Fprint(s.out, "... ")
+		}
+		if !sc.Scan() {
+			fmt.Fprintln(s.out)
+			return
+		}
+		line := sc.Text()
+		if len(buf) == 0 {
+			cmd := strings.Fields(line)
+			if len(cmd) > 0 && strings.HasPrefix(cmd[0], ":") {
+				switch cmd[0] {
+				case ":q", ":quit":
+					return
+				case ":h", ":help":
+					fmt.Fprint(s.out, replHelp)
+				case ":l", ":load":
+					if len(cmd) != 2 {
+						fmt.Fprintln(s.out, "expected a file name")
+					} else {
+						s.load(ctx, cmd[1])
+					}
+				default:
+					fmt.Fprintf(s.out, "unknown command %s, type :help for the list of commands\n", cmd[0])
+				}
+				continue
+			}
+		}
+		buf = append(buf, line)
+		src := strings.Join(buf, "\n")
+		if openBrackets(src) > 0 {
+			continue
+		}
+		buf = buf[:0]
+		if strings.TrimSpace(src) != "" {
+			s.n++
+			s.exec(ctx, fmt.Sprintf("<input %d>", s.n), src, true)
+		}
+	}
+}
+
+// Execute the source code file nm in the session.
+func (s *replSession) load(ctx context.Context, nm string) {
+	r, err := new(runtime.FileResolver).Resolve(nm)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	b, err := ioutil.ReadAll(r)
+	if rc, ok := r.(io.Closer); ok {
+		rc.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	// Each execution is a new module, the ID must be unique
+	s.n++
+	s.exec(ctx, fmt.Sprintf("%s#%d", nm, s.n), string(b), false)
+}
+
+// Execute the source code src as the module id. If expr is true, src is first
+// compiled as an expression. The returned value is printed, as are the errors,
+// the state of the session is kept.
+func (s *replSession) exec(ctx context.Context, id, src string, expr bool) {
+	var m runtime.Module
+	var err error
+	if expr {
+		m, err = s.compile(id, "return "+src)
+	}
+	if !expr || err != nil {
+		if m, err = s.compile(id, src); err != nil {
+			fmt.Fprintln(s.out, err)
+			return
+		}
+	}
+	v, err := m.Run(ctx)
+	if e, ok := err.(*runtime.Error); ok {
+		fmt.Fprintln(s.out, e.StackTrace())
+	} else if err != nil {
+		fmt.Fprintln(s.out, err)
+	} else if v != runtime.Nil {
+		fmt.Fprintf(s.out, "= %s\n", dumpVal(v))
+	}
+}
+
+// Compile the source code src as the module id. The module is executed once,
+// it is not kept in the cache of the execution context.
+func (s *replSession) compile(id, src string) (runtime.Module, error) {
+	s.inputs[id] = src
+	defer delete(s.inputs, id)
+	defer s.ktx.Unload(id)
+	return s.ktx.Load(id)
+}
+
+// Resolve returns the source code of an input, or resolves the module in the
+// file system.
+func (s *replSession) Resolve(id string) (io.Reader, error) {
+	if src, ok := s.inputs[id]; ok {
+		return strings.NewReader(src), nil
+	}
+	return new(runtime.FileResolver).Resolve(id)
+}
+
+// Compile compiles the module id.
+func (s *replSession) Compile(id string, r io.Reader) (*bytecode.File, error) {
+	return s.CompileWithGlobals(id, r, s.ktx.Globals())
+}
+
+// CompileWithGlobals compiles an input with its top-level variables as global
+// variables, where the globals that are not built-ins are the global variables
+// of the previous inputs. Other modules are compiled as usual, they only see
+// the built-ins.
+func (s *replSession) CompileWithGlobals(id string, r io.Reader, globals []string) (*bytecode.File, error) {
+	if _, ok := s.inputs[id]; !ok {
+		return new(compiler.Compiler).CompileWithGlobals(id, r, s.builtins)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	builtin := make(map[string]bool, len(s.builtins))
+	for _, nm := range s.builtins {
+		builtin[nm] = true
+	}
+	p := parser.New()
+	p.Globals = s.builtins
+	for _, nm := range globals {
+		if !builtin[nm] {
+			p.Vars = append(p.Vars, nm)
+		}
+	}
+	file, err := p.ParseFile(id, b)
+	if err != nil {
+		return nil, err
+	}
+	e := new(emitter.Emitter)
+	e.GlobalVars = true
+	return e.Emit(id, file)
+}
+
+// Get the number of braces, brackets and parentheses left open in src.
+func openBrackets(src string) int {
+	var sc scanner.Scanner
+	sc.Init("", []byte(src), func(token.Position, string) {})
+	n := 0
+	for {
+		tok, _, _ := sc.Scan()
+		switch tok {
+		case token.EOF:
+			return n
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			n++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			n--
+		}
+	}
+}
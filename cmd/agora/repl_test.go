@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var replCases = []struct {
+	script string
+	exp    string
+}{
+	0: {
+		// The variables and functions are kept from one input to the next, an
+		// input continues while a brace is open
+		script: "x := 1\nx + 1\nfunc f(a) {\nreturn a + x\n}\nf(2)\n",
+		exp:    "> > = 2 (Number)\n> ... ... > = 3 (Number)\n> \n",
+	},
+	1: {
+		// The session goes on after the errors
+		script: "y := \nz := [1,\n2,\n]\nlen(z)\nundefinedVar + 1\nnil.a\nlen(z)\n",
+		exp: "> <input 1>:1:6: unexpected end of input\n" +
+			"> ... ... > = 2 (Number)\n" +
+			"> <input 4>:1:1: [tok: (name) ; sym: (name) ; val: undefinedVar] undefined (and 1 more errors)\n" +
+			"> type error: object not allowed with type nil\n\t<input 5> (<input 5>:1)\n" +
+			"> = 2 (Number)\n> \n",
+	},
+	2: {
+		// Commands
+		script: ":load testdata/repl.agora\nloaded\ndouble(3)\n:load\n:foo\n:quit\n1\n",
+		exp: "> > = 42 (Number)\n" +
+			"> = 6 (Number)\n" +
+			"> expected a file name\n" +
+			"> unknown command :foo, type :help for the list of commands\n> ",
+	},
+}
+
+func TestRepl(t *testing.T) {
+	for i, c := range replCases {
+		var out bytes.Buffer
+		s := newReplSession(newKtx(nil, true), &out)
+		s.loop(context.Background(), strings.NewReader(c.script))
+		if out.String() != c.exp {
+			t.Errorf("[%d] - expected\n%s\ngot\n%s", i, c.exp, out.String())
+		}
+		// The modules of the inputs are not kept
+		for n := 1; n <= s.n; n++ {
+			for _, id := range []string{fmt.Sprintf("<input %d>", n), fmt.Sprintf("testdata/repl.agora#%d", n)} {
+				if _, err := s.ktx.Load(id); err == nil {
+					t.Errorf("[%d] - expected the module %s to be unloaded", i, id)
+				}
+			}
+		}
+	}
+}
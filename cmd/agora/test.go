@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/bobg/agora/agoratest"
+	"github.com/bobg/agora/runtime"
+)
+
+// The test command struct
+type tester struct {
+	Verbose  bool          `short:"v" long:"verbose" description:"list all tests, not just the failures"`
+	Run      string        `short:"r" long:"run" description:"run only the tests whose name matches this regular expression"`
+	Format   string        `short:"f" long:"format" description:"format of the report, plain or junit" default:"plain"`
+	Output   string        `short:"o" long:"output" description:"output file of the report"`
+	Timeout  time.Duration `short:"t" long:"timeout" description:"maximum duration of a test, i.e. 10s (0 means no limit)"`
+	NoStdlib bool          `short:"S" long:"no-stdlib" description:"do not import the stdlib"`
+}
+
+// Execute the test command. The arguments are test files or directories, in
+// which case all *_test.agora files of the directory and its sub-directories
+// are executed. Without arguments, the current directory is used.
+func (t *tester) Execute(args []string) error {
+	var write func(io.Writer, []*agoratest.FileResult) error
+	switch t.Format {
+	case "plain":
+		write = func(w io.Writer, res []*agoratest.FileResult) error {
+			return agoratest.WritePlain(w, res, t.Verbose)
+		}
+	case "junit":
+		write = agoratest.WriteJUnit
+	default:
+		return fmt.Errorf("unknown report format: %s", t.Format)
+	}
+	r := &agoratest.Runner{
+		Timeout: t.Timeout,
+	}
+	if t.Run != "" {
+		rx, err := regexp.Compile(t.Run)
+		if err != nil {
+			return err
+		}
+		r.Run = rx
+	}
+	if t.NoStdlib {
+		r.Setup = func(*runtime.Kontext) {}
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	// Execute the tests
+	ctx := context.Background()
+	var res []*agoratest.FileResult
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			res = append(res, r.RunFile(ctx, arg))
+			continue
+		}
+		fres, err := r.RunDir(ctx, arg)
+		if err != nil {
+			return err
+		}
+		res = append(res, fres...)
+	}
+
+	// Write the report
+	out := stdout
+	if t.Output != "" {
+		outf, err := os.Create(t.Output)
+		if err != nil {
+			return err
+		}
+		defer outf.Close()
+		out = outf
+	}
+	if err := write(out, res); err != nil {
+		return err
+	}
+	n := 0
+	for _, fr := range res {
+		if fr.Failed() {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d test file(s) failed", n)
+	}
+	return nil
+}
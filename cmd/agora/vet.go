@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/scanner"
+	"github.com/bobg/agora/compiler/vet"
+)
+
+// The vet command struct
+type vetter struct {
+	Globals []string `short:"g" long:"global" description:"name of a global value defined by the host program"`
+
+	n int // number of issues and errors found
+}
+
+// Execute the vet command. The arguments are files or directories, all .agora
+// files of a directory are checked. Without arguments, the standard input is
+// checked.
+func (v *vetter) Execute(args []string) error {
+	var err error
+	v.n = 0
+	if len(args) == 0 {
+		err = v.check("<stdin>", stdin)
+	} else {
+		err = walkSources(args, v.check)
+	}
+	if err != nil {
+		return err
+	}
+	if v.n > 0 {
+		return fmt.Errorf("%d issue(s) found", v.n)
+	}
+	return nil
+}
+
+// Check the source code read from r, identified by path, and print the issues.
+func (v *vetter) check(path string, r io.Reader) error {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var globals []string
+	if len(v.Globals) > 0 {
+		globals = append(append(globals, parser.DefaultGlobals...), v.Globals...)
+	}
+	issues, err := vet.SourceWithGlobals(path, src, globals)
+	if err != nil {
+		// Print all parse errors, not just the summary
+		if el, ok := err.(scanner.ErrorList); ok {
+			scanner.PrintError(stdout, el)
+			v.n += len(el)
+			return nil
+		}
+		return err
+	}
+	for _, i := range issues {
+		fmt.Fprintln(stdout, i)
+	}
+	v.n += len(issues)
+	return nil
+}
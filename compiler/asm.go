@@ -13,6 +13,7 @@ import (
 var (
 	// Predefined errors
 	ErrInvalidInstruction = errors.New("invalid instruction")
+	ErrInvalidPosition    = errors.New("invalid line table position")
 	ErrNoInput            = errors.New("no input provided")
 )
 
@@ -106,8 +107,8 @@ func (a *Asm) readLs(fn *bytecode.Fn) {
 func (a *Asm) readIs(fn *bytecode.Fn) {
 	var l string
 	var ok bool
-	// While a new F section is not reached
-	for l, ok = a.getLine(false); ok && l != "[f]"; l, ok = a.getLine(false) {
+	// While a new F section or the optional P section is not reached
+	for l, ok = a.getLine(false); ok && l != "[f]" && l != "[p]"; l, ok = a.getLine(false) {
 		// Split in three parts
 		parts := strings.SplitN(l, " ", 3)
 		if a.assertIParts(parts) {
@@ -118,6 +119,35 @@ func (a *Asm) readIs(fn *bytecode.Fn) {
 			fn.Is = append(fn.Is, bytecode.NewInstr(o, f, ix))
 		}
 	}
+	if ok {
+		if l == "[p]" {
+			a.readPs(fn)
+		} else {
+			a.readFn()
+		}
+	}
+}
+
+func (a *Asm) readPs(fn *bytecode.Fn) {
+	var l string
+	var ok bool
+	// While a new F section is not reached
+	for l, ok = a.getLine(false); ok && l != "[f]"; l, ok = a.getLine(false) {
+		// Split in two parts, the instruction index and the line
+		parts := strings.SplitN(l, " ", 2)
+		if len(parts) != 2 {
+			if a.err == nil {
+				a.err = ErrInvalidPosition
+			}
+			return
+		}
+		var p bytecode.Pos
+		p.Ix, a.err = strconv.ParseInt(parts[0], 10, 64)
+		if a.err == nil {
+			p.Line, a.err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		}
+		fn.Ps = append(fn.Ps, p)
+	}
 	if ok {
 		a.readFn()
 	}
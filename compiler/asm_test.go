@@ -33,8 +33,8 @@ var (
 			exp: AppendAny(SigVer(bytecode.Version()), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 		},
 		2: {
 			// Full valid func
@@ -57,6 +57,9 @@ POP V 0  // Pop the value from the stack into variable identified by constant 0
 PUSH V 0 // Push value of variable identified by constant 0 on the stack (a)
 DUMP S 1
 RET _ 0
+[p]
+0 1
+3 2
 `,
 			exp: AppendAny(SigVer(bytecode.Version()), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
@@ -69,6 +72,8 @@ RET _ 0
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("PUSH"), bytecode.NewFlag("V"), 0))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("DUMP"), bytecode.NewFlag("S"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// 2 Ps
+				Int64ToByteSlice(2), ExpZeroInt64, Int64ToByteSlice(1), Int64ToByteSlice(3), Int64ToByteSlice(2),
 			),
 		},
 		3: {
@@ -181,6 +186,8 @@ RET _ 0
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("CALL"), bytecode.NewFlag("A"), 2))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("DUMP"), bytecode.NewFlag("S"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// Ps
+				ExpZeroInt64,
 				// 2nd fn
 				Int64ToByteSlice(3), 'A', 'd', 'd',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
@@ -192,6 +199,8 @@ RET _ 0
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("PUSH"), bytecode.NewFlag("V"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("ADD"), bytecode.NewFlag("_"), 0))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// Ps
+				ExpZeroInt64,
 			),
 		},
 	}
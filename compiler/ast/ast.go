@@ -0,0 +1,526 @@
+// Package ast declares the types used to represent the syntax tree of agora
+// source code, as produced by the parser.
+//
+// All nodes carry the positions of their tokens in the source code, and the
+// identifiers are resolved to their definition, so that tools such as the
+// emitter, the formatter or the linter can work on a typed tree.
+package ast
+
+import (
+	"strings"
+
+	"github.com/bobg/agora/compiler/token"
+)
+
+// A Node is a node of the syntax tree.
+type Node interface {
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position of the first character immediately after the node
+}
+
+// An Expr is an expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// A Stmt is a statement node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// A Comment is a comment of the source code, including its delimiters.
+type Comment struct {
+	Slash token.Position // position of the leading slash
+	Text  string
+}
+
+func (c *Comment) Pos() token.Position { return c.Slash }
+func (c *Comment) End() token.Position { return after(c.Slash, c.Text) }
+
+// Expressions.
+type (
+	// A BadExpr is a placeholder for an expression with syntax errors.
+	BadExpr struct {
+		From, To token.Position
+	}
+
+	// An Ident is a name: a variable, a parameter, a function, a label, a
+	// field name, an object key or a global value.
+	Ident struct {
+		NamePos token.Position
+		Name    string
+		// Def is the identifier of the variable, parameter or function that
+		// the name refers to, the identifier itself if it is the definition. It
+		// is nil if the name is not defined in the source code, i.e. for a global
+		// value, a label, a field name or an object key.
+		Def *Ident
+		// Global is true if the name is a global value provided by the
+		// execution context, such as a built-in function.
+		Global bool
+	}
+
+	// A BasicLit is a number or a string literal. Value is the literal as
+	// written in the source code, i.e. with the quotes of a string.
+	BasicLit struct {
+		ValuePos token.Position
+		Kind     token.Token // token.INT, token.FLOAT or token.STRING
+		Value    string
+	}
+
+	// A BoolLit is the true or the false constant.
+	BoolLit struct {
+		ValuePos token.Position
+		Value    bool
+	}
+
+	// A NilLit is the nil constant.
+	NilLit struct {
+		NilPos token.Position
+	}
+
+	// A ThisExpr is the this keyword.
+	ThisExpr struct {
+		This token.Position
+	}
+
+	// An ArgsExpr is the args keyword.
+	ArgsExpr struct {
+		Args token.Position
+	}
+
+	// A ParenExpr is a parenthesized expression.
+	ParenExpr struct {
+		Lparen token.Position
+		X      Expr
+		Rparen token.Position
+	}
+
+	// An ArrayLit is an array literal, i.e. `[1, 2]`.
+	ArrayLit struct {
+		Lbrack token.Position
+		Elts   []Expr
+		Rbrack token.Position
+	}
+
+	// An ObjectLit is an object literal, i.e. `{a: 1, b: 2}`.
+	ObjectLit struct {
+		Lbrace token.Position
+		Elts   []*KeyValueExpr
+		Rbrace token.Position
+	}
+
+	// A KeyValueExpr is a field of an object literal. The key is an *Ident or
+	// a *BasicLit.
+	KeyValueExpr struct {
+		Key   Expr
+		Value Expr
+	}
+
+	// A SelectorExpr is a field selector, i.e. `x.name`.
+	SelectorExpr struct {
+		X   Expr
+		Sel *Ident
+	}
+
+	// An IndexExpr is a field selected by an expression, i.e. `x[i]`.
+	IndexExpr struct {
+		X      Expr
+		Lbrack token.Position
+		Index  Expr
+		Rbrack token.Position
+	}
+
+	// A CallExpr is a function call, or a method call if Fun is a *SelectorExpr
+	// or an *IndexExpr.
+	CallExpr struct {
+		Fun    Expr
+		Lparen token.Position
+		Args   []Expr
+		Rparen token.Position
+	}
+
+	// A UnaryExpr is a unary expression, the operator being token.SUB or
+	// token.NOT.
+	UnaryExpr struct {
+		OpPos token.Position
+		Op    token.Token
+		X     Expr
+	}
+
+	// A BinaryExpr is a binary expression, including the token.AND and token.OR
+	// logical operators.
+	BinaryExpr struct {
+		X     Expr
+		OpPos token.Position
+		Op    token.Token
+		Y     Expr
+	}
+
+	// A CondExpr is a ternary expression, i.e. `cond ? x : y`.
+	CondExpr struct {
+		Cond     Expr
+		Question token.Position
+		Then     Expr
+		Else     Expr
+	}
+
+	// A FuncLit is a function, either an expression or the function of a
+	// FuncDecl statement. The last statement of its body is always a return
+	// statement, possibly implicit.
+	FuncLit struct {
+		Func   token.Position // position of the func keyword
+		Params []*Ident
+		Body   *BlockStmt
+		Scope  *Scope // the names defined by the function
+	}
+
+	// A YieldExpr is a yield expression. X is nil if there is no value.
+	YieldExpr struct {
+		Yield token.Position
+		X     Expr
+	}
+)
+
+// Statements.
+type (
+	// A BadStmt is a placeholder for a statement with syntax errors.
+	BadStmt struct {
+		From, To token.Position
+	}
+
+	// An ExprStmt is an expression used as a statement: a call or a yield.
+	ExprStmt struct {
+		X Expr
+	}
+
+	// An AssignStmt is an assignment, a definition or an assignment operation,
+	// i.e. `a, b = b, a`, `x := 1` or `x += 1`. Tok is token.ASSIGN,
+	// token.DEFINE, or one of the assignment operations such as
+	// token.ADD_ASSIGN.
+	AssignStmt struct {
+		Lhs    []Expr
+		TokPos token.Position
+		Tok    token.Token
+		Rhs    []Expr
+	}
+
+	// An IncDecStmt is an increment or a decrement statement, Tok being
+	// token.INC or token.DEC.
+	IncDecStmt struct {
+		X      Expr
+		TokPos token.Position
+		Tok    token.Token
+	}
+
+	// A LabeledStmt is a for, a range or a switch statement with a label.
+	LabeledStmt struct {
+		Label *Ident
+		Stmt  Stmt
+	}
+
+	// A BranchStmt is a break or a continue statement, Tok being token.BREAK
+	// or token.CONTINUE. Label is nil if there is no label.
+	BranchStmt struct {
+		TokPos token.Position
+		Tok    token.Token
+		Label  *Ident
+	}
+
+	// A BlockStmt is a list of statements in braces.
+	BlockStmt struct {
+		Lbrace token.Position
+		List   []Stmt
+		Rbrace token.Position
+	}
+
+	// An IfStmt is an if statement. Else is nil, an *IfStmt or a *BlockStmt.
+	IfStmt struct {
+		If   token.Position
+		Cond Expr
+		Body *BlockStmt
+		Else Stmt
+	}
+
+	// A ForStmt is a for statement. Init, Cond and Post are all nil for an
+	// infinite loop, only Cond is set for the single condition form.
+	ForStmt struct {
+		For  token.Position
+		Init Stmt
+		Cond Expr
+		Post Stmt
+		Body *BlockStmt
+	}
+
+	// A RangeStmt is a for statement with a range clause, i.e.
+	// `for x := range 10 {}`. Tok is token.DEFINE or token.ASSIGN.
+	RangeStmt struct {
+		For    token.Position
+		Value  Expr
+		TokPos token.Position
+		Tok    token.Token
+		Range  token.Position
+		Args   []Expr
+		Body   *BlockStmt
+	}
+
+	// A SwitchStmt is a switch statement, the statements of its body being
+	// *CaseClause. Tag is nil if there is no tag.
+	SwitchStmt struct {
+		Switch token.Position
+		Tag    Expr
+		Body   *BlockStmt
+	}
+
+	// A CaseClause is a case or, if List is nil, the default clause of a
+	// switch statement.
+	CaseClause struct {
+		Case  token.Position // position of the case or default keyword
+		List  []Expr
+		Colon token.Position
+		Body  []Stmt
+	}
+
+	// A ReturnStmt is a return statement, the results being empty for a return
+	// without value. Implicit is true if the statement is not in the source
+	// code, but added at the end of a function.
+	ReturnStmt struct {
+		Return   token.Position
+		Results  []Expr
+		Implicit bool
+	}
+
+	// A DeferStmt is a defer statement.
+	DeferStmt struct {
+		Defer token.Position
+		Call  *CallExpr
+	}
+
+	// A DebugStmt is a debug statement, Count being the number of stack frames
+	// to print, nil if there is none.
+	DebugStmt struct {
+		Debug token.Position
+		Count *BasicLit
+	}
+
+	// A FuncDecl is a func statement, i.e. `func Add(x, y) {}`, that defines
+	// the variable Name.
+	FuncDecl struct {
+		Name *Ident
+		Func *FuncLit
+	}
+)
+
+// A File is the syntax tree of a source file, the statements being the body of
+// its top-level function. The last statement is always a return statement,
+// possibly implicit.
+type File struct {
+	Name     string // the file name
+	Stmts    []Stmt
+	Scope    *Scope     // the names defined by the top-level function
+	Comments []*Comment // the comments of the source code, in order
+}
+
+// Pos and End implementations of the expressions.
+
+func (x *BadExpr) Pos() token.Position      { return x.From }
+func (x *Ident) Pos() token.Position        { return x.NamePos }
+func (x *BasicLit) Pos() token.Position     { return x.ValuePos }
+func (x *BoolLit) Pos() token.Position      { return x.ValuePos }
+func (x *NilLit) Pos() token.Position       { return x.NilPos }
+func (x *ThisExpr) Pos() token.Position     { return x.This }
+func (x *ArgsExpr) Pos() token.Position     { return x.Args }
+func (x *ParenExpr) Pos() token.Position    { return x.Lparen }
+func (x *ArrayLit) Pos() token.Position     { return x.Lbrack }
+func (x *ObjectLit) Pos() token.Position    { return x.Lbrace }
+func (x *KeyValueExpr) Pos() token.Position { return x.Key.Pos() }
+func (x *SelectorExpr) Pos() token.Position { return x.X.Pos() }
+func (x *IndexExpr) Pos() token.Position    { return x.X.Pos() }
+func (x *CallExpr) Pos() token.Position     { return x.Fun.Pos() }
+func (x *UnaryExpr) Pos() token.Position    { return x.OpPos }
+func (x *BinaryExpr) Pos() token.Position   { return x.X.Pos() }
+func (x *CondExpr) Pos() token.Position     { return x.Cond.Pos() }
+func (x *FuncLit) Pos() token.Position      { return x.Func }
+func (x *YieldExpr) Pos() token.Position    { return x.Yield }
+
+func (x *BadExpr) End() token.Position      { return x.To }
+func (x *Ident) End() token.Position        { return after(x.NamePos, x.Name) }
+func (x *BasicLit) End() token.Position     { return after(x.ValuePos, x.Value) }
+func (x *NilLit) End() token.Position       { return after(x.NilPos, "nil") }
+func (x *ThisExpr) End() token.Position     { return after(x.This, "this") }
+func (x *ArgsExpr) End() token.Position     { return after(x.Args, "args") }
+func (x *ParenExpr) End() token.Position    { return after(x.Rparen, ")") }
+func (x *ArrayLit) End() token.Position     { return after(x.Rbrack, "]") }
+func (x *ObjectLit) End() token.Position    { return after(x.Rbrace, "}") }
+func (x *KeyValueExpr) End() token.Position { return x.Value.End() }
+func (x *SelectorExpr) End() token.Position { return x.Sel.End() }
+func (x *IndexExpr) End() token.Position    { return after(x.Rbrack, "]") }
+func (x *CallExpr) End() token.Position     { return after(x.Rparen, ")") }
+func (x *UnaryExpr) End() token.Position    { return x.X.End() }
+func (x *BinaryExpr) End() token.Position   { return x.Y.End() }
+func (x *CondExpr) End() token.Position     { return x.Else.End() }
+func (x *FuncLit) End() token.Position      { return x.Body.End() }
+
+func (x *BoolLit) End() token.Position {
+	if x.Value {
+		return after(x.ValuePos, "true")
+	}
+	return after(x.ValuePos, "false")
+}
+
+func (x *YieldExpr) End() token.Position {
+	if x.X != nil {
+		return x.X.End()
+	}
+	return after(x.Yield, "yield")
+}
+
+func (*BadExpr) exprNode()      {}
+func (*Ident) exprNode()        {}
+func (*BasicLit) exprNode()     {}
+func (*BoolLit) exprNode()      {}
+func (*NilLit) exprNode()       {}
+func (*ThisExpr) exprNode()     {}
+func (*ArgsExpr) exprNode()     {}
+func (*ParenExpr) exprNode()    {}
+func (*ArrayLit) exprNode()     {}
+func (*ObjectLit) exprNode()    {}
+func (*KeyValueExpr) exprNode() {}
+func (*SelectorExpr) exprNode() {}
+func (*IndexExpr) exprNode()    {}
+func (*CallExpr) exprNode()     {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*CondExpr) exprNode()     {}
+func (*FuncLit) exprNode()      {}
+func (*YieldExpr) exprNode()    {}
+
+// Pos and End implementations of the statements.
+
+func (s *BadStmt) Pos() token.Position     { return s.From }
+func (s *ExprStmt) Pos() token.Position    { return s.X.Pos() }
+func (s *AssignStmt) Pos() token.Position  { return s.Lhs[0].Pos() }
+func (s *IncDecStmt) Pos() token.Position  { return s.X.Pos() }
+func (s *LabeledStmt) Pos() token.Position { return s.Label.Pos() }
+func (s *BranchStmt) Pos() token.Position  { return s.TokPos }
+func (s *BlockStmt) Pos() token.Position   { return s.Lbrace }
+func (s *IfStmt) Pos() token.Position      { return s.If }
+func (s *ForStmt) Pos() token.Position     { return s.For }
+func (s *RangeStmt) Pos() token.Position   { return s.For }
+func (s *SwitchStmt) Pos() token.Position  { return s.Switch }
+func (s *CaseClause) Pos() token.Position  { return s.Case }
+func (s *ReturnStmt) Pos() token.Position  { return s.Return }
+func (s *DeferStmt) Pos() token.Position   { return s.Defer }
+func (s *DebugStmt) Pos() token.Position   { return s.Debug }
+func (s *FuncDecl) Pos() token.Position    { return s.Func.Pos() }
+
+func (s *BadStmt) End() token.Position     { return s.To }
+func (s *ExprStmt) End() token.Position    { return s.X.End() }
+func (s *AssignStmt) End() token.Position  { return s.Rhs[len(s.Rhs)-1].End() }
+func (s *IncDecStmt) End() token.Position  { return after(s.TokPos, s.Tok.String()) }
+func (s *LabeledStmt) End() token.Position { return s.Stmt.End() }
+func (s *BlockStmt) End() token.Position   { return after(s.Rbrace, "}") }
+func (s *ForStmt) End() token.Position     { return s.Body.End() }
+func (s *RangeStmt) End() token.Position   { return s.Body.End() }
+func (s *SwitchStmt) End() token.Position  { return s.Body.End() }
+func (s *DeferStmt) End() token.Position   { return s.Call.End() }
+func (s *FuncDecl) End() token.Position    { return s.Func.End() }
+
+func (s *BranchStmt) End() token.Position {
+	if s.Label != nil {
+		return s.Label.End()
+	}
+	return after(s.TokPos, s.Tok.String())
+}
+
+func (s *IfStmt) End() token.Position {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Body.End()
+}
+
+func (s *CaseClause) End() token.Position {
+	if n := len(s.Body); n > 0 {
+		return s.Body[n-1].End()
+	}
+	return after(s.Colon, ":")
+}
+
+func (s *ReturnStmt) End() token.Position {
+	if s.Implicit {
+		return s.Return
+	}
+	if n := len(s.Results); n > 0 {
+		return s.Results[n-1].End()
+	}
+	return after(s.Return, "return")
+}
+
+func (s *DebugStmt) End() token.Position {
+	if s.Count != nil {
+		return s.Count.End()
+	}
+	return after(s.Debug, "debug")
+}
+
+func (*BadStmt) stmtNode()     {}
+func (*ExprStmt) stmtNode()    {}
+func (*AssignStmt) stmtNode()  {}
+func (*IncDecStmt) stmtNode()  {}
+func (*LabeledStmt) stmtNode() {}
+func (*BranchStmt) stmtNode()  {}
+func (*BlockStmt) stmtNode()   {}
+func (*IfStmt) stmtNode()      {}
+func (*ForStmt) stmtNode()     {}
+func (*RangeStmt) stmtNode()   {}
+func (*SwitchStmt) stmtNode()  {}
+func (*CaseClause) stmtNode()  {}
+func (*ReturnStmt) stmtNode()  {}
+func (*DeferStmt) stmtNode()   {}
+func (*DebugStmt) stmtNode()   {}
+func (*FuncDecl) stmtNode()    {}
+
+// Pos returns the position of the first statement of the file.
+func (f *File) Pos() token.Position {
+	if len(f.Stmts) == 0 {
+		return token.Position{Filename: f.Name}
+	}
+	return f.Stmts[0].Pos()
+}
+
+// End returns the position of the end of the file, i.e. of its implicit
+// return statement.
+func (f *File) End() token.Position {
+	if len(f.Stmts) == 0 {
+		return token.Position{Filename: f.Name}
+	}
+	return f.Stmts[len(f.Stmts)-1].End()
+}
+
+// Unparen returns the expression x without its enclosing parentheses, if any.
+func Unparen(x Expr) Expr {
+	for {
+		p, ok := x.(*ParenExpr)
+		if !ok {
+			return x
+		}
+		x = p.X
+	}
+}
+
+// Returns the position right after the text s, that starts at the position p.
+func after(p token.Position, s string) token.Position {
+	if !p.IsValid() {
+		return p
+	}
+	p.Offset += len(s)
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		p.Line += strings.Count(s, "\n")
+		p.Column = len(s) - i
+	} else {
+		p.Column += len(s)
+	}
+	return p
+}
@@ -0,0 +1,227 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bobg/agora/compiler/token"
+)
+
+func pos(line, col int) token.Position {
+	return token.Position{Line: line, Column: col}
+}
+
+// The syntax tree of:
+//
+//	x := f((1), "a")
+//	if x {
+//		return x
+//	}
+//	return
+func testFile() *File {
+	x := &Ident{NamePos: pos(1, 1), Name: "x"}
+	x.Def = x
+	return &File{
+		Stmts: []Stmt{
+			&AssignStmt{
+				Lhs:    []Expr{x},
+				TokPos: pos(1, 3),
+				Tok:    token.DEFINE,
+				Rhs: []Expr{&CallExpr{
+					Fun:    &Ident{NamePos: pos(1, 6), Name: "f"},
+					Lparen: pos(1, 7),
+					Args: []Expr{
+						&ParenExpr{Lparen: pos(1, 8), X: &BasicLit{ValuePos: pos(1, 9), Kind: token.INT, Value: "1"}, Rparen: pos(1, 10)},
+						&BasicLit{ValuePos: pos(1, 13), Kind: token.STRING, Value: `"a"`},
+					},
+					Rparen: pos(1, 16),
+				}},
+			},
+			&IfStmt{
+				If:   pos(2, 1),
+				Cond: &Ident{NamePos: pos(2, 4), Name: "x", Def: x},
+				Body: &BlockStmt{
+					Lbrace: pos(2, 6),
+					List: []Stmt{
+						&ReturnStmt{Return: pos(3, 2), Results: []Expr{&Ident{NamePos: pos(3, 9), Name: "x", Def: x}}},
+					},
+					Rbrace: pos(4, 1),
+				},
+			},
+			&ReturnStmt{Return: pos(5, 1)},
+		},
+	}
+}
+
+// A visitor records the nodes it visits, with their depth.
+type visitor struct {
+	depth int
+	nodes []string
+}
+
+func (v *visitor) Visit(n Node) Visitor {
+	if n == nil {
+		v.depth--
+		return nil
+	}
+	v.nodes = append(v.nodes, fmt.Sprintf("%s%T", strings.Repeat(".", v.depth), n))
+	v.depth++
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	v := new(visitor)
+	Walk(v, testFile())
+	exp := []string{
+		"*ast.File",
+		".*ast.AssignStmt",
+		"..*ast.Ident",
+		"..*ast.CallExpr",
+		"...*ast.Ident",
+		"...*ast.ParenExpr",
+		"....*ast.BasicLit",
+		"...*ast.BasicLit",
+		".*ast.IfStmt",
+		"..*ast.Ident",
+		"..*ast.BlockStmt",
+		"...*ast.ReturnStmt",
+		"....*ast.Ident",
+		".*ast.ReturnStmt",
+	}
+	if got := strings.Join(v.nodes, "\n"); got != strings.Join(exp, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(exp, "\n"), got)
+	}
+	if v.depth != 0 {
+		t.Errorf("expected the end of each node to be visited, got depth %d", v.depth)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	// The children of the if statement are skipped
+	var names []string
+	Inspect(testFile(), func(n Node) bool {
+		switch n := n.(type) {
+		case *Ident:
+			names = append(names, fmt.Sprintf("%s@%s", n.Name, n.Pos()))
+		case *IfStmt:
+			return false
+		}
+		return true
+	})
+	if exp := "x@1:1 f@1:6"; strings.Join(names, " ") != exp {
+		t.Errorf("expected %s, got %s", exp, strings.Join(names, " "))
+	}
+}
+
+func TestPositions(t *testing.T) {
+	f := testFile()
+	asg := f.Stmts[0].(*AssignStmt)
+	call := asg.Rhs[0].(*CallExpr)
+	cases := []struct {
+		n          Node
+		start, end string
+	}{
+		{f, "1:1", "5:7"},
+		{asg, "1:1", "1:17"},
+		{call.Args[0], "1:8", "1:11"},
+		{call.Args[1], "1:13", "1:16"},
+		{f.Stmts[1], "2:1", "4:2"},
+		{&BasicLit{ValuePos: pos(1, 5), Kind: token.STRING, Value: "`a\nbc`"}, "1:5", "2:4"},
+		{&ReturnStmt{Return: pos(7, 1), Implicit: true}, "7:1", "7:1"},
+	}
+	for i, c := range cases {
+		if got := fmt.Sprintf("%s %s", c.n.Pos(), c.n.End()); got != c.start+" "+c.end {
+			t.Errorf("[%d] - expected %s %s, got %s", i, c.start, c.end, got)
+		}
+	}
+}
+
+func TestUnparen(t *testing.T) {
+	lit := &BasicLit{Kind: token.INT, Value: "1"}
+	if x := Unparen(&ParenExpr{X: &ParenExpr{X: lit}}); x != lit {
+		t.Errorf("expected the literal, got %T", x)
+	}
+	if x := Unparen(lit); x != lit {
+		t.Errorf("expected the literal, got %T", x)
+	}
+}
+
+func TestScope(t *testing.T) {
+	outer := NewScope(nil)
+	x := &Ident{Name: "x"}
+	outer.Insert(x)
+	s := NewScope(outer)
+	s.Insert(&Ident{Name: "y"})
+	if s.Lookup("x") != nil || s.Outer.Lookup("x") != x || s.Lookup("y") == nil {
+		t.Error("unexpected lookup result")
+	}
+}
+
+func TestFprintJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := FprintJSON(&buf, testFile()); err != nil {
+		t.Fatal(err)
+	}
+	var f struct {
+		Type  string
+		Stmts []struct {
+			Type string
+			Pos  struct{ Line, Column int }
+			Lhs  []struct {
+				Name string
+				Def  struct{ Line, Column int }
+			}
+			Rhs []struct {
+				Args []struct {
+					Kind, Value string
+				}
+			}
+			Implicit bool
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &f); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.String())
+	}
+	if f.Type != "File" || len(f.Stmts) != 3 {
+		t.Fatalf("unexpected file %+v", f)
+	}
+	asg := f.Stmts[0]
+	if asg.Type != "AssignStmt" || asg.Pos.Line != 1 || asg.Lhs[0].Name != "x" || asg.Lhs[0].Def.Column != 1 {
+		t.Errorf("unexpected assignment %+v", asg)
+	}
+	if arg := asg.Rhs[0].Args[1]; arg.Kind != "(string)" || arg.Value != `"a"` {
+		t.Errorf("unexpected argument %+v", arg)
+	}
+	if s := f.Stmts[2]; s.Type != "ReturnStmt" || s.Implicit {
+		t.Errorf("unexpected return %+v", s)
+	}
+}
+
+func TestFprintSexpr(t *testing.T) {
+	var buf bytes.Buffer
+	x := &Ident{NamePos: pos(1, 1), Name: "x"}
+	x.Def = x
+	err := FprintSexpr(&buf, &IncDecStmt{X: x, TokPos: pos(1, 2), Tok: token.INC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `(IncDecStmt
+ :pos (:line 1 :column 1 :offset 0)
+ :end (:line 1 :column 4 :offset 2)
+ :x (Ident
+     :pos (:line 1 :column 1 :offset 0)
+     :end (:line 1 :column 2 :offset 1)
+     :namePos (:line 1 :column 1 :offset 0)
+     :name "x"
+     :def (:line 1 :column 1 :offset 0)
+     :global false)
+ :tokPos (:line 1 :column 2 :offset 0)
+ :tok "++")
+`
+	if buf.String() != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, buf.String())
+	}
+}
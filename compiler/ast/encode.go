@@ -0,0 +1,294 @@
+package ast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bobg/agora/compiler/token"
+)
+
+// The syntax tree is encoded as a tree of values, each node being an object
+// with its type, its position, its end and then its fields in the order of
+// declaration, named as the Go field with a lower-case initial. The positions
+// are objects with the line, the column and the byte offset, null if they are
+// not valid, the tokens are their string, i.e. "+=" or "(int)", and the scopes
+// are the list of their definitions.
+//
+// The Def field of an identifier is the position of its definition, or null,
+// so that the tree has no cycle.
+
+// An object is an encoded node, position or definition. Its type is empty if
+// it is not a node.
+type object struct {
+	typ    string
+	fields []field
+}
+
+// A field is a named value of an object.
+type field struct {
+	name string
+	val  interface{} // nil, bool, int, string, *object or []interface{}
+}
+
+var (
+	positionType = reflect.TypeOf(token.Position{})
+	tokenType    = reflect.TypeOf(token.ILLEGAL)
+	scopeType    = reflect.TypeOf((*Scope)(nil))
+)
+
+// FprintJSON writes the syntax tree of node to w as JSON, indented with tabs.
+// The tree of:
+//
+//	x := 1
+//
+// starts with:
+//
+//	{
+//		"type": "File",
+//		"pos": {"line": 1, "column": 1, "offset": 0},
+//		"end": ...,
+//		"name": "a.agora",
+//		"stmts": [
+//			{
+//				"type": "AssignStmt",
+//				...
+func FprintJSON(w io.Writer, node Node) error {
+	bw := bufio.NewWriter(w)
+	writeJSON(bw, encodeNode(node), 0)
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// FprintSexpr writes the syntax tree of node to w as an S-expression, one
+// field per line. A node is a list starting with its type followed by its
+// fields as keyword and value pairs, i.e. `(Ident :pos (:line 1 ...) :name
+// "x" ...)`, null being nil and the booleans true and false.
+func FprintSexpr(w io.Writer, node Node) error {
+	bw := bufio.NewWriter(w)
+	writeSexpr(bw, encodeNode(node), 0)
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+func encodeNode(n Node) interface{} {
+	v := reflect.ValueOf(n)
+	if n == nil || v.IsNil() {
+		return nil
+	}
+	o := &object{typ: v.Elem().Type().Name()}
+	o.add("pos", encodePos(n.Pos()))
+	o.add("end", encodePos(n.End()))
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if id, ok := n.(*Ident); ok && f.Name == "Def" {
+			// The definition of an identifier may be the identifier itself
+			if id.Def != nil {
+				o.add("def", encodePos(id.Def.NamePos))
+			} else {
+				o.add("def", nil)
+			}
+			continue
+		}
+		o.add(fieldName(f.Name), encodeValue(v.Field(i)))
+	}
+	return o
+}
+
+func encodeValue(v reflect.Value) interface{} {
+	switch v.Type() {
+	case positionType:
+		return encodePos(v.Interface().(token.Position))
+	case tokenType:
+		return v.Interface().(token.Token).String()
+	case scopeType:
+		if v.IsNil() {
+			return nil
+		}
+		defs := []interface{}{}
+		for _, id := range v.Interface().(*Scope).Defs {
+			o := &object{}
+			o.add("name", id.Name)
+			o.add("pos", encodePos(id.NamePos))
+			defs = append(defs, o)
+		}
+		return defs
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		list := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, encodeValue(v.Index(i)))
+		}
+		return list
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if n, ok := v.Interface().(Node); ok {
+			return encodeNode(n)
+		}
+	}
+	panic(fmt.Sprintf("ast: unexpected field type %s", v.Type()))
+}
+
+func encodePos(pos token.Position) interface{} {
+	if !pos.IsValid() {
+		return nil
+	}
+	o := &object{}
+	o.add("line", pos.Line)
+	o.add("column", pos.Column)
+	o.add("offset", pos.Offset)
+	return o
+}
+
+func (o *object) add(name string, val interface{}) {
+	o.fields = append(o.fields, field{name, val})
+}
+
+// fieldName returns the name of the Go field name with a lower-case initial.
+func fieldName(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[n:]
+}
+
+// isPos returns true if the value is an encoded position, that is written on a
+// single line.
+func isPos(val interface{}) bool {
+	o, ok := val.(*object)
+	return ok && o.typ == "" && len(o.fields) == 3 && o.fields[0].name == "line"
+}
+
+func writeJSON(w *bufio.Writer, val interface{}, depth int) {
+	switch val := val.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(val))
+	case int:
+		w.WriteString(strconv.Itoa(val))
+	case string:
+		w.WriteString(jsonQuote(val))
+	case []interface{}:
+		if len(val) == 0 {
+			w.WriteString("[]")
+			return
+		}
+		w.WriteString("[\n")
+		for i, elt := range val {
+			w.WriteString(strings.Repeat("\t", depth+1))
+			writeJSON(w, elt, depth+1)
+			if i < len(val)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(strings.Repeat("\t", depth) + "]")
+	case *object:
+		fields := val.fields
+		if val.typ != "" {
+			fields = append([]field{{"type", val.typ}}, fields...)
+		}
+		if isPos(val) {
+			w.WriteString("{")
+			for i, f := range fields {
+				if i > 0 {
+					w.WriteString(", ")
+				}
+				w.WriteString(jsonQuote(f.name) + ": ")
+				writeJSON(w, f.val, depth)
+			}
+			w.WriteString("}")
+			return
+		}
+		w.WriteString("{\n")
+		for i, f := range fields {
+			w.WriteString(strings.Repeat("\t", depth+1) + jsonQuote(f.name) + ": ")
+			writeJSON(w, f.val, depth+1)
+			if i < len(fields)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(strings.Repeat("\t", depth) + "}")
+	}
+}
+
+// jsonQuote returns the string s as a JSON string literal.
+func jsonQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == utf8.RuneError {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func writeSexpr(w *bufio.Writer, val interface{}, depth int) {
+	switch val := val.(type) {
+	case nil:
+		w.WriteString("nil")
+	case bool:
+		w.WriteString(strconv.FormatBool(val))
+	case int:
+		w.WriteString(strconv.Itoa(val))
+	case string:
+		w.WriteString(strconv.Quote(val))
+	case []interface{}:
+		if len(val) == 0 {
+			w.WriteString("()")
+			return
+		}
+		w.WriteString("(")
+		for i, elt := range val {
+			if i > 0 {
+				w.WriteString("\n" + strings.Repeat(" ", depth+1))
+			}
+			writeSexpr(w, elt, depth+1)
+		}
+		w.WriteString(")")
+	case *object:
+		w.WriteString("(" + val.typ)
+		for i, f := range val.fields {
+			switch {
+			case isPos(val):
+				if i > 0 || val.typ != "" {
+					w.WriteString(" ")
+				}
+			case i > 0 || val.typ != "":
+				w.WriteString("\n" + strings.Repeat(" ", depth+1))
+			}
+			w.WriteString(":" + f.name + " ")
+			writeSexpr(w, f.val, depth+len(f.name)+3)
+		}
+		w.WriteString(")")
+	}
+}
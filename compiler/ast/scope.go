@@ -0,0 +1,30 @@
+package ast
+
+// A Scope holds the names defined by a function, the only scopes of agora
+// being the functions. The top-level code of a file is in an implicit
+// top-level function, and thus scope.
+type Scope struct {
+	Outer *Scope   // the scope of the enclosing function, nil for the file
+	Defs  []*Ident // the variables, parameters and functions, in order of definition
+}
+
+// NewScope creates a new scope, nested in the scope outer.
+func NewScope(outer *Scope) *Scope {
+	return &Scope{Outer: outer}
+}
+
+// Lookup returns the definition of the name in the scope s, ignoring the
+// enclosing scopes, or nil if the name is not defined.
+func (s *Scope) Lookup(name string) *Ident {
+	for _, id := range s.Defs {
+		if id.Name == name {
+			return id
+		}
+	}
+	return nil
+}
+
+// Insert adds the definition id to the scope s.
+func (s *Scope) Insert(id *Ident) {
+	s.Defs = append(s.Defs, id)
+}
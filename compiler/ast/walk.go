@@ -0,0 +1,190 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the children of node
+// with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+func walkExprs(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+func walkStmts(v Visitor, list []Stmt) {
+	for _, s := range list {
+		Walk(v, s)
+	}
+}
+
+func walkIdents(v Visitor, list []*Ident) {
+	for _, id := range list {
+		Walk(v, id)
+	}
+}
+
+// Walk traverses the syntax tree in depth-first order, the children of a node
+// being visited in the order of the source code. It starts by calling
+// v.Visit(node), node must not be nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	// Comments and leaf nodes
+	case *Comment, *BadExpr, *Ident, *BasicLit, *BoolLit, *NilLit, *ThisExpr,
+		*ArgsExpr, *BadStmt:
+		// Nothing to do
+
+	// Expressions
+	case *ParenExpr:
+		Walk(v, n.X)
+
+	case *ArrayLit:
+		walkExprs(v, n.Elts)
+
+	case *ObjectLit:
+		for _, kv := range n.Elts {
+			Walk(v, kv)
+		}
+
+	case *KeyValueExpr:
+		Walk(v, n.Key)
+		Walk(v, n.Value)
+
+	case *SelectorExpr:
+		Walk(v, n.X)
+		Walk(v, n.Sel)
+
+	case *IndexExpr:
+		Walk(v, n.X)
+		Walk(v, n.Index)
+
+	case *CallExpr:
+		Walk(v, n.Fun)
+		walkExprs(v, n.Args)
+
+	case *UnaryExpr:
+		Walk(v, n.X)
+
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+
+	case *CondExpr:
+		Walk(v, n.Cond)
+		Walk(v, n.Then)
+		Walk(v, n.Else)
+
+	case *FuncLit:
+		walkIdents(v, n.Params)
+		Walk(v, n.Body)
+
+	case *YieldExpr:
+		if n.X != nil {
+			Walk(v, n.X)
+		}
+
+	// Statements
+	case *ExprStmt:
+		Walk(v, n.X)
+
+	case *AssignStmt:
+		walkExprs(v, n.Lhs)
+		walkExprs(v, n.Rhs)
+
+	case *IncDecStmt:
+		Walk(v, n.X)
+
+	case *BranchStmt:
+		if n.Label != nil {
+			Walk(v, n.Label)
+		}
+
+	case *LabeledStmt:
+		Walk(v, n.Label)
+		Walk(v, n.Stmt)
+
+	case *BlockStmt:
+		walkStmts(v, n.List)
+
+	case *IfStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+
+	case *ForStmt:
+		if n.Init != nil {
+			Walk(v, n.Init)
+		}
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+		if n.Post != nil {
+			Walk(v, n.Post)
+		}
+		Walk(v, n.Body)
+
+	case *RangeStmt:
+		Walk(v, n.Value)
+		walkExprs(v, n.Args)
+		Walk(v, n.Body)
+
+	case *SwitchStmt:
+		if n.Tag != nil {
+			Walk(v, n.Tag)
+		}
+		Walk(v, n.Body)
+
+	case *CaseClause:
+		walkExprs(v, n.List)
+		walkStmts(v, n.Body)
+
+	case *ReturnStmt:
+		walkExprs(v, n.Results)
+
+	case *DeferStmt:
+		Walk(v, n.Call)
+
+	case *DebugStmt:
+		if n.Count != nil {
+			Walk(v, n.Count)
+		}
+
+	case *FuncDecl:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+
+	// Files
+	case *File:
+		walkStmts(v, n.Stmts)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the syntax tree in depth-first order, like Walk. It starts
+// by calling f(node), node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
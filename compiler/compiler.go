@@ -19,15 +19,24 @@ type Compiler struct{}
 // If an error is encountered, it is returned as second value, otherwise it is
 // nil.
 func (c *Compiler) Compile(id string, r io.Reader) (*bytecode.File, error) {
+	return c.CompileWithGlobals(id, r, nil)
+}
+
+// CompileWithGlobals is like Compile, but the global names defined by the
+// execution context are provided, so that they are not reported as undefined.
+// If globals is nil, the default built-in functions are the only globals. It
+// implements the runtime.GlobalsCompiler interface.
+func (c *Compiler) CompileWithGlobals(id string, r io.Reader, globals []string) (*bytecode.File, error) {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 	p := parser.New()
-	syms, scps, err := p.Parse(id, b)
+	p.Globals = globals
+	file, err := p.ParseFile(id, b)
 	if err != nil {
 		return nil, err
 	}
 	e := new(emitter.Emitter)
-	return e.Emit(id, syms, scps)
+	return e.Emit(id, file)
 }
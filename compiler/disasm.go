@@ -63,6 +63,15 @@ func (d *Disasm) ToAsm(f *bytecode.File, w io.Writer) error {
 			d.write(" ", false)
 			d.write(ix, true)
 		}
+		// 6- Write the function's P section, if there is a line table
+		if len(fn.Ps) > 0 {
+			d.write("[p]", true)
+			for _, p := range fn.Ps {
+				d.write(p.Ix, false)
+				d.write(" ", false)
+				d.write(p.Line, true)
+			}
+		}
 	}
 	return d.err
 }
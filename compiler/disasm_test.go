@@ -24,8 +24,8 @@ var (
 			src: AppendAny(SigVer(bytecode.Version()), Int64ToByteSlice(4), 't', 'e', 's', 't',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
 				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64,
-				// Ks - Ls - Is
-				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
+				// Ks - Ls - Is - Ps
+				ExpZeroInt64, ExpZeroInt64, ExpZeroInt64, ExpZeroInt64),
 			exp: disasmComment + `
 [f]
 test
@@ -52,6 +52,8 @@ test
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("PUSH"), bytecode.NewFlag("V"), 0))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("DUMP"), bytecode.NewFlag("Sn"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// 2 Ps
+				Int64ToByteSlice(2), ExpZeroInt64, Int64ToByteSlice(1), Int64ToByteSlice(3), Int64ToByteSlice(2),
 			),
 			exp: disasmComment + `
 [f]
@@ -71,6 +73,9 @@ POP V 0
 PUSH V 0
 DUMP Sn 1
 RET _ 0
+[p]
+0 1
+3 2
 `,
 		},
 		3: {
@@ -89,6 +94,8 @@ RET _ 0
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("CALL"), bytecode.NewFlag("An"), 2))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("DUMP"), bytecode.NewFlag("Sn"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// Ps
+				ExpZeroInt64,
 				// 2nd fn
 				Int64ToByteSlice(3), 'A', 'd', 'd',
 				// StackSz - ExpArgs - ParentFnIx - LineStart - LineEnd
@@ -100,6 +107,8 @@ RET _ 0
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("PUSH"), bytecode.NewFlag("V"), 1))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("ADD"), bytecode.NewFlag("_"), 0))),
 				UInt64ToByteSlice(uint64(bytecode.NewInstr(bytecode.NewOpcode("RET"), bytecode.NewFlag("_"), 0))),
+				// Ps
+				ExpZeroInt64,
 			),
 			exp: disasmComment + `
 [f]
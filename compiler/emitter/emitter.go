@@ -4,47 +4,56 @@ package emitter
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/bobg/agora/bytecode"
-	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/ast"
+	"github.com/bobg/agora/compiler/token"
 )
 
 var (
-	binSym2op = map[string]bytecode.Opcode{
-		"+":  bytecode.OP_ADD,
-		"-":  bytecode.OP_SUB,
-		"*":  bytecode.OP_MUL,
-		"/":  bytecode.OP_DIV,
-		"%":  bytecode.OP_MOD,
-		"<":  bytecode.OP_LT,
-		"<=": bytecode.OP_LTE,
-		">":  bytecode.OP_GT,
-		">=": bytecode.OP_GTE,
-		"==": bytecode.OP_EQ,
-		"!=": bytecode.OP_NEQ,
-	}
-	binAsgSym2op = map[string]bytecode.Opcode{
-		"+=": bytecode.OP_ADD,
-		"-=": bytecode.OP_SUB,
-		"*=": bytecode.OP_MUL,
-		"/=": bytecode.OP_DIV,
-		"%=": bytecode.OP_MOD,
-	}
-	unrSym2op = map[string]bytecode.Opcode{
-		"++": bytecode.OP_ADD,
-		"--": bytecode.OP_SUB,
-		"!":  bytecode.OP_NOT,
-		"-":  bytecode.OP_UNM,
+	binTok2op = map[token.Token]bytecode.Opcode{
+		token.ADD: bytecode.OP_ADD,
+		token.SUB: bytecode.OP_SUB,
+		token.MUL: bytecode.OP_MUL,
+		token.DIV: bytecode.OP_DIV,
+		token.MOD: bytecode.OP_MOD,
+		token.LSS: bytecode.OP_LT,
+		token.LEQ: bytecode.OP_LTE,
+		token.GTR: bytecode.OP_GT,
+		token.GEQ: bytecode.OP_GTE,
+		token.EQL: bytecode.OP_EQ,
+		token.NEQ: bytecode.OP_NEQ,
+	}
+	asgTok2op = map[token.Token]bytecode.Opcode{
+		token.ADD_ASSIGN: bytecode.OP_ADD,
+		token.SUB_ASSIGN: bytecode.OP_SUB,
+		token.MUL_ASSIGN: bytecode.OP_MUL,
+		token.DIV_ASSIGN: bytecode.OP_DIV,
+		token.MOD_ASSIGN: bytecode.OP_MOD,
+	}
+	unrTok2op = map[token.Token]bytecode.Opcode{
+		token.INC: bytecode.OP_ADD,
+		token.DEC: bytecode.OP_SUB,
+		token.NOT: bytecode.OP_NOT,
+		token.SUB: bytecode.OP_UNM,
 	}
 )
 
 type forData struct {
 	breaks []int
 	conts  []int
+	label  string
+	sw     bool // switch statement, only breaks apply
+	rng    bool // range loop, its iterator must be released when exiting the loop
 }
 
+// The name of the hidden local variable that holds the tag of a switch statement.
+// It is not a valid identifier, so it cannot clash with a user variable.
+const switchTagName = "(switch)"
+
 type kId struct {
 	v string
 	t bytecode.KType
@@ -65,13 +74,19 @@ type Emitter struct {
 	stackSz map[*bytecode.Fn]int64
 	forNest map[*bytecode.Fn][]*forData
 	fnIx    []int64
+	line    int64 // source line of the node being emitted
+
+	// If set, the variables defined by the top-level code are global variables
+	// of the execution context instead of local variables of the module, so
+	// that they are kept across executions, e.g. in a REPL.
+	GlobalVars bool
 }
 
-// Emit takes a module identifier, the symbols generated by the parser (the headless *AST*),
-// and the root scope, and emits the instructions required to execute the program.
+// Emit takes a module identifier and the syntax tree generated by the parser,
+// and emits the instructions required to execute the program.
 // It returns the in-memory bytecode representation of the program. If an error is
 // encountered, it is returned as second value, otherwise it returns nil.
-func (e *Emitter) Emit(id string, syms []*parser.Symbol, scps *parser.Scope) (*bytecode.File, error) {
+func (e *Emitter) Emit(id string, file *ast.File) (*bytecode.File, error) {
 	// Reset the internal fields
 	e.err = nil
 	e.kMap = make(map[*bytecode.Fn]map[kId]int)
@@ -80,35 +95,39 @@ func (e *Emitter) Emit(id string, syms []*parser.Symbol, scps *parser.Scope) (*b
 
 	// Create the bytecode representation structure
 	f := bytecode.NewFile(id)
+	f.GlobalVars = e.GlobalVars
 	fn := new(bytecode.Fn)
 	fn.Header.Name = f.Name // Expected args and parent func are always 0 for top-level func
-	// TODO : Line start and end
+	// Line start and end are set based on the lines of the instructions
+	e.line = 0
 	f.Fns = append(f.Fns, fn)
 	e.fnIx = []int64{0}
-	e.emitBlock(f, fn, syms)
+	e.emitBlock(f, fn, file.Stmts)
+	if e.err == nil {
+		// Variables are emitted by name, now that the locals of all functions
+		// are known, resolve them to local variable slots and upvalues.
+		f.ResolveVars()
+	}
 	return f, e.err
 }
 
-func (e *Emitter) emitFn(f *bytecode.File, sym *parser.Symbol) {
+func (e *Emitter) emitFn(f *bytecode.File, name string, x *ast.FuncLit) {
 	if e.err != nil {
 		return
 	}
-	e.assert(sym.Ar == parser.ArFunction, errors.New("expected `"+sym.Id+"` to have function arity"))
 	fn := new(bytecode.Fn)
-	fn.Header.Name = sym.Name
-	args := sym.First.([]*parser.Symbol)
-	fn.Header.ExpArgs = int64(len(args))
+	fn.Header.Name = name
+	fn.Header.ExpArgs = int64(len(x.Params))
 	fn.Header.ParentFnIx = e.fnIx[len(e.fnIx)-1]
-	// TODO : Line Start, Line End
+	fn.Header.LineStart = int64(x.Pos().Line)
+	fn.Header.LineEnd = int64(x.Body.Rbrace.Line)
 	f.Fns = append(f.Fns, fn)
 	e.fnIx = append(e.fnIx, int64(len(f.Fns)-1))
 	// Define the expected args in the K table - *MUST* be defined in spots 0..ExpArgs - 1
-	for _, arg := range args {
-		e.assert(arg.Ar == parser.ArName, errors.New("expected argument to have name arity"))
-		e.registerK(fn, arg.Val, true, true)
+	for _, prm := range x.Params {
+		e.registerK(fn, prm.Name, true, true)
 	}
-	stmts := sym.Second.([]*parser.Symbol)
-	e.emitBlock(f, fn, stmts)
+	e.emitBlock(f, fn, x.Body.List)
 	// Cleanup map keys of this fn
 	e.fnIx = e.fnIx[:len(e.fnIx)-1]
 	delete(e.kMap, fn)
@@ -116,328 +135,557 @@ func (e *Emitter) emitFn(f *bytecode.File, sym *parser.Symbol) {
 	delete(e.forNest, fn)
 }
 
-func (e *Emitter) emitAny(f *bytecode.File, fn *bytecode.Fn, sym *parser.Symbol, any interface{}) {
-	switch v := any.(type) {
-	case *parser.Symbol:
-		e.emitSymbol(f, fn, v, atFalse)
-	case []*parser.Symbol:
-		e.emitBlock(f, fn, v)
-	default:
-		e.assert(false, errors.New("expected branch of `"+sym.Id+"` to be a symbol or a slice of symbols"))
+func (e *Emitter) emitBlock(f *bytecode.File, fn *bytecode.Fn, list []ast.Stmt) {
+	for _, s := range list {
+		e.emitStmt(f, fn, s)
 	}
 }
 
-func (e *Emitter) emitBlock(f *bytecode.File, fn *bytecode.Fn, syms []*parser.Symbol) {
-	for _, sym := range syms {
-		e.emitSymbol(f, fn, sym, atFalse)
+// Map the instructions emitted for the node n to its line, until the returned
+// function is called.
+func (e *Emitter) mapLine(n ast.Node) func() {
+	prev := e.line
+	if ln := int64(n.Pos().Line); ln > 0 {
+		e.line = ln
+	}
+	return func() {
+		e.line = prev
+	}
+}
+
+// Emit a list of expressions, each one pushing a value on the stack.
+func (e *Emitter) emitExprs(f *bytecode.File, fn *bytecode.Fn, list []ast.Expr) {
+	for _, x := range list {
+		e.emitExpr(f, fn, x, atFalse)
 	}
 }
 
-func (e *Emitter) emitShortcutIf(f *bytecode.File, fn *bytecode.Fn, parent *parser.Symbol, cond, truePart, falsePart interface{}) {
+// Emit a multiple assignment, i.e. `a, b = b, a` or `a, b := f()`. All values
+// are pushed on the stack before being assigned, from the last one to the first one.
+func (e *Emitter) emitMultiAsg(f *bytecode.File, fn *bytecode.Fn, lefts, rights []ast.Expr, asg asgType) {
+	if len(rights) == 1 && len(lefts) > 1 {
+		// Single function call, unpack as many values as there are variables
+		_, ok := ast.Unparen(rights[0]).(*ast.CallExpr)
+		e.assert(ok, errors.New("expected a function call on the right hand side of a multiple assignment"))
+		e.emitExpr(f, fn, rights[0], atFalse)
+		e.addInstr(fn, bytecode.OP_UNPK, bytecode.FLG_Rn, uint64(len(lefts)))
+	} else {
+		e.assert(len(lefts) == len(rights), errors.New("assignment count mismatch"))
+		e.emitExprs(f, fn, rights)
+	}
+	for i := len(lefts) - 1; i >= 0; i-- {
+		e.emitExpr(f, fn, lefts[i], asg)
+	}
+}
+
+func (e *Emitter) emitShortcutIf(f *bytecode.File, fn *bytecode.Fn, cond, truePart, falsePart ast.Expr) {
 	// Emit the condition
-	e.emitAny(f, fn, parent, cond)
+	e.emitExpr(f, fn, cond, atFalse)
 	// Next comes the TEST
 	tstIx := e.addTempInstr(fn)
 	// Then the true expression
-	e.emitAny(f, fn, parent, truePart)
+	e.emitExpr(f, fn, truePart, atFalse)
 	// Then a jump over the false expression
 	jmpIx := e.addTempInstr(fn)
 	// Update the test instruction, here starts the false part
 	e.updateTestInstr(fn, tstIx)
 	// Emit the false expression
-	e.emitAny(f, fn, parent, falsePart)
+	e.emitExpr(f, fn, falsePart, atFalse)
 	// Update the jump instruction, to after the false part
 	e.updateJumpfInstr(fn, jmpIx)
 }
 
-func (e *Emitter) emitSymbol(f *bytecode.File, fn *bytecode.Fn, sym *parser.Symbol, asg asgType) {
+// Push the name of a field or of a key as a constant, mapped to the line of
+// the node n.
+func (e *Emitter) emitName(fn *bytecode.Fn, name string, n ast.Node) {
+	defer e.mapLine(n)()
+	kix := e.registerK(fn, name, true, false)
+	e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
+}
+
+// Emit a statement. The values returned by a function call used as a
+// statement are discarded, so that they don't grow the stack.
+func (e *Emitter) emitStmt(f *bytecode.File, fn *bytecode.Fn, s ast.Stmt) {
 	if e.err != nil {
 		return
 	}
-	switch sym.Id {
-	case "nil":
-		e.assert(asg == atFalse, errors.New("invalid assignment to nil"))
-		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_N, 0)
-	case "(name)", "import", "panic", "recover", "len", "keys", "string", "number",
-		"bool", "type", "status", "reset": // TODO : Cleaner way to handle all builtins
-		// Register the symbol, may or may not be a local
-		e.assert(sym.Ar == parser.ArName || sym.Ar == parser.ArLiteral, errors.New("expected `"+sym.Id+"` to have name or literal arity"))
-		kix := e.registerK(fn, sym.Val, true, asg == atDefine)
-		if asg != atFalse {
-			e.addInstr(fn, bytecode.OP_POP, bytecode.FLG_V, kix)
-		} else if sym.Ar == parser.ArLiteral {
-			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
-		} else {
-			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_V, kix)
+	// The instructions emitted for this statement are mapped to its line
+	defer e.mapLine(s)()
+	switch s := s.(type) {
+	case *ast.ExprStmt:
+		e.emitExpr(f, fn, s.X, atFalse)
+		if _, ok := ast.Unparen(s.X).(*ast.CallExpr); ok {
+			e.addInstr(fn, bytecode.OP_UNPK, bytecode.FLG_Rn, 0)
 		}
-	case "(literal)", "true", "false":
-		// Register the symbol
-		e.assert(asg == atFalse, errors.New("invalid assignment to a literal"))
-		e.assert(sym.Ar == parser.ArLiteral, errors.New("expected `"+sym.Id+"` to have literal arity"))
-		kix := e.registerK(fn, sym.Val, false, false)
-		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
-	case "this":
-		e.assert(asg == atFalse, errors.New("invalid assignment to the `this` keyword"))
-		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_T, 0)
-	case "args":
-		e.assert(asg == atFalse, errors.New("invalid assignment to the `args` keyword"))
-		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_A, 0)
-	case ".", "[":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `"+sym.Id+"` to have binary arity"))
-		e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		if asg != atFalse {
-			e.addInstr(fn, bytecode.OP_SFLD, bytecode.FLG__, 0)
-		} else {
-			e.addInstr(fn, bytecode.OP_GFLD, bytecode.FLG__, 0)
-		}
-	case ":=":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `:=` to have binary arity"))
-		e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atDefine)
-	case "!":
-		e.assert(sym.Ar == parser.ArUnary, errors.New("expected `!` to have unary arity"))
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		e.addInstr(fn, unrSym2op[sym.Id], bytecode.FLG__, 0)
-	case "-":
-		if sym.Ar == parser.ArUnary {
-			e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-			e.addInstr(fn, unrSym2op[sym.Id], bytecode.FLG__, 0)
-			break
-		}
-		fallthrough
-	case "+", "*", "/", "%", "<", ">", "<=", ">=", "==", "!=":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `"+sym.Id+"` to have binary arity"))
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		e.addInstr(fn, binSym2op[sym.Id], bytecode.FLG__, 0)
-	case "&&", "||":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `"+sym.Id+"` to have binary arity"))
-		if sym.Id == "&&" {
-			// Equivalent to if <first> then <second> else <first>
-			e.emitShortcutIf(f, fn, sym, sym.First, sym.Second, sym.First)
-		} else {
-			// Equivalent to if <first> then <first> else <second>
-			e.emitShortcutIf(f, fn, sym, sym.First, sym.First, sym.Second)
-		}
-	case "=":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `=` to have binary arity"))
-		e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		left := sym.First.(*parser.Symbol)
-		if left.Id == "." {
-			// Emit left, which will generate a SFLD
-			e.emitSymbol(f, fn, left, atTrue)
-		} else {
-			// Emit a standard POP instruction
-			e.emitSymbol(f, fn, left, atTrue)
+	case *ast.AssignStmt:
+		switch s.Tok {
+		case token.DEFINE, token.ASSIGN:
+			asg := atTrue
+			if s.Tok == token.DEFINE {
+				asg = atDefine
+			}
+			if len(s.Lhs) != 1 || len(s.Rhs) != 1 {
+				e.emitMultiAsg(f, fn, s.Lhs, s.Rhs, asg)
+				break
+			}
+			e.emitExpr(f, fn, s.Rhs[0], atFalse)
+			e.emitExpr(f, fn, s.Lhs[0], asg)
+		default:
+			op, ok := asgTok2op[s.Tok]
+			e.assert(ok, errors.New("unexpected assignment operator "+s.Tok.String()))
+			e.assert(len(s.Lhs) == 1 && len(s.Rhs) == 1, errors.New("expected `"+s.Tok.String()+"` to have a single operand on each side"))
+			if e.err != nil {
+				break
+			}
+			e.emitExpr(f, fn, s.Lhs[0], atFalse)
+			e.emitExpr(f, fn, s.Rhs[0], atFalse)
+			e.addInstr(fn, op, bytecode.FLG__, 0)
+			e.emitExpr(f, fn, s.Lhs[0], atTrue)
 		}
-	case "+=", "-=", "*=", "/=", "%=":
-		e.assert(sym.Ar == parser.ArBinary, errors.New("expected `"+sym.Id+"` to have binary arity"))
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		e.addInstr(fn, binAsgSym2op[sym.Id], bytecode.FLG__, 0)
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atTrue)
-	case "++", "--":
-		e.assert(sym.Ar == parser.ArStatement, errors.New("expected `"+sym.Id+"` to have statement arity"))
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
+	case *ast.IncDecStmt:
+		e.emitExpr(f, fn, s.X, atFalse)
 		// Implicit `1` constant
 		ix := e.registerK(fn, "1", false, false)
 		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, ix)
-		e.addInstr(fn, unrSym2op[sym.Id], bytecode.FLG__, 0)
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atTrue)
-	case "func":
+		e.addInstr(fn, unrTok2op[s.Tok], bytecode.FLG__, 0)
+		e.emitExpr(f, fn, s.X, atTrue)
+	case *ast.FuncDecl:
+		// Register the name as a K, and push the function's value into this
+		// variable.
 		funcIx := len(f.Fns) // New Fn will be added at this index
-		if sym.Name != "" {
-			// Function defined as a statement, register the name as a K,
-			// and push the function's value into this variable.
-			kix := e.registerK(fn, sym.Name, true, true)
-			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_F, uint64(funcIx))
-			e.addInstr(fn, bytecode.OP_POP, bytecode.FLG_V, kix)
-		}
-		e.emitFn(f, sym)
-		if sym.Name == "" {
-			// Func defined as an expression, must be pushed on the stack
-			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_F, uint64(funcIx))
-		}
-	case "(":
-		e.assert(sym.Ar == parser.ArBinary || sym.Ar == parser.ArTernary, errors.New("expected `(` to have binary or ternary arity"))
-		// Push parameters
-		var parms []*parser.Symbol
-		var op bytecode.Opcode
-		if sym.Ar == parser.ArBinary {
-			parms = sym.Second.([]*parser.Symbol)
-			op = bytecode.OP_CALL
-		} else {
-			parms = sym.Third.([]*parser.Symbol)
-			op = bytecode.OP_CFLD
-		}
-		for _, parm := range parms {
-			e.emitSymbol(f, fn, parm, atFalse)
-		}
-		// If ternary, push field (Second)
-		if sym.Ar == parser.ArTernary {
-			e.emitSymbol(f, fn, sym.Second.(*parser.Symbol), atFalse)
-		}
-		// Push function name (or parent object of the field if ternary)
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		// Call
-		e.addInstr(fn, op, bytecode.FLG_An, uint64(len(parms)))
-	case "{":
-		e.assert(sym.Ar == parser.ArUnary, errors.New("expected `{` to have unary arity"))
-		ln := 0
-		if !e.isEmpty(sym.First) {
-			e.emitAny(f, fn, sym, sym.First)
-			if ar, ok := sym.First.([]*parser.Symbol); ok {
-				ln = len(ar)
-			}
-		}
-		e.addInstr(fn, bytecode.OP_NEW, bytecode.FLG__, uint64(ln))
-	case "?":
-		// Similar to if, but yields a value
-		e.assert(sym.Ar == parser.ArTernary, errors.New("expected `?` to have ternary arity"))
-		e.emitShortcutIf(f, fn, sym, sym.First, sym.Second, sym.Third)
-	case "if":
-		e.assert(sym.Ar == parser.ArStatement, errors.New("expected `if` to have statement arity"))
-		// First is the condition, always a *Symbol
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
+		kix := e.registerK(fn, s.Name.Name, true, true)
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_F, uint64(funcIx))
+		e.addInstr(fn, bytecode.OP_POP, bytecode.FLG_V, kix)
+		e.emitFn(f, s.Name.Name, s.Func)
+	case *ast.DeferStmt:
+		e.emitCall(f, fn, s.Call, true)
+	case *ast.IfStmt:
+		e.emitExpr(f, fn, s.Cond, atFalse)
 		// Next comes the TEST, but we don't know yet how many instructions to jump
 		// insert a placeholder (invalid op) so that it fails explicitly should it ever make it to
 		// the VM.
 		tstIx := e.addTempInstr(fn)
 		// Then comes the body
-		e.emitBlock(f, fn, sym.Second.([]*parser.Symbol))
+		e.emitBlock(f, fn, s.Body.List)
 		// Update the test instruction, now that we know where to jump to
 		e.updateTestInstr(fn, tstIx)
 		// Then comes the ELSE/ELSE IF, maybe
-		if sym.Third != nil {
+		if s.Else != nil {
 			// If so, insert a jump over the else part
 			jmpIx := e.addTempInstr(fn)
 			// And re-update the test instruction, since an instr was added
 			e.updateTestInstr(fn, tstIx)
 			// Emit the else or else-if part
-			e.emitAny(f, fn, sym, sym.Third)
+			if b, ok := s.Else.(*ast.BlockStmt); ok {
+				e.emitBlock(f, fn, b.List)
+			} else {
+				e.emitStmt(f, fn, s.Else)
+			}
 			// Update the jump instruction now that we know how many instrs to jump over
 			e.updateJumpfInstr(fn, jmpIx)
 		}
-	case "forr":
-		// For-range notation, distinct from regular for
-		// First must be either a `=` or a `:=`
-		assign := sym.First.(*parser.Symbol)
-		e.assert(assign.Id == "=" || assign.Id == ":=", errors.New("left hand side of `for...range` must be `=` or `:=`"))
-		rng := assign.Second.(*parser.Symbol)
-		e.assert(rng.Id == "range", errors.New("right hand side of `for...range` must be the `range` keyword"))
-		// Push `range` args onto the stack
-		args := rng.First.([]*parser.Symbol)
-		e.emitBlock(f, fn, args)
-		// Start the `range` coroutine
-		e.addInstr(fn, bytecode.OP_RNGS, bytecode.FLG_An, uint64(len(args)))
-		// For loop officially starts here
-		start := len(fn.Is)
-		// Push one value from the coro (until multiple vals are supported), + condition
-		e.addInstr(fn, bytecode.OP_RNGP, bytecode.FLG_An, 1)
-		// Test the end of loop
-		tstIx := e.addTempInstr(fn)
-		// Pop the top value from the stack into the iteration var
-		if assign.Id == "=" {
-			e.emitSymbol(f, fn, assign.First.(*parser.Symbol), atTrue)
+	case *ast.LabeledStmt:
+		switch st := s.Stmt.(type) {
+		case *ast.ForStmt:
+			e.emitFor(f, fn, st, s.Label.Name)
+		case *ast.RangeStmt:
+			e.emitRange(f, fn, st, s.Label.Name)
+		case *ast.SwitchStmt:
+			e.emitSwitch(f, fn, st, s.Label.Name)
+		default:
+			e.err = errors.New("label " + s.Label.Name + " must be followed by a `for` loop or a `switch`")
+		}
+	case *ast.ForStmt:
+		e.emitFor(f, fn, s, "")
+	case *ast.RangeStmt:
+		e.emitRange(f, fn, s, "")
+	case *ast.SwitchStmt:
+		e.emitSwitch(f, fn, s, "")
+	case *ast.DebugStmt:
+		var err error
+		var ix int64 = 1 // Default to 1 stack to dump
+		if s.Count != nil {
+			// If present, it must be a literal number
+			ix, err = strconv.ParseInt(s.Count.Value, 10, 64)
+			e.assert(err == nil, errors.New("invalid number literal"))
+		}
+		e.addInstr(fn, bytecode.OP_DUMP, bytecode.FLG_Sn, uint64(ix))
+	case *ast.BranchStmt:
+		label := ""
+		if s.Label != nil {
+			label = s.Label.Name
+		}
+		e.emitForJmp(fn, s.Tok == token.BREAK, label)
+	case *ast.ReturnStmt:
+		switch len(s.Results) {
+		case 0:
+			// Return without value, equivalent to return nil
+			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_N, 0)
+			e.addInstr(fn, bytecode.OP_RET, bytecode.FLG__, 0)
+		case 1:
+			e.emitExpr(f, fn, s.Results[0], atFalse)
+			if _, ok := ast.Unparen(s.Results[0]).(*ast.CallExpr); ok {
+				// Forward all the values returned by the call
+				e.addInstr(fn, bytecode.OP_RET, bytecode.FLG_Rn, 0)
+			} else {
+				e.addInstr(fn, bytecode.OP_RET, bytecode.FLG__, 0)
+			}
+		default:
+			// Multiple return values
+			e.emitExprs(f, fn, s.Results)
+			e.addInstr(fn, bytecode.OP_RET, bytecode.FLG_Rn, uint64(len(s.Results)))
+		}
+	default:
+		e.err = fmt.Errorf("unexpected statement %T", s)
+	}
+}
+
+func (e *Emitter) emitExpr(f *bytecode.File, fn *bytecode.Fn, x ast.Expr, asg asgType) {
+	if e.err != nil {
+		return
+	}
+	// The instructions emitted for this expression are mapped to its line
+	defer e.mapLine(x)()
+	switch x := x.(type) {
+	case *ast.NilLit:
+		e.assert(asg == atFalse, errors.New("invalid assignment to nil"))
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_N, 0)
+	case *ast.Ident:
+		// Register the name, may or may not be a local. Globals are names like
+		// any other, resolved at runtime.
+		kix := e.registerK(fn, x.Name, true, asg == atDefine)
+		if asg != atFalse {
+			e.addInstr(fn, bytecode.OP_POP, bytecode.FLG_V, kix)
 		} else {
-			e.emitSymbol(f, fn, assign.First.(*parser.Symbol), atDefine)
+			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_V, kix)
 		}
-		// Emit the body
-		e.startFor(fn)
-		e.emitAny(f, fn, sym, sym.Second)
-		// Update the continue statements (must jump to the next statement)
-		e.updateForJmp(fn, false)
-		// Add the jump back to RNGP instruction
-		e.addInstr(fn, bytecode.OP_JMP, bytecode.FLG_Jb, uint64(len(fn.Is)-start))
-		// Break statements must jump to the next statement (RNGE)
-		e.updateForJmp(fn, true)
-		// Update the test instruction to jump to the next statement (RNGE)
+	case *ast.BasicLit:
+		e.assert(asg == atFalse, errors.New("invalid assignment to a literal"))
+		kix := e.registerK(fn, x.Value, false, false)
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
+	case *ast.BoolLit:
+		e.assert(asg == atFalse, errors.New("invalid assignment to a literal"))
+		kix := e.registerK(fn, x.Value, false, false)
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
+	case *ast.ThisExpr:
+		e.assert(asg == atFalse, errors.New("invalid assignment to the `this` keyword"))
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_T, 0)
+	case *ast.ArgsExpr:
+		e.assert(asg == atFalse, errors.New("invalid assignment to the `args` keyword"))
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_A, 0)
+	case *ast.ParenExpr:
+		e.emitExpr(f, fn, x.X, asg)
+	case *ast.ArrayLit:
+		e.assert(asg == atFalse, errors.New("invalid assignment to an array literal"))
+		e.emitExprs(f, fn, x.Elts)
+		e.addInstr(fn, bytecode.OP_NEWA, bytecode.FLG__, uint64(len(x.Elts)))
+	case *ast.ObjectLit:
+		e.assert(asg == atFalse, errors.New("invalid assignment to an object literal"))
+		for _, kv := range x.Elts {
+			e.emitExpr(f, fn, kv.Value, atFalse)
+			// The key is pushed after its value
+			switch k := kv.Key.(type) {
+			case *ast.Ident:
+				e.emitName(fn, k.Name, kv.Value)
+			case *ast.BasicLit:
+				e.emitName(fn, k.Value, kv.Value)
+			default:
+				e.err = fmt.Errorf("unexpected object key %T", k)
+			}
+		}
+		e.addInstr(fn, bytecode.OP_NEW, bytecode.FLG__, uint64(len(x.Elts)))
+	case *ast.SelectorExpr:
+		e.emitName(fn, x.Sel.Name, x.Sel)
+		e.emitExpr(f, fn, x.X, atFalse)
+		e.emitFieldOp(fn, asg)
+	case *ast.IndexExpr:
+		e.emitExpr(f, fn, x.Index, atFalse)
+		e.emitExpr(f, fn, x.X, atFalse)
+		e.emitFieldOp(fn, asg)
+	case *ast.UnaryExpr:
+		op, ok := unrTok2op[x.Op]
+		e.assert(ok && x.Op != token.INC && x.Op != token.DEC, errors.New("unexpected unary operator "+x.Op.String()))
+		e.emitExpr(f, fn, x.X, atFalse)
+		e.addInstr(fn, op, bytecode.FLG__, 0)
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.AND:
+			// Equivalent to if <first> then <second> else <first>
+			e.emitShortcutIf(f, fn, x.X, x.Y, x.X)
+		case token.OR:
+			// Equivalent to if <first> then <first> else <second>
+			e.emitShortcutIf(f, fn, x.X, x.X, x.Y)
+		default:
+			op, ok := binTok2op[x.Op]
+			e.assert(ok, errors.New("unexpected binary operator "+x.Op.String()))
+			e.emitExpr(f, fn, x.X, atFalse)
+			e.emitExpr(f, fn, x.Y, atFalse)
+			e.addInstr(fn, op, bytecode.FLG__, 0)
+		}
+	case *ast.CondExpr:
+		// Similar to if, but yields a value
+		e.emitShortcutIf(f, fn, x.Cond, x.Then, x.Else)
+	case *ast.FuncLit:
+		// Func defined as an expression, must be pushed on the stack
+		funcIx := len(f.Fns) // New Fn will be added at this index
+		e.emitFn(f, "", x)
+		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_F, uint64(funcIx))
+	case *ast.CallExpr:
+		e.emitCall(f, fn, x, false)
+	case *ast.YieldExpr:
+		e.assert(len(e.fnIx) > 1, errors.New("cannot yield from the top-level module function"))
+		// Push the value to yield
+		if x.X != nil {
+			e.emitExpr(f, fn, x.X, atFalse)
+		} else {
+			e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_N, 0)
+		}
+		// Yield
+		e.addInstr(fn, bytecode.OP_YLD, bytecode.FLG__, 0)
+	default:
+		e.err = fmt.Errorf("unexpected expression %T", x)
+	}
+}
+
+// Emit the instruction that sets or gets a field, the key and the object
+// being on the stack.
+func (e *Emitter) emitFieldOp(fn *bytecode.Fn, asg asgType) {
+	if asg != atFalse {
+		e.addInstr(fn, bytecode.OP_SFLD, bytecode.FLG__, 0)
+	} else {
+		e.addInstr(fn, bytecode.OP_GFLD, bytecode.FLG__, 0)
+	}
+}
+
+// Emit a function or method call. If dfr is true, the call is deferred
+// until the function returns, instead of being executed immediately.
+func (e *Emitter) emitCall(f *bytecode.File, fn *bytecode.Fn, x *ast.CallExpr, dfr bool) {
+	// Push parameters
+	e.emitExprs(f, fn, x.Args)
+	op := bytecode.OP_CALL
+	var flg bytecode.Flag = bytecode.FLG_An
+	switch fun := ast.Unparen(x.Fun).(type) {
+	case *ast.SelectorExpr:
+		// Method call, push the field, then the object
+		e.emitName(fn, fun.Sel.Name, fun.Sel)
+		e.emitExpr(f, fn, fun.X, atFalse)
+		op = bytecode.OP_CFLD
+	case *ast.IndexExpr:
+		e.emitExpr(f, fn, fun.Index, atFalse)
+		e.emitExpr(f, fn, fun.X, atFalse)
+		op = bytecode.OP_CFLD
+	default:
+		// Push the function
+		e.emitExpr(f, fn, x.Fun, atFalse)
+	}
+	if dfr {
+		if op == bytecode.OP_CFLD {
+			flg = bytecode.FLG_Mn
+		}
+		op = bytecode.OP_DFR
+	}
+	// Call
+	e.addInstr(fn, op, flg, uint64(len(x.Args)))
+}
+
+// Emit a `for` loop, with its optional label.
+func (e *Emitter) emitFor(f *bytecode.File, fn *bytecode.Fn, s *ast.ForStmt, label string) {
+	var tstIx int
+	start := len(fn.Is)
+	if s.Init != nil {
+		// 3-part form, render the init part
+		e.emitStmt(f, fn, s.Init)
+		// The start of the loop, for the jumpback instruction, is now the next instr
+		start = len(fn.Is)
+	}
+	if s.Cond != nil {
+		// Emit the condition
+		e.emitExpr(f, fn, s.Cond, atFalse)
+		// Add a test instruction placeholder
+		tstIx = e.addTempInstr(fn)
+	}
+	// Emit the body
+	e.startFor(fn, label, false, false)
+	e.emitBlock(f, fn, s.Body.List)
+	// Update the continue statements (must jump to the next statement)
+	e.updateForJmp(fn, false)
+	if s.Post != nil {
+		// Emit the post statement
+		e.emitStmt(f, fn, s.Post)
+	}
+	// Add the jump-back to for condition instruction (or for body start if no condition)
+	e.addInstr(fn, bytecode.OP_JMP, bytecode.FLG_Jb, uint64(len(fn.Is)-start))
+	if s.Cond != nil {
+		// Update the test instruction
 		e.updateTestInstr(fn, tstIx)
-		e.endFor(fn)
-		// Emit the range end (clear coroutine) statement
-		e.addInstr(fn, bytecode.OP_RNGE, bytecode.FLG__, 0)
+	}
+	// The break statements must jump to the next statement (after the whole for loop)
+	e.updateForJmp(fn, true)
+	e.endFor(fn)
+}
+
+// Emit a `for...range` loop, with its optional label.
+func (e *Emitter) emitRange(f *bytecode.File, fn *bytecode.Fn, s *ast.RangeStmt, label string) {
+	e.assert(s.Tok == token.ASSIGN || s.Tok == token.DEFINE, errors.New("left hand side of `for...range` must be `=` or `:=`"))
+	// Push `range` args onto the stack
+	e.emitExprs(f, fn, s.Args)
+	// Start the `range` coroutine
+	e.addInstr(fn, bytecode.OP_RNGS, bytecode.FLG_An, uint64(len(s.Args)))
+	// For loop officially starts here
+	start := len(fn.Is)
+	// Push one value from the coro (until multiple vals are supported), + condition
+	e.addInstr(fn, bytecode.OP_RNGP, bytecode.FLG_An, 1)
+	// Test the end of loop
+	tstIx := e.addTempInstr(fn)
+	// Pop the top value from the stack into the iteration var
+	if s.Tok == token.ASSIGN {
+		e.emitExpr(f, fn, s.Value, atTrue)
+	} else {
+		e.emitExpr(f, fn, s.Value, atDefine)
+	}
+	// Emit the body
+	e.startFor(fn, label, false, true)
+	e.emitBlock(f, fn, s.Body.List)
+	// Update the continue statements (must jump to the next statement)
+	e.updateForJmp(fn, false)
+	// Add the jump back to RNGP instruction
+	e.addInstr(fn, bytecode.OP_JMP, bytecode.FLG_Jb, uint64(len(fn.Is)-start))
+	// Break statements must jump to the next statement (RNGE)
+	e.updateForJmp(fn, true)
+	// Update the test instruction to jump to the next statement (RNGE)
+	e.updateTestInstr(fn, tstIx)
+	e.endFor(fn)
+	// Emit the range end (clear coroutine) statement
+	e.addInstr(fn, bytecode.OP_RNGE, bytecode.FLG__, 0)
+}
 
-	case "for":
+// Emit a `switch` statement, with its optional label.
+func (e *Emitter) emitSwitch(f *bytecode.File, fn *bytecode.Fn, s *ast.SwitchStmt, label string) {
+	var tagIx uint64
+	tagged := s.Tag != nil
+	if tagged {
+		// Evaluate the tag only once, store it in a hidden local variable
+		e.emitExpr(f, fn, s.Tag, atFalse)
+		tagIx = e.registerSwitchTag(fn)
+		e.addInstr(fn, bytecode.OP_POP, bytecode.FLG_V, tagIx)
+	}
+	e.startFor(fn, label, true, false)
+	// The default clause, if any, is always emitted last
+	var dflt *ast.CaseClause
+	for _, st := range s.Body.List {
+		c, ok := st.(*ast.CaseClause)
+		e.assert(ok, errors.New("expected the body of `switch` to be case clauses"))
+		if !ok {
+			return
+		}
+		if c.List == nil {
+			dflt = c
+			continue
+		}
 		var tstIx int
-		var parts []interface{}
-		var ok bool
-		start := len(fn.Is)
-		empty := e.isEmpty(sym.First)
-		longForm := false
-		if !empty {
-			var cond interface{}
-			if parts, ok = sym.First.([]interface{}); ok {
-				// 3-part form, render the init part
-				e.assert(len(parts) == 3, errors.New("expected 3-part `for` loop to have 3 parts, got "+strconv.Itoa(len(parts))))
-				longForm = true
-				e.emitAny(f, fn, sym, parts[0])
-				// The start of the loop, for the jumpback instruction, is now the next instr
-				start = len(fn.Is)
-				cond = parts[1]
+		var bodyJmps []int
+		for i, v := range c.List {
+			if tagged {
+				// Compare the tag with the value
+				e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_V, tagIx)
+				e.emitExpr(f, fn, v, atFalse)
+				e.addInstr(fn, bytecode.OP_EQ, bytecode.FLG__, 0)
 			} else {
-				cond = sym.First
+				// The value is the condition
+				e.emitExpr(f, fn, v, atFalse)
 			}
-			// Emit the condition
-			e.emitAny(f, fn, sym, cond)
-			// Add a test instruction placeholder
 			tstIx = e.addTempInstr(fn)
+			if i < len(c.List)-1 {
+				// On a match, jump to the body, otherwise test the next value
+				bodyJmps = append(bodyJmps, e.addTempInstr(fn))
+				e.updateTestInstr(fn, tstIx)
+			}
 		}
-		// Emit the body
-		e.startFor(fn)
-		e.emitAny(f, fn, sym, sym.Second)
-		// Update the continue statements (must jump to the next statement)
-		e.updateForJmp(fn, false)
-		if !empty && longForm {
-			// Emit the post statement
-			e.emitAny(f, fn, sym, parts[2])
+		for _, ix := range bodyJmps {
+			e.updateJumpfInstr(fn, ix)
 		}
-		// Add the jump-back to for condition instruction (or for body start if no condition)
-		e.addInstr(fn, bytecode.OP_JMP, bytecode.FLG_Jb, uint64(len(fn.Is)-start))
-		if !empty {
-			// Update the test instruction
-			e.updateTestInstr(fn, tstIx)
+		e.emitBlock(f, fn, c.Body)
+		// Jump to the end of the switch, exactly like a break
+		e.addForData(fn, true, e.addTempInstr(fn))
+		// If the last value doesn't match, test the next case
+		e.updateTestInstr(fn, tstIx)
+	}
+	if dflt != nil {
+		e.emitBlock(f, fn, dflt.Body)
+	}
+	// The break statements must jump to the next statement (after the whole switch)
+	e.updateForJmp(fn, true)
+	e.endFor(fn)
+}
+
+// Start a `for` loop or `switch` statement, identified by its label, if any.
+// For a switch statement, sw is true, and for a range loop, rng is true.
+func (e *Emitter) startFor(fn *bytecode.Fn, label string, sw, rng bool) {
+	if label != "" {
+		for _, f := range e.forNest[fn] {
+			e.assert(f.label != label, errors.New("label "+label+" already defined"))
 		}
-		// The break statements must jump to the next statement (after the whole for loop)
-		e.updateForJmp(fn, true)
-		e.endFor(fn)
-	case "debug":
-		var err error
-		var ix int64 = 1 // Default to 1 stack to dump
-		if sym.First != nil {
-			// If present, it must be a literal number
-			ix, err = strconv.ParseInt(sym.First.(*parser.Symbol).Val.(string), 10, 64)
-			e.assert(err == nil, errors.New("invalid number literal"))
+	}
+	e.forNest[fn] = append(e.forNest[fn], &forData{
+		label: label,
+		sw:    sw,
+		rng:   rng,
+	})
+}
+
+// Emit the jump of a break or continue statement. It applies to the statement
+// identified by the label, or if there is no label, to the innermost `for` loop
+// or `switch` for a break, and to the innermost `for` loop for a continue.
+func (e *Emitter) emitForJmp(fn *bytecode.Fn, br bool, label string) {
+	fors := e.forNest[fn]
+	i := len(fors) - 1
+	for ; i >= 0; i-- {
+		if label != "" {
+			if fors[i].label == label {
+				break
+			}
+		} else if br || !fors[i].sw {
+			break
 		}
-		e.addInstr(fn, bytecode.OP_DUMP, bytecode.FLG_Sn, uint64(ix))
-	case "break":
-		e.assert(len(e.forNest[fn]) > 0, errors.New("invalid break statement outside any `for` loop"))
-		e.addForData(fn, true, e.addTempInstr(fn))
-	case "continue":
-		e.assert(len(e.forNest[fn]) > 0, errors.New("invalid continue statement outside any `for` loop"))
-		e.addForData(fn, false, e.addTempInstr(fn))
-	case "yield":
-		e.assert(len(e.fnIx) > 1, errors.New("cannot yield from the top-level module function"))
-		// Push the value to yield
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		// Yield
-		e.addInstr(fn, bytecode.OP_YLD, bytecode.FLG__, 0)
-	case "return":
-		e.emitSymbol(f, fn, sym.First.(*parser.Symbol), atFalse)
-		e.addInstr(fn, bytecode.OP_RET, bytecode.FLG__, 0)
+	}
+	switch {
+	case label != "":
+		e.assert(i >= 0, errors.New("undefined label "+label))
+	case br:
+		e.assert(i >= 0, errors.New("invalid break statement outside any `for` loop or `switch`"))
 	default:
-		e.err = errors.New("unexpected symbol id: " + sym.Id)
+		e.assert(i >= 0, errors.New("invalid continue statement outside any `for` loop"))
 	}
-	// After treating the symbol, if it had a Key value, push the Key name
-	if sym.Key != nil {
-		// Can be on name, literal, func call, any operator, hard to assert...
-		kix := e.registerK(fn, sym.Key, true, false)
-		e.addInstr(fn, bytecode.OP_PUSH, bytecode.FLG_K, kix)
+	if e.err != nil {
+		return
+	}
+	e.assert(br || !fors[i].sw, errors.New("invalid continue label "+label+", not a `for` loop"))
+	// Release the iterators of the range loops that are exited by the jump
+	for j := len(fors) - 1; j > i; j-- {
+		if fors[j].rng {
+			e.addInstr(fn, bytecode.OP_RNGE, bytecode.FLG__, 0)
+		}
+	}
+	ix := e.addTempInstr(fn)
+	if br {
+		fors[i].breaks = append(fors[i].breaks, ix)
+	} else {
+		fors[i].conts = append(fors[i].conts, ix)
 	}
 }
 
-func (e *Emitter) startFor(fn *bytecode.Fn) {
-	e.forNest[fn] = append(e.forNest[fn], &forData{})
+// Register the hidden local variable that holds the tag of a switch statement.
+// Since the tag is never read once the body of a case is entered, a single
+// variable per function is enough, even for nested switch statements.
+func (e *Emitter) registerSwitchTag(fn *bytecode.Fn) uint64 {
+	kix := e.registerK(fn, switchTagName, true, false)
+	for _, l := range fn.Ls {
+		if l == int64(kix) {
+			return kix
+		}
+	}
+	fn.Ls = append(fn.Ls, int64(kix))
+	return kix
 }
 
 func (e *Emitter) endFor(fn *bytecode.Fn) {
@@ -467,21 +715,6 @@ func (e *Emitter) addForData(fn *bytecode.Fn, br bool, ix int) {
 	}
 }
 
-func (e *Emitter) isEmpty(v interface{}) bool {
-	if v == nil {
-		return true
-	}
-	switch s := v.(type) {
-	case *parser.Symbol:
-		return s == nil
-	case []*parser.Symbol:
-		return len(s) == 0
-	case []interface{}:
-		return len(s) == 0
-	}
-	return true
-}
-
 func (e *Emitter) addTempInstr(fn *bytecode.Fn) int {
 	e.addInstr(fn, bytecode.OP_INVL, bytecode.FLG_INVL, 0)
 	return len(fn.Is) - 1
@@ -504,7 +737,17 @@ func (e *Emitter) addInstr(fn *bytecode.Fn, op bytecode.Opcode, flg bytecode.Fla
 		e.stackSz[fn] += 1
 	case bytecode.OP_NEW:
 		e.stackSz[fn] += (1 - (2 * int64(ix)))
-	case bytecode.OP_POP, bytecode.OP_RET, bytecode.OP_UNM, bytecode.OP_NOT, bytecode.OP_TEST,
+	case bytecode.OP_NEWA:
+		e.stackSz[fn] += (1 - int64(ix))
+	case bytecode.OP_UNPK:
+		e.stackSz[fn] += (int64(ix) - 1)
+	case bytecode.OP_RET:
+		if flg == bytecode.FLG_Rn && ix > 0 {
+			e.stackSz[fn] -= int64(ix)
+		} else {
+			e.stackSz[fn] -= 1
+		}
+	case bytecode.OP_POP, bytecode.OP_UNM, bytecode.OP_NOT, bytecode.OP_TEST,
 		bytecode.OP_LT, bytecode.OP_LTE, bytecode.OP_GT, bytecode.OP_GTE, bytecode.OP_EQ,
 		bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL,
 		bytecode.OP_DIV, bytecode.OP_MOD, bytecode.OP_GFLD, bytecode.OP_NEQ:
@@ -515,11 +758,35 @@ func (e *Emitter) addInstr(fn *bytecode.Fn, op bytecode.Opcode, flg bytecode.Fla
 		e.stackSz[fn] -= (int64(ix) + 1)
 	case bytecode.OP_CFLD:
 		e.stackSz[fn] -= (int64(ix) + 2)
+	case bytecode.OP_DFR:
+		if flg == bytecode.FLG_Mn {
+			e.stackSz[fn] -= (int64(ix) + 2)
+		} else {
+			e.stackSz[fn] -= (int64(ix) + 1)
+		}
 	}
 	if e.stackSz[fn] > fn.Header.StackSz {
 		fn.Header.StackSz = e.stackSz[fn]
 	}
 	fn.Is = append(fn.Is, bytecode.NewInstr(op, flg, ix))
+	e.addPos(fn)
+}
+
+// Record the line of the last instruction in the line table of the function,
+// if it is different from the line of the previous instruction.
+func (e *Emitter) addPos(fn *bytecode.Fn) {
+	if e.line <= 0 {
+		return
+	}
+	if l := len(fn.Ps); l == 0 || fn.Ps[l-1].Line != e.line {
+		fn.Ps = append(fn.Ps, bytecode.Pos{Ix: int64(len(fn.Is) - 1), Line: e.line})
+	}
+	if fn.Header.LineStart == 0 || e.line < fn.Header.LineStart {
+		fn.Header.LineStart = e.line
+	}
+	if e.line > fn.Header.LineEnd {
+		fn.Header.LineEnd = e.line
+	}
 }
 
 func (e *Emitter) registerK(fn *bytecode.Fn, val interface{}, isName bool, local bool) uint64 {
@@ -569,7 +836,7 @@ func (e *Emitter) registerK(fn *bytecode.Fn, val interface{}, isName bool, local
 	// If this is a local definition, add the K index to this function's L table.
 	// It can't have duplicates by definition, because it would have been caught as an
 	// error in the parser stage.
-	if local {
+	if local && !(e.GlobalVars && len(e.fnIx) == 1) {
 		fn.Ls = append(fn.Ls, int64(i))
 	}
 	return uint64(i)
@@ -5,23 +5,25 @@ import (
 	"testing"
 
 	"github.com/bobg/agora/bytecode"
+	"github.com/bobg/agora/compiler/ast"
 	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/token"
 	"github.com/davecgh/go-spew/spew"
 )
 
 var (
 	// The cases here match the files in /compiler/emitter/testdata/*
-	// The symbols (src field of the case) are validated by running
+	// The syntax trees (src field of the case) are validated by running
 	// `agora ast FILE`.
 	emitcases = []struct {
-		src []*parser.Symbol
+		src []ast.Stmt
 		exp *bytecode.File
 		err bool
 	}{
 		0: {
 			// Assignment
-			src: []*parser.Symbol{
-				&parser.Symbol{Id: ":=", Ar: parser.ArBinary, First: &parser.Symbol{Id: "(name)", Val: "a"}, Second: &parser.Symbol{Id: "(literal)", Val: "5"}},
+			src: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.DEFINE, Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "5"}}},
 			},
 			exp: &bytecode.File{
 				Fns: []*bytecode.Fn{
@@ -39,7 +41,7 @@ var (
 						},
 						Is: []bytecode.Instr{
 							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
-							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_V, 1),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
 						},
 					},
 				},
@@ -47,8 +49,8 @@ var (
 		},
 		1: {
 			// return nil
-			src: []*parser.Symbol{
-				&parser.Symbol{Id: "return", Ar: parser.ArStatement, First: &parser.Symbol{Id: "nil", Ar: parser.ArName, Val: nil}},
+			src: []ast.Stmt{
+				&ast.ReturnStmt{Results: []ast.Expr{&ast.NilLit{}}},
 			},
 			exp: &bytecode.File{
 				Fns: []*bytecode.Fn{
@@ -63,9 +65,9 @@ var (
 		},
 		2: {
 			// NOT (!) operator
-			src: []*parser.Symbol{
-				&parser.Symbol{Id: ":=", Ar: parser.ArBinary, First: &parser.Symbol{Id: "(name)", Val: "a"},
-					Second: &parser.Symbol{Id: "!", Ar: parser.ArUnary, First: &parser.Symbol{Id: "true", Val: true, Ar: parser.ArLiteral}}},
+			src: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.UnaryExpr{Op: token.NOT, X: &ast.BoolLit{Value: true}}}},
 			},
 			exp: &bytecode.File{
 				Fns: []*bytecode.Fn{
@@ -83,7 +85,7 @@ var (
 						Is: []bytecode.Instr{
 							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
 							bytecode.NewInstr(bytecode.OP_NOT, bytecode.FLG__, 0),
-							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_V, 1),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
 						},
 					},
 				},
@@ -91,9 +93,9 @@ var (
 		},
 		3: {
 			// UNM (-) operator
-			src: []*parser.Symbol{
-				&parser.Symbol{Id: ":=", Ar: parser.ArBinary, First: &parser.Symbol{Id: "(name)", Val: "a"},
-					Second: &parser.Symbol{Id: "-", Ar: parser.ArUnary, First: &parser.Symbol{Id: "(literal)", Val: "1", Ar: parser.ArLiteral}}},
+			src: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.UnaryExpr{Op: token.SUB, X: &ast.BasicLit{Kind: token.INT, Value: "1"}}}},
 			},
 			exp: &bytecode.File{
 				Fns: []*bytecode.Fn{
@@ -111,7 +113,7 @@ var (
 						Is: []bytecode.Instr{
 							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
 							bytecode.NewInstr(bytecode.OP_UNM, bytecode.FLG__, 0),
-							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_V, 1),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
 						},
 					},
 				},
@@ -119,9 +121,9 @@ var (
 		},
 		4: {
 			// ADD (+) operator
-			src: []*parser.Symbol{
-				&parser.Symbol{Id: ":=", Ar: parser.ArBinary, First: &parser.Symbol{Id: "(name)", Val: "a"},
-					Second: &parser.Symbol{Id: "+", Ar: parser.ArBinary, First: &parser.Symbol{Id: "(literal)", Val: "5", Ar: parser.ArLiteral}, Second: &parser.Symbol{Id: "(literal)", Val: "2", Ar: parser.ArLiteral}}},
+			src: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.BinaryExpr{X: &ast.BasicLit{Kind: token.INT, Value: "5"}, Op: token.ADD, Y: &ast.BasicLit{Kind: token.INT, Value: "2"}}}},
 			},
 			exp: &bytecode.File{
 				Fns: []*bytecode.Fn{
@@ -144,7 +146,264 @@ var (
 							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
 							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 1),
 							bytecode.NewInstr(bytecode.OP_ADD, bytecode.FLG__, 0),
-							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_V, 2),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
+						},
+					},
+				},
+			},
+		},
+		5: {
+			// Array literal
+			src: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.DEFINE,
+					Rhs: []ast.Expr{&ast.ArrayLit{Elts: []ast.Expr{
+						&ast.BasicLit{Kind: token.INT, Value: "10"},
+						&ast.BoolLit{Value: true},
+					}}}},
+			},
+			exp: &bytecode.File{
+				Fns: []*bytecode.Fn{
+					&bytecode.Fn{
+						Ks: []*bytecode.K{
+							&bytecode.K{
+								Type: bytecode.KtInteger,
+								Val:  int64(10),
+							},
+							&bytecode.K{
+								Type: bytecode.KtBoolean,
+								Val:  int64(1),
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "a",
+							},
+						},
+						Is: []bytecode.Instr{
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 1),
+							bytecode.NewInstr(bytecode.OP_NEWA, bytecode.FLG__, 2),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
+						},
+					},
+				},
+			},
+		},
+		6: {
+			// Multiple assignment, call statement and multiple return values
+			src: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{
+						&ast.Ident{Name: "x"},
+						&ast.Ident{Name: "y"},
+					},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{Fun: &ast.Ident{Name: "f"}},
+					}},
+				&ast.ExprStmt{X: &ast.CallExpr{Fun: &ast.Ident{Name: "f"}}},
+				&ast.ReturnStmt{Results: []ast.Expr{
+					&ast.Ident{Name: "y"},
+					&ast.Ident{Name: "x"},
+				}},
+			},
+			exp: &bytecode.File{
+				Fns: []*bytecode.Fn{
+					&bytecode.Fn{
+						Ks: []*bytecode.K{
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "f",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "y",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "x",
+							},
+						},
+						Is: []bytecode.Instr{
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_V, 0),
+							bytecode.NewInstr(bytecode.OP_CALL, bytecode.FLG_An, 0),
+							bytecode.NewInstr(bytecode.OP_UNPK, bytecode.FLG_Rn, 2),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 1),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_V, 0),
+							bytecode.NewInstr(bytecode.OP_CALL, bytecode.FLG_An, 0),
+							bytecode.NewInstr(bytecode.OP_UNPK, bytecode.FLG_Rn, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_L, 1),
+							bytecode.NewInstr(bytecode.OP_RET, bytecode.FLG_Rn, 2),
+						},
+					},
+				},
+			},
+		},
+		7: {
+			// Switch statement
+			src: []ast.Stmt{
+				&ast.SwitchStmt{
+					Tag: &ast.Ident{Name: "a"},
+					Body: &ast.BlockStmt{List: []ast.Stmt{
+						&ast.CaseClause{
+							List: []ast.Expr{
+								&ast.BasicLit{Kind: token.INT, Value: "1"},
+								&ast.BasicLit{Kind: token.INT, Value: "2"},
+							},
+							Body: []ast.Stmt{
+								&ast.AssignStmt{Lhs: []ast.Expr{&ast.Ident{Name: "a"}}, Tok: token.ASSIGN,
+									Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "2"}}},
+							}},
+						&ast.CaseClause{
+							Body: []ast.Stmt{
+								&ast.BranchStmt{Tok: token.BREAK},
+							}},
+					}}},
+			},
+			exp: &bytecode.File{
+				Fns: []*bytecode.Fn{
+					&bytecode.Fn{
+						Ks: []*bytecode.K{
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "a",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "(switch)",
+							},
+							&bytecode.K{
+								Type: bytecode.KtInteger,
+								Val:  int64(1),
+							},
+							&bytecode.K{
+								Type: bytecode.KtInteger,
+								Val:  int64(2),
+							},
+						},
+						Is: []bytecode.Instr{
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_V, 0),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 2),
+							bytecode.NewInstr(bytecode.OP_EQ, bytecode.FLG__, 0),
+							bytecode.NewInstr(bytecode.OP_TEST, bytecode.FLG_Jf, 1),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jf, 4),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 3),
+							bytecode.NewInstr(bytecode.OP_EQ, bytecode.FLG__, 0),
+							bytecode.NewInstr(bytecode.OP_TEST, bytecode.FLG_Jf, 3),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 3),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_V, 0),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jf, 1),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jf, 0),
+						},
+					},
+				},
+			},
+		},
+		8: {
+			// Labeled break out of nested range loops
+			src: []ast.Stmt{
+				&ast.LabeledStmt{Label: &ast.Ident{Name: "outer"}, Stmt: &ast.RangeStmt{
+					Value: &ast.Ident{Name: "x"}, Tok: token.DEFINE,
+					Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "3"}},
+					Body: &ast.BlockStmt{List: []ast.Stmt{
+						&ast.RangeStmt{
+							Value: &ast.Ident{Name: "y"}, Tok: token.DEFINE,
+							Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "3"}},
+							Body: &ast.BlockStmt{List: []ast.Stmt{
+								&ast.BranchStmt{Tok: token.BREAK, Label: &ast.Ident{Name: "outer"}},
+							}}},
+					}}}},
+			},
+			exp: &bytecode.File{
+				Fns: []*bytecode.Fn{
+					&bytecode.Fn{
+						Ks: []*bytecode.K{
+							&bytecode.K{
+								Type: bytecode.KtInteger,
+								Val:  int64(3),
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "x",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "y",
+							},
+						},
+						Is: []bytecode.Instr{
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
+							bytecode.NewInstr(bytecode.OP_RNGS, bytecode.FLG_An, 1),
+							bytecode.NewInstr(bytecode.OP_RNGP, bytecode.FLG_An, 1),
+							bytecode.NewInstr(bytecode.OP_TEST, bytecode.FLG_Jf, 11),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
+							bytecode.NewInstr(bytecode.OP_RNGS, bytecode.FLG_An, 1),
+							bytecode.NewInstr(bytecode.OP_RNGP, bytecode.FLG_An, 1),
+							bytecode.NewInstr(bytecode.OP_TEST, bytecode.FLG_Jf, 4),
+							bytecode.NewInstr(bytecode.OP_POP, bytecode.FLG_L, 1),
+							bytecode.NewInstr(bytecode.OP_RNGE, bytecode.FLG__, 0),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jf, 3),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jb, 5),
+							bytecode.NewInstr(bytecode.OP_RNGE, bytecode.FLG__, 0),
+							bytecode.NewInstr(bytecode.OP_JMP, bytecode.FLG_Jb, 12),
+							bytecode.NewInstr(bytecode.OP_RNGE, bytecode.FLG__, 0),
+						},
+					},
+				},
+			},
+		},
+		9: {
+			// Undefined label
+			src: []ast.Stmt{
+				&ast.LabeledStmt{Label: &ast.Ident{Name: "outer"}, Stmt: &ast.ForStmt{
+					Body: &ast.BlockStmt{List: []ast.Stmt{
+						&ast.BranchStmt{Tok: token.CONTINUE, Label: &ast.Ident{Name: "inner"}},
+					}}}},
+			},
+			err: true,
+		},
+		10: {
+			// Deferred function and method calls
+			src: []ast.Stmt{
+				&ast.DeferStmt{Call: &ast.CallExpr{Fun: &ast.Ident{Name: "f"},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.INT, Value: "1"},
+					}}},
+				&ast.DeferStmt{Call: &ast.CallExpr{Fun: &ast.SelectorExpr{X: &ast.Ident{Name: "o"}, Sel: &ast.Ident{Name: "m"}}}},
+			},
+			exp: &bytecode.File{
+				Fns: []*bytecode.Fn{
+					&bytecode.Fn{
+						Ks: []*bytecode.K{
+							&bytecode.K{
+								Type: bytecode.KtInteger,
+								Val:  int64(1),
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "f",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "m",
+							},
+							&bytecode.K{
+								Type: bytecode.KtString,
+								Val:  "o",
+							},
+						},
+						Is: []bytecode.Instr{
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 0),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_V, 1),
+							bytecode.NewInstr(bytecode.OP_DFR, bytecode.FLG_An, 1),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_K, 2),
+							bytecode.NewInstr(bytecode.OP_PUSH, bytecode.FLG_V, 3),
+							bytecode.NewInstr(bytecode.OP_DFR, bytecode.FLG_Mn, 0),
 						},
 					},
 				},
@@ -167,7 +426,7 @@ func TestEmit(t *testing.T) {
 		}
 
 		// Act
-		f, err := e.Emit("", c.src, nil)
+		f, err := e.Emit("", &ast.File{Stmts: c.src})
 
 		// Assert
 		if (err != nil) != c.err {
@@ -191,6 +450,43 @@ func TestEmit(t *testing.T) {
 	}
 }
 
+func TestLineTable(t *testing.T) {
+	src := `a := 1
+func f(x) {
+	b := x + a
+	return b
+}
+f(a)
+`
+	file, err := parser.New().ParseFile("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := new(Emitter).Emit("test", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []struct {
+		start, end int64
+		ps         []bytecode.Pos
+	}{
+		0: {1, 7, []bytecode.Pos{{Ix: 0, Line: 1}, {Ix: 2, Line: 2}, {Ix: 4, Line: 6}, {Ix: 8, Line: 7}}},
+		1: {2, 5, []bytecode.Pos{{Ix: 0, Line: 3}, {Ix: 4, Line: 4}}},
+	}
+	if len(f.Fns) != len(exp) {
+		t.Fatalf("expected %d functions, got %d", len(exp), len(f.Fns))
+	}
+	for i, c := range exp {
+		h := f.Fns[i].Header
+		if h.LineStart != c.start || h.LineEnd != c.end {
+			t.Errorf("[%d] - expected lines %d to %d, got %d to %d", i, c.start, c.end, h.LineStart, h.LineEnd)
+		}
+		if fmt.Sprint(f.Fns[i].Ps) != fmt.Sprint(c.ps) {
+			t.Errorf("[%d] - expected line table %v, got %v", i, c.ps, f.Fns[i].Ps)
+		}
+	}
+}
+
 // Duplicate from decode_test.go in bytecode package, but different
 // equal checks, here I don't care about Header and such
 func equal(c int, f1, f2 *bytecode.File) bool {
@@ -0,0 +1,570 @@
+// Package format implements the canonical formatting of agora source code,
+// as done by the `agora fmt` command.
+//
+// The formatted source code is indented with tabs, has one statement per line,
+// spaces around binary operators and at most one blank line between statements.
+// Comments are kept, as are the parentheses, the line breaks between the
+// elements of object and array literals and between the arguments of calls.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/token"
+)
+
+// Source formats the agora source code src. The source code must be valid,
+// otherwise the parse error is returned. Only the default built-in functions
+// are globals, see SourceWithGlobals to format source code that uses the globals
+// defined by an execution context.
+func Source(src []byte) ([]byte, error) {
+	return SourceWithGlobals(src, nil)
+}
+
+// SourceWithGlobals is like Source, but the global names defined by the
+// execution context are provided, so that they are not reported as undefined.
+// If globals is nil, the default built-in functions are the only globals.
+func SourceWithGlobals(src []byte, globals []string) ([]byte, error) {
+	p := parser.New()
+	p.Globals = globals
+	syms, _, err := p.Parse("", src)
+	if err != nil {
+		return nil, err
+	}
+	pr := &printer{
+		src:      src,
+		comments: p.Comments(),
+	}
+	pr.stmts(syms, math.MaxInt32)
+	if pr.out.Len() > 0 {
+		pr.out.WriteByte('\n')
+	}
+	return pr.out.Bytes(), nil
+}
+
+// A printer prints the symbols of the parser as formatted source code,
+// interleaving the comments based on their position.
+type printer struct {
+	out      bytes.Buffer
+	src      []byte
+	comments []*parser.Comment // comments not printed yet
+	indent   int
+	line     int  // line in the source code of the last token printed
+	pending  int  // newlines to print before the next token
+	bol      bool // at the beginning of a line
+	open     bool // a block was just opened, a blank line is not kept
+	comment  bool // a block comment was just printed, a space separates it from a token
+}
+
+// Print s, after the pending newlines and the indentation.
+func (p *printer) print(s string) {
+	if p.out.Len() > 0 {
+		for ; p.pending > 0; p.pending-- {
+			p.out.WriteByte('\n')
+			p.bol = true
+			p.comment = false
+		}
+	}
+	p.pending = 0
+	if p.bol {
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.bol = false
+	}
+	if p.comment && s != "" && !strings.ContainsAny(s[:1], " ,;:)]}") {
+		p.out.WriteByte(' ')
+	}
+	p.comment = false
+	p.out.WriteString(s)
+}
+
+// Print the comma before the element at the position next in the source
+// code, after the comments that are before the comma.
+func (p *printer) comma(next token.Position) {
+	if off := p.commaOffset(next.Offset); off >= 0 {
+		p.flush(off)
+	}
+	p.print(",")
+}
+
+// Get the offset of the comma before the offset offs in the source code,
+// ignoring the spaces and comments between them, or -1 if there is no comma.
+func (p *printer) commaOffset(offs int) int {
+	i := offs - 1
+loop:
+	for i >= 0 && i < len(p.src) {
+		// Skip a comment that ends at i
+		for _, c := range p.comments {
+			if start := c.Pos.Offset; start <= i && i < start+len(c.Text) {
+				i = start - 1
+				continue loop
+			}
+		}
+		switch p.src[i] {
+		case ' ', '\t', '\r', '\n':
+			i--
+		case ',':
+			return i
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// Print the token s at the position pos in the source code, after the comments
+// before it.
+func (p *printer) token(s string, pos token.Position) {
+	p.flush(pos.Offset)
+	p.print(s)
+	if pos.IsValid() {
+		p.line = pos.Line + strings.Count(s, "\n")
+	}
+}
+
+// Start a new line.
+func (p *printer) newline() {
+	if p.pending < 1 {
+		p.pending = 1
+	}
+}
+
+// Start a new line for an element at the line l of the source code, keeping
+// one blank line if there is at least one in the source code.
+func (p *printer) linebreak(l int) {
+	n := 1
+	if l > p.line+1 && !p.open {
+		n = 2
+	}
+	if p.pending < n {
+		p.pending = n
+	}
+	p.open = false
+}
+
+// Print the comments before the offset offs in the source code. A comment on
+// the line of the last token printed stays on this line.
+func (p *printer) flush(offs int) {
+	for len(p.comments) > 0 && p.comments[0].Pos.Offset < offs {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		txt := c.Text
+		line := !strings.HasPrefix(txt, "/*")
+		if line {
+			txt = strings.TrimRight(txt, " \t")
+		}
+		if c.Pos.Line == p.line && p.pending == 0 && p.out.Len() > 0 {
+			// After an opening parenthesis or bracket, an inline block comment
+			// sticks to it
+			b := p.out.Bytes()
+			if last := b[len(b)-1]; line || (last != '(' && last != '[' && last != ' ') {
+				p.out.WriteByte(' ')
+			}
+			p.out.WriteString(txt)
+		} else {
+			p.linebreak(c.Pos.Line)
+			p.print(txt)
+		}
+		p.line = c.Pos.Line + strings.Count(txt, "\n")
+		if line {
+			p.newline()
+		} else {
+			p.comment = true
+		}
+	}
+}
+
+// Print the statements of a block, ending at the offset end in the source code.
+func (p *printer) stmts(list []*parser.Symbol, end int) {
+	for _, s := range list {
+		if s.Implicit() {
+			continue
+		}
+		start := startPos(s)
+		p.flush(start.Offset)
+		p.linebreak(start.Line)
+		p.stmt(s)
+		if e := s.End(); e.IsValid() {
+			p.line = e.Line
+		}
+	}
+	p.flush(end)
+}
+
+// Print a block of statements, its closing brace being at the position end in
+// the source code. An empty block is printed on one line if compact is true,
+// i.e. for functions.
+func (p *printer) block(list []*parser.Symbol, end token.Position, compact bool) {
+	p.print(" {")
+	p.indent++
+	p.open = true
+	n := p.out.Len()
+	p.stmts(list, end.Offset)
+	p.indent--
+	p.open = false
+	if p.out.Len() != n || !compact {
+		p.newline()
+	}
+	p.token("}", end)
+}
+
+// Print the statement s.
+func (p *printer) stmt(s *parser.Symbol) {
+	switch s.Id {
+	case "if":
+		p.ifStmt(s)
+	case "for", "forr":
+		p.label(s)
+		p.token("for", s.Pos())
+		switch f := s.First.(type) {
+		case *parser.Symbol:
+			p.print(" ")
+			p.expr(f)
+		case []interface{}:
+			for i, pt := range f {
+				if i > 0 {
+					p.print(";")
+				}
+				p.print(" ")
+				p.expr(pt.(*parser.Symbol))
+			}
+		}
+		p.block(s.Second.([]*parser.Symbol), s.BlockEnd(), false)
+	case "switch":
+		p.switchStmt(s)
+	case "break", "continue":
+		p.token(s.Id, s.Pos())
+		if s.Name != "" {
+			p.print(" " + s.Name)
+		}
+	case "return":
+		p.token("return", s.Pos())
+		if v, ok := s.First.(*parser.Symbol); !ok || !v.Implicit() {
+			p.print(" ")
+			p.exprs(s.First)
+		}
+	case "defer":
+		p.token("defer ", s.Pos())
+		p.expr(s.First.(*parser.Symbol))
+	case "debug":
+		p.token("debug", s.Pos())
+		if v, ok := s.First.(*parser.Symbol); ok {
+			p.print(" ")
+			p.expr(v)
+		}
+	default:
+		p.expr(s)
+	}
+}
+
+// Print the label of a for or switch statement, if it has one.
+func (p *printer) label(s *parser.Symbol) {
+	if s.Name != "" {
+		p.print(s.Name + ": ")
+	}
+}
+
+// Print the if statement s, and its else if statements.
+func (p *printer) ifStmt(s *parser.Symbol) {
+	p.token("if ", s.Pos())
+	p.expr(s.First.(*parser.Symbol))
+	p.block(s.Second.([]*parser.Symbol), s.BlockEnd(), false)
+	switch e := s.Third.(type) {
+	case *parser.Symbol:
+		p.print(" else ")
+		p.ifStmt(e)
+	case []*parser.Symbol:
+		p.print(" else")
+		p.block(e, s.End(), false)
+	}
+}
+
+// Print the switch statement s. The case clauses are at the indentation of
+// the switch statement.
+func (p *printer) switchStmt(s *parser.Symbol) {
+	p.label(s)
+	p.token("switch", s.Pos())
+	if v, ok := s.First.(*parser.Symbol); ok {
+		p.print(" ")
+		p.expr(v)
+	}
+	p.print(" {")
+	p.open = true
+	clauses := s.Second.([]*parser.Symbol)
+	for i, c := range clauses {
+		p.flush(c.Pos().Offset)
+		p.linebreak(c.Pos().Line)
+		if c.Id == "case" {
+			p.token("case ", c.Pos())
+			p.exprs(c.First)
+		} else {
+			p.token("default", c.Pos())
+		}
+		p.print(":")
+		end := s.End()
+		if i < len(clauses)-1 {
+			end = clauses[i+1].Pos()
+		}
+		p.indent++
+		p.open = true
+		p.stmts(c.Second.([]*parser.Symbol), end.Offset)
+		p.indent--
+	}
+	p.flush(s.End().Offset)
+	p.open = false
+	p.newline()
+	p.token("}", s.End())
+}
+
+// Print the comma-separated list of expressions v, either a symbol or a list
+// of symbols.
+func (p *printer) exprs(v interface{}) {
+	switch v := v.(type) {
+	case *parser.Symbol:
+		p.expr(v)
+	case []*parser.Symbol:
+		for i, s := range v {
+			if i > 0 {
+				p.comma(startPos(s))
+				p.print(" ")
+			}
+			p.expr(s)
+		}
+	}
+}
+
+// Print the expression s.
+func (p *printer) expr(s *parser.Symbol) {
+	if s.Parenthesized() {
+		p.print("(")
+		defer p.print(")")
+	}
+	switch s.Id {
+	case "func":
+		p.fn(s)
+	case "yield":
+		p.token("yield", s.Pos())
+		if v := s.First.(*parser.Symbol); !v.Implicit() {
+			p.print(" ")
+			p.expr(v)
+		}
+	case "range":
+		p.token("range ", s.Pos())
+		p.exprs(s.First)
+	case "?":
+		p.expr(s.First.(*parser.Symbol))
+		p.token(" ? ", s.Pos())
+		p.expr(s.Second.(*parser.Symbol))
+		p.print(" : ")
+		p.expr(s.Third.(*parser.Symbol))
+	case "++", "--":
+		p.expr(s.First.(*parser.Symbol))
+		p.token(s.Id, s.Pos())
+	case "(":
+		p.call(s)
+	case ".":
+		p.expr(s.First.(*parser.Symbol))
+		p.token("."+name(s.Second.(*parser.Symbol)), s.Pos())
+	case "{", "[":
+		if s.Ar == parser.ArUnary {
+			p.literal(s)
+			return
+		}
+		p.expr(s.First.(*parser.Symbol))
+		p.token("[", s.Pos())
+		p.expr(s.Second.(*parser.Symbol))
+		p.print("]")
+	default:
+		switch s.Ar {
+		case parser.ArUnary:
+			p.token(s.Id, s.Pos())
+			// Avoid printing `--` for nested unary minus
+			if v := s.First.(*parser.Symbol); s.Id == "-" && v.Id == "-" && v.Ar == parser.ArUnary && !v.Parenthesized() {
+				p.print(" ")
+			}
+			p.expr(s.First.(*parser.Symbol))
+		case parser.ArBinary:
+			p.binary(s)
+		default:
+			p.token(name(s), s.Pos())
+		}
+	}
+}
+
+// Print the binary expression s, i.e. an operation or an assignment. If the
+// right operand is on another line in the source code, so it is.
+func (p *printer) binary(s *parser.Symbol) {
+	p.exprs(s.First)
+	p.token(" "+s.Id, s.Pos())
+	if v, ok := s.Second.(*parser.Symbol); ok && startPos(v).Line > s.Pos().Line {
+		p.indent++
+		p.flush(startPos(v).Offset)
+		p.newline()
+		p.expr(v)
+		p.indent--
+		return
+	}
+	p.print(" ")
+	p.exprs(s.Second)
+}
+
+// Print the function s, either a statement or an expression.
+func (p *printer) fn(s *parser.Symbol) {
+	p.token("func", s.Pos())
+	if s.Name != "" {
+		p.print(" " + s.Name)
+	}
+	p.print("(")
+	p.exprs(s.First)
+	p.print(")")
+	p.block(s.Second.([]*parser.Symbol), s.End(), true)
+}
+
+// Print the function call s. The arguments that are on a new line in the
+// source code are printed on a new line, with an extra indentation.
+func (p *printer) call(s *parser.Symbol) {
+	var args []*parser.Symbol
+	if s.Ar == parser.ArTernary {
+		// Method call
+		p.expr(s.First.(*parser.Symbol))
+		if k := s.Second.(*parser.Symbol); isField(k) {
+			p.print("." + name(k))
+		} else {
+			p.print("[")
+			p.expr(k)
+			p.print("]")
+		}
+		args, _ = s.Third.([]*parser.Symbol)
+	} else {
+		p.expr(s.First.(*parser.Symbol))
+		args, _ = s.Second.([]*parser.Symbol)
+	}
+	p.token("(", s.Pos())
+	cont := false
+	for i, a := range args {
+		if i > 0 {
+			p.comma(startPos(a))
+		}
+		start := startPos(a)
+		if start.Line > p.line && !cont {
+			cont = true
+			p.indent++
+		}
+		p.flush(start.Offset)
+		if start.Line > p.line {
+			p.newline()
+		} else if i > 0 {
+			p.print(" ")
+		}
+		p.expr(a)
+	}
+	if cont {
+		p.indent--
+	}
+	p.token(")", s.End())
+}
+
+// Print the object or array literal s. If it spans multiple lines in the source
+// code, each element that starts a line in the source code starts a line, and
+// all elements are followed by a comma.
+func (p *printer) literal(s *parser.Symbol) {
+	closing := "}"
+	if s.Id == "[" {
+		closing = "]"
+	}
+	elems := s.First.([]*parser.Symbol)
+	p.token(s.Id, s.Pos())
+	if s.End().Line == s.Pos().Line {
+		for i, e := range elems {
+			if i > 0 {
+				p.comma(startPos(e))
+				p.print(" ")
+			}
+			p.element(e)
+		}
+		p.token(closing, s.End())
+		return
+	}
+	p.indent++
+	p.open = true
+	for i, e := range elems {
+		start := startPos(e)
+		p.flush(start.Offset)
+		if i == 0 || start.Line > p.line || p.pending > 0 {
+			p.linebreak(start.Line)
+		} else {
+			p.print(" ")
+		}
+		p.element(e)
+		next := s.End()
+		if i < len(elems)-1 {
+			next = startPos(elems[i+1])
+		}
+		p.comma(next)
+	}
+	p.flush(s.End().Offset)
+	p.indent--
+	p.open = false
+	p.newline()
+	p.token(closing, s.End())
+}
+
+// Print an element of an object or array literal.
+func (p *printer) element(e *parser.Symbol) {
+	if e.Key != nil {
+		p.print(fmt.Sprintf("%v: ", e.Key))
+	}
+	p.expr(e)
+}
+
+// Get the name of the name or literal symbol s, as in the source code.
+func name(s *parser.Symbol) string {
+	if v, ok := s.Val.(string); ok {
+		return v
+	}
+	// Constants have the value of the constant
+	return s.Id
+}
+
+// Check if the key of a method call, the second operand of the call, is a
+// field name, i.e. `obj.name()` rather than `obj[expr]()`.
+func isField(k *parser.Symbol) bool {
+	_, ok := k.Val.(string)
+	return ok && k.Ar == parser.ArLiteral && k.Id != "(literal)"
+}
+
+// Get the position of the first token of the symbol s in the source code,
+// the key of an element of an object literal included.
+func startPos(s *parser.Symbol) token.Position {
+	var pos token.Position
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case *parser.Symbol:
+			if v.Implicit() {
+				return
+			}
+			for _, p := range []token.Position{v.Pos(), v.KeyPos()} {
+				if p.IsValid() && (!pos.IsValid() || p.Offset < pos.Offset) {
+					pos = p
+				}
+			}
+			walk(v.First)
+			walk(v.Second)
+			walk(v.Third)
+		case []*parser.Symbol:
+			for _, s := range v {
+				walk(s)
+			}
+		case []interface{}:
+			for _, s := range v {
+				walk(s)
+			}
+		}
+	}
+	walk(s)
+	return pos
+}
@@ -0,0 +1,208 @@
+package format
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobg/agora/compiler/parser"
+)
+
+var cases = []struct {
+	src     string
+	globals []string
+	exp     string
+}{
+	0: {
+		src: `fmt:=import("fmt")   // trailing
+   x := {a: 1,b: 2}
+y := [ 1 , 2, ]
+func   add( a,b ) {
+  return a+b
+}
+
+
+
+return add(x.a, -(- y[0]))`,
+		exp: `fmt := import("fmt") // trailing
+x := {a: 1, b: 2}
+y := [1, 2]
+func add(a, b) {
+	return a + b
+}
+
+return add(x.a, -(-y[0]))
+`,
+	},
+	1: {
+		// Multi-line literals and calls, comments inside
+		src: `x := {
+  a: 1, b: [1,
+2],
+      // commented out
+   // c: 3,
+     }
+z := add(1,
+     2)`,
+		globals: []string{"add"},
+		exp: `x := {
+	a: 1, b: [
+		1,
+		2,
+	],
+	// commented out
+	// c: 3,
+}
+z := add(1,
+	2)
+`,
+	},
+	2: {
+		src: `// header
+
+if x == 1 &&
+   x.b == 2 { // why
+	/* block
+	   comment */
+	fmt.Println("ok")   /* inline */
+} else if x > 0 {
+  // in else if
+} else {
+}
+for i := 0; i < 2; i++ { f(i); continue
+}
+outer: for {
+    break outer
+}
+switch x {
+    case 1, 2:
+        // nothing
+    default:
+        return
+}`,
+		globals: []string{"x", "f", "fmt"},
+		exp: `// header
+
+if x == 1 &&
+	x.b == 2 { // why
+	/* block
+	   comment */
+	fmt.Println("ok") /* inline */
+} else if x > 0 {
+	// in else if
+} else {
+}
+for i := 0; i < 2; i++ {
+	f(i)
+	continue
+}
+outer: for {
+	break outer
+}
+switch x {
+case 1, 2:
+	// nothing
+default:
+	return
+}
+`,
+	},
+	3: {
+		src: `o.m = func(n) { yield
+return n * (2 + 3) // six
+}
+f := func() {}
+v := o.m(1) > 0 ? o["m"](2) : nil
+for k := range o {
+}`,
+		globals: []string{"o"},
+		exp: `o.m = func(n) {
+	yield
+	return n * (2 + 3) // six
+}
+f := func() {}
+v := o.m(1) > 0 ? o["m"](2) : nil
+for k := range o {
+}
+`,
+	},
+	4: {
+		// Inline block comments around the separators
+		src: `func f(a /* first */, b) {}
+n := len(/* c2 */ "s")
+l := [a /* c3 */, b]
+g(a, /* c4 */ b)
+o := {
+	a: 1 /* c6 */,
+	b: 2, /* c7 */
+}`,
+		globals: []string{"a", "b", "g"},
+		exp: `func f(a /* first */, b) {}
+n := len(/* c2 */ "s")
+l := [a /* c3 */, b]
+g(a, /* c4 */ b)
+o := {
+	a: 1 /* c6 */,
+	b: 2, /* c7 */
+}
+`,
+	},
+}
+
+func TestSource(t *testing.T) {
+	for i, c := range cases {
+		got, err := SourceWithGlobals([]byte(c.src), append(c.globals, parser.DefaultGlobals...))
+		if err != nil {
+			t.Errorf("[%d] - unexpected error: %s", i, err)
+			continue
+		}
+		if string(got) != c.exp {
+			t.Errorf("[%d] - expected\n%s\ngot\n%s", i, c.exp, got)
+		}
+	}
+	if _, err := Source([]byte(`x :=`)); err == nil {
+		t.Errorf("expected an error for invalid source code")
+	}
+}
+
+// Formatting the source files of the tests keeps their syntax tree, and
+// formatting them again does not change them.
+func TestSourceFiles(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/src/*.agora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range files {
+		src, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, err := tree(src)
+		if err != nil {
+			// Some files test the parse errors
+			continue
+		}
+		res, err := Source(src)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", fn, err)
+			continue
+		}
+		if got, err := tree(res); err != nil || got != exp {
+			t.Errorf("%s: the formatted source code has another syntax tree:\n%s", fn, res)
+		}
+		if res2, err := Source(res); err != nil || !bytes.Equal(res, res2) {
+			t.Errorf("%s: formatting the formatted source code changes it:\n%s", fn, res2)
+		}
+	}
+}
+
+// Get the representation of the syntax tree of src.
+func tree(src []byte) (string, error) {
+	syms, _, err := parser.New().Parse("", src)
+	var buf bytes.Buffer
+	for _, s := range syms {
+		buf.WriteString(s.String())
+	}
+	return buf.String(), err
+}
@@ -0,0 +1,354 @@
+package parser
+
+import (
+	"github.com/bobg/agora/compiler/ast"
+	"github.com/bobg/agora/compiler/token"
+)
+
+// The tokens of the operators, by symbol ID.
+var opTokens = map[string]token.Token{
+	"+":  token.ADD,
+	"-":  token.SUB,
+	"*":  token.MUL,
+	"/":  token.DIV,
+	"%":  token.MOD,
+	"==": token.EQL,
+	"<":  token.LSS,
+	">":  token.GTR,
+	"!=": token.NEQ,
+	"<=": token.LEQ,
+	">=": token.GEQ,
+	"&&": token.AND,
+	"||": token.OR,
+	"!":  token.NOT,
+	"=":  token.ASSIGN,
+	":=": token.DEFINE,
+	"+=": token.ADD_ASSIGN,
+	"-=": token.SUB_ASSIGN,
+	"*=": token.MUL_ASSIGN,
+	"/=": token.DIV_ASSIGN,
+	"%=": token.MOD_ASSIGN,
+	"++": token.INC,
+	"--": token.DEC,
+}
+
+// ParseFile parses the provided source code like Parse, and returns its
+// syntax tree. If the source code has errors, the tree is returned along with
+// the error (corresponding to the scanner.ErrorList), with *ast.BadExpr and
+// *ast.BadStmt nodes where the source code is invalid.
+func (p *Parser) ParseFile(filename string, src []byte) (*ast.File, error) {
+	syms, _, err := p.Parse(filename, src)
+	c := &converter{defs: make(map[*Symbol]*ast.Ident)}
+	f := &ast.File{Name: filename}
+	f.Scope = c.openScope()
+	f.Stmts = c.stmts(syms)
+	// The references are resolved once all the definitions are known
+	for id, def := range c.refs {
+		id.Def = c.defs[def]
+	}
+	for _, cm := range p.comments {
+		f.Comments = append(f.Comments, &ast.Comment{Slash: cm.Pos, Text: cm.Text})
+	}
+	return f, err
+}
+
+// A converter converts the symbols generated by the parser to a syntax tree.
+type converter struct {
+	scope *ast.Scope             // the scope of the current function
+	defs  map[*Symbol]*ast.Ident // the identifiers of the definitions
+	refs  map[*ast.Ident]*Symbol // the definitions of the other identifiers
+}
+
+func (c *converter) openScope() *ast.Scope {
+	c.scope = ast.NewScope(c.scope)
+	return c.scope
+}
+
+// Returns v as a symbol, or nil if it is not a symbol, which may happen in
+// the tree of invalid source code.
+func asSymbol(v interface{}) *Symbol {
+	s, _ := v.(*Symbol)
+	return s
+}
+
+// Returns v as a list of symbols, v being a symbol or a list of symbols.
+func asSymbols(v interface{}) []*Symbol {
+	switch v := v.(type) {
+	case []*Symbol:
+		return v
+	case *Symbol:
+		if v != nil {
+			return []*Symbol{v}
+		}
+	}
+	return nil
+}
+
+// Convert the name s to an identifier, resolving its definition.
+func (c *converter) ident(s *Symbol) *ast.Ident {
+	if s == nil {
+		return &ast.Ident{}
+	}
+	nm, _ := s.Val.(string)
+	id := &ast.Ident{NamePos: s.pos, Name: nm, Global: s.IsGlobal()}
+	switch {
+	case s.def == s:
+		id.Def = id
+		c.defs[s] = id
+		c.scope.Insert(id)
+	case s.def != nil:
+		if c.refs == nil {
+			c.refs = make(map[*ast.Ident]*Symbol)
+		}
+		c.refs[id] = s.def
+	}
+	return id
+}
+
+// Convert the symbol s, a field name or a label, to an identifier that does
+// not refer to a definition.
+func (c *converter) name(s *Symbol) *ast.Ident {
+	if s == nil {
+		return nil
+	}
+	nm, _ := s.Val.(string)
+	return &ast.Ident{NamePos: s.pos, Name: nm}
+}
+
+func (c *converter) exprs(v interface{}) []ast.Expr {
+	var list []ast.Expr
+	for _, s := range asSymbols(v) {
+		list = append(list, c.expr(s))
+	}
+	return list
+}
+
+// Convert the expression v, with its enclosing parentheses.
+func (c *converter) expr(v interface{}) ast.Expr {
+	s := asSymbol(v)
+	if s == nil {
+		return &ast.BadExpr{}
+	}
+	x := c.bareExpr(s)
+	for _, pp := range s.paren {
+		x = &ast.ParenExpr{Lparen: pp.lparen, X: x, Rparen: pp.rparen}
+	}
+	return x
+}
+
+func (c *converter) bareExpr(s *Symbol) ast.Expr {
+	id := s.Id
+	if s.IsGlobal() {
+		id = _SYM_NAME
+	}
+	switch id {
+	case _SYM_NAME:
+		return c.ident(s)
+	case _SYM_LIT:
+		v, _ := s.Val.(string)
+		return &ast.BasicLit{ValuePos: s.pos, Kind: s.tok, Value: v}
+	case "true", "false":
+		return &ast.BoolLit{ValuePos: s.pos, Value: id == "true"}
+	case "nil":
+		return &ast.NilLit{NilPos: s.pos}
+	case "this":
+		return &ast.ThisExpr{This: s.pos}
+	case "args":
+		return &ast.ArgsExpr{Args: s.pos}
+	case "[":
+		if s.Ar == ArUnary {
+			return &ast.ArrayLit{Lbrack: s.pos, Elts: c.exprs(s.First), Rbrack: s.end}
+		}
+		return &ast.IndexExpr{X: c.expr(s.First), Lbrack: s.pos, Index: c.expr(s.Second), Rbrack: s.end}
+	case ".":
+		return &ast.SelectorExpr{X: c.expr(s.First), Sel: c.name(asSymbol(s.Second))}
+	case "{":
+		x := &ast.ObjectLit{Lbrace: s.pos, Rbrace: s.end}
+		for _, v := range asSymbols(s.First) {
+			x.Elts = append(x.Elts, &ast.KeyValueExpr{Key: c.key(v.keySym), Value: c.expr(v)})
+		}
+		return x
+	case "!", "-", "+", "*", "/", "%", "==", "<", ">", "!=", "<=", ">=", "&&", "||":
+		if s.Ar == ArUnary {
+			return &ast.UnaryExpr{OpPos: s.pos, Op: opTokens[id], X: c.expr(s.First)}
+		}
+		return &ast.BinaryExpr{X: c.expr(s.First), OpPos: s.pos, Op: opTokens[id], Y: c.expr(s.Second)}
+	case "?":
+		return &ast.CondExpr{Cond: c.expr(s.First), Question: s.pos, Then: c.expr(s.Second), Else: c.expr(s.Third)}
+	case "func":
+		return c.funcLit(s)
+	case "(":
+		x := &ast.CallExpr{Lparen: s.pos, Rparen: s.end}
+		if s.Ar == ArTernary {
+			// Method call, the selector is kept apart
+			x.Fun = c.expr(s.fun)
+			x.Args = c.exprs(s.Third)
+		} else {
+			x.Fun = c.expr(s.First)
+			x.Args = c.exprs(s.Second)
+		}
+		return x
+	case "yield":
+		x := &ast.YieldExpr{Yield: s.pos}
+		if v := asSymbol(s.First); v != nil && !v.implicit {
+			x.X = c.expr(v)
+		}
+		return x
+	}
+	return &ast.BadExpr{From: s.pos, To: s.pos}
+}
+
+// Convert the key of an object literal value.
+func (c *converter) key(s *Symbol) ast.Expr {
+	if s == nil {
+		return &ast.BadExpr{}
+	}
+	if s.tok == token.IDENT || s.tok.IsKeyword() {
+		return c.name(s)
+	}
+	v, _ := s.Val.(string)
+	return &ast.BasicLit{ValuePos: s.pos, Kind: s.tok, Value: v}
+}
+
+// Convert the function s, its parameters and statements being in a new scope.
+func (c *converter) funcLit(s *Symbol) *ast.FuncLit {
+	x := &ast.FuncLit{Func: s.pos}
+	x.Scope = c.openScope()
+	defer func() {
+		c.scope = c.scope.Outer
+	}()
+	for _, prm := range asSymbols(s.First) {
+		x.Params = append(x.Params, c.ident(prm))
+	}
+	x.Body = c.block(s.Second, s.lbrace, s.end)
+	return x
+}
+
+func (c *converter) block(v interface{}, lbrace, rbrace token.Position) *ast.BlockStmt {
+	return &ast.BlockStmt{Lbrace: lbrace, List: c.stmts(v), Rbrace: rbrace}
+}
+
+func (c *converter) stmts(v interface{}) []ast.Stmt {
+	var list []ast.Stmt
+	for _, s := range asSymbols(v) {
+		list = append(list, c.stmt(s))
+	}
+	return list
+}
+
+// Convert the statement s, with its label if it has one.
+func (c *converter) stmt(s *Symbol) ast.Stmt {
+	if s == nil {
+		return &ast.BadStmt{}
+	}
+	st := c.bareStmt(s)
+	switch s.Id {
+	case "for", "forr", "switch":
+		if s.nameSym != nil {
+			st = &ast.LabeledStmt{Label: c.name(s.nameSym), Stmt: st}
+		}
+	}
+	return st
+}
+
+func (c *converter) bareStmt(s *Symbol) ast.Stmt {
+	switch s.Id {
+	case ":=", "=", "+=", "-=", "*=", "/=", "%=":
+		return &ast.AssignStmt{Lhs: c.exprs(s.First), TokPos: s.pos, Tok: opTokens[s.Id], Rhs: c.exprs(s.Second)}
+	case "++", "--":
+		return &ast.IncDecStmt{X: c.expr(s.First), TokPos: s.pos, Tok: opTokens[s.Id]}
+	case "func":
+		if s.Name == "" {
+			return &ast.ExprStmt{X: c.expr(s)}
+		}
+		// The name is defined in the scope of the enclosing function
+		return &ast.FuncDecl{Name: c.ident(s.nameSym), Func: c.funcLit(s)}
+	case "if":
+		st := &ast.IfStmt{If: s.pos, Cond: c.expr(s.First), Body: c.block(s.Second, s.lbrace, s.blockEnd)}
+		switch v := s.Third.(type) {
+		case *Symbol:
+			st.Else = c.stmt(v)
+		case []*Symbol:
+			st.Else = c.block(v, s.elseBr, s.end)
+		}
+		return st
+	case "for":
+		st := &ast.ForStmt{For: s.pos, Body: c.block(s.Second, s.lbrace, s.blockEnd)}
+		switch v := s.First.(type) {
+		case []interface{}:
+			if len(v) == 3 {
+				st.Init = c.stmt(asSymbol(v[0]))
+				st.Cond = c.expr(v[1])
+				st.Post = c.stmt(asSymbol(v[2]))
+			}
+		case *Symbol:
+			if v != nil {
+				st.Cond = c.expr(v)
+			}
+		}
+		return st
+	case "forr":
+		asg := asSymbol(s.First)
+		if asg == nil {
+			break
+		}
+		rng := asSymbol(asg.Second)
+		if rng == nil {
+			break
+		}
+		return &ast.RangeStmt{
+			For:    s.pos,
+			Value:  c.expr(asg.First),
+			TokPos: asg.pos,
+			Tok:    opTokens[asg.Id],
+			Range:  rng.pos,
+			Args:   c.exprs(rng.First),
+			Body:   c.block(s.Second, s.lbrace, s.blockEnd),
+		}
+	case "switch":
+		st := &ast.SwitchStmt{Switch: s.pos, Body: &ast.BlockStmt{Lbrace: s.lbrace, Rbrace: s.end}}
+		if v := asSymbol(s.First); v != nil {
+			st.Tag = c.expr(v)
+		}
+		for _, cl := range asSymbols(s.Second) {
+			cc := &ast.CaseClause{Case: cl.pos, Colon: cl.end, Body: c.stmts(cl.Second)}
+			if cl.Id == "case" {
+				cc.List = c.exprs(cl.First)
+			}
+			st.Body.List = append(st.Body.List, cc)
+		}
+		return st
+	case "break", "continue":
+		tok := token.BREAK
+		if s.Id == "continue" {
+			tok = token.CONTINUE
+		}
+		return &ast.BranchStmt{TokPos: s.pos, Tok: tok, Label: c.name(s.nameSym)}
+	case "return":
+		st := &ast.ReturnStmt{Return: s.pos, Implicit: s.implicit}
+		if v := asSymbol(s.First); v == nil || !v.implicit {
+			st.Results = c.exprs(s.First)
+		}
+		return st
+	case "defer":
+		if call, ok := ast.Unparen(c.expr(s.First)).(*ast.CallExpr); ok {
+			return &ast.DeferStmt{Defer: s.pos, Call: call}
+		}
+	case "debug":
+		st := &ast.DebugStmt{Debug: s.pos}
+		if v := asSymbol(s.First); v != nil {
+			lit, _ := v.Val.(string)
+			st.Count = &ast.BasicLit{ValuePos: v.pos, Kind: v.tok, Value: lit}
+		}
+		return st
+	case _SYM_BAD:
+	default:
+		// An expression, i.e. a call, or one of the parts of a 3-part for loop
+		return &ast.ExprStmt{X: c.expr(s)}
+	}
+	end := s.end
+	if !end.IsValid() {
+		end = s.pos
+	}
+	return &ast.BadStmt{From: s.pos, To: end}
+}
@@ -10,6 +10,8 @@ func (p *Parser) defineGrammar() {
 	p.makeSymbol("]", 0)
 	p.makeSymbol("}", 0)
 	p.makeSymbol("else", 0)
+	p.makeSymbol("case", 0)
+	p.makeSymbol("default", 0)
 
 	// Infix operators
 	p.infix("+", 50, nil)  // Add
@@ -40,7 +42,9 @@ func (p *Parser) defineGrammar() {
 		if p.tkn.Ar != ArName {
 			p.error(p.tkn, "expected a field name")
 		}
+		// The field name is not a variable, even if one has the same name
 		p.tkn.Ar = ArLiteral
+		p.tkn.def = nil
 		sym.Second = p.tkn
 		sym.Ar = ArBinary
 		p.advance(_SYM_ANY)
@@ -52,6 +56,7 @@ func (p *Parser) defineGrammar() {
 		sym.First = left
 		sym.Second = p.expression(0)
 		sym.Ar = ArBinary
+		sym.end = p.tkn.pos
 		p.advance("]")
 		return sym
 	})
@@ -67,6 +72,7 @@ func (p *Parser) defineGrammar() {
 	// The expression grouping operator
 	p.prefix("(", func(sym *Symbol) *Symbol {
 		e := p.expression(0)
+		e.paren = append(e.paren, parenPos{sym.pos, p.tkn.pos})
 		p.advance(")")
 		return e
 	})
@@ -79,7 +85,7 @@ func (p *Parser) defineGrammar() {
 			sym.First = e
 		} else {
 			// Equivalent of yield nil
-			sym.First = p.makeSymbol("nil", 0).clone()
+			sym.First = p.implicitNil()
 		}
 		return sym
 	})
@@ -116,6 +122,7 @@ func (p *Parser) defineGrammar() {
 	// Statement
 	p.stmt("{", func(sym *Symbol) interface{} {
 		a := p.statements()
+		p.blockEnd = p.tkn.pos
 		p.advance("}")
 		return a
 	})
@@ -149,16 +156,67 @@ func (p *Parser) defineGrammar() {
 				sym.First = []interface{}{pt1, pt2, pt3}
 			}
 		}
+		sym.lbrace = p.tkn.pos
 		sym.Second = p.block()
+		sym.blockEnd = p.blockEnd
 		p.advance(";")
 		sym.Ar = ArStatement
 		return sym
 	})
 
+	// Switch statement
+	p.stmt("switch", func(sym *Symbol) interface{} {
+		// The tag is optional (i.e. `switch { case cond: }`). If it is absent,
+		// sym.First is nil, while sym.Second holds the case clauses.
+		sym.First = nil
+		if p.tkn.Id != "{" {
+			sym.First = p.expression(0)
+		}
+		sym.lbrace = p.tkn.pos
+		p.advance("{")
+		var clauses []*Symbol
+		hasDefault := false
+		for p.tkn.Id == "case" || p.tkn.Id == "default" {
+			c := p.tkn
+			p.scp.reserve(c)
+			p.advance(_SYM_ANY)
+			if c.Id == "case" {
+				// Comma-separated list of values to match
+				var vals []*Symbol
+				for {
+					vals = append(vals, p.expression(0))
+					if p.tkn.Id != "," {
+						break
+					}
+					p.advance(",")
+				}
+				c.First = vals
+			} else {
+				if hasDefault {
+					p.error(c, "multiple defaults in switch")
+				}
+				hasDefault = true
+			}
+			c.end = p.tkn.pos
+			p.advance(":")
+			// The body of the clause ends at the next case, default or closing brace
+			c.Second = p.statements()
+			c.Ar = ArStatement
+			clauses = append(clauses, c)
+		}
+		p.advance("}")
+		p.advance(";")
+		sym.Second = clauses
+		sym.Ar = ArStatement
+		return sym
+	})
+
 	// If statement
 	p.stmt("if", func(sym *Symbol) interface{} {
 		sym.First = p.expression(0)
+		sym.lbrace = p.tkn.pos
 		sym.Second = p.block()
+		sym.blockEnd = p.blockEnd
 		sym.Third = nil
 		if p.tkn.Id == "else" {
 			p.scp.reserve(p.tkn)
@@ -166,6 +224,7 @@ func (p *Parser) defineGrammar() {
 			if p.tkn.Id == "if" {
 				sym.Third = p.statement()
 			} else {
+				sym.elseBr = p.tkn.pos
 				sym.Third = p.block()
 				p.advance(";")
 			}
@@ -178,8 +237,9 @@ func (p *Parser) defineGrammar() {
 
 	// break statement
 	p.stmt("break", func(sym *Symbol) interface{} {
+		p.optionalLabel(sym)
 		p.advance(";")
-		if p.tkn.Id != "}" && p.tkn.Id != _SYM_END {
+		if !p.endOfBlock() {
 			p.error(p.tkn, "unreachable statement")
 		}
 		sym.Ar = ArStatement
@@ -188,6 +248,18 @@ func (p *Parser) defineGrammar() {
 
 	// continue statement
 	p.stmt("continue", func(sym *Symbol) interface{} {
+		p.optionalLabel(sym)
+		p.advance(";")
+		sym.Ar = ArStatement
+		return sym
+	})
+
+	// defer statement
+	p.stmt("defer", func(sym *Symbol) interface{} {
+		sym.First = p.expression(0)
+		if call := sym.First.(*Symbol); call.Id != "(" {
+			p.error(call, "expected a function call in defer")
+		}
 		p.advance(";")
 		sym.Ar = ArStatement
 		return sym
@@ -213,31 +285,28 @@ func (p *Parser) defineGrammar() {
 	p.stmt("return", func(sym *Symbol) interface{} {
 		if p.tkn.Id == ";" {
 			// Empty return, treat as return nil
-			sym.First = p.makeSymbol("nil", 0).clone()
+			sym.First = p.implicitNil()
 		} else {
 			sym.First = p.expression(0)
+			if p.tkn.Id == "," {
+				// Multiple return values
+				rets := []*Symbol{sym.First.(*Symbol)}
+				for p.tkn.Id == "," {
+					p.advance(",")
+					rets = append(rets, p.expression(0))
+				}
+				sym.First = rets
+			}
+			p.checkValues(sym.First)
 		}
 		p.advance(";")
-		if p.tkn.Id != "}" && p.tkn.Id != _SYM_END {
+		if !p.endOfBlock() {
 			p.error(p.tkn, "unreachable statement")
 		}
 		sym.Ar = ArStatement
 		return sym
 	})
 
-	// import builtin
-	p.builtin("import")
-	p.builtin("panic")
-	p.builtin("recover")
-	p.builtin("len")
-	p.builtin("keys")
-	p.builtin("number")
-	p.builtin("string")
-	p.builtin("bool")
-	p.builtin("type")
-	p.builtin("status")
-	p.builtin("reset")
-
 	// func can be both an expression prefix:
 	//   fnAdd := func(x, y) {return x+y}
 	// or a statement:
@@ -257,8 +326,10 @@ func (p *Parser) defineGrammar() {
 				p.advance(",")
 			}
 		}
+		sym.end = p.tkn.pos
 		p.advance(")")
-		if left.Id == "." || left.Id == "[" {
+		if left.Id == "." || (left.Id == "[" && left.Ar == ArBinary) {
+			sym.fun = left
 			sym.Ar = ArTernary
 			sym.First = left.First
 			sym.Second = left.Second
@@ -303,6 +374,7 @@ func (p *Parser) defineGrammar() {
 				p.advance(":")
 				v := p.expression(0)
 				v.Key = n.Val
+				v.keySym = n
 				a = append(a, v)
 				if p.tkn.Id != "," {
 					break
@@ -313,12 +385,35 @@ func (p *Parser) defineGrammar() {
 				}
 			}
 		}
+		sym.end = p.tkn.pos
 		p.advance("}")
 		sym.First = a
 		sym.Ar = ArUnary
 		return sym
 	})
 
+	// The array literal notation
+	p.prefix("[", func(sym *Symbol) *Symbol {
+		var a []*Symbol
+		if p.tkn.Id != "]" {
+			for {
+				a = append(a, p.expression(0))
+				if p.tkn.Id != "," {
+					break
+				}
+				p.advance(",")
+				if p.tkn.Id == "]" {
+					break
+				}
+			}
+		}
+		sym.end = p.tkn.pos
+		p.advance("]")
+		sym.First = a
+		sym.Ar = ArUnary
+		return sym
+	})
+
 	// Increment/decrement statements
 	p.suffix("--")
 	p.suffix("++")
@@ -331,6 +426,7 @@ func makeFuncParser(p *Parser, prefix bool) func(*Symbol) *Symbol {
 		if !prefix && p.tkn.Ar == ArName { // Only for statement notation
 			p.scp.define(p.tkn)
 			sym.Name = p.tkn.Val.(string)
+			sym.nameSym = p.tkn
 			p.advance(_SYM_ANY)
 		}
 		p.newScope()
@@ -351,10 +447,12 @@ func makeFuncParser(p *Parser, prefix bool) func(*Symbol) *Symbol {
 		}
 		sym.First = a
 		p.advance(")")
+		sym.lbrace = p.tkn.pos
 		p.advance("{")
 		stmts := p.statements()
 		stmts = p.appendReturnNil(stmts)
 		sym.Second = stmts
+		sym.end = p.tkn.pos
 		p.advance("}")
 		if !prefix { // Don't consume the ending semicolon when func is an expression
 			p.advance(";")
@@ -372,12 +470,35 @@ func makeFuncParserIface(p *Parser, prefix bool) func(*Symbol) interface{} {
 	}
 }
 
+// Parse the optional label of a break or continue statement, storing
+// it in the Name field of the statement's symbol.
+func (p *Parser) optionalLabel(sym *Symbol) {
+	if p.tkn.Id == "(name)" {
+		sym.Name = p.tkn.Val.(string)
+		sym.nameSym = p.tkn
+		p.advance(_SYM_ANY)
+	}
+}
+
+// Create a nil symbol that is not in the source code, i.e. for `return`
+// without value.
+func (p *Parser) implicitNil() *Symbol {
+	nl := p.makeSymbol("nil", 0).clone()
+	nl.implicit = true
+	return nl
+}
+
 func (p *Parser) appendReturnNil(s []*Symbol) []*Symbol {
 	// Make sure the function ends with a return statement, adding a return nil otherwise
 	if l := len(s); l == 0 || s[l-1].Id != "return" {
+		// The implicit return is at the position of the end of the function
 		ret := p.makeSymbol("return", 0).clone()
 		ret.Ar = ArStatement
-		ret.First = p.makeSymbol("nil", 0).clone()
+		ret.pos = p.tkn.pos
+		ret.implicit = true
+		nl := p.implicitNil()
+		nl.pos = p.tkn.pos
+		ret.First = nl
 		s = append(s, ret)
 	}
 	return s
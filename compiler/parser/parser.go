@@ -25,6 +25,24 @@ var (
 		_SYM_NAME,
 		_SYM_LIT,
 	}
+
+	// DefaultGlobals are the names of the built-in functions provided by the
+	// runtime.
+	DefaultGlobals = []string{
+		"import",
+		"panic",
+		"recover",
+		"len",
+		"keys",
+		"number",
+		"string",
+		"bool",
+		"type",
+		"status",
+		"reset",
+		"setproto",
+		"getproto",
+	}
 )
 
 // A Parser is an agora source code parser.
@@ -33,14 +51,30 @@ type Parser struct {
 	scn *scanner.Scanner // the Scanner
 
 	// Parse state reinitialized at each .Parse() call
-	tkn     *Symbol            // current token in Symbol representation
-	tbl     map[string]*Symbol // Symbol table
-	scp     *Scope             // the top-level (universe) scope
-	err     *scanner.ErrorList // the error handler
-	isRange bool
+	tkn      *Symbol            // current token in Symbol representation
+	tbl      map[string]*Symbol // Symbol table
+	globals  map[string]bool    // Global names
+	scp      *Scope             // the top-level (universe) scope
+	err      *scanner.ErrorList // the error handler
+	comments []*Comment         // comments of the source code
+	prev     token.Position     // position of the last token consumed, excluding semicolons
+	blockEnd token.Position     // position of the closing brace of the last block
+	isRange  bool
+	isStmt   bool // set when the next expression starts a statement
 
 	// Exported fields
 	Debug bool
+
+	// The names of the global values provided by the execution context, such
+	// as the built-in functions, so that they are not reported as undefined. If
+	// it is nil, DefaultGlobals is used. The names that are keywords are
+	// ignored.
+	Globals []string
+
+	// The names of the global variables defined by previously executed code,
+	// such as the previous inputs of a REPL. Unlike Globals, they can be
+	// assigned to, and redefined by the top-level code.
+	Vars []string
 }
 
 // New returns a new parser, initialized with its scanner.Scanner.
@@ -50,16 +84,25 @@ func New() *Parser {
 	}
 }
 
-// Parse the provided source code and returns the AST along with the
-// various scopes and an error (corresponding to the scanner.ErrorList).
+// Parse the provided source code and returns the symbols of the parse tree
+// along with the various scopes and an error (corresponding to the
+// scanner.ErrorList). See ParseFile for the typed syntax tree of the ast
+// package.
 func (p *Parser) Parse(filename string, src []byte) ([]*Symbol, *Scope, error) {
 	// Initialize parsing state
 	p.tbl = make(map[string]*Symbol)
 	p.err = new(scanner.ErrorList)
+	p.comments = nil
+	p.prev = token.Position{}
 	p.isRange = false
+	p.isStmt = false
+	p.scp = nil
+	vs := p.newScope()
 	u := p.newScope()
 	p.defineRequiredSymbols()
 	p.defineGrammar()
+	p.defineGlobals()
+	p.defineVars(vs)
 
 	// Initialize the scanner
 	p.scn.Init(filename, src, p.err.Add)
@@ -83,6 +126,12 @@ func (p *Parser) Parse(filename string, src []byte) ([]*Symbol, *Scope, error) {
 	return s, u, p.err.Err()
 }
 
+// Comments returns the comments of the source code of the last call to Parse,
+// in order.
+func (p *Parser) Comments() []*Comment {
+	return p.comments
+}
+
 // Those tokens *must* exist in the symbol table, they are required
 // regardless of the grammar of the language:
 // - (name)
@@ -97,6 +146,36 @@ func (p *Parser) defineRequiredSymbols() {
 	}
 }
 
+// Define the global names as reserved names, that cannot be assigned to.
+func (p *Parser) defineGlobals() {
+	nms := p.Globals
+	if nms == nil {
+		nms = DefaultGlobals
+	}
+	p.globals = make(map[string]bool, len(nms))
+	for _, nm := range nms {
+		if _, ok := p.tbl[nm]; ok {
+			continue
+		}
+		p.builtin(nm)
+		p.globals[nm] = true
+	}
+}
+
+// Define the global variables in the scope vs, the parent of the top-level
+// scope, so that they can be redefined.
+func (p *Parser) defineVars(vs *Scope) {
+	for _, nm := range p.Vars {
+		if _, ok := p.tbl[nm]; ok {
+			continue
+		}
+		sym := p.tbl[_SYM_NAME].clone()
+		sym.Val = nm
+		sym.Ar = ArName
+		vs.define(sym)
+	}
+}
+
 // Create a new scope, as a child of the current scope of the parser.
 func (p *Parser) newScope() *Scope {
 	p.scp = &Scope{
@@ -136,6 +215,9 @@ func (p *Parser) advance(id string) *Symbol {
 	if id != _SYM_ANY && p.tkn.Id != id {
 		p.error(p.tkn, "expected "+id)
 	}
+	if p.tkn != nil && p.tkn.Id != ";" {
+		p.prev = p.tkn.pos
+	}
 	var (
 		tok token.Token
 		lit string
@@ -143,7 +225,10 @@ func (p *Parser) advance(id string) *Symbol {
 	)
 scan:
 	for tok, lit, pos = p.scn.Scan(); tok == token.ILLEGAL || tok == token.COMMENT; tok, lit, pos = p.scn.Scan() {
-		// Skip Illegal and Comment tokens
+		// Skip Illegal tokens, keep the comments apart
+		if tok == token.COMMENT {
+			p.comments = append(p.comments, &Comment{lit, pos})
+		}
 	}
 	if p.Debug {
 		fmt.Println("SCAN: ", tok, lit, pos)
@@ -187,12 +272,18 @@ scan:
 
 func (p *Parser) expression(rbp int) *Symbol {
 	t := p.tkn
+	stmt := p.isStmt
+	p.isStmt = false
 	p.advance(_SYM_ANY)
 	// Special case if in the process of defining a new var:
 	//   `a := x`
+	// or, at the start of a statement, new vars in a multiple assignment:
+	//   `a, b := x, y`
 	// then a.nudfn is nil, but will be defined once := is processed.
+	// Labels (i.e. `outer: for {}`) are not variables, so they don't have to
+	// be defined either.
 	var left *Symbol
-	if t.nudfn == nil && t.Ar == ArName && p.tkn.Id == ":=" {
+	if t.nudfn == nil && t.Ar == ArName && (p.tkn.Id == ":=" || (stmt && (p.tkn.Id == "," || p.tkn.Id == ":"))) {
 		left = t
 	} else {
 		left = t.nud()
@@ -252,7 +343,7 @@ func (p *Parser) prefix(id string, nudfn func(*Symbol) *Symbol) *Symbol {
 
 func (p *Parser) suffix(id string) *Symbol {
 	return p.infixr(id, 10, func(sym, left *Symbol) *Symbol {
-		if left.Id != "." && left.Id != "[" && left.Ar != ArName {
+		if left.Id != "." && (left.Id != "[" || left.Ar != ArBinary) && left.Ar != ArName {
 			p.error(left, "bad lvalue")
 		}
 		sym.First = left
@@ -264,7 +355,7 @@ func (p *Parser) suffix(id string) *Symbol {
 
 func (p *Parser) assignment(id string) *Symbol {
 	return p.infixr(id, 10, func(sym, left *Symbol) *Symbol {
-		if left.Id != "." && left.Id != "[" && left.Ar != ArName {
+		if left.Id != "." && (left.Id != "[" || left.Ar != ArBinary) && left.Ar != ArName {
 			p.error(left, "bad lvalue")
 		}
 		if left.res {
@@ -291,6 +382,77 @@ func (p *Parser) define(id string) *Symbol {
 	})
 }
 
+// Parse a labeled statement, the label being already parsed. Only the for and
+// switch statements can be labeled, the label is stored in the Name field of
+// the statement's symbol.
+func (p *Parser) labeledStatement(lbl *Symbol) interface{} {
+	p.advance(":")
+	// The token is checked rather than the symbol ID, the for statement changes
+	// the ID of its symbol for the range loops.
+	if p.tkn.tok != token.FOR && p.tkn.tok != token.SWITCH {
+		p.error(p.tkn, "expected for or switch statement after label")
+	}
+	s := p.statement()
+	if sym, ok := s.(*Symbol); ok {
+		sym.Name = lbl.Val.(string)
+		sym.nameSym = lbl
+	}
+	return s
+}
+
+// Parse the rest of a multiple assignment statement, i.e. `a, b = b, a` or
+// `a, b := f()`, the first left-hand side expression being already parsed.
+// The returned symbol is the assignment operator, with the list of
+// left-hand side symbols in First, and the list of values in Second.
+func (p *Parser) multiAssignment(left *Symbol) *Symbol {
+	lefts := []*Symbol{left}
+	for p.tkn.Id == "," {
+		p.advance(",")
+		// Stop before the assignment operator, its binding power is 10
+		p.isStmt = true
+		lefts = append(lefts, p.expression(10))
+	}
+	sym := p.tkn
+	if sym.Id != "=" && sym.Id != ":=" {
+		p.error(sym, "expected = or :=")
+		return sym
+	}
+	p.advance(_SYM_ANY)
+	var rights []*Symbol
+	for {
+		rights = append(rights, p.expression(9))
+		if p.tkn.Id != "," {
+			break
+		}
+		p.advance(",")
+	}
+	for _, l := range lefts {
+		if sym.Id == ":=" {
+			if l.Ar != ArName {
+				p.error(l, "expected variable name")
+			} else {
+				p.scp.define(l)
+			}
+			continue
+		}
+		if l.Ar == ArName && l.nudfn == nil {
+			p.error(l, "undefined")
+		} else if l.Id != "." && (l.Id != "[" || l.Ar != ArBinary) && l.Ar != ArName {
+			p.error(l, "bad lvalue")
+		} else if l.res {
+			p.error(l, "cannot assign to a reserved identifier")
+		}
+	}
+	if len(rights) != len(lefts) && (len(rights) != 1 || rights[0].Id != "(") {
+		p.error(sym, "assignment count mismatch")
+	}
+	sym.First = lefts
+	sym.Second = rights
+	sym.asg = sym.Id == "="
+	sym.Ar = ArBinary
+	return sym
+}
+
 func (p *Parser) constant(id string, v interface{}) *Symbol {
 	s := p.makeSymbol(id, 0)
 	s.nudfn = func(sym *Symbol) *Symbol {
@@ -315,25 +477,60 @@ func (p *Parser) builtin(id string) *Symbol {
 	return s
 }
 
+// Report an error for the assignments in v, a symbol or a list of symbols used
+// as values, i.e. `return x := 1`.
+func (p *Parser) checkValues(v interface{}) {
+	syms, ok := v.([]*Symbol)
+	if !ok {
+		syms = []*Symbol{v.(*Symbol)}
+	}
+	for _, s := range syms {
+		if s.asg || s.Id == ":=" {
+			p.error(s, "assignment used as value")
+		}
+	}
+}
+
 func (p *Parser) statement() interface{} {
 	n := p.tkn
 	if n.stdfn != nil {
 		p.advance(_SYM_ANY)
 		p.scp.reserve(n)
-		return n.std()
+		s := n.std()
+		if sym, ok := s.(*Symbol); ok {
+			sym.end = p.prev
+		}
+		return s
 	}
+	p.isStmt = true
 	v := p.expression(0)
+	if p.tkn.Id == "," {
+		v = p.multiAssignment(v)
+	} else if p.tkn.Id == ":" && v.Id == "(name)" {
+		return p.labeledStatement(v)
+	}
 	if !v.asg && v.Id != "(" && v.Id != ":=" && v.Id != "yield" {
 		p.error(v, "bad expression statement")
 	}
 	p.advance(";")
+	v.end = p.prev
 	return v
 }
 
+// Returns true if the current token ends a list of statements, i.e. the end of
+// a block, of the source code, or of a case clause in a switch statement.
+func (p *Parser) endOfBlock() bool {
+	switch p.tkn.Id {
+	case "}", _SYM_END, "case", "default":
+		return true
+	}
+	return false
+}
+
 func (p *Parser) statements() []*Symbol {
 	var a []*Symbol
 	for {
-		if p.tkn.Id == "}" || p.tkn.Id == _SYM_END {
+		if p.endOfBlock() {
 			break
 		}
 		tok := p.tkn
@@ -356,6 +553,7 @@ func (p *Parser) stmt(id string, stdfn func(*Symbol) interface{}) *Symbol {
 	return s
 }
 
+// Parse a block, the position of its closing brace is stored in p.blockEnd.
 func (p *Parser) block() interface{} {
 	t := p.tkn
 	p.advance("{")
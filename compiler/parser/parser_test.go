@@ -2,7 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/bobg/agora/compiler/ast"
+	"github.com/bobg/agora/compiler/token"
 )
 
 var (
@@ -673,6 +677,203 @@ if true {
 				&Symbol{Id: "nil"},
 			},
 		},
+		30: {
+			src: []byte(`
+			a := [10, true, "hi",]
+			return a[2]
+`),
+			exp: []*Symbol{
+				&Symbol{Id: ":="},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "[", Ar: ArUnary},
+				&Symbol{Id: "(literal)", Val: "10"},
+				&Symbol{Id: "true"},
+				&Symbol{Id: "(literal)", Val: `"hi"`},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "[", Ar: ArBinary},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "(literal)", Val: "2"},
+			},
+		},
+		31: {
+			// Invalid assignment to an array literal
+			src: []byte(`
+			[1, 2] = 3
+`),
+			err: true,
+		},
+		32: {
+			// Multiple return values and multiple assignments
+			src: []byte(`
+			func f() {
+				return 1, 2
+			}
+			x, y := f()
+			x, y = y, x
+			return x
+`),
+			exp: []*Symbol{
+				&Symbol{Id: "func", Name: "f"},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "(literal)", Val: "1"},
+				&Symbol{Id: "(literal)", Val: "2"},
+				&Symbol{Id: ":=", Ar: ArBinary},
+				&Symbol{Id: "(name)", Val: "x"},
+				&Symbol{Id: "(name)", Val: "y"},
+				&Symbol{Id: "(", Ar: ArBinary},
+				&Symbol{Id: "(name)", Val: "f"},
+				&Symbol{Id: "=", Ar: ArBinary},
+				&Symbol{Id: "(name)", Val: "x"},
+				&Symbol{Id: "(name)", Val: "y"},
+				&Symbol{Id: "(name)", Val: "y"},
+				&Symbol{Id: "(name)", Val: "x"},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "(name)", Val: "x"},
+			},
+		},
+		33: {
+			// Multiple assignment to undefined variables
+			src: []byte(`
+			x, y = 1, 2
+`),
+			err: true,
+		},
+		34: {
+			// Multiple assignment count mismatch
+			src: []byte(`
+			x, y := 1, 2, 3
+`),
+			err: true,
+		},
+		35: {
+			// Multiple assignment of a single non-call value
+			src: []byte(`
+			x, y := 1
+`),
+			err: true,
+		},
+		36: {
+			// Switch statement
+			src: []byte(`
+			a := 1
+			switch a {
+			case 1, 2:
+				a = 3
+				break
+			default:
+			}
+			switch {
+			case a > 2:
+			}
+			return a
+`),
+			exp: []*Symbol{
+				&Symbol{Id: ":="},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "(literal)", Val: "1"},
+				&Symbol{Id: "switch", Ar: ArStatement},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "case", Ar: ArStatement},
+				&Symbol{Id: "(literal)", Val: "1"},
+				&Symbol{Id: "(literal)", Val: "2"},
+				&Symbol{Id: "="},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "(literal)", Val: "3"},
+				&Symbol{Id: "break"},
+				&Symbol{Id: "default", Ar: ArStatement},
+				&Symbol{Id: "switch", Ar: ArStatement},
+				&Symbol{Id: "case", Ar: ArStatement},
+				&Symbol{Id: ">"},
+				&Symbol{Id: "(name)", Val: "a"},
+				&Symbol{Id: "(literal)", Val: "2"},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "(name)", Val: "a"},
+			},
+		},
+		37: {
+			// Multiple defaults in a switch
+			src: []byte(`
+			switch {
+			default:
+			default:
+			}
+`),
+			err: true,
+		},
+		38: {
+			// Labeled statements
+			src: []byte(`
+			outer: for {
+				for {
+					continue outer
+				}
+				break outer
+			}
+`),
+			exp: []*Symbol{
+				&Symbol{Id: "for", Name: "outer"},
+				&Symbol{Id: "for"},
+				&Symbol{Id: "continue", Name: "outer"},
+				&Symbol{Id: "break", Name: "outer"},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "nil"},
+			},
+		},
+		39: {
+			// Only for and switch can be labeled
+			src: []byte(`
+			a := 1
+			lbl: a++
+`),
+			err: true,
+		},
+		40: {
+			// Defer statements
+			src: []byte(`
+			f := 1
+			o := 2
+			defer f(1)
+			defer o.m()
+`),
+			exp: []*Symbol{
+				&Symbol{Id: ":="},
+				&Symbol{Id: "(name)", Val: "f"},
+				&Symbol{Id: "(literal)", Val: "1"},
+				&Symbol{Id: ":="},
+				&Symbol{Id: "(name)", Val: "o"},
+				&Symbol{Id: "(literal)", Val: "2"},
+				&Symbol{Id: "defer", Ar: ArStatement},
+				&Symbol{Id: "(", Ar: ArBinary},
+				&Symbol{Id: "(name)", Val: "f"},
+				&Symbol{Id: "(literal)", Val: "1"},
+				&Symbol{Id: "defer", Ar: ArStatement},
+				&Symbol{Id: "(", Ar: ArTernary},
+				&Symbol{Id: "(name)", Val: "o"},
+				&Symbol{Id: "(name)", Val: "m"},
+				&Symbol{Id: "return"},
+				&Symbol{Id: "nil"},
+			},
+		},
+		41: {
+			// Only a function call can be deferred
+			src: []byte(`
+			a := 1
+			defer a + 1
+`),
+			err: true,
+		},
+		42: {
+			// An assignment is not a value
+			src: []byte(`
+			a := 1
+			return a = 2
+`),
+			err: true,
+		},
+		43: {
+			src: []byte(`a :=`),
+			err: true,
+		},
 	}
 
 	isolateCase = -1
@@ -749,3 +950,278 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestGlobals(t *testing.T) {
+	p := New()
+	p.Globals = []string{"len", "config", "if"}
+	syms, _, err := p.Parse("test", []byte(`return len(config.name)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := syms[0].First.(*Symbol)
+	for _, s := range []*Symbol{call.First.(*Symbol), call.Second.([]*Symbol)[0].First.(*Symbol)} {
+		if !s.IsGlobal() {
+			t.Errorf("expected `%s` to be a global", s.Id)
+		}
+	}
+	cases := []string{
+		// Not a global anymore
+		`import("fmt")`,
+		// Globals are reserved
+		`config = 1`,
+	}
+	for i, c := range cases {
+		if _, _, err := p.Parse("test", []byte(c)); err == nil {
+			t.Errorf("[%d] - expected an error for `%s`", i, c)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	p := New()
+	p.Vars = []string{"count", "greet"}
+	srcs := []string{
+		// Variables can be used and assigned
+		`count = count + 1
+		return greet("x")`,
+		// and redefined
+		`count := "a"
+		return count`,
+	}
+	for i, src := range srcs {
+		if _, _, err := p.Parse("test", []byte(src)); err != nil {
+			t.Errorf("[%d] - expected no error, got %s", i, err)
+		}
+	}
+	// Variables are only defined for the parse that has them
+	p.Vars = nil
+	if _, _, err := p.Parse("test", []byte(srcs[0])); err == nil {
+		t.Errorf("expected an error for undefined variables")
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `// header
+a := (1 + 2) /* multi
+line */
+func f() {
+	return a // trailing
+}`
+	p := New()
+	syms, _, err := p.Parse("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmts := p.Comments()
+	exp := []struct {
+		text string
+		line int
+		col  int
+	}{
+		{"// header", 1, 1},
+		{"/* multi\nline */", 2, 14},
+		{"// trailing", 5, 11},
+	}
+	if len(cmts) != len(exp) {
+		t.Fatalf("expected %d comments, got %d", len(exp), len(cmts))
+	}
+	for i, c := range cmts {
+		if c.Text != exp[i].text || c.Pos.Line != exp[i].line || c.Pos.Column != exp[i].col {
+			t.Errorf("[%d] - expected %q at %d:%d, got %q at %s", i, exp[i].text, exp[i].line, exp[i].col, c.Text, c.Pos)
+		}
+	}
+	if len(syms) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(syms))
+	}
+	if !syms[0].Second.(*Symbol).Parenthesized() {
+		t.Errorf("expected the value of `a` to be parenthesized")
+	}
+	if end := syms[1].End(); end.Line != 6 || end.Column != 1 {
+		t.Errorf("expected the function to end at 6:1, got %s", end)
+	}
+	if !syms[2].Implicit() {
+		t.Errorf("expected the last return statement to be implicit")
+	}
+}
+
+func TestDefs(t *testing.T) {
+	src := `x := 1
+func f(y) {
+	x := y
+	return x + len(y)
+}
+return f(x)`
+	syms, _, err := New().Parse("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The definitions refer to themselves
+	x := syms[0].First.(*Symbol)
+	if x.Def() != x || x.Shadows() != nil {
+		t.Errorf("expected the top-level `x` to be a definition that shadows nothing")
+	}
+	f := syms[1]
+	y := f.First.([]*Symbol)[0]
+	if y.Def() != y {
+		t.Errorf("expected the parameter `y` to be a definition")
+	}
+	body := f.Second.([]*Symbol)
+	x2 := body[0].First.(*Symbol)
+	if x2.Def() != x2 || x2.Shadows() != x {
+		t.Errorf("expected the `x` of f to shadow the top-level `x`")
+	}
+	if body[0].Second.(*Symbol).Def() != y {
+		t.Errorf("expected `y` to refer to the parameter")
+	}
+	add := body[1].First.(*Symbol)
+	if add.First.(*Symbol).Def() != x2 {
+		t.Errorf("expected `x` in f to refer to the `x` of f")
+	}
+	if add.Second.(*Symbol).First.(*Symbol).Def() != nil {
+		t.Errorf("expected the built-in `len` to have no definition")
+	}
+	if syms[2].First.(*Symbol).Second.([]*Symbol)[0].Def() != x {
+		t.Errorf("expected `x` to refer to the top-level `x`")
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	src := "// add returns the sum\n" +
+		"func add(a, b) {\n" +
+		"	return (a + b)\n" +
+		"}\n" +
+		"o := {x: 1, \"y\": [2, 3]}\n" +
+		"outer: for i := 0; i < 3; i++ {\n" +
+		"	if o.x > i {\n" +
+		"		break outer\n" +
+		"	} else {\n" +
+		"		o.m(`raw\n" +
+		"str`)\n" +
+		"	}\n" +
+		"}\n" +
+		"return add(o[\"x\"], len(args))\n"
+	f, err := New().ParseFile("test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Comments) != 1 || f.Comments[0].Text != "// add returns the sum" {
+		t.Errorf("unexpected comments %v", f.Comments)
+	}
+	if len(f.Stmts) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(f.Stmts))
+	}
+	var names []string
+	for _, id := range f.Scope.Defs {
+		names = append(names, id.Name)
+	}
+	if exp := "add o i"; strings.Join(names, " ") != exp {
+		t.Errorf("expected top-level definitions %s, got %s", exp, strings.Join(names, " "))
+	}
+
+	// The function and its scope
+	fd := f.Stmts[0].(*ast.FuncDecl)
+	if fd.Name.Def != fd.Name || fd.Pos().Line != 2 || fd.End().Line != 4 || fd.End().Column != 2 {
+		t.Errorf("unexpected function %s at %s to %s", fd.Name.Name, fd.Pos(), fd.End())
+	}
+	if len(fd.Func.Params) != 2 || fd.Func.Scope.Lookup("b") != fd.Func.Params[1] || fd.Func.Scope.Outer != f.Scope {
+		t.Errorf("expected the parameters to be defined in the scope of the function")
+	}
+	ret := fd.Func.Body.List[0].(*ast.ReturnStmt)
+	sum, ok := ret.Results[0].(*ast.ParenExpr)
+	if !ok || ret.Implicit {
+		t.Fatalf("expected an explicit return of a parenthesized expression")
+	}
+	if add := sum.X.(*ast.BinaryExpr); add.Op != token.ADD || add.X.(*ast.Ident).Def != fd.Func.Params[0] {
+		t.Errorf("expected `a` to refer to the parameter")
+	}
+
+	// The object literal
+	obj := f.Stmts[1].(*ast.AssignStmt).Rhs[0].(*ast.ObjectLit)
+	if k, ok := obj.Elts[0].Key.(*ast.Ident); !ok || k.Name != "x" || k.Def != nil {
+		t.Errorf("expected the key x to be a name without definition")
+	}
+	if k, ok := obj.Elts[1].Key.(*ast.BasicLit); !ok || k.Kind != token.STRING || k.Value != `"y"` {
+		t.Errorf("expected the key \"y\" to be a string literal")
+	}
+	if arr := obj.Elts[1].Value.(*ast.ArrayLit); len(arr.Elts) != 2 || arr.End().Column != 24 {
+		t.Errorf("expected an array literal of 2 values ending at column 24, got %d values ending at %s", len(arr.Elts), arr.End())
+	}
+
+	// The labeled for loop
+	lbl := f.Stmts[2].(*ast.LabeledStmt)
+	loop := lbl.Stmt.(*ast.ForStmt)
+	if lbl.Label.Name != "outer" || loop.Init.(*ast.AssignStmt).Tok != token.DEFINE ||
+		loop.Cond.(*ast.BinaryExpr).Op != token.LSS || loop.Post.(*ast.IncDecStmt).Tok != token.INC {
+		t.Errorf("unexpected for loop %+v", loop)
+	}
+	ifs := loop.Body.List[0].(*ast.IfStmt)
+	if br := ifs.Body.List[0].(*ast.BranchStmt); br.Tok != token.BREAK || br.Label.Name != "outer" {
+		t.Errorf("expected a break with the label outer")
+	}
+	els := ifs.Else.(*ast.BlockStmt)
+	call := els.List[0].(*ast.ExprStmt).X.(*ast.CallExpr)
+	if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "m" || sel.X.(*ast.Ident).Def != f.Scope.Lookup("o") {
+		t.Errorf("expected a method call on o")
+	}
+	if end := call.Args[0].End(); end.Line != 11 || end.Column != 5 {
+		t.Errorf("expected the raw string to end at 11:5, got %s", end)
+	}
+	if els.Lbrace.Line != 9 || els.Rbrace.Line != 12 || lbl.End().Line != 13 {
+		t.Errorf("unexpected positions of the blocks")
+	}
+
+	// The return statement
+	ret = f.Stmts[3].(*ast.ReturnStmt)
+	call = ret.Results[0].(*ast.CallExpr)
+	if call.Fun.(*ast.Ident).Def != fd.Name {
+		t.Errorf("expected `add` to refer to the function")
+	}
+	if _, ok := call.Args[0].(*ast.IndexExpr); !ok {
+		t.Errorf("expected an index expression")
+	}
+	if ln := call.Args[1].(*ast.CallExpr); !ln.Fun.(*ast.Ident).Global || ln.Fun.(*ast.Ident).Def != nil {
+		t.Errorf("expected `len` to be a global value")
+	}
+	if _, ok := call.Args[1].(*ast.CallExpr).Args[0].(*ast.ArgsExpr); !ok {
+		t.Errorf("expected the args keyword")
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	f, err := New().ParseFile("test", []byte("a := 1\nb := )\nf(a)\n"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var bad int
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.BadExpr, *ast.BadStmt:
+			bad++
+		}
+		return true
+	})
+	if bad == 0 {
+		t.Error("expected bad nodes in the syntax tree")
+	}
+	if len(f.Stmts) == 0 {
+		t.Error("expected the valid statements in the syntax tree")
+	}
+}
+
+func TestLabelAfterRange(t *testing.T) {
+	// The for statement of the range loop is the first one of its scope
+	srcs := []string{
+		"for i := range 2 {\n}\no2: for {\n\tbreak o2\n}\n",
+		"func f() {\n\tfor i := range 2 {\n\t}\n\to2: for {\n\t\tbreak o2\n\t}\n}\n",
+	}
+	for i, src := range srcs {
+		syms, _, err := New().Parse("test", []byte(src))
+		if err != nil {
+			t.Errorf("[%d] - unexpected error: %s", i, err)
+			continue
+		}
+		if exp := "o2"; !strings.Contains(fmt.Sprint(syms), exp) {
+			t.Errorf("[%d] - expected the label %s", i, exp)
+		}
+	}
+}
@@ -18,7 +18,19 @@ func (s *Scope) define(n *Symbol) *Symbol {
 			s.p.error(t, "already defined")
 		}
 	}
+	// Keep the definition hidden by this one in the parent scopes, if any
+	n.shadow = nil
+	for scp := s.parent; scp != nil; scp = scp.parent {
+		if t, ok := scp.def[n.Val.(string)]; ok {
+			if !t.res {
+				n.shadow = t
+			}
+			break
+		}
+	}
 	s.def[n.Val.(string)] = n
+	// The symbols of the name, cloned from this one, refer to the definition
+	n.def = n
 	n.res = false
 	n.lbp = 0
 	n.nudfn = itselfNud
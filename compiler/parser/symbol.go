@@ -59,50 +59,132 @@ func itselfStd(s *Symbol) interface{} {
 	return s
 }
 
-// A Symbol represents a node in the abstract syntax tree generated by the parser.
-// It holds the required information - operands, children, etc. - to generate the
-// bytecode instructions.
+// A Symbol represents a node in the parse tree generated by the parser.
+// It holds the required information - operands, children, etc. - to build the
+// syntax tree of the ast package.
 type Symbol struct {
-	p      *Parser
-	Id     string
-	Val    interface{}
-	Name   string
-	Key    interface{}
-	lbp    int
-	Ar     Arity
-	res    bool
-	asg    bool
-	tok    token.Token
-	pos    token.Position
-	First  interface{} // May all be []*Symbol or *Symbol
-	Second interface{}
-	Third  interface{}
+	p        *Parser
+	Id       string
+	Val      interface{}
+	Name     string
+	Key      interface{}
+	lbp      int
+	Ar       Arity
+	res      bool
+	asg      bool
+	paren    []parenPos // The enclosing parentheses, innermost first
+	implicit bool       // The symbol is not in the source code
+	tok      token.Token
+	pos      token.Position
+	end      token.Position // End of the statement, function, call, index, literal or case
+	blockEnd token.Position // End of the block, for `if` and `for` statements
+	lbrace   token.Position // Start of the block, for `if`, `for`, `switch` and `func`
+	elseBr   token.Position // Start of the `else` block of an `if` statement
+	def      *Symbol        // Definition of the name
+	shadow   *Symbol        // Definition hidden by this one
+	nameSym  *Symbol        // Name of a function, or label of a statement
+	keySym   *Symbol        // Key of an object literal value
+	fun      *Symbol        // Selector of a method call
+	First    interface{}    // May all be []*Symbol or *Symbol
+	Second   interface{}
+	Third    interface{}
 
 	nudfn func(*Symbol) *Symbol
 	ledfn func(*Symbol, *Symbol) *Symbol
 	stdfn func(*Symbol) interface{} // May return []*Symbol or *Symbol
 }
 
+// The positions of the parentheses enclosing an expression.
+type parenPos struct {
+	lparen, rparen token.Position
+}
+
+// Clone the symbol, without the data of its occurrence in the source code,
+// such as its name (i.e. the label of a statement) and its children. The
+// definition is kept, so that the clone of a defined name refers to it.
 func (s Symbol) clone() *Symbol {
 	return &Symbol{
-		s.p,
-		s.Id,
-		s.Val,
-		s.Name,
-		nil,
-		s.lbp,
-		s.Ar,
-		s.res,
-		s.asg,
-		s.tok,
-		s.pos,
-		nil,
-		nil,
-		nil,
-		s.nudfn,
-		s.ledfn,
-		s.stdfn,
+		p:     s.p,
+		Id:    s.Id,
+		Val:   s.Val,
+		lbp:   s.lbp,
+		Ar:    s.Ar,
+		res:   s.res,
+		asg:   s.asg,
+		tok:   s.tok,
+		pos:   s.pos,
+		def:   s.def,
+		nudfn: s.nudfn,
+		ledfn: s.ledfn,
+		stdfn: s.stdfn,
+	}
+}
+
+// Pos returns the position of the symbol in the source code.
+func (s *Symbol) Pos() token.Position {
+	return s.pos
+}
+
+// IsGlobal returns true if the symbol is one of the global names of the
+// parser, resolved by the execution context at runtime.
+func (s *Symbol) IsGlobal() bool {
+	return s.p != nil && s.p.globals[s.Id]
+}
+
+// End returns the position of the last token of the symbol in the source
+// code, if the symbol is a statement, i.e. the closing brace of a function, or
+// the closing delimiter of a call, an index, an object or an array literal. For
+// a case clause of a switch statement, it is the position of the colon.
+func (s *Symbol) End() token.Position {
+	return s.end
+}
+
+// BlockEnd returns the position of the closing brace of the block of a `for`
+// or an `if` statement. For an `if` statement with an `else` block, it is the
+// end of the first block, the end of the `else` block being the end of the
+// statement.
+func (s *Symbol) BlockEnd() token.Position {
+	return s.blockEnd
+}
+
+// KeyPos returns the position of the key of a value of an object literal, the
+// position is not valid if the symbol has no key.
+func (s *Symbol) KeyPos() token.Position {
+	if s.keySym == nil {
+		return token.Position{}
 	}
+	return s.keySym.pos
+}
+
+// Parenthesized returns true if the expression is enclosed in parentheses in
+// the source code.
+func (s *Symbol) Parenthesized() bool {
+	return len(s.paren) > 0
+}
+
+// Implicit returns true if the symbol is not in the source code, such as the
+// `return nil` statement added at the end of a function.
+func (s *Symbol) Implicit() bool {
+	return s.implicit
+}
+
+// Def returns the definition of the variable or parameter that the symbol
+// names, which is the symbol itself if it is the definition. It returns nil if
+// the symbol is not a name defined in the source code, i.e. a global value.
+func (s *Symbol) Def() *Symbol {
+	return s.def
+}
+
+// Shadows returns the definition of the same name in an enclosing function
+// that is hidden by the definition, or nil if there is none.
+func (s *Symbol) Shadows() *Symbol {
+	return s.shadow
+}
+
+// A Comment is a comment of the source code, including its delimiters.
+type Comment struct {
+	Text string
+	Pos  token.Position
 }
 
 func (s *Symbol) led(left *Symbol) *Symbol {
@@ -122,6 +204,11 @@ func (s *Symbol) std() interface{} {
 func (s *Symbol) nud() *Symbol {
 	if s.nudfn == nil {
 		s.p.error(s, "undefined")
+		if s.nudfn == nil {
+			// The end of the input is not turned into a bad symbol
+			s.p.err.Add(s.pos, "unexpected end of input")
+			return s
+		}
 	}
 	return s.nudfn(s)
 }
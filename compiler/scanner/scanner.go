@@ -41,6 +41,8 @@ type Scanner struct {
 	rdOffset       int  // reading offset (position after current character)
 	lineOffset     int  // current line offset
 	tokStartOffset int  // character offset of the start of the current token
+	tokStartLine   int  // line of the start of the current token
+	tokLineOffset  int  // line offset of the start of the current token
 	insertSemi     bool // insert a semicolon before next newline
 	line           int
 
@@ -113,6 +115,8 @@ func (s *Scanner) Init(filename string, src []byte, err ErrorHandler) {
 	s.rdOffset = 0
 	s.lineOffset = 0
 	s.tokStartOffset = 0
+	s.tokStartLine = 1
+	s.tokLineOffset = 0
 	s.insertSemi = false
 	s.ErrorCount = 0
 	s.line = 1
@@ -468,12 +472,14 @@ func (s *Scanner) switch4(tok0, tok1 token.Token, ch2 rune, tok2, tok3 token.Tok
 	return tok0
 }
 
+// Get the position of the start of the current token, so that tokens spanning
+// multiple lines, such as comments and raw strings, are at their first line.
 func (s *Scanner) getPosition() token.Position {
 	return token.Position{
 		Filename: s.filename,
-		Offset:   s.offset,
-		Line:     s.line,
-		Column:   s.tokStartOffset - s.lineOffset + 1,
+		Offset:   s.tokStartOffset,
+		Line:     s.tokStartLine,
+		Column:   s.tokStartOffset - s.tokLineOffset + 1,
 	}
 }
 
@@ -514,6 +520,8 @@ func (s *Scanner) Scan() (tok token.Token, lit string, pos token.Position) {
 	// determine token value
 	insertSemi := false
 	s.tokStartOffset = s.offset
+	s.tokStartLine = s.line
+	s.tokLineOffset = s.lineOffset
 	switch ch := s.ch; {
 	case isLetter(ch):
 		lit = s.scanIdentifier()
@@ -87,6 +87,10 @@ const (
 	CONTINUE
 	YIELD
 	RANGE
+	SWITCH
+	CASE
+	DEFAULT
+	DEFER
 	keyword_end
 )
 
@@ -153,6 +157,10 @@ var tokens = [...]string{
 	CONTINUE: "continue",
 	YIELD:    "yield",
 	RANGE:    "range",
+	SWITCH:   "switch",
+	CASE:     "case",
+	DEFAULT:  "default",
+	DEFER:    "defer",
 }
 
 // String returns the string corresponding to the token tok.
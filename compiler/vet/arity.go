@@ -0,0 +1,105 @@
+package vet
+
+// An arity is the number of arguments expected by a function, max is -1 if
+// the number of arguments is not limited.
+type arity struct {
+	min, max int
+}
+
+var (
+	// The arity of the built-in functions
+	builtins = map[string]arity{
+		"import":   {1, 1},
+		"panic":    {1, 2},
+		"recover":  {1, -1},
+		"len":      {1, 1},
+		"keys":     {1, 1},
+		"number":   {1, 1},
+		"string":   {1, 1},
+		"bool":     {1, 1},
+		"type":     {1, 1},
+		"status":   {1, 1},
+		"reset":    {1, 1},
+		"setproto": {2, 2},
+		"getproto": {1, 1},
+	}
+
+	// The arity of the functions of the stdlib modules, identified by the
+	// module ID and the function name.
+	stdlib = map[string]arity{
+		"filepath.Abs":   {1, 1},
+		"filepath.Base":  {1, 1},
+		"filepath.Dir":   {1, 1},
+		"filepath.Ext":   {1, 1},
+		"filepath.IsAbs": {1, 1},
+		"filepath.Join":  {1, -1},
+
+		"fmt.Print":   {0, -1},
+		"fmt.Println": {0, -1},
+		"fmt.Scanln":  {0, 0},
+		"fmt.Scanint": {0, 0},
+
+		"math.Abs":      {1, 1},
+		"math.Acos":     {1, 1},
+		"math.Acosh":    {1, 1},
+		"math.Asin":     {1, 1},
+		"math.Asinh":    {1, 1},
+		"math.Atan":     {1, 1},
+		"math.Atan2":    {2, 2},
+		"math.Atanh":    {1, 1},
+		"math.Ceil":     {1, 1},
+		"math.Cos":      {1, 1},
+		"math.Cosh":     {1, 1},
+		"math.Exp":      {1, 1},
+		"math.Floor":    {1, 1},
+		"math.Inf":      {1, 1},
+		"math.IsInf":    {2, 2},
+		"math.IsNaN":    {1, 1},
+		"math.Max":      {2, -1},
+		"math.Min":      {2, -1},
+		"math.NaN":      {0, 0},
+		"math.Pow":      {2, 2},
+		"math.Sin":      {1, 1},
+		"math.Sinh":     {1, 1},
+		"math.Sqrt":     {1, 1},
+		"math.Tan":      {1, 1},
+		"math.Tanh":     {1, 1},
+		"math.RandSeed": {1, 1},
+		"math.Rand":     {0, 2},
+
+		"os.Exit":      {0, 1},
+		"os.Getenv":    {1, 1},
+		"os.Getwd":     {0, 0},
+		"os.Exec":      {1, -1},
+		"os.Mkdir":     {0, -1},
+		"os.ReadDir":   {1, 1},
+		"os.Remove":    {0, -1},
+		"os.RemoveAll": {0, -1},
+		"os.Rename":    {2, 2},
+		"os.ReadFile":  {1, 1},
+		"os.WriteFile": {1, -1},
+		"os.Open":      {1, 2},
+		"os.TryOpen":   {1, 2},
+
+		"strings.ByteAt":    {2, 2},
+		"strings.Concat":    {2, -1},
+		"strings.Contains":  {2, -1},
+		"strings.HasPrefix": {2, -1},
+		"strings.HasSuffix": {2, -1},
+		"strings.Index":     {2, -1},
+		"strings.Join":      {1, 2},
+		"strings.LastIndex": {2, -1},
+		"strings.Matches":   {2, 3},
+		"strings.Repeat":    {2, 2},
+		"strings.Replace":   {2, 4},
+		"strings.Slice":     {2, 3},
+		"strings.Split":     {2, 3},
+		"strings.ToLower":   {1, -1},
+		"strings.ToUpper":   {1, -1},
+		"strings.Trim":      {1, 2},
+
+		"time.Date":  {1, 7},
+		"time.Now":   {0, 0},
+		"time.Sleep": {1, 1},
+	}
+)
@@ -0,0 +1,472 @@
+// Package vet implements the static analysis of agora source code, as done by
+// the `agora vet` command. It reports the likely bugs of valid source code,
+// that the parser accepts but that are probably not what the author meant:
+//
+//   - unused: a variable or a parameter that is never used. Assigning a value
+//     to a variable is not a use. The names `_` are ignored.
+//   - shadow: a variable defined with `:=` that hides a variable or a parameter
+//     of the same name in an enclosing function.
+//   - undefined: a variable used or assigned outside the block where it is
+//     defined, i.e. after an `if` statement that defines it. Agora variables are
+//     defined for the whole function, but if the block is not executed, the
+//     variable does not exist.
+//   - args: a call to a built-in function or to a function of a stdlib module
+//     with a wrong number of arguments.
+//   - yield: a `yield` in the top-level code of a module, which cannot be
+//     resumed since a module is only executed once.
+//   - unreachable: a statement that follows a `continue`, a panic with a
+//     constant value, or a statement that never completes, such as an `if` with
+//     an `else` whose both blocks return.
+package vet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/token"
+)
+
+// An Issue is a likely bug found in the source code.
+type Issue struct {
+	Pos   token.Position
+	Check string // the name of the check that reports the issue, i.e. "unused"
+	Msg   string
+}
+
+// String returns the position and the message of the issue.
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.Pos, i.Msg)
+}
+
+// Source checks the agora source code src, identified by filename in the
+// positions of the issues. The source code must be valid, otherwise the parse
+// error is returned. Only the default built-in functions are globals, see
+// SourceWithGlobals to check source code that uses the globals defined by an
+// execution context.
+func Source(filename string, src []byte) ([]*Issue, error) {
+	return SourceWithGlobals(filename, src, nil)
+}
+
+// SourceWithGlobals is like Source, but the global names defined by the
+// execution context are provided, so that they are not reported as undefined.
+// If globals is nil, the default built-in functions are the only globals.
+func SourceWithGlobals(filename string, src []byte, globals []string) ([]*Issue, error) {
+	p := parser.New()
+	p.Globals = globals
+	syms, _, err := p.Parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		block:   new(block),
+		blocks:  make(map[*parser.Symbol]*block),
+		params:  make(map[*parser.Symbol]bool),
+		used:    make(map[*parser.Symbol]bool),
+		modules: make(map[*parser.Symbol]string),
+	}
+	c.stmts(syms)
+	for _, d := range c.defs {
+		if c.used[d] || d.Val == "_" {
+			continue
+		}
+		if c.params[d] {
+			c.report(d.Pos(), "unused", "parameter %s is not used", d.Val)
+		} else {
+			c.report(d.Pos(), "unused", "%s is defined but not used", d.Val)
+		}
+	}
+	sort.Stable(byPos(c.issues))
+	return c.issues, nil
+}
+
+// A block is a list of statements that may not be executed, such as the body
+// of an `if` statement.
+type block struct {
+	parent *block
+}
+
+// Returns true if the block b is b2 or one of its parents.
+func (b *block) encloses(b2 *block) bool {
+	for ; b2 != nil; b2 = b2.parent {
+		if b2 == b {
+			return true
+		}
+	}
+	return false
+}
+
+// A checker walks the symbols of the parser to find the issues.
+type checker struct {
+	issues  []*Issue
+	block   *block                    // the current block
+	depth   int                       // the depth of functions, 0 for the top-level code
+	defs    []*parser.Symbol          // the definitions, in order
+	blocks  map[*parser.Symbol]*block // the block of each definition
+	params  map[*parser.Symbol]bool   // the definitions that are parameters
+	used    map[*parser.Symbol]bool   // the definitions that are used
+	modules map[*parser.Symbol]string // the variables that hold a stdlib module
+}
+
+// Add an issue at pos for the check, the message is formatted with the args.
+func (c *checker) report(pos token.Position, check, msg string, args ...interface{}) {
+	c.issues = append(c.issues, &Issue{pos, check, fmt.Sprintf(msg, args...)})
+}
+
+// Check the list of statements, reporting the first unreachable one.
+func (c *checker) stmts(list []*parser.Symbol) {
+	unreachable := false
+	for i, s := range list {
+		if !unreachable && i > 0 && !s.Implicit() && terminates(list[i-1]) {
+			c.report(start(s), "unreachable", "unreachable code")
+			unreachable = true
+		}
+		c.sym(s)
+	}
+}
+
+// Check the list of statements in a new block.
+func (c *checker) blockStmts(list []*parser.Symbol) {
+	c.block = &block{parent: c.block}
+	c.stmts(list)
+	c.block = c.block.parent
+}
+
+// Check the symbol s, a statement or an expression, and its children.
+func (c *checker) sym(s *parser.Symbol) {
+	switch s.Id {
+	case "(name)":
+		c.ref(s, true)
+	case "func":
+		c.depth++
+		c.block = &block{parent: c.block}
+		for _, prm := range s.First.([]*parser.Symbol) {
+			c.define(prm, true)
+		}
+		c.stmts(s.Second.([]*parser.Symbol))
+		c.block = c.block.parent
+		c.depth--
+	case ":=":
+		c.any(s.Second)
+		if l, ok := s.First.(*parser.Symbol); ok {
+			c.define(l, false)
+			if id, ok := importedModule(s.Second); ok {
+				c.modules[l] = id
+			}
+			break
+		}
+		for _, l := range s.First.([]*parser.Symbol) {
+			c.define(l, false)
+		}
+	case "if":
+		c.sym(s.First.(*parser.Symbol))
+		c.blockStmts(s.Second.([]*parser.Symbol))
+		switch v := s.Third.(type) {
+		case []*parser.Symbol:
+			c.blockStmts(v)
+		case *parser.Symbol:
+			c.sym(v)
+		}
+	case "for", "forr":
+		c.any(s.First)
+		if s.First == nil {
+			// The body of an infinite loop is executed at least once
+			c.stmts(s.Second.([]*parser.Symbol))
+		} else {
+			c.blockStmts(s.Second.([]*parser.Symbol))
+		}
+	case "switch":
+		c.any(s.First)
+		for _, cl := range s.Second.([]*parser.Symbol) {
+			c.any(cl.First)
+			c.blockStmts(cl.Second.([]*parser.Symbol))
+		}
+	case "yield":
+		if c.depth == 0 {
+			c.report(s.Pos(), "yield", "yield in the top-level code of a module, which cannot be resumed")
+		}
+		c.any(s.First)
+	case "(":
+		c.call(s)
+		c.any(s.First)
+		if s.Ar == parser.ArTernary {
+			// Second is the field name of the method
+			c.any(s.Third)
+		} else {
+			c.any(s.Second)
+		}
+	case ".":
+		// Second is the field name
+		c.any(s.First)
+	default:
+		if lv, ok := s.First.(*parser.Symbol); ok && isAssignment(s) && lv.Ar == parser.ArName {
+			c.ref(lv, false)
+		} else if lvs, ok := s.First.([]*parser.Symbol); ok && isAssignment(s) {
+			for _, lv := range lvs {
+				if lv.Ar == parser.ArName {
+					c.ref(lv, false)
+				} else {
+					c.sym(lv)
+				}
+			}
+		} else {
+			c.any(s.First)
+		}
+		c.any(s.Second)
+		c.any(s.Third)
+	}
+}
+
+// Check v, a child of a symbol.
+func (c *checker) any(v interface{}) {
+	switch v := v.(type) {
+	case *parser.Symbol:
+		c.sym(v)
+	case []*parser.Symbol:
+		for _, s := range v {
+			c.sym(s)
+		}
+	case []interface{}:
+		for _, s := range v {
+			c.any(s)
+		}
+	}
+}
+
+// Register the definition of a variable or a parameter.
+func (c *checker) define(s *parser.Symbol, param bool) {
+	c.defs = append(c.defs, s)
+	c.blocks[s] = c.block
+	c.params[s] = param
+	if sh := s.Shadows(); sh != nil && !param {
+		c.report(s.Pos(), "shadow", "%s shadows the definition at %s", s.Val, lineCol(sh.Pos()))
+	}
+}
+
+// Check the reference to a name, a use or an assignment.
+func (c *checker) ref(s *parser.Symbol, use bool) {
+	d := s.Def()
+	b, ok := c.blocks[d]
+	if !ok || d == s {
+		// Not defined in the source code
+		return
+	}
+	if use {
+		c.used[d] = true
+	} else {
+		delete(c.modules, d)
+	}
+	if !b.encloses(c.block) {
+		if use {
+			c.report(s.Pos(), "undefined", "%s may be undefined, it is defined at %s in a block that does not enclose this use", s.Val, lineCol(d.Pos()))
+		} else {
+			c.report(s.Pos(), "undefined", "assignment to %s, which may be undefined, it is defined at %s in a block that does not enclose this assignment", s.Val, lineCol(d.Pos()))
+		}
+	}
+}
+
+// Check the number of arguments of a call to a known function.
+func (c *checker) call(s *parser.Symbol) {
+	var (
+		name string
+		ar   arity
+		ok   bool
+		args []*parser.Symbol
+	)
+	if s.Ar == parser.ArTernary {
+		// Method call
+		ob, fld := s.First.(*parser.Symbol), s.Second.(*parser.Symbol)
+		mod, isMod := c.modules[ob.Def()]
+		if ob.Id != "(name)" || !isMod || fld.Ar != parser.ArLiteral {
+			return
+		}
+		// The field name is an identifier after a dot, a string literal in brackets
+		fn := fld.Val.(string)
+		if s, err := strconv.Unquote(fn); err == nil {
+			fn = s
+		}
+		name = mod + "." + fn
+		ar, ok = stdlib[name]
+		args, _ = s.Third.([]*parser.Symbol)
+	} else {
+		fn := s.First.(*parser.Symbol)
+		if !fn.IsGlobal() {
+			return
+		}
+		name = fn.Id
+		ar, ok = builtins[name]
+		args, _ = s.Second.([]*parser.Symbol)
+	}
+	if !ok {
+		return
+	}
+	if len(args) < ar.min {
+		c.report(s.Pos(), "args", "not enough arguments in call to %s, expected at least %d, got %d", name, ar.min, len(args))
+	} else if ar.max >= 0 && len(args) > ar.max {
+		c.report(s.Pos(), "args", "too many arguments in call to %s, expected at most %d, got %d", name, ar.max, len(args))
+	}
+}
+
+// Returns the ID of the module imported by v, if it is a call to import with a
+// string literal.
+func importedModule(v interface{}) (string, bool) {
+	s, ok := v.(*parser.Symbol)
+	if !ok || s.Id != "(" || s.Ar != parser.ArBinary {
+		return "", false
+	}
+	fn, args := s.First.(*parser.Symbol), s.Second.([]*parser.Symbol)
+	if fn.Id != "import" || !fn.IsGlobal() || len(args) != 1 || args[0].Id != "(literal)" {
+		return "", false
+	}
+	id, err := strconv.Unquote(args[0].Val.(string))
+	return id, err == nil
+}
+
+// Returns true if s is an assignment, its First child being the value(s)
+// assigned to.
+func isAssignment(s *parser.Symbol) bool {
+	switch s.Id {
+	case "=", "+=", "-=", "*=", "/=", "%=", "++", "--":
+		return true
+	}
+	return false
+}
+
+// Returns true if the statement s never completes, so that the statements
+// after it cannot be executed.
+func terminates(s *parser.Symbol) bool {
+	switch s.Id {
+	case "return", "break", "continue":
+		return true
+	case "(":
+		return isPanic(s)
+	case "if":
+		switch v := s.Third.(type) {
+		case []*parser.Symbol:
+			return terminatesList(s.Second.([]*parser.Symbol)) && terminatesList(v)
+		case *parser.Symbol:
+			return terminatesList(s.Second.([]*parser.Symbol)) && terminates(v)
+		}
+	case "for":
+		return s.First == nil && !breaks(s.Second.([]*parser.Symbol), s, true)
+	case "switch":
+		hasDefault := false
+		for _, cl := range s.Second.([]*parser.Symbol) {
+			list := cl.Second.([]*parser.Symbol)
+			if !terminatesList(list) || breaks(list, s, true) {
+				return false
+			}
+			hasDefault = hasDefault || cl.Id == "default"
+		}
+		return hasDefault
+	}
+	return false
+}
+
+// Returns true if the last statement of the list never completes.
+func terminatesList(list []*parser.Symbol) bool {
+	return len(list) > 0 && terminates(list[len(list)-1])
+}
+
+// Returns true if a statement of the list breaks out of the for or switch
+// statement target. If direct is true, the list is in target, not in a
+// for or switch statement in target, so that a break without label applies to
+// target.
+func breaks(list []*parser.Symbol, target *parser.Symbol, direct bool) bool {
+	for _, s := range list {
+		switch s.Id {
+		case "break":
+			if (s.Name == "" && direct) || (s.Name != "" && s.Name == target.Name) {
+				return true
+			}
+		case "if":
+			if breaks(s.Second.([]*parser.Symbol), target, direct) {
+				return true
+			}
+			switch v := s.Third.(type) {
+			case []*parser.Symbol:
+				if breaks(v, target, direct) {
+					return true
+				}
+			case *parser.Symbol:
+				if breaks([]*parser.Symbol{v}, target, direct) {
+					return true
+				}
+			}
+		case "for", "forr":
+			if breaks(s.Second.([]*parser.Symbol), target, false) {
+				return true
+			}
+		case "switch":
+			for _, cl := range s.Second.([]*parser.Symbol) {
+				if breaks(cl.Second.([]*parser.Symbol), target, false) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if s is a call to panic with a constant value that raises an
+// error, i.e. a non-empty string.
+func isPanic(s *parser.Symbol) bool {
+	if s.Ar != parser.ArBinary {
+		return false
+	}
+	fn, args := s.First.(*parser.Symbol), s.Second.([]*parser.Symbol)
+	if fn.Id != "panic" || !fn.IsGlobal() || len(args) == 0 {
+		return false
+	}
+	switch v := args[0]; v.Id {
+	case "true":
+		return true
+	case "(literal)":
+		lit := v.Val.(string)
+		if lit[0] == '"' || lit[0] == '`' {
+			str, err := strconv.Unquote(lit)
+			return err == nil && str != ""
+		}
+		n, err := strconv.ParseFloat(lit, 64)
+		return err == nil && n != 0
+	}
+	return false
+}
+
+// Get the position of the first token of the statement s.
+func start(s *parser.Symbol) token.Position {
+	for {
+		var f *parser.Symbol
+		switch v := s.First.(type) {
+		case *parser.Symbol:
+			f = v
+		case []*parser.Symbol:
+			if len(v) > 0 {
+				f = v[0]
+			}
+		}
+		if f == nil || f.Implicit() || f.Pos().Offset >= s.Pos().Offset {
+			return s.Pos()
+		}
+		s = f
+	}
+}
+
+// Get the line:column representation of the position, the file being the
+// one of the issue.
+func lineCol(pos token.Position) string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// Sort the issues by position.
+type byPos []*Issue
+
+func (b byPos) Len() int      { return len(b) }
+func (b byPos) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPos) Less(i, j int) bool {
+	if b[i].Pos.Line != b[j].Pos.Line {
+		return b[i].Pos.Line < b[j].Pos.Line
+	}
+	return b[i].Pos.Column < b[j].Pos.Column
+}
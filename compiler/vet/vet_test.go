@@ -0,0 +1,184 @@
+package vet
+
+import (
+	"testing"
+)
+
+var cases = []struct {
+	src string
+	exp []string
+}{
+	0: {
+		// No issue
+		src: `fmt := import("fmt")
+func add(a, b) {
+	return a + b
+}
+n := 0
+for i := 0; i < 3; i++ {
+	n += add(i, 1)
+}
+fmt.Println(n)
+return func() {
+	yield n
+}`,
+	},
+	1: {
+		// Unused variables and parameters, assignments are not uses
+		src: `a := 1
+b := 2
+b = 3
+func f(x, _, y) {
+	return y
+}
+return f(a)`,
+		exp: []string{
+			"2:1: b is defined but not used",
+			"4:8: parameter x is not used",
+		},
+	},
+	2: {
+		// Shadowing with :=, but not with parameters
+		src: `x := 1
+func f(x) {
+	g := func() {
+		x := 2
+		return x
+	}
+	return g() + x
+}
+return f(x)`,
+		exp: []string{
+			"4:3: x shadows the definition at 2:8",
+		},
+	},
+	3: {
+		// Use and assignment outside the block of the definition
+		src: `if true {
+	a := 1
+	a++
+} else {
+	a = 2
+}
+for {
+	b := 3
+	break
+}
+switch b {
+case 1:
+	c := 4
+	f := func() {
+		return c
+	}
+	f()
+}
+return a + b`,
+		exp: []string{
+			"5:2: assignment to a, which may be undefined, it is defined at 2:2 in a block that does not enclose this assignment",
+			"19:8: a may be undefined, it is defined at 2:2 in a block that does not enclose this use",
+		},
+	},
+	4: {
+		// Argument counts of the built-ins and stdlib functions
+		src: `fmt := import("fmt")
+m := import("math")
+s := import("strings")
+fmt.Println()
+fmt.Scanln(1)
+m.Pow(2)
+s["Split"]("a,b", ",", 1, 2)
+x := len()
+x = keys({}, 1)
+y := m.Unknown(1, 2, 3)
+s = import("other")
+s.Split()
+return x + y`,
+		exp: []string{
+			"5:11: too many arguments in call to fmt.Scanln, expected at most 0, got 1",
+			"6:6: not enough arguments in call to math.Pow, expected at least 2, got 1",
+			"7:11: too many arguments in call to strings.Split, expected at most 3, got 4",
+			"8:9: not enough arguments in call to len, expected at least 1, got 0",
+			"9:9: too many arguments in call to keys, expected at most 1, got 2",
+		},
+	},
+	5: {
+		// Yield in the top-level code
+		src: `x := yield 1
+return x`,
+		exp: []string{
+			"1:6: yield in the top-level code of a module, which cannot be resumed",
+		},
+	},
+	6: {
+		// Unreachable code
+		src: `func f(x) {
+	for i := 0; i < x; i++ {
+		continue
+		x++
+	}
+	if x {
+		return 1
+	} else if !x {
+		panic("oops")
+	} else {
+		return 3
+	}
+	x = 4
+	return x
+}
+func g(x) {
+	for {
+		if x {
+			break
+		}
+	}
+	x++
+	switch x {
+	case 1:
+		break
+	default:
+		return
+	}
+	panic(nil)
+	x++
+	loop: for {
+		for {
+			break loop
+		}
+	}
+	x++
+	for {
+	}
+	x = 2
+	return x
+}
+return f(1) + g(2)`,
+		exp: []string{
+			"4:3: unreachable code",
+			"13:2: unreachable code",
+			"39:2: unreachable code",
+		},
+	},
+}
+
+func TestSource(t *testing.T) {
+	for i, c := range cases {
+		issues, err := Source("", []byte(c.src))
+		if err != nil {
+			t.Errorf("[%d] - unexpected error: %s", i, err)
+			continue
+		}
+		if len(issues) != len(c.exp) {
+			t.Errorf("[%d] - expected %d issues, got %d: %v", i, len(c.exp), len(issues), issues)
+			continue
+		}
+		for j, is := range issues {
+			if is.String() != c.exp[j] {
+				t.Errorf("[%d] - expected issue %d to be %q, got %q", i, j, c.exp[j], is)
+			}
+		}
+	}
+	if _, err := Source("", []byte(`x = 1`)); err == nil {
+		t.Errorf("expected an error for invalid source code")
+	}
+}
@@ -0,0 +1,131 @@
+package lsp
+
+// The documentation of a global value or of a member of a module, displayed
+// by hover and completion.
+type doc struct {
+	sig  string // the signature, i.e. `fmt.Println(vals...)`
+	text string
+}
+
+var (
+	// The documentation of the built-in functions
+	builtinDocs = map[string]doc{
+		"import":   {"import(id)", "Takes a single string value as argument, identifying a module to load and run, and returns the return value of the imported module."},
+		"panic":    {"panic(val[, cause])", "Takes a value as argument, and if it is \"truthy\", raises a runtime error (a \"panic\") with this value. If the value is \"falsy\", it is a no-op and returns `nil`. An optional second argument is the cause of the error, usually an error returned by `recover`. If the value is an error returned by `recover` and there is no cause, the error is raised again as-is, keeping its original call stack."},
+		"recover":  {"recover(fn, args...)", "Takes at least a single value as argument, which must be a function. If more values are provided, they are passed as arguments to the function. It executes the function and catches any error (panic) that the function may raise (it runs the function in *protected mode*). If an error is caught, it returns it as an error object (see *Errors* below), otherwise it returns `nil`."},
+		"len":      {"len(val)", "Takes a single value as argument. If it is `nil`, returns `0`. If it is an object, returns the number of fields defined on the object (this behaviour may be overridden if the object has a `__len` meta-method). Otherwise it returns the length of the string value."},
+		"keys":     {"keys(ob)", "Takes a single value as argument, which must be an object (it panics otherwise). Returns an array-like object holding all the keys of the object passed as argument. If the object has a `__keys` meta-method, it is called and its return value is returned. The order of the keys are undefined, even for an array-like object."},
+		"number":   {"number(val)", "Converts a value to a number."},
+		"string":   {"string(val)", "Converts a value to a string."},
+		"bool":     {"bool(val)", "Converts a value to a boolean."},
+		"type":     {"type(val)", "Returns the type of a value, namely `number`, `string`, `bool`, `func`, `object`, `nil` or `custom`."},
+		"status":   {"status(fn)", "Returns the coroutine status of a function, which can be empty string (\"\") if it isn't a coroutine, `running` if the coroutine is currently in execution, and `suspended` if it is in `yield` state, waiting to resume."},
+		"reset":    {"reset(fn)", "Resets a coroutine function so that the next call to the function restarts its execution from the beginning."},
+		"setproto": {"setproto(ob, proto)", "Takes two values as arguments, an object and its new prototype, which must be an object or `nil` to remove the prototype. It panics if the prototype chain would contain a cycle. Returns the object, so that it can be used in an expression like `dog := setproto({name: \"Rex\"}, animal)`."},
+		"getproto": {"getproto(ob)", "Takes a single object as argument, and returns its prototype, or `nil` if it doesn't have one."},
+	}
+
+	// The documentation of the native modules
+	moduleDocs = map[string]string{
+		"filepath": "File path manipulation functions, a subset of Go's `path/filepath` package.",
+		"fmt":      "Formatted I/O, a subset of Go's `fmt` package.",
+		"math":     "The usual mathematical functions, a subset of Go's `math` and `math/rand` packages.",
+		"os":       "File access and process manipulation, a subset of Go's `os`, `os/exec` and `io/ioutil` packages.",
+		"strings":  "String manipulation functions and regular expressions, a subset of Go's `strings` and `regexp` packages.",
+		"time":     "Date and time functions and types, a subset of Go's `time` package.",
+		"assert":   "The assertions of the test files executed by `agora test`.",
+	}
+
+	// The documentation of the members of the native modules, identified by
+	// the module ID and the member name.
+	memberDocs = map[string]doc{
+		"filepath.Abs":   {"filepath.Abs(val)", "Returns the absolute path of val. It may panic."},
+		"filepath.Base":  {"filepath.Base(val)", "Returns the last element of val."},
+		"filepath.Dir":   {"filepath.Dir(val)", "Returns all but the last element of val."},
+		"filepath.Ext":   {"filepath.Ext(val)", "Returns the extension of the last element of val. The extension is the suffix of the last element starting at the last dot."},
+		"filepath.IsAbs": {"filepath.IsAbs(val)", "Returns true if val is an absolute path."},
+		"filepath.Join":  {"filepath.Join(vals...)", "Joins any number of path elements into a single path, and returns the resulting path."},
+
+		"fmt.Print":   {"fmt.Print(vals...)", "Prints the vals to stdout."},
+		"fmt.Println": {"fmt.Println(vals...)", "Prints the vals to stdout, then prints a newline."},
+		"fmt.Scanln":  {"fmt.Scanln()", "Reads text up to a newline character from stdin."},
+		"fmt.Scanint": {"fmt.Scanint()", "Reads and returns an integer value from stdin."},
+
+		"math.Pi":       {"math.Pi", "Number field that holds the Pi value."},
+		"math.Abs":      {"math.Abs(val)", "Returns the absolute value of val."},
+		"math.Acos":     {"math.Acos(val)", "Returns the arccosine of val."},
+		"math.Acosh":    {"math.Acosh(val)", "Returns the inverse hyperbolic cosine of val."},
+		"math.Asin":     {"math.Asin(val)", "Returns the arcsine of val."},
+		"math.Asinh":    {"math.Asinh(val)", "Returns the inverse hyperbolic sine of val."},
+		"math.Atan":     {"math.Atan(val)", "Returns the arctangent of val."},
+		"math.Atan2":    {"math.Atan2(val1, val2)", "Returns the arctangent of val1/val2."},
+		"math.Atanh":    {"math.Atanh(val)", "Returns the inverse hyperbolic tangent of val."},
+		"math.Ceil":     {"math.Ceil(val)", "Returns the ceiling of val."},
+		"math.Cos":      {"math.Cos(val)", "Returns the cosine of val."},
+		"math.Cosh":     {"math.Cosh(val)", "Returns the hyperbolic cosine of val."},
+		"math.Exp":      {"math.Exp(val)", "Returns the base-e exponential of val."},
+		"math.Floor":    {"math.Floor(val)", "Returns the floor of val."},
+		"math.Inf":      {"math.Inf(val)", "Returns positive infinity if val >= 0, negative infinity otherwise."},
+		"math.IsInf":    {"math.IsInf(val1, val2)", "Returns true if val1 is infinity according to the sign of val2."},
+		"math.IsNaN":    {"math.IsNaN(val)", "Returns true if val is not a number (NaN)."},
+		"math.Max":      {"math.Max(vals...)", "Returns the maximum value of all vals."},
+		"math.Min":      {"math.Min(vals...)", "Returns the minimum value of all vals."},
+		"math.NaN":      {"math.NaN()", "Returns the not-a-number (NaN) value."},
+		"math.Pow":      {"math.Pow(val1, val2)", "Returns the base-val1 exponential of val2."},
+		"math.Sin":      {"math.Sin(val)", "Returns the sine of val."},
+		"math.Sinh":     {"math.Sinh(val)", "Returns the hyperbolic sine of val."},
+		"math.Sqrt":     {"math.Sqrt(val)", "Returns the square root of val."},
+		"math.Tan":      {"math.Tan(val)", "Returns the tangent of val."},
+		"math.Tanh":     {"math.Tanh(val)", "Returns the hyperbolic tangent of val."},
+		"math.RandSeed": {"math.RandSeed(val)", "Initializes the random generator with the val seed."},
+		"math.Rand":     {"math.Rand([val1[, val2]])", "Returns a random value >= 0. If val1 is provided, it is used as the higher bound. If both val1 and val2 are provided, val1 is the inclusive lower bound, val2 is the higher bound."},
+
+		"os.TempDir":           {"os.TempDir", "String field that holds the temporary directory."},
+		"os.PathSeparator":     {"os.PathSeparator", "String field that holds the path separator."},
+		"os.PathListSeparator": {"os.PathListSeparator", "String field that holds the path list separator."},
+		"os.DevNull":           {"os.DevNull", "String field that holds the name of the OS's null device."},
+		"os.Exit":              {"os.Exit([val])", "Terminates the current process with the val exit code, or 0 if no val is specified."},
+		"os.Getenv":            {"os.Getenv(val)", "Returns the environment variable identified by val."},
+		"os.Getwd":             {"os.Getwd()", "Returns the current working directory."},
+		"os.Exec":              {"os.Exec(val[, vals])", "Executes the process identified by val, with vals as arguments. Returns the combined stdout and stderr output as a string."},
+		"os.Mkdir":             {"os.Mkdir(vals...)", "Creates all directories as specified by vals, creating missing subdirectories as required. If the last argument is a number, it is used as the permission flag, otherwise all directories are created with the 0777 permission."},
+		"os.ReadDir":           {"os.ReadDir(val)", "Reads all files and subdirectories in val, and returns an array-like object holding all those files and subdirectories."},
+		"os.Remove":            {"os.Remove(vals...)", "Removes all directories specified by vals."},
+		"os.RemoveAll":         {"os.RemoveAll(vals...)", "Removes all directories and their content specified by vals."},
+		"os.Rename":            {"os.Rename(val1, val2)", "Renames the file or directory identified by val1 to val2."},
+		"os.ReadFile":          {"os.ReadFile(val)", "Reads the content of the file identified by val and returns it as a string."},
+		"os.WriteFile":         {"os.WriteFile(val, vals...)", "Creates a new file or replace an existing file identified by val, and writes all vals to this file. Returns the number of bytes written."},
+		"os.Open":              {"os.Open(val1[, val2])", "Opens the file identified by val1, by default in read-only mode. If a second argument is provided, it is the open mode, one of `r`, `w`, `a`, `r+`, `w+` or `a+`."},
+		"os.TryOpen":           {"os.TryOpen(val1[, val2])", "Same as `Open`, but returns `nil` instead of a runtime error if there is an error opening the file."},
+
+		"strings.ByteAt":    {"strings.ByteAt(s, i)", "Returns the byte at position i in string s, as a string value. It returns an empty string if i is out of bounds."},
+		"strings.Concat":    {"strings.Concat(vals...)", "Concatenates all vals in order and returns the resulting string."},
+		"strings.Contains":  {"strings.Contains(val, vals...)", "Returns true if val contains any of the vals."},
+		"strings.HasPrefix": {"strings.HasPrefix(val, vals...)", "Checks if val starts with any of the vals, returning true if this is the case."},
+		"strings.HasSuffix": {"strings.HasSuffix(val, vals...)", "Checks if val ends with any of the vals, returning true if this is the case."},
+		"strings.Index":     {"strings.Index(s[, start], vals...)", "Returns the index of the first of vals found within s. If start is specified, looks for vals starting at index start in s."},
+		"strings.Join":      {"strings.Join(ob[, sep])", "Takes an array-like object and joins each part using the separator sep, or empty string by default. Returns the resulting string."},
+		"strings.LastIndex": {"strings.LastIndex(val[, start], vals...)", "Same as Index but returns the last index of vals instead of the first encounter."},
+		"strings.Matches":   {"strings.Matches(s, pat[, n])", "Returns the matches of regular expression pat applied to the source string s. If n is provided, a maximum of n matches are returned. The return value is an array-like object holding all matches or nil if there is none."},
+		"strings.Repeat":    {"strings.Repeat(s, n)", "Returns a string consisting of `n` times the string `s`."},
+		"strings.Replace":   {"strings.Replace(s, old[, new][, n])", "Replaces occurrences of old in s with new, or empty string if new is not provided. If n is provided, replaces a maximum of n occurrences. If the third argument is a number, it is considered to be n and new defaults to empty string."},
+		"strings.Slice":     {"strings.Slice(s, start[, end])", "Returns a slice of string s start at start and ending at end (or the end of s if end is not provided). Is equivalent to Go's s[start:end] notation."},
+		"strings.Split":     {"strings.Split(s, sep[, n])", "Returns an array-like object holding the parts of string s split at separator sep. If n is provided, a maximum of n parts are returned, the last part holding the rest of s if required."},
+		"strings.ToLower":   {"strings.ToLower(vals...)", "Converts and concatenates all vals to lowercase, and returns the resulting string."},
+		"strings.ToUpper":   {"strings.ToUpper(vals...)", "Converts and concatenates all vals to uppercase, and returns the resulting string."},
+		"strings.Trim":      {"strings.Trim(s[, cut])", "Returns a string with all characters from cut removed from the start and the end of s. If cut is not provided, removes whitespace (space, \\n, \\t, \\r, \\v)."},
+
+		"time.Date":  {"time.Date(year[, month[, day[, hour[, min[, sec[, ns]]]]]])", "Returns a time object corresponding to the requested time. Month and day default to 1 if not provided, while hour, minute, second and nanosecond default to 0."},
+		"time.Now":   {"time.Now()", "Returns a time object corresponding to the current time."},
+		"time.Sleep": {"time.Sleep(ms)", "Pauses execution of the agora program for the specified number of milliseconds. It returns nil."},
+
+		"assert.True":     {"assert.True(val, msgs...)", "Asserts that val is true."},
+		"assert.False":    {"assert.False(val, msgs...)", "Asserts that val is false."},
+		"assert.Equal":    {"assert.Equal(exp, val, msgs...)", "Asserts that val is equal to exp. Objects are equal if they have the same keys and their values are equal."},
+		"assert.NotEqual": {"assert.NotEqual(exp, val, msgs...)", "Asserts that val is not equal to exp."},
+		"assert.Nil":      {"assert.Nil(val, msgs...)", "Asserts that val is nil."},
+		"assert.NotNil":   {"assert.NotNil(val, msgs...)", "Asserts that val is not nil."},
+		"assert.Panics":   {"assert.Panics(fn, args...)", "Asserts that calling fn with args raises an error, and returns the error."},
+		"assert.Fail":     {"assert.Fail(msgs...)", "Fails unconditionally."},
+	}
+)
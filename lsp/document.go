@@ -0,0 +1,431 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/compiler/scanner"
+	"github.com/bobg/agora/compiler/token"
+	"github.com/bobg/agora/compiler/vet"
+)
+
+// A ref is an occurrence of a name in the source code: a variable, a
+// parameter, a function, a global value or a field name.
+type ref struct {
+	off    int            // the byte offset of the name
+	name   string         // the name
+	def    int            // the byte offset of the definition, -1 if it is not defined in the document
+	global bool           // the name is a global value
+	obj    *parser.Symbol // the object of a field name, nil if the name is not a field
+}
+
+// Returns true if the byte offset off is in the name, or right after it.
+func (r *ref) contains(off int) bool {
+	return off >= r.off && off <= r.off+len(r.name)
+}
+
+// A definition is a variable, a parameter or a func statement defined in the
+// source code.
+type definition struct {
+	off    int            // the byte offset of the name
+	name   string         // the name
+	fn     *parser.Symbol // the func statement, if it is the name of a function
+	module string         // the ID of the module imported by the variable, if any
+}
+
+// A document is an open text document, and the result of its analysis.
+type document struct {
+	uri   string
+	text  string
+	lines []int // the byte offsets of the start of the lines
+
+	diags []*Diagnostic
+	refs  []*ref              // the names, in order
+	defs  map[int]*definition // the definitions, by byte offset
+	funcs []*DocumentSymbol   // the func statements
+}
+
+// Create the document uri with the source code text, and analyze it. The
+// globals are the names of the global values, see parser.Parser.Globals.
+func newDocument(uri, text string, globals []string) *document {
+	d := &document{
+		uri:   uri,
+		text:  text,
+		lines: []int{0},
+		defs:  make(map[int]*definition),
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	// The names are resolved by the scopes of the parser, even if the source
+	// code has errors, so that most of the features keep working while the
+	// document is being edited.
+	syms, err := parse(uri, text, globals)
+	d.analyzeAll(syms)
+	if err != nil {
+		el, ok := err.(scanner.ErrorList)
+		if !ok {
+			// The position of the failure is unknown, it is reported at the end
+			// of the document, where the code is most likely being typed.
+			el = scanner.ErrorList{{Pos: token.Position{Offset: len(text)}, Msg: err.Error()}}
+		}
+		for _, e := range el {
+			d.diags = append(d.diags, &Diagnostic{
+				Range:    d.wordRange(e.Pos.Offset),
+				Severity: SeverityError,
+				Source:   "agora",
+				Message:  e.Msg,
+			})
+		}
+		return d
+	}
+	// The source code is valid, report the likely bugs
+	issues, err := vet.SourceWithGlobals(filename(uri), []byte(text), globals)
+	if err != nil {
+		return d
+	}
+	for _, i := range issues {
+		d.diags = append(d.diags, &Diagnostic{
+			Range:    d.wordRange(i.Pos.Offset),
+			Severity: SeverityWarning,
+			Code:     i.Check,
+			Source:   "vet",
+			Message:  i.Msg,
+		})
+	}
+	return d
+}
+
+// Parse the source code text of the document uri. The parser may panic on
+// some incomplete source code, the panic is returned as an error, without
+// symbols.
+func parse(uri, text string, globals []string) (syms []*parser.Symbol, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			syms, err = nil, fmt.Errorf("syntax error: %v", e)
+		}
+	}()
+	p := parser.New()
+	p.Globals = globals
+	syms, _, err = p.Parse(filename(uri), []byte(text))
+	return syms, err
+}
+
+// Collect the names and the func statements of syms. The tree of invalid
+// source code may be inconsistent, so a failure of the analysis is ignored,
+// keeping the names collected so far.
+func (d *document) analyzeAll(syms []*parser.Symbol) {
+	defer func() {
+		recover()
+		sort.Sort(byOffset(d.refs))
+	}()
+	d.analyze(syms, nil)
+}
+
+// Collect the names and the func statements of v, a child of a symbol. The
+// func statements are added to the children of fn, or to the document if fn
+// is nil.
+func (d *document) analyze(v interface{}, fn *DocumentSymbol) {
+	switch v := v.(type) {
+	case []*parser.Symbol:
+		for _, s := range v {
+			d.analyze(s, fn)
+		}
+	case []interface{}:
+		for _, s := range v {
+			d.analyze(s, fn)
+		}
+	case *parser.Symbol:
+		if v == nil {
+			return
+		}
+		switch v.Id {
+		case "(name)":
+			if v.Ar != parser.ArName {
+				break
+			}
+			r := &ref{off: v.Pos().Offset, name: v.Val.(string), def: -1}
+			if def := v.Def(); def != nil {
+				r.def = def.Pos().Offset
+				if def == v {
+					d.define(r.off, r.name)
+				}
+			}
+			d.refs = append(d.refs, r)
+			return
+		case "func":
+			if v.Name != "" {
+				fn = d.funcStmt(v, fn)
+			}
+			d.analyze(v.First, fn)
+			d.analyze(v.Second, fn)
+			return
+		case ":=":
+			d.analyze(v.Second, fn)
+			d.analyze(v.First, fn)
+			if l, ok := v.First.(*parser.Symbol); ok && l.Def() == l {
+				if id, ok := importedModule(v.Second); ok {
+					d.define(l.Pos().Offset, l.Val.(string)).module = id
+				}
+			}
+			return
+		case ".":
+			d.analyze(v.First, fn)
+			d.field(v.First, v.Second)
+			return
+		case "(":
+			if v.Ar == parser.ArTernary {
+				// Method call, Second is the field name
+				d.analyze(v.First, fn)
+				if !d.field(v.First, v.Second) {
+					d.analyze(v.Second, fn)
+				}
+				d.analyze(v.Third, fn)
+				return
+			}
+		}
+		if v.IsGlobal() {
+			d.refs = append(d.refs, &ref{off: v.Pos().Offset, name: v.Id, def: -1, global: true})
+		}
+		d.analyze(v.First, fn)
+		d.analyze(v.Second, fn)
+		d.analyze(v.Third, fn)
+	}
+}
+
+// Register the definition of name at the byte offset off.
+func (d *document) define(off int, name string) *definition {
+	def, ok := d.defs[off]
+	if !ok {
+		def = &definition{off: off, name: name}
+		d.defs[off] = def
+	}
+	return def
+}
+
+// Register the func statement s, a child of the function parent, and return
+// its document symbol.
+func (d *document) funcStmt(s *parser.Symbol, parent *DocumentSymbol) *DocumentSymbol {
+	// The name follows the func keyword
+	off := s.Pos().Offset + len("func")
+	for off < len(d.text) && unicode.IsSpace(rune(d.text[off])) {
+		off++
+	}
+	d.define(off, s.Name).fn = s
+	d.refs = append(d.refs, &ref{off: off, name: s.Name, def: off})
+
+	end := s.End().Offset + 1
+	if end < off {
+		// The function is incomplete
+		end = off + len(s.Name)
+	}
+	ds := &DocumentSymbol{
+		Name:           s.Name,
+		Detail:         d.signature(s),
+		Kind:           SymbolKindFunction,
+		Range:          Range{d.position(s.Pos().Offset), d.position(end)},
+		SelectionRange: d.rangeOf(off, len(s.Name)),
+	}
+	if parent != nil {
+		parent.Children = append(parent.Children, ds)
+	} else {
+		d.funcs = append(d.funcs, ds)
+	}
+	return ds
+}
+
+// Register the field name fld of the object ob, if fld is an identifier.
+func (d *document) field(ob interface{}, fld interface{}) bool {
+	o, ok := ob.(*parser.Symbol)
+	if !ok {
+		return false
+	}
+	f, ok := fld.(*parser.Symbol)
+	if !ok || f.Ar != parser.ArLiteral {
+		return false
+	}
+	nm, ok := f.Val.(string)
+	if !ok || !isIdent(nm) {
+		return false
+	}
+	d.refs = append(d.refs, &ref{off: f.Pos().Offset, name: nm, def: -1, obj: o})
+	return true
+}
+
+// Get the signature of the func statement s, i.e. `func Add(x, y)`.
+func (d *document) signature(s *parser.Symbol) string {
+	var prms []string
+	list, _ := s.First.([]*parser.Symbol)
+	for _, prm := range list {
+		prms = append(prms, prm.Val.(string))
+	}
+	return "func " + s.Name + "(" + strings.Join(prms, ", ") + ")"
+}
+
+// Find the name at the byte offset off, or nil if there is none.
+func (d *document) refAt(off int) *ref {
+	i := sort.Search(len(d.refs), func(i int) bool {
+		return d.refs[i].off+len(d.refs[i].name) >= off
+	})
+	if i < len(d.refs) && d.refs[i].contains(off) {
+		return d.refs[i]
+	}
+	return nil
+}
+
+// Get the ID of the module held by the variable ob, if any.
+func (d *document) moduleOf(ob *parser.Symbol) string {
+	if ob.Id != "(name)" || ob.Def() == nil {
+		return ""
+	}
+	if def, ok := d.defs[ob.Def().Pos().Offset]; ok {
+		return def.module
+	}
+	return ""
+}
+
+// Get the ID of the module held by the variable name, the last one defined
+// before the byte offset off.
+func (d *document) moduleNamed(name string, off int) string {
+	var last *definition
+	for _, def := range d.defs {
+		if def.name == name && def.module != "" && def.off < off && (last == nil || def.off > last.off) {
+			last = def
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return last.module
+}
+
+// Get the source code line that holds the byte offset off, without the
+// leading and trailing spaces.
+func (d *document) lineAt(off int) string {
+	p := d.position(off)
+	end := len(d.text)
+	if p.Line+1 < len(d.lines) {
+		end = d.lines[p.Line+1]
+	}
+	return strings.TrimSpace(d.text[d.lines[p.Line]:end])
+}
+
+// Convert the byte offset off to a position.
+func (d *document) position(off int) Position {
+	if off > len(d.text) {
+		off = len(d.text)
+	}
+	if off < 0 {
+		off = 0
+	}
+	l := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > off
+	}) - 1
+	n := 0
+	for _, r := range d.text[d.lines[l]:off] {
+		n += utf16Len(r)
+	}
+	return Position{l, n}
+}
+
+// Convert the position p to a byte offset.
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	off := d.lines[p.Line]
+	for n := 0; n < p.Character && off < len(d.text); {
+		r, sz := utf8.DecodeRuneInString(d.text[off:])
+		if r == '\n' {
+			break
+		}
+		n += utf16Len(r)
+		off += sz
+	}
+	return off
+}
+
+// Get the range of n bytes at the byte offset off.
+func (d *document) rangeOf(off, n int) Range {
+	return Range{d.position(off), d.position(off + n)}
+}
+
+// Get the range of the word at the byte offset off, or of the character if it
+// is not in a word, so that the position of an error is visible.
+func (d *document) wordRange(off int) Range {
+	end := off
+	for end < len(d.text) {
+		r, sz := utf8.DecodeRuneInString(d.text[end:])
+		if !isIdentRune(r) {
+			break
+		}
+		end += sz
+	}
+	if end == off && end < len(d.text) && d.text[end] != '\n' {
+		_, sz := utf8.DecodeRuneInString(d.text[end:])
+		end += sz
+	}
+	return Range{d.position(off), d.position(end)}
+}
+
+// Get the location of n bytes at the byte offset off.
+func (d *document) location(off, n int) *Location {
+	return &Location{d.uri, d.rangeOf(off, n)}
+}
+
+// Returns the number of UTF-16 code units of r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// Returns true if r may be part of an identifier.
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Returns true if s is an identifier.
+func isIdent(s string) bool {
+	for i, r := range s {
+		if !isIdentRune(r) || (i == 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Returns the ID of the module imported by v, if it is a call to import with a
+// string literal.
+func importedModule(v interface{}) (string, bool) {
+	s, ok := v.(*parser.Symbol)
+	if !ok || s.Id != "(" || s.Ar != parser.ArBinary {
+		return "", false
+	}
+	fn, ok1 := s.First.(*parser.Symbol)
+	args, ok2 := s.Second.([]*parser.Symbol)
+	if !ok1 || !ok2 || fn.Id != "import" || !fn.IsGlobal() || len(args) != 1 || args[0].Id != "(literal)" {
+		return "", false
+	}
+	id, err := strconv.Unquote(args[0].Val.(string))
+	return id, err == nil
+}
+
+// Sort the names by offset.
+type byOffset []*ref
+
+func (b byOffset) Len() int           { return len(b) }
+func (b byOffset) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byOffset) Less(i, j int) bool { return b[i].off < b[j].off }
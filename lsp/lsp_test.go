@@ -0,0 +1,328 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	testURI = "file:///tmp/test.agora"
+	testSrc = `fmt := import("fmt")
+
+func Add(a, b) {
+	return a + b
+}
+
+x := Add(1, 2)
+fmt.Println(x, len("abc"))
+
+func Outer() {
+	y := 1
+	func Inner() {
+	}
+	return Inner
+}
+return Add(x, 3)
+`
+)
+
+// A client sends the requests to the server and reads its responses and
+// notifications.
+type client struct {
+	in bytes.Buffer
+	id int
+}
+
+// Add the request or notification method with the parameters prm. It returns
+// the ID of the request, or 0 if notif is true.
+func (c *client) send(method string, prm interface{}, notif bool) int {
+	b, err := json.Marshal(prm)
+	if err != nil {
+		panic(err)
+	}
+	m := &message{Method: method, Params: b}
+	if !notif {
+		c.id++
+		id := json.RawMessage(fmt.Sprint(c.id))
+		m.ID = &id
+	}
+	if err := newConn(nil, &c.in).write(m); err != nil {
+		panic(err)
+	}
+	if notif {
+		return 0
+	}
+	return c.id
+}
+
+// Execute the server with the requests, and return the messages it sent.
+func (c *client) run(t *testing.T, s *Server) []*message {
+	c.send("shutdown", nil, false)
+	c.send("exit", nil, true)
+	out := bytes.NewBuffer(nil)
+	if err := s.Serve(context.Background(), &c.in, out); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*message
+	cn := newConn(out, nil)
+	for {
+		m, err := cn.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Find the response to the request id in msgs, and decode its result into v.
+func result(t *testing.T, msgs []*message, id int, v interface{}) {
+	for _, m := range msgs {
+		if m.ID == nil || string(*m.ID) != fmt.Sprint(id) {
+			continue
+		}
+		if m.Error != nil {
+			t.Fatalf("request %d: %s", id, m.Error)
+		}
+		// A null result is decoded as a nil raw message
+		if m.Result == nil {
+			return
+		}
+		if err := json.Unmarshal(*m.Result, v); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Fatalf("no response to request %d", id)
+}
+
+func position(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     Position{line, char},
+	}
+}
+
+func open(c *client, uri, src string) {
+	c.send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": textDocumentItem{URI: uri, LanguageID: "agora", Version: 1, Text: src},
+	}, true)
+}
+
+func TestServer(t *testing.T) {
+	c := new(client)
+	initID := c.send("initialize", map[string]interface{}{}, false)
+	c.send("initialized", map[string]interface{}{}, true)
+	open(c, testURI, testSrc)
+	defID := c.send("textDocument/definition", position(testURI, 3, 8), false)
+	refs := position(testURI, 2, 6)
+	refs["context"] = map[string]bool{"includeDeclaration": true}
+	refsID := c.send("textDocument/references", refs, false)
+	refs = position(testURI, 6, 0)
+	refs["context"] = map[string]bool{"includeDeclaration": false}
+	refsNoDeclID := c.send("textDocument/references", refs, false)
+	hoverFnID := c.send("textDocument/hover", position(testURI, 7, 6), false)
+	hoverBuiltinID := c.send("textDocument/hover", position(testURI, 7, 16), false)
+	hoverVarID := c.send("textDocument/hover", position(testURI, 15, 12), false)
+	hoverNoneID := c.send("textDocument/hover", position(testURI, 1, 0), false)
+	symID := c.send("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	}, false)
+	unknownID := c.send("textDocument/unknown", nil, false)
+	msgs := c.run(t, new(Server))
+
+	var init initializeResult
+	result(t, msgs, initID, &init)
+	if !init.Capabilities.DefinitionProvider || init.ServerInfo.Name != "agora" {
+		t.Errorf("unexpected initialize result %+v", init)
+	}
+
+	// The diagnostics of the document are published when it is opened
+	var diags publishDiagnosticsParams
+	for _, m := range msgs {
+		if m.Method == "textDocument/publishDiagnostics" {
+			if err := json.Unmarshal(m.Params, &diags); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if len(diags.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d", len(diags.Diagnostics))
+	}
+	if d := diags.Diagnostics[0]; d.Severity != SeverityWarning || d.Code != "unused" || d.Range != (Range{Position{10, 1}, Position{10, 2}}) {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+
+	var loc Location
+	result(t, msgs, defID, &loc)
+	if exp := (Location{testURI, Range{Position{2, 9}, Position{2, 10}}}); loc != exp {
+		t.Errorf("definition: expected %v, got %v", exp, loc)
+	}
+
+	lines := func(locs []*Location) string {
+		var s []string
+		for _, l := range locs {
+			s = append(s, fmt.Sprintf("%d:%d", l.Range.Start.Line, l.Range.Start.Character))
+		}
+		return strings.Join(s, " ")
+	}
+	var locs []*Location
+	result(t, msgs, refsID, &locs)
+	if exp := "2:5 6:5 15:7"; lines(locs) != exp {
+		t.Errorf("references: expected %s, got %s", exp, lines(locs))
+	}
+	result(t, msgs, refsNoDeclID, &locs)
+	if exp := "7:12 15:11"; lines(locs) != exp {
+		t.Errorf("references without declaration: expected %s, got %s", exp, lines(locs))
+	}
+
+	hovers := []struct {
+		id  int
+		exp string
+	}{
+		{hoverFnID, "```agora\nfmt.Println(vals...)\n```\n\nPrints the vals to stdout, then prints a newline."},
+		{hoverBuiltinID, "```agora\nlen(val)\n```\n\nTakes a single value as argument."},
+		{hoverVarID, "```agora\nx := Add(1, 2)\n```\n"},
+	}
+	for _, h := range hovers {
+		var hv Hover
+		result(t, msgs, h.id, &hv)
+		if !strings.HasPrefix(hv.Contents.Value, h.exp) {
+			t.Errorf("hover: expected %q, got %q", h.exp, hv.Contents.Value)
+		}
+	}
+	var hv *Hover
+	result(t, msgs, hoverNoneID, &hv)
+	if hv != nil {
+		t.Errorf("hover: expected nil, got %+v", hv)
+	}
+
+	var syms []*DocumentSymbol
+	result(t, msgs, symID, &syms)
+	if len(syms) != 2 || syms[0].Name != "Add" || syms[0].Detail != "func Add(a, b)" || syms[1].Name != "Outer" ||
+		len(syms[1].Children) != 1 || syms[1].Children[0].Name != "Inner" {
+		t.Errorf("unexpected document symbols %+v", syms)
+	}
+	if exp := (Range{Position{9, 0}, Position{14, 1}}); syms[1].Range != exp {
+		t.Errorf("document symbol: expected range %v, got %v", exp, syms[1].Range)
+	}
+
+	for _, m := range msgs {
+		if m.ID != nil && string(*m.ID) == fmt.Sprint(unknownID) {
+			if m.Error == nil || m.Error.Code != codeMethodNotFound {
+				t.Errorf("expected a method not found error, got %+v", m.Error)
+			}
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	const uri = "file:///tmp/completion.agora"
+	c := new(client)
+	open(c, uri, "fmt := import(\"fmt\")\nx := 3\nfmt.Pri\n")
+	c.send("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]string{"uri": uri},
+		"contentChanges": []map[string]string{{"text": "fmt := import(\"fmt\")\nx := 3\nfmt.\n"}},
+	}, true)
+	modID := c.send("textDocument/completion", position(uri, 2, 4), false)
+	varID := c.send("textDocument/completion", position(uri, 1, 3), false)
+	msgs := c.run(t, new(Server))
+
+	// The diagnostics are published on open and on change, with a parse error
+	n := 0
+	for _, m := range msgs {
+		if m.Method == "textDocument/publishDiagnostics" {
+			var diags publishDiagnosticsParams
+			if err := json.Unmarshal(m.Params, &diags); err != nil {
+				t.Fatal(err)
+			}
+			if len(diags.Diagnostics) == 0 || diags.Diagnostics[0].Severity != SeverityError {
+				t.Errorf("expected a parse error, got %+v", diags.Diagnostics)
+			}
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected 2 diagnostics notifications, got %d", n)
+	}
+
+	var items []*CompletionItem
+	result(t, msgs, modID, &items)
+	var labels []string
+	for _, it := range items {
+		labels = append(labels, it.Label)
+	}
+	if exp := "Print Println Scanint Scanln"; strings.Join(labels, " ") != exp {
+		t.Errorf("expected completion %s, got %s", exp, strings.Join(labels, " "))
+	}
+	if len(items) > 1 && (items[1].Kind != CompletionKindFunction || items[1].Detail != "fmt.Println(vals...)") {
+		t.Errorf("unexpected completion item %+v", items[1])
+	}
+	result(t, msgs, varID, &items)
+	if len(items) != 0 {
+		t.Errorf("expected no completion, got %d items", len(items))
+	}
+}
+
+func TestTruncatedSource(t *testing.T) {
+	const uri = "file:///tmp/truncated.agora"
+	c := new(client)
+	open(c, uri, "func Fib(n) {\n  if")
+	// Every prefix of the source code, as while it is being typed
+	for i := 1; i <= len(testSrc); i++ {
+		c.send("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]string{"uri": uri},
+			"contentChanges": []map[string]string{{"text": testSrc[:i]}},
+		}, true)
+	}
+	hoverID := c.send("textDocument/hover", position(uri, 7, 6), false)
+	msgs := c.run(t, new(Server))
+
+	n := 0
+	for _, m := range msgs {
+		if m.Method == "textDocument/publishDiagnostics" {
+			n++
+		}
+	}
+	if n != len(testSrc)+1 {
+		t.Errorf("expected %d diagnostics notifications, got %d", len(testSrc)+1, n)
+	}
+	var h Hover
+	result(t, msgs, hoverID, &h)
+	if !strings.Contains(h.Contents.Value, "fmt.Println") {
+		t.Errorf("expected the hover of fmt.Println, got %q", h.Contents.Value)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	d := newDocument(testURI, "a := \"é𝄞\"\nb := a\n", nil)
+	cases := []struct {
+		off int
+		pos Position
+	}{
+		{0, Position{0, 0}},
+		{6, Position{0, 6}},
+		{8, Position{0, 7}},
+		{12, Position{0, 9}},
+		{13, Position{0, 10}},
+		{14, Position{1, 0}},
+		{19, Position{1, 5}},
+	}
+	for _, c := range cases {
+		if got := d.position(c.off); got != c.pos {
+			t.Errorf("%d: expected position %v, got %v", c.off, c.pos, got)
+		}
+		if got := d.offset(c.pos); got != c.off {
+			t.Errorf("%v: expected offset %d, got %d", c.pos, c.off, got)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// The types of the Language Server Protocol used by the server, a subset of
+// the specification, see
+// https://microsoft.github.io/language-server-protocol/specification.
+
+// A Position is a zero-based line and character offset in a document, the
+// character offset being counted in UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// A Range is a range in a document, the end position is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// A Location is a range in a document identified by its URI.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// The severities of the diagnostics.
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+// A Diagnostic is an error or a warning in a document.
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// The kinds of the symbols and of the completion items.
+const (
+	SymbolKindFunction = 12
+
+	CompletionKindFunction = 3
+	CompletionKindField    = 5
+)
+
+// A DocumentSymbol is a function of a document, with the functions it
+// defines.
+type DocumentSymbol struct {
+	Name           string            `json:"name"`
+	Detail         string            `json:"detail,omitempty"`
+	Kind           int               `json:"kind"`
+	Range          Range             `json:"range"`
+	SelectionRange Range             `json:"selectionRange"`
+	Children       []*DocumentSymbol `json:"children,omitempty"`
+}
+
+// A CompletionItem is a proposed completion.
+type CompletionItem struct {
+	Label         string         `json:"label"`
+	Kind          int            `json:"kind"`
+	Detail        string         `json:"detail,omitempty"`
+	Documentation *MarkupContent `json:"documentation,omitempty"`
+}
+
+// A MarkupContent is a text in the markdown format.
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// A Hover is the information displayed for the symbol under the cursor.
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+// The result of the initialize request.
+type (
+	initializeResult struct {
+		Capabilities serverCapabilities `json:"capabilities"`
+		ServerInfo   serverInfo         `json:"serverInfo"`
+	}
+
+	serverCapabilities struct {
+		TextDocumentSync       int                `json:"textDocumentSync"`
+		DefinitionProvider     bool               `json:"definitionProvider"`
+		ReferencesProvider     bool               `json:"referencesProvider"`
+		HoverProvider          bool               `json:"hoverProvider"`
+		DocumentSymbolProvider bool               `json:"documentSymbolProvider"`
+		CompletionProvider     completionProvider `json:"completionProvider"`
+	}
+
+	completionProvider struct {
+		TriggerCharacters []string `json:"triggerCharacters"`
+	}
+
+	serverInfo struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+)
+
+// The full content of the document is sent on each change.
+const textDocumentSyncFull = 1
+
+// The parameters of the requests and notifications.
+type (
+	textDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+
+	textDocumentItem struct {
+		URI        string `json:"uri"`
+		LanguageID string `json:"languageId"`
+		Version    int    `json:"version"`
+		Text       string `json:"text"`
+	}
+
+	didOpenParams struct {
+		TextDocument textDocumentItem `json:"textDocument"`
+	}
+
+	didChangeParams struct {
+		TextDocument   textDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Range *Range `json:"range"`
+			Text  string `json:"text"`
+		} `json:"contentChanges"`
+	}
+
+	didCloseParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	textDocumentPositionParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+		Position     Position               `json:"position"`
+	}
+
+	referenceParams struct {
+		textDocumentPositionParams
+		Context struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+	}
+
+	documentSymbolParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	publishDiagnosticsParams struct {
+		URI         string        `json:"uri"`
+		Diagnostics []*Diagnostic `json:"diagnostics"`
+	}
+)
+
+// The JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// A message is a JSON-RPC request, response or notification. A request has
+// an ID and a method, a notification has only a method, and a response has
+// only an ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+// An rpcError is the error of a JSON-RPC response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error interface implementation.
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// A conn reads and writes the JSON-RPC messages, each message being preceded
+// by a header with its Content-Length.
+type conn struct {
+	r *textproto.Reader
+	w io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{textproto.NewReader(bufio.NewReader(r)), w}
+}
+
+// Read the next message. It returns io.EOF if there are no more messages.
+func (c *conn) read() (*message, error) {
+	h, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(h) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(h.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", h.Get("Content-Length"))
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, b); err != nil {
+		return nil, err
+	}
+	m := new(message)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, &rpcError{codeParseError, err.Error()}
+	}
+	return m, nil
+}
+
+// Write the message m.
+func (c *conn) write(m *message) error {
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(b)
+	return err
+}
@@ -0,0 +1,453 @@
+// Package lsp implements a Language Server Protocol server for agora source
+// code, as done by the `agora lsp` command, so that editors can provide:
+//
+//   - diagnostics: the parse errors of the source code, and the likely bugs
+//     reported by the compiler/vet package if the source code is valid.
+//   - go to definition and find references of the variables, parameters and
+//     functions, as resolved by the scopes of the parser.
+//   - hover: the documentation of the built-in functions and of the members of
+//     the stdlib modules, and the definition of the variables.
+//   - document symbols: the func statements.
+//   - completion: the members of the native modules, after `name.` where name
+//     is a variable that holds an imported native module.
+//
+// The server communicates with JSON-RPC messages over a reader and a writer,
+// usually the standard input and output of the process. The documents are
+// synchronized in full on each change.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bobg/agora/agoratest"
+	"github.com/bobg/agora/bytecode"
+	"github.com/bobg/agora/compiler"
+	"github.com/bobg/agora/compiler/parser"
+	"github.com/bobg/agora/runtime"
+	"github.com/bobg/agora/runtime/stdlib"
+)
+
+// The prefix of the source code before the cursor that requests the
+// completion of a module member, i.e. `fmt.Pri`.
+var memberRx = regexp.MustCompile(`([A-Za-z_][A-Za-z_0-9]*)\.[A-Za-z_0-9]*$`)
+
+// A Server is a language server for agora source code.
+type Server struct {
+	// Globals are the names of the global values defined by the host program,
+	// in addition to the built-in functions, so that they are not reported as
+	// undefined.
+	Globals []string
+
+	// Setup is called with the execution context that provides the members of
+	// the native modules, to register the modules. If it is nil, the stdlib
+	// modules and the assert module of the test files are registered.
+	Setup func(*runtime.Kontext)
+
+	conn     *conn
+	docs     map[string]*document
+	ktx      *runtime.Kontext
+	members  map[string][]*CompletionItem // the members of the native modules, by module ID
+	shutdown bool
+}
+
+// Serve reads the requests and notifications of the client from r, and writes
+// the responses and notifications of the server to w, until the client sends
+// the exit notification or r is closed. It returns an error if the client
+// exits without requesting the shutdown of the server first.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.conn = newConn(r, w)
+	s.docs = make(map[string]*document)
+	s.members = make(map[string][]*CompletionItem)
+	s.shutdown = false
+	s.ktx = runtime.NewKtx(noResolver{}, new(compiler.Compiler))
+	if s.Setup != nil {
+		s.Setup(s.ktx)
+	} else {
+		s.ktx.RegisterNativeModule(new(stdlib.FilepathMod))
+		s.ktx.RegisterNativeModule(new(stdlib.FmtMod))
+		s.ktx.RegisterNativeModule(new(stdlib.MathMod))
+		s.ktx.RegisterNativeModule(new(stdlib.OsMod))
+		s.ktx.RegisterNativeModule(new(stdlib.StringsMod))
+		s.ktx.RegisterNativeModule(new(stdlib.TimeMod))
+		s.ktx.RegisterNativeModule(new(agoratest.AssertMod))
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*rpcError); ok {
+			// Invalid JSON, the ID of the request is unknown
+			null := json.RawMessage("null")
+			if err := s.conn.write(&message{ID: &null, Error: e}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+
+		res, err := s.handle(ctx, m)
+		if m.ID == nil {
+			// A notification has no response, even if it fails
+			continue
+		}
+		resp := &message{ID: m.ID}
+		if err != nil {
+			e, ok := err.(*rpcError)
+			if !ok {
+				e = &rpcError{codeInvalidRequest, err.Error()}
+			}
+			resp.Error = e
+		} else {
+			b, err := json.Marshal(res)
+			if err != nil {
+				return err
+			}
+			raw := json.RawMessage(b)
+			resp.Result = &raw
+		}
+		if err := s.conn.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// Handle the request or notification m, and return the result of the
+// request.
+func (s *Server) handle(ctx context.Context, m *message) (interface{}, error) {
+	if s.shutdown {
+		return nil, &rpcError{codeInvalidRequest, "the server is shut down"}
+	}
+	switch m.Method {
+	case "initialize":
+		maj, min := bytecode.Version()
+		return &initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:       textDocumentSyncFull,
+				DefinitionProvider:     true,
+				ReferencesProvider:     true,
+				HoverProvider:          true,
+				DocumentSymbolProvider: true,
+				CompletionProvider: completionProvider{
+					TriggerCharacters: []string{"."},
+				},
+			},
+			ServerInfo: serverInfo{
+				Name:    "agora",
+				Version: fmt.Sprintf("%d.%d", maj, min),
+			},
+		}, nil
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var prm didOpenParams
+		if err := unmarshal(m.Params, &prm); err != nil {
+			return nil, err
+		}
+		return nil, s.update(prm.TextDocument.URI, prm.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var prm didChangeParams
+		if err := unmarshal(m.Params, &prm); err != nil {
+			return nil, err
+		}
+		if n := len(prm.ContentChanges); n > 0 {
+			return nil, s.update(prm.TextDocument.URI, prm.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var prm didCloseParams
+		if err := unmarshal(m.Params, &prm); err != nil {
+			return nil, err
+		}
+		delete(s.docs, prm.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+			URI:         prm.TextDocument.URI,
+			Diagnostics: []*Diagnostic{},
+		})
+
+	case "textDocument/definition":
+		d, off, err := s.position(m.Params)
+		if d == nil || err != nil {
+			return nil, err
+		}
+		if r := d.refAt(off); r != nil && r.def >= 0 {
+			return d.location(r.def, len(r.name)), nil
+		}
+		return nil, nil
+
+	case "textDocument/references":
+		var prm referenceParams
+		if err := unmarshal(m.Params, &prm); err != nil {
+			return nil, err
+		}
+		d, ok := s.docs[prm.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+		return d.references(d.offset(prm.Position), prm.Context.IncludeDeclaration), nil
+
+	case "textDocument/hover":
+		d, off, err := s.position(m.Params)
+		if d == nil || err != nil {
+			return nil, err
+		}
+		return d.hover(off), nil
+
+	case "textDocument/documentSymbol":
+		var prm documentSymbolParams
+		if err := unmarshal(m.Params, &prm); err != nil {
+			return nil, err
+		}
+		d, ok := s.docs[prm.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+		if d.funcs == nil {
+			return []*DocumentSymbol{}, nil
+		}
+		return d.funcs, nil
+
+	case "textDocument/completion":
+		d, off, err := s.position(m.Params)
+		if d == nil || err != nil {
+			return nil, err
+		}
+		return s.completion(ctx, d, off), nil
+	}
+
+	if m.ID == nil {
+		// Unknown notifications, i.e. `$/cancelRequest`, are ignored
+		return nil, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not found: " + m.Method}
+}
+
+// Analyze the document uri with the source code text, and publish its
+// diagnostics.
+func (s *Server) update(uri, text string) error {
+	var globals []string
+	if len(s.Globals) > 0 {
+		globals = append(append(globals, parser.DefaultGlobals...), s.Globals...)
+	}
+	d := newDocument(uri, text, globals)
+	s.docs[uri] = d
+	diags := d.diags
+	if diags == nil {
+		diags = []*Diagnostic{}
+	}
+	return s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diags,
+	})
+}
+
+// Send the notification method with the parameters prm.
+func (s *Server) notify(method string, prm interface{}) error {
+	b, err := json.Marshal(prm)
+	if err != nil {
+		return err
+	}
+	return s.conn.write(&message{Method: method, Params: b})
+}
+
+// Get the document and the byte offset of the position of the request
+// parameters raw. The document is nil if it is not open.
+func (s *Server) position(raw json.RawMessage) (*document, int, error) {
+	var prm textDocumentPositionParams
+	if err := unmarshal(raw, &prm); err != nil {
+		return nil, 0, err
+	}
+	d, ok := s.docs[prm.TextDocument.URI]
+	if !ok {
+		return nil, 0, nil
+	}
+	return d, d.offset(prm.Position), nil
+}
+
+// Get the completion items at the byte offset off of the document d.
+func (s *Server) completion(ctx context.Context, d *document, off int) []*CompletionItem {
+	line := d.text[d.lines[d.position(off).Line]:off]
+	m := memberRx.FindStringSubmatch(line)
+	if m == nil {
+		return []*CompletionItem{}
+	}
+	id := d.moduleNamed(m[1], off)
+	if id == "" {
+		return []*CompletionItem{}
+	}
+	return s.moduleMembers(ctx, id)
+}
+
+// Get the members of the native module id, as completion items.
+func (s *Server) moduleMembers(ctx context.Context, id string) []*CompletionItem {
+	if items, ok := s.members[id]; ok {
+		return items
+	}
+	items := []*CompletionItem{}
+	if m, err := s.ktx.Load(id); err == nil {
+		if v, err := m.Run(ctx); err == nil {
+			if ob, ok := v.(runtime.Object); ok {
+				items = members(ctx, id, ob)
+			}
+		}
+	}
+	s.members[id] = items
+	return items
+}
+
+// Get the members of the object ob, the value of the module id, as completion
+// items, in alphabetical order.
+func members(ctx context.Context, id string, ob runtime.Object) []*CompletionItem {
+	items := []*CompletionItem{}
+	ks := ob.Keys(ctx).(runtime.Object)
+	for i, n := int64(0), ks.Len(ctx).Int(ctx); i < n; i++ {
+		k := ks.Get(runtime.Number(i))
+		nm, ok := k.(runtime.String)
+		if !ok || strings.HasPrefix(string(nm), "__") {
+			continue
+		}
+		it := &CompletionItem{
+			Label: string(nm),
+			Kind:  CompletionKindField,
+		}
+		if _, ok := ob.Get(k).(runtime.Func); ok {
+			it.Kind = CompletionKindFunction
+		}
+		if dc, ok := memberDocs[id+"."+string(nm)]; ok {
+			it.Detail = dc.sig
+			it.Documentation = &MarkupContent{"markdown", dc.text}
+		}
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// Get the locations of the names that refer to the same definition, or to
+// the same global value, as the name at the byte offset off. The definition
+// itself is included if decl is true.
+func (d *document) references(off int, decl bool) []*Location {
+	locs := []*Location{}
+	r := d.refAt(off)
+	if r == nil || (r.def < 0 && !r.global) {
+		return locs
+	}
+	for _, r2 := range d.refs {
+		if r.def >= 0 && r2.def != r.def {
+			continue
+		}
+		if r.global && (!r2.global || r2.name != r.name) {
+			continue
+		}
+		if r2.off == r2.def && !decl {
+			continue
+		}
+		locs = append(locs, d.location(r2.off, len(r2.name)))
+	}
+	return locs
+}
+
+// Get the hover information of the name at the byte offset off, or nil if
+// there is none.
+func (d *document) hover(off int) *Hover {
+	r := d.refAt(off)
+	if r == nil {
+		return nil
+	}
+	var md string
+	switch {
+	case r.obj != nil:
+		dc, ok := memberDocs[d.moduleOf(r.obj)+"."+r.name]
+		if !ok {
+			return nil
+		}
+		md = dc.markdown()
+	case r.global:
+		dc, ok := builtinDocs[r.name]
+		if !ok {
+			dc = doc{r.name, "A global value defined by the host program."}
+		}
+		md = dc.markdown()
+	case r.def >= 0:
+		def, ok := d.defs[r.def]
+		if !ok {
+			return nil
+		}
+		if def.fn != nil {
+			md = codeBlock(d.signature(def.fn))
+		} else {
+			md = codeBlock(d.lineAt(def.off))
+			if txt, ok := moduleDocs[def.module]; ok {
+				md += "\n" + txt
+			}
+		}
+	default:
+		return nil
+	}
+	rng := d.rangeOf(r.off, len(r.name))
+	return &Hover{MarkupContent{"markdown", md}, &rng}
+}
+
+// Get the markdown representation of the documentation.
+func (d doc) markdown() string {
+	return codeBlock(d.sig) + "\n" + d.text
+}
+
+// Get the markdown code block of the agora source code src.
+func codeBlock(src string) string {
+	return "```agora\n" + src + "\n```\n"
+}
+
+// Decode the parameters raw into v.
+func unmarshal(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// Get the file name of the document uri, for the positions of the errors.
+func filename(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// A noResolver does not resolve any module, so that only the native modules
+// can be loaded.
+type noResolver struct{}
+
+func (noResolver) Resolve(id string) (io.Reader, error) {
+	return nil, runtime.NewModuleNotFoundError(id)
+}
@@ -0,0 +1,258 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// An array is an object optimized for dense, 0-based integer keys. The values
+// stored at keys 0 to n-1 are kept in a slice, so that no hashing is required
+// to get or set them. Any other key is stored in an overflow object, created
+// on demand, so that an array behaves exactly like any other object.
+type array struct {
+	s     []Val
+	o     *object
+	proto Object
+}
+
+// NewArray returns a new array-like object, with the provided values set at
+// keys 0 to len(vals) - 1.
+func NewArray(vals ...Val) Object {
+	a := &array{
+		s: make([]Val, 0, len(vals)),
+	}
+	for i, v := range vals {
+		a.Set(Number(i), v)
+	}
+	return a
+}
+
+// Get the slice index corresponding to the key, if it is a non-negative integer
+// number. It doesn't check if the index is within the bounds of the slice.
+func arrayIndex(key Val) (int, bool) {
+	if n, ok := key.(Number); ok {
+		if i := int(n); i >= 0 && float64(i) == float64(n) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Dump pretty-prints the content of the array.
+func (a *array) Dump() string {
+	buf := bytes.NewBuffer(nil)
+	for _, v := range a.s {
+		buf.WriteString(fmt.Sprintf(" %s, ", dumpVal(v)))
+	}
+	if a.o != nil {
+		for k, v := range a.o.m {
+			buf.WriteString(fmt.Sprintf(" %s: %s, ", dumpVal(k), dumpVal(v)))
+		}
+	}
+	return fmt.Sprintf("[%s] (Array)", buf)
+}
+
+func (a *array) callMetaMethod(ctx context.Context, nm string, args ...Val) (Val, bool) {
+	if f, ok := a.Get(String(nm)).(Func); ok {
+		return f.Call(ctx, a, args...), true
+	}
+	return nil, false
+}
+
+// Int returns the integer value of the array. Such behaviour can be defined
+// if a `__int` method is available on the array.
+func (a *array) Int(ctx context.Context) int64 {
+	if v, ok := a.callMetaMethod(ctx, "__int"); ok {
+		return v.Int(ctx)
+	}
+	panic(NewTypeError(Type(a), "", "int"))
+}
+
+// Float returns the float value of the array. Such behaviour can be defined
+// if a `__float` method is available on the array.
+func (a *array) Float(ctx context.Context) float64 {
+	if v, ok := a.callMetaMethod(ctx, "__float"); ok {
+		return v.Float(ctx)
+	}
+	panic(NewTypeError(Type(a), "", "float"))
+}
+
+// String returns the string value of the array. Such behaviour can be overridden
+// if a `__string` method is available on the array. Otherwise it prints the
+// content of the array the same way an object does.
+func (a *array) String(ctx context.Context) string {
+	if v, ok := a.callMetaMethod(ctx, "__string"); ok {
+		return v.String(ctx)
+	}
+	return objectString(ctx, a)
+}
+
+// Bool returns the boolean value of the array. Such behaviour can be defined
+// if a `__bool` method is available on the array. Otherwise it returns true.
+func (a *array) Bool(ctx context.Context) bool {
+	if v, ok := a.callMetaMethod(ctx, "__bool"); ok {
+		return v.Bool(ctx)
+	}
+	return true
+}
+
+// Native returns the Go native value of the array. Such behaviour can be defined
+// if a `__native` method is available on the array. Otherwise, it returns the
+// internal slice of values if the array only holds dense integer keys, or a
+// map of all keys and values if it doesn't.
+func (a *array) Native(ctx context.Context) interface{} {
+	if v, ok := a.callMetaMethod(ctx, "__native"); ok {
+		return v.Native(ctx)
+	}
+	if a.o == nil || len(a.o.m) == 0 {
+		return a.s
+	}
+	m := make(map[Val]Val, len(a.s)+len(a.o.m))
+	for i, v := range a.s {
+		m[Number(i)] = v
+	}
+	for k, v := range a.o.m {
+		m[k] = v
+	}
+	return m
+}
+
+// Len returns the number of keys of the array. The behaviour can be overridden
+// if a `__len` method is available on the array.
+func (a *array) Len(ctx context.Context) Val {
+	if v, ok := a.callMetaMethod(ctx, "__len"); ok {
+		return v
+	}
+	if a.o == nil {
+		return Number(len(a.s))
+	}
+	return Number(len(a.s) + len(a.o.m))
+}
+
+// Keys returns the keys of the array in an array value. The dense integer
+// keys come first, in ascending order, followed by the other keys, unordered.
+// The behaviour can be overridden if a `__keys` method is available on the array.
+func (a *array) Keys(ctx context.Context) Val {
+	if v, ok := a.callMetaMethod(ctx, "__keys"); ok {
+		return v
+	}
+	n := len(a.s)
+	if a.o != nil {
+		n += len(a.o.m)
+	}
+	ks := &array{
+		s: make([]Val, 0, n),
+	}
+	for i := range a.s {
+		ks.s = append(ks.s, Number(i))
+	}
+	if a.o != nil {
+		for k := range a.o.m {
+			ks.s = append(ks.s, k)
+		}
+	}
+	return ks
+}
+
+// Get returns the value of the field identified by key. If the field does not
+// exist, it is looked up in the prototype chain, and Nil is returned if it
+// isn't found.
+func (a *array) Get(key Val) Val {
+	if i, ok := arrayIndex(key); ok && i < len(a.s) {
+		return a.s[i]
+	}
+	if a.o != nil {
+		if v, ok := a.o.m[key]; ok {
+			return v
+		}
+	}
+	if a.proto != nil {
+		return a.proto.Get(key)
+	}
+	return Nil
+}
+
+// Proto returns the prototype of the array, or nil if it has none.
+func (a *array) Proto() Object {
+	return a.proto
+}
+
+// SetProto sets the prototype of the array. A nil prototype removes it.
+func (a *array) SetProto(p Object) {
+	checkProtoCycle(a, p)
+	a.proto = p
+}
+
+// Set assigns the value v to the field identified by key. Like for any object,
+// setting a Nil value removes the key from the array.
+func (a *array) Set(key Val, v Val) {
+	if i, ok := arrayIndex(key); ok && i <= len(a.s) {
+		switch {
+		case v == Nil:
+			if i < len(a.s) {
+				a.truncate(i)
+			}
+		case i == len(a.s):
+			a.s = append(a.s, v)
+			a.migrate()
+		default:
+			a.s[i] = v
+		}
+		return
+	}
+	if a.o == nil {
+		if v == Nil {
+			return
+		}
+		a.o = &object{m: make(map[Val]Val)}
+	}
+	a.o.Set(key, v)
+}
+
+// Remove the value at index i from the dense part of the array. The values
+// following it no longer have contiguous keys, so they move to the overflow object.
+func (a *array) truncate(i int) {
+	if i+1 < len(a.s) && a.o == nil {
+		a.o = &object{m: make(map[Val]Val, len(a.s)-i-1)}
+	}
+	for j := i + 1; j < len(a.s); j++ {
+		a.o.m[Number(j)] = a.s[j]
+	}
+	for j := i; j < len(a.s); j++ {
+		a.s[j] = nil // free this reference for gc
+	}
+	a.s = a.s[:i]
+}
+
+// Move the values from the overflow object to the dense part of the array,
+// as long as their keys are contiguous to the end of the slice.
+func (a *array) migrate() {
+	if a.o == nil {
+		return
+	}
+	for len(a.o.m) > 0 {
+		k := Number(len(a.s))
+		v, ok := a.o.m[k]
+		if !ok {
+			return
+		}
+		delete(a.o.m, k)
+		a.s = append(a.s, v)
+	}
+}
+
+// callMethod calls the method identified by nm with the provided arguments.
+// It panics if the field does not hold a function. If the field does not
+// exist and a method named `__noSuchMethod` is defined, it is called instead.
+func (a *array) callMethod(ctx context.Context, nm Val, args ...Val) Val {
+	if v := a.Get(nm); v != Nil {
+		if f, ok := v.(Func); ok {
+			return f.Call(ctx, a, args...)
+		}
+		panic(NewNoSuchMethodError(nm.String(ctx)))
+	} else if v, ok := a.callMetaMethod(ctx, "__noSuchMethod", append([]Val{nm}, args...)...); ok {
+		return v
+	}
+	panic(NewNoSuchMethodError(nm.String(ctx)))
+}
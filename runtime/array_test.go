@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArrayGetSet(t *testing.T) {
+	ctx := context.Background()
+	a := NewArray(Number(10), Bool(true), String("hi"))
+	if l := a.Len(ctx).Int(ctx); l != 3 {
+		t.Errorf("expected length %d, got %d", 3, l)
+	}
+	for i, exp := range []Val{Number(10), Bool(true), String("hi")} {
+		if v := a.Get(Number(i)); v != exp {
+			t.Errorf("[%d] - expected %s, got %s", i, dumpVal(exp), dumpVal(v))
+		}
+	}
+	// Non-index keys are stored too
+	a.Set(String("k"), Number(1))
+	a.Set(Number(1.5), Number(2))
+	a.Set(Number(-1), Number(3))
+	if l := a.Len(ctx).Int(ctx); l != 6 {
+		t.Errorf("expected length %d, got %d", 6, l)
+	}
+	if v := a.Get(String("k")); v != Number(1) {
+		t.Errorf("expected %s, got %s", dumpVal(Number(1)), dumpVal(v))
+	}
+	if v := a.Get(Number(1.5)); v != Number(2) {
+		t.Errorf("expected %s, got %s", dumpVal(Number(2)), dumpVal(v))
+	}
+	if v := a.Get(Number(10)); v != Nil {
+		t.Errorf("expected %s, got %s", dumpVal(Nil), dumpVal(v))
+	}
+}
+
+func TestArraySparse(t *testing.T) {
+	ctx := context.Background()
+	a := NewArray()
+	a.Set(Number(2), String("c"))
+	a.Set(Number(0), String("a"))
+	if l := a.Len(ctx).Int(ctx); l != 2 {
+		t.Errorf("expected length %d, got %d", 2, l)
+	}
+	// Filling the gap makes the array dense again
+	a.Set(Number(1), String("b"))
+	arr := a.(*array)
+	if len(arr.s) != 3 || len(arr.o.m) != 0 {
+		t.Errorf("expected 3 dense values and no overflow, got %d and %d", len(arr.s), len(arr.o.m))
+	}
+	// Removing a value in the middle keeps the other keys
+	a.Set(Number(1), Nil)
+	if l := a.Len(ctx).Int(ctx); l != 2 {
+		t.Errorf("expected length %d, got %d", 2, l)
+	}
+	for i, exp := range []Val{String("a"), Nil, String("c")} {
+		if v := a.Get(Number(i)); v != exp {
+			t.Errorf("[%d] - expected %s, got %s", i, dumpVal(exp), dumpVal(v))
+		}
+	}
+}
+
+func TestArrayKeys(t *testing.T) {
+	ctx := context.Background()
+	a := NewArray(String("a"), String("b"))
+	a.Set(String("k"), Bool(true))
+	ks := a.Keys(ctx).(Object)
+	if l := ks.Len(ctx).Int(ctx); l != 3 {
+		t.Fatalf("expected %d keys, got %d", 3, l)
+	}
+	// Dense keys come first, in order
+	for i, exp := range []Val{Number(0), Number(1), String("k")} {
+		if v := ks.Get(Number(i)); v != exp {
+			t.Errorf("[%d] - expected key %s, got %s", i, dumpVal(exp), dumpVal(v))
+		}
+	}
+}
+
+func TestArrayMetaMethod(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+	a := NewArray(Number(1), Number(2))
+	a.Set(String("__string"), NewNativeFunc(ktx, "", func(_ context.Context, args ...Val) Val {
+		return String("array")
+	}))
+	if s := a.String(ctx); s != "array" {
+		t.Errorf("expected %s, got %s", "array", s)
+	}
+}
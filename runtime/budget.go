@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"fmt"
+)
+
+// A Budget identifies a limit of the resources used by the execution of a module.
+type Budget string
+
+const (
+	// The possible budgets
+	BudgetInstructions Budget = "instructions"
+	BudgetCallDepth    Budget = "call depth"
+	BudgetAllocBytes   Budget = "allocated bytes"
+)
+
+// The default maximum depth of the call stack, so that deep recursion does
+// not overflow the Go stack.
+const DefaultMaxCallDepth = 10000
+
+// The approximate number of bytes allocated for the values created by the VM.
+// Strings are counted by their length.
+const (
+	objectAllocSize = 64 // An empty object or array
+	fieldAllocSize  = 32 // A field of an object or an item of an array
+	funcAllocSize   = 64 // A function value (a closure)
+)
+
+// The BudgetExceededError is raised when the execution of a module exceeds one
+// of the budgets of the execution context.
+type BudgetExceededError struct {
+	Budget Budget // The budget that was exceeded
+	Limit  int64  // The limit of this budget
+}
+
+// Error interface implementation.
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget exceeded: %s limit of %d", e.Budget, e.Limit)
+}
+
+// Create a new BudgetExceededError.
+func NewBudgetExceededError(b Budget, limit int64) BudgetExceededError {
+	return BudgetExceededError{b, limit}
+}
+
+// Reset the instructions and allocations used, at the start of a new execution.
+func (c *Kontext) resetBudgets() {
+	c.instrs = 0
+	c.allocs = 0
+}
+
+// Count the execution of an instruction, panic if the instructions budget
+// is exceeded.
+func (c *Kontext) instr() {
+	c.instrs++
+	if c.instrs > c.MaxInstructions {
+		panic(NewBudgetExceededError(BudgetInstructions, c.MaxInstructions))
+	}
+}
+
+// Count the allocation of n bytes, panic if the allocations budget is exceeded.
+func (c *Kontext) alloc(n int64) {
+	if c.MaxAllocBytes <= 0 {
+		return
+	}
+	c.allocs += n
+	if c.allocs > c.MaxAllocBytes {
+		panic(NewBudgetExceededError(BudgetAllocBytes, c.MaxAllocBytes))
+	}
+}
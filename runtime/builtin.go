@@ -2,7 +2,6 @@ package runtime
 
 import (
 	"context"
-	"fmt"
 )
 
 type builtinMod struct {
@@ -29,6 +28,8 @@ func (b *builtinMod) Run(_ ...Val) (v Val, err error) {
 		b.ob.Set(String("type"), NewNativeFunc(b.ktx, "type", b._type))
 		b.ob.Set(String("status"), NewNativeFunc(b.ktx, "status", b._status))
 		b.ob.Set(String("reset"), NewNativeFunc(b.ktx, "reset", b._reset))
+		b.ob.Set(String("setproto"), NewNativeFunc(b.ktx, "setproto", b._setproto))
+		b.ob.Set(String("getproto"), NewNativeFunc(b.ktx, "getproto", b._getproto))
 	}
 	return b.ob, nil
 }
@@ -53,7 +54,12 @@ func (b *builtinMod) _import(ctx context.Context, args ...Val) Val {
 func (b *builtinMod) _panic(ctx context.Context, args ...Val) Val {
 	ExpectAtLeastNArgs(1, args)
 	if args[0].Bool(ctx) {
-		panic(args[0])
+		// The optional second argument is the cause of the error
+		var cause error
+		if len(args) > 1 && args[1] != Nil {
+			cause = b.ktx.newError(ctx, args[1], nil)
+		}
+		panic(b.ktx.newError(ctx, args[0], cause))
 	}
 	return Nil
 }
@@ -62,18 +68,11 @@ func (b *builtinMod) _recover(ctx context.Context, args ...Val) (ret Val) {
 	// Do not catch panics if args are invalid
 	ExpectAtLeastNArgs(1, args)
 	// Catch panics in running the function. Cannot use PanicToError, because
-	// it needs the true type of the panic'd value.
+	// it returns the *Error as a Go error, not as an agora value.
 	ret = Nil
 	defer func() {
 		if err := recover(); err != nil {
-			switch v := err.(type) {
-			case Val:
-				ret = v
-			case error:
-				ret = String(v.Error())
-			default:
-				ret = String(fmt.Sprintf("%v", v))
-			}
+			ret = b.ktx.newError(ctx, err, nil)
 		}
 	}()
 	// The value must be a function
@@ -153,3 +152,32 @@ func (b *builtinMod) _reset(_ context.Context, args ...Val) Val {
 	}
 	return Nil
 }
+
+func (b *builtinMod) _setproto(_ context.Context, args ...Val) Val {
+	ExpectAtLeastNArgs(2, args)
+	ob, ok := args[0].(Object)
+	if !ok {
+		panic(NewTypeError(Type(args[0]), "", "setproto"))
+	}
+	// A nil prototype removes the current prototype
+	var proto Object
+	if args[1] != Nil {
+		if proto, ok = args[1].(Object); !ok {
+			panic(NewTypeError(Type(args[1]), "", "setproto"))
+		}
+	}
+	ob.SetProto(proto)
+	return ob
+}
+
+func (b *builtinMod) _getproto(_ context.Context, args ...Val) Val {
+	ExpectAtLeastNArgs(1, args)
+	ob, ok := args[0].(Object)
+	if !ok {
+		panic(NewTypeError(Type(args[0]), "", "getproto"))
+	}
+	if proto := ob.Proto(); proto != nil {
+		return proto
+	}
+	return Nil
+}
@@ -124,11 +124,11 @@ lines`),
 		},
 		5: {
 			src: &object{
-				map[Val]Val{
+				m: map[Val]Val{
 					Number(1):      String("val1"),
 					String("name"): Bool(false),
 					String("subobj"): &object{
-						map[Val]Val{
+						m: map[Val]Val{
 							String("key"): Number(10),
 						},
 					},
@@ -188,7 +188,7 @@ func TestPanic(t *testing.T) {
 		},
 		5: {
 			src: &object{
-				map[Val]Val{
+				m: map[Val]Val{
 					String("__bool"): NewNativeFunc(ktx, "", func(_ context.Context, args ...Val) Val {
 						return Bool(false)
 					}),
@@ -222,7 +222,7 @@ func TestPanic(t *testing.T) {
 		},
 		12: {
 			src: &object{
-				map[Val]Val{
+				m: map[Val]Val{
 					String("__bool"): NewNativeFunc(ktx, "", func(_ context.Context, args ...Val) Val {
 						return Bool(true)
 					}),
@@ -303,8 +303,25 @@ func TestRecover(t *testing.T) {
 			return Nil
 		})
 		ret := bi._recover(ctx, f)
-		if c.exp != ret {
-			t.Errorf("[%d] - expected %v, got %v", i, c.exp, ret)
+		if c.exp == Nil {
+			if ret != Nil {
+				t.Errorf("[%d] - expected nil, got %v", i, ret)
+			}
+			continue
+		}
+		e, ok := ret.(*Error)
+		if !ok {
+			t.Errorf("[%d] - expected an *Error, got %T", i, ret)
+			continue
+		}
+		if e.Value != c.exp {
+			t.Errorf("[%d] - expected value %v, got %v", i, c.exp, e.Value)
+		}
+		if e.Message != c.exp.String(ctx) {
+			t.Errorf("[%d] - expected message %s, got %s", i, c.exp.String(ctx), e.Message)
+		}
+		if len(e.Frames) != 1 {
+			t.Errorf("[%d] - expected 1 frame, got %d", i, len(e.Frames))
 		}
 	}
 }
@@ -585,3 +602,64 @@ func TestConvType(t *testing.T) {
 		}
 	}
 }
+
+func TestProto(t *testing.T) {
+	ctx := context.Background()
+
+	ktx := NewKtx(nil, nil)
+	bm := new(builtinMod)
+	bm.SetKtx(ktx)
+
+	base := NewObject()
+	base.Set(String("a"), Number(1))
+	base.Set(String("__string"), NewNativeFunc(ktx, "", func(_ context.Context, args ...Val) Val { return String("base") }))
+	ob := NewObject()
+	ob.Set(String("b"), Number(2))
+	arr := NewArray(Number(3))
+
+	if ret := bm._getproto(ctx, ob); ret != Nil {
+		t.Errorf("expected no prototype, got %v", ret)
+	}
+	if ret := bm._setproto(ctx, ob, base); ret != ob {
+		t.Errorf("expected setproto to return the object, got %v", ret)
+	}
+	bm._setproto(ctx, arr, ob)
+	if ret := bm._getproto(ctx, arr); ret != ob {
+		t.Errorf("expected prototype %v, got %v", ob, ret)
+	}
+	// Lookups go up the prototype chain
+	if v := arr.Get(String("a")); v != Number(1) {
+		t.Errorf("expected inherited key a to be 1, got %v", v)
+	}
+	if v := arr.Get(Number(0)); v != Number(3) {
+		t.Errorf("expected own key 0 to be 3, got %v", v)
+	}
+	// So do meta-methods
+	if s := arr.String(ctx); s != "base" {
+		t.Errorf("expected inherited __string meta-method, got %s", s)
+	}
+	// Setting a key stays local
+	arr.Set(String("a"), Number(4))
+	if v := base.Get(String("a")); v != Number(1) {
+		t.Errorf("expected key a of prototype to stay 1, got %v", v)
+	}
+	if l := ob.Len(ctx).Int(ctx); l != 1 {
+		t.Errorf("expected length of own keys to be 1, got %d", l)
+	}
+	// Cycles are rejected
+	func() {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Errorf("expected a panic on a prototype cycle, got none")
+			} else if _, ok := err.(ProtoCycleError); !ok {
+				t.Errorf("expected a ProtoCycleError, got %T", err)
+			}
+		}()
+		bm._setproto(ctx, base, arr)
+	}()
+	// A nil prototype removes it
+	bm._setproto(ctx, ob, Nil)
+	if v := arr.Get(String("__string")); v != Nil {
+		t.Errorf("expected removed prototype, got %v", v)
+	}
+}
@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Error raised when an exported value is not found
+type ExportNotFoundError string
+
+// Error interface implementation.
+func (e ExportNotFoundError) Error() string {
+	return string(e)
+}
+
+// Create a new ExportNotFoundError.
+func NewExportNotFoundError(id, path string) ExportNotFoundError {
+	return ExportNotFoundError(fmt.Sprintf("export not found: %s in module %s", path, id))
+}
+
+// Lookup returns the function exported by the module identified by id at the
+// dot-separated path, i.e. `handlers.onSave` for the field `onSave` of the
+// field `handlers` of the value returned by the module. The module is loaded
+// and run if it is not already. It returns an ExportNotFoundError if a part of
+// the path does not exist, or a TypeError if the value is not a function.
+//
+// The returned function can be called any number of times with Kontext.Call.
+func (c *Kontext) Lookup(ctx context.Context, id, path string) (Func, error) {
+	m, err := c.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	v, err := m.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		for _, p := range strings.Split(path, ".") {
+			ob, ok := v.(Object)
+			if !ok {
+				return nil, NewExportNotFoundError(id, path)
+			}
+			if v = ob.Get(String(p)); v == Nil {
+				return nil, NewExportNotFoundError(id, path)
+			}
+		}
+	}
+	fn, ok := v.(Func)
+	if !ok {
+		return nil, NewTypeError(Type(v), "", "call")
+	}
+	return fn, nil
+}
+
+// Call calls the function fn with the Go arguments args, converted with
+// Kontext.ToVal, and returns its return value. If the call panics, the error
+// is returned, as an *Error holding the agora call stack if it was raised by
+// the function.
+//
+// If the call stack is empty, i.e. if Call is not called by a native function
+// during the execution of a module, it starts a new execution and the budgets
+// of the execution context are reset.
+func (c *Kontext) Call(ctx context.Context, fn Func, args ...interface{}) (v Val, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = c.newError(ctx, p, nil)
+		}
+	}()
+	if c.frmsp == 0 {
+		c.resetBudgets()
+	}
+	vals := make([]Val, len(args))
+	for i, a := range args {
+		vals[i] = c.ToVal(a)
+	}
+	return fn.Call(ctx, nil, vals...), nil
+}
+
+// CallExport looks up the function exported by the module identified by id
+// at path, as described by Kontext.Lookup, and calls it with args, as
+// described by Kontext.Call.
+func (c *Kontext) CallExport(ctx context.Context, id, path string, args ...interface{}) (Val, error) {
+	fn, err := c.Lookup(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Call(ctx, fn, args...)
+}
@@ -1,9 +1,11 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/bobg/agora/bytecode"
 )
@@ -40,6 +42,15 @@ type Compiler interface {
 	Compile(string, io.Reader) (*bytecode.File, error)
 }
 
+// A GlobalsCompiler is a Compiler that accepts the names of the global values
+// of the execution context, so that it does not report them as undefined. If
+// the Compiler of the execution context implements it, it is used instead
+// of Compile.
+type GlobalsCompiler interface {
+	Compiler
+	CompileWithGlobals(string, io.Reader, []string) (*bytecode.File, error)
+}
+
 // A frame represents a currently executing function. A native function has no
 // VM.
 type frame struct {
@@ -63,30 +74,44 @@ type Kontext struct {
 	Resolver   ModuleResolver // The module loading resolver (match a module to a string literal)
 	Compiler   Compiler       // The source code compiler
 	Debug      bool           // Debug mode outputs helpful messages
+	Hook       Hook           // The hook notified of the execution, if any
+
+	// Budgets of an execution, 0 means no limit. The instructions and allocations
+	// are counted from the start of the execution of a module that is not imported
+	// by another one, i.e. when the call stack is empty.
+	MaxInstructions int64 // Maximum number of instructions executed
+	MaxCallDepth    int   // Maximum depth of the call stack
+	MaxAllocBytes   int64 // Approximate maximum number of bytes allocated by the VM
 
 	// Call stack
 	frames []*frame
 	frmsp  int
 
+	// Budgets usage
+	instrs int64
+	allocs int64
+
 	// Modules management
 	loadingMods map[string]bool // Modules currently being loaded
 	loadedMods  map[string]Module
-	builtin     Object
+	globals     map[Val]Val // Global values, including the built-in functions
+	evalScope   map[Val]Val // Variables of the frame in which an expression is evaluated
 }
 
 // NewKtx returns a new execution context, using the provided module resolver
 // and compiler.
 func NewKtx(resolver ModuleResolver, comp Compiler) *Kontext {
 	c := &Kontext{
-		Stdout:      os.Stdout,
-		Stdin:       os.Stdin,
-		Stderr:      os.Stderr,
-		Arithmetic:  defaultArithmetic{},
-		Comparer:    defaultComparer{},
-		Resolver:    resolver,
-		Compiler:    comp,
-		loadingMods: make(map[string]bool),
-		loadedMods:  make(map[string]Module),
+		Stdout:       os.Stdout,
+		Stdin:        os.Stdin,
+		Stderr:       os.Stderr,
+		Arithmetic:   defaultArithmetic{},
+		Comparer:     defaultComparer{},
+		Resolver:     resolver,
+		Compiler:     comp,
+		MaxCallDepth: DefaultMaxCallDepth,
+		loadingMods:  make(map[string]bool),
+		loadedMods:   make(map[string]Module),
 	}
 	// Automatically add the built-in functions
 	b := new(builtinMod)
@@ -94,7 +119,14 @@ func NewKtx(resolver ModuleResolver, comp Compiler) *Kontext {
 	if v, err := b.Run(); err != nil {
 		panic("error loading agora builtin module: " + err.Error())
 	} else {
-		c.builtin = v.(Object)
+		ctx := context.Background()
+		ob := v.(Object)
+		ks := ob.Keys(ctx).(Object)
+		c.globals = make(map[Val]Val)
+		for i, n := int64(0), ks.Len(ctx).Int(ctx); i < n; i++ {
+			k := ks.Get(Number(i))
+			c.globals[k] = ob.Get(k)
+		}
 	}
 	return c
 }
@@ -141,7 +173,11 @@ func (c *Kontext) Load(id string) (Module, error) {
 		f, err = dec.Decode()
 	} else {
 		// Compile to bytecode
-		f, err = c.Compiler.Compile(id, r)
+		if gc, ok := c.Compiler.(GlobalsCompiler); ok {
+			f, err = gc.CompileWithGlobals(id, r, c.Globals())
+		} else {
+			f, err = c.Compiler.Compile(id, r)
+		}
 	}
 	if err != nil {
 		return nil, err
@@ -152,6 +188,33 @@ func (c *Kontext) Load(id string) (Module, error) {
 	return mod, nil
 }
 
+// SetGlobal defines the global value identified by name, visible to all modules
+// subsequently loaded in this execution context, replacing any global with the
+// same name, including the built-in functions. The value is converted
+// with ToVal, so it may be any Go value supported by ToVal, such as a func.
+func (c *Kontext) SetGlobal(name string, v interface{}) {
+	c.globals[String(name)] = c.ToVal(v)
+}
+
+// RemoveGlobal removes the global value identified by name, such as a built-in
+// function like `import`. Modules subsequently loaded in this execution context
+// can no longer refer to it.
+func (c *Kontext) RemoveGlobal(name string) {
+	delete(c.globals, String(name))
+}
+
+// Globals returns the sorted names of the global values of the execution
+// context, i.e. the built-in functions, the values defined by SetGlobal and the
+// global variables assigned by the modules.
+func (c *Kontext) Globals() []string {
+	nms := make([]string, 0, len(c.globals))
+	for k := range c.globals {
+		nms = append(nms, string(k.(String)))
+	}
+	sort.Strings(nms)
+	return nms
+}
+
 // RegisterNativeModule adds the provided native module to the list of loaded and cached
 // modules in this execution context (replacing any other module with the same ID).
 func (c *Kontext) RegisterNativeModule(m NativeModule) {
@@ -159,6 +222,13 @@ func (c *Kontext) RegisterNativeModule(m NativeModule) {
 	c.loadedMods[m.ID()] = m
 }
 
+// Unload removes the module identified by id from the cache of the execution
+// context, so that loading it again resolves and compiles it again. The values
+// created by the module, such as its functions, remain valid.
+func (c *Kontext) Unload(id string) {
+	delete(c.loadedMods, id)
+}
+
 // Mark the specified module as currently executing
 func (c *Kontext) pushModule(id string) {
 	if c.loadingMods[id] {
@@ -174,6 +244,9 @@ func (c *Kontext) popModule(id string) {
 
 // Push a function onto the frame stack.
 func (c *Kontext) pushFn(f Func, fvm *agoraFuncVM) {
+	if c.MaxCallDepth > 0 && c.frmsp >= c.MaxCallDepth {
+		panic(NewBudgetExceededError(BudgetCallDepth, int64(c.MaxCallDepth)))
+	}
 	// Stack has to grow as needed
 	if c.frmsp == len(c.frames) {
 		if c.Debug && c.frmsp == cap(c.frames) {
@@ -186,8 +259,16 @@ func (c *Kontext) pushFn(f Func, fvm *agoraFuncVM) {
 	c.frmsp++
 }
 
-// Pop the top function from the frame stack.
-func (c *Kontext) popFn() {
+// Pop the top function from the frame stack. It must be called in a defer
+// statement: if the function is panicking, the panic value is turned into
+// an *Error while the frames of the call stack are still available.
+func (c *Kontext) popFn(ctx context.Context) {
+	if p := recover(); p != nil {
+		err := c.newError(ctx, p, nil)
+		c.frmsp--
+		c.frames[c.frmsp] = nil
+		panic(err)
+	}
 	c.frmsp--
 	c.frames[c.frmsp] = nil // free this reference for gc
 }
@@ -202,43 +283,6 @@ func (c *Kontext) IsRunning(f Func) bool {
 	return false
 }
 
-// Get the variable identified by name, looking up the lexical scope stack and ultimately the
-// built-ins.
-func (c *Kontext) getVar(nm string, fvm *agoraFuncVM) (Val, bool) {
-	// First look in locals
-	if v, ok := fvm.vars[nm]; ok {
-		return v, true
-	}
-	// Then recursively in parent environments
-	for parent := fvm.val.env; parent != nil; parent = parent.parent {
-		if v, ok := parent.upvals[nm]; ok {
-			return v, true
-		}
-	}
-	// Finally, look if the identifier refers to a built-in function.
-	// This will return Nil if it doesn't match any built-in.
-	b := c.builtin.Get(String(nm))
-	return b, b != Nil
-}
-
-// Set the value of the variable identified by the provided name, looking up the
-// frame stack if necessary. Returns true if the variable was found.
-func (c *Kontext) setVar(nm string, v Val, fvm *agoraFuncVM) bool {
-	// First attempt to set as local var
-	if _, ok := fvm.vars[nm]; ok {
-		fvm.vars[nm] = v
-		return true
-	}
-	// Then recursively in parent environments
-	for parent := fvm.val.env; parent != nil; parent = parent.parent {
-		if _, ok := parent.upvals[nm]; ok {
-			parent.upvals[nm] = v
-			return true
-		}
-	}
-	return false
-}
-
 // Pretty-print the execution context, up to n number of frames.
 func (c *Kontext) dump(n int) {
 	if n < 0 {
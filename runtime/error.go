@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// A Frame describes a function of the agora call stack at the moment an
+// error was raised.
+type Frame struct {
+	Name   string // Name of the function
+	Module string // ID of the module defining the function, empty for native functions
+	Line   int64  // Line in the module's source code, 0 if unknown
+}
+
+// String returns the representation of the frame, i.e. `fn (module:line)`.
+func (f Frame) String() string {
+	nm := f.Name
+	if nm == "" {
+		nm = "?"
+	}
+	if f.Module == "" {
+		return fmt.Sprintf("%s (native)", nm)
+	}
+	return fmt.Sprintf("%s (%s:%d)", nm, f.Module, f.Line)
+}
+
+// An Error is raised when agora code panics, either explicitly with the `panic`
+// built-in, or because of a runtime error such as a TypeError. It records the
+// agora call stack at the point where the panic was raised.
+//
+// It is both a Go error, returned by Module.Run, and an agora object, returned
+// by the `recover` built-in, with the fields `message`, `value`, `cause` and
+// `frames`.
+type Error struct {
+	Object
+	Message string  // The error message
+	Value   Val     // The value passed to panic, or the message as a String
+	Cause   error   // The error that caused this one, if any
+	Frames  []Frame // The call stack, from the innermost function
+}
+
+// Create a new Error for the panic value p, with the frames of the execution
+// context. If p is already an *Error, it is returned as-is, so that the call
+// stack is the one where the error was initially raised.
+func (c *Kontext) newError(ctx context.Context, p interface{}, cause error) *Error {
+	if e, ok := p.(*Error); ok && cause == nil {
+		return e
+	}
+	e := &Error{
+		Cause:  cause,
+		Frames: c.CallStack(),
+	}
+	switch v := p.(type) {
+	case Val:
+		e.Message = v.String(ctx)
+		e.Value = v
+	case error:
+		e.Message = v.Error()
+		if e.Cause == nil {
+			e.Cause = v
+		}
+	default:
+		e.Message = fmt.Sprintf("%v", v)
+	}
+	if e.Value == nil {
+		e.Value = String(e.Message)
+	}
+	e.Object = e.newObject(c)
+	return e
+}
+
+// Create the agora object representation of the error. Its string is the
+// message, so that it can be printed or added to a string as the recovered
+// message was before the errors were objects, i.e. `"failed: " + err`.
+func (e *Error) newObject(c *Kontext) Object {
+	ob := NewObject()
+	ob.Set(String("__string"), NewNativeFunc(c, "__string", func(ctx context.Context, args ...Val) Val {
+		return String(e.Message)
+	}))
+	ob.Set(String("__add"), NewNativeFunc(c, "__add", func(ctx context.Context, args ...Val) Val {
+		ExpectAtLeastNArgs(2, args)
+		if Type(args[0]) != "string" {
+			panic(NewTypeError("object", Type(args[0]), "add"))
+		}
+		if args[1].Bool(ctx) {
+			return String(e.Message + args[0].String(ctx))
+		}
+		return String(args[0].String(ctx) + e.Message)
+	}))
+	ob.Set(String("message"), String(e.Message))
+	ob.Set(String("value"), e.Value)
+	switch c := e.Cause.(type) {
+	case nil:
+		ob.Set(String("cause"), Nil)
+	case *Error:
+		ob.Set(String("cause"), c)
+	default:
+		ob.Set(String("cause"), String(c.Error()))
+	}
+	frms := make([]Val, len(e.Frames))
+	for i, f := range e.Frames {
+		fob := NewObject()
+		fob.Set(String("name"), String(f.Name))
+		fob.Set(String("module"), String(f.Module))
+		fob.Set(String("line"), Number(f.Line))
+		frms[i] = fob
+	}
+	ob.Set(String("frames"), NewArray(frms...))
+	return ob
+}
+
+// CallStack returns the frames of the call stack currently executing, from
+// the innermost function. For agora functions, the line of each frame is
+// the line currently executing in that function.
+func (c *Kontext) CallStack() []Frame {
+	frms := make([]Frame, 0, c.frmsp)
+	for i := c.frmsp - 1; i >= 0; i-- {
+		frm := c.frames[i]
+		if frm.fvm != nil {
+			frms = append(frms, Frame{
+				Name:   frm.fvm.proto.name,
+				Module: frm.fvm.proto.mod.ID(),
+				Line:   frm.fvm.line(),
+			})
+		} else if nf, ok := frm.f.(*NativeFunc); ok {
+			frms = append(frms, Frame{Name: nf.name})
+		} else {
+			frms = append(frms, Frame{})
+		}
+	}
+	return frms
+}
+
+// Error interface implementation. It returns the error message.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the cause of the error, if any.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// StackTrace returns the error message followed by the frames of the call stack,
+// one per line.
+func (e *Error) StackTrace() string {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(e.Message)
+	for _, f := range e.Frames {
+		buf.WriteString("\n\t")
+		buf.WriteString(f.String())
+	}
+	if c, ok := e.Cause.(*Error); ok {
+		buf.WriteString("\ncaused by: ")
+		buf.WriteString(c.StackTrace())
+	}
+	return buf.String()
+}
+
+// String returns the error message.
+func (e *Error) String(context.Context) string {
+	return e.Message
+}
+
+// Native returns the Go error.
+func (e *Error) Native(context.Context) interface{} {
+	return e
+}
+
+// Dump pretty-prints the error for debugging purpose.
+func (e *Error) Dump() string {
+	return fmt.Sprintf("%s (Error)", e.Message)
+}
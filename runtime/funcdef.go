@@ -18,15 +18,19 @@ type Func interface {
 
 // An agoraFuncDef represents an agora function's prototype.
 type agoraFuncDef struct {
-	ktx *Kontext
-	mod *agoraModule
+	ktx    *Kontext
+	mod    *agoraModule
+	parent *agoraFuncDef // enclosing function, nil for the top-level function
 	// Internal fields filled by the compiler
-	name    string
-	stackSz int64
-	expArgs int64
-	kTable  []Val
-	lTable  []string
-	code    []bytecode.Instr
+	name      string
+	stackSz   int64
+	expArgs   int64
+	lineStart int64
+	lineEnd   int64
+	kTable    []Val
+	lTable    []string
+	code      []bytecode.Instr
+	ps        []bytecode.Pos // line table
 }
 
 func newAgoraFuncDef(mod *agoraModule, c *Kontext) *agoraFuncDef {
@@ -73,6 +77,6 @@ func (n *NativeFunc) Native(context.Context) interface{} {
 // Call executes the native function and returns its return value.
 func (n *NativeFunc) Call(ctx context.Context, _ Val, args ...Val) Val {
 	n.ktx.pushFn(n, nil)
-	defer n.ktx.popFn()
+	defer n.ktx.popFn(ctx)
 	return n.fn(ctx, args...)
 }
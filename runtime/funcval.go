@@ -37,9 +37,10 @@ func (f *funcVal) Bool(context.Context) bool {
 	return true
 }
 
-// The environment for a given func value. This is a linked list.
+// The environment for a given func value. This is a linked list, each
+// element holds the local variables of an enclosing function, by slot.
 type env struct {
-	upvals map[string]Val
+	upvals []Val
 	parent *env
 }
 
@@ -84,7 +85,7 @@ func (a *agoraFuncVal) Call(ctx context.Context, this Val, args ...Val) Val {
 	// Set the `this` each time, the same value may have been assigned to an object and called
 	vm.this = this
 	a.ktx.pushFn(a, vm)
-	defer a.ktx.popFn()
+	defer a.ktx.popFn(ctx)
 	return vm.run(ctx, args...)
 }
 
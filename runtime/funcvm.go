@@ -6,10 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"sort"
-	"strings"
 
-	"github.com/PuerkitoBio/gocoro"
 	"github.com/bobg/agora/bytecode"
 )
 
@@ -25,13 +22,37 @@ type agoraFuncVM struct {
 	pc     int   // program counter
 	stack  []Val // function stack
 	sp     int
-	rstack []gocoro.Caller // range native coroutine stack
+	rstack []iterator // range iterators stack
 	rsp    int
+	rets   *Values // multiple values returned by the last call, if any
+	defers []deferredCall
 
 	// Variables
-	vars map[string]Val
+	vars []Val // local variables, by slot
 	this Val
 	args Val
+
+	// Debugging
+	dbg      *DebugFrame
+	hookLine int64 // line last notified to the hook
+}
+
+// A deferredCall is a function or method call registered by the `defer`
+// statement, executed when the function returns.
+type deferredCall struct {
+	fn   Func
+	ob   Object
+	key  Val
+	args []Val
+}
+
+// call executes the deferred call, discarding its return value.
+func (d deferredCall) call(ctx context.Context) {
+	if d.ob != nil {
+		d.ob.callMethod(ctx, d.key, d.args...)
+		return
+	}
+	d.fn.Call(ctx, nil, d.args...)
 }
 
 // Instantiate a runnable representation of the function prototype.
@@ -42,10 +63,38 @@ func newFuncVM(fv *agoraFuncVal) *agoraFuncVM {
 		proto: p,
 		debug: p.ktx.Debug,
 		stack: make([]Val, 0, p.stackSz),
-		vars:  make(map[string]Val, len(p.lTable)),
+		vars:  make([]Val, len(p.lTable)),
 	}
 }
 
+// Get the line currently executing in the source code of the function, or
+// the line where the function starts if it is unknown. The program counter
+// is already incremented when an instruction executes, so the current
+// instruction is the previous one.
+func (f *agoraFuncVM) line() int64 {
+	ix := int64(f.pc - 1)
+	if ix < 0 {
+		ix = 0
+	}
+	if l := bytecode.LineOf(f.proto.ps, ix); l > 0 {
+		return l
+	}
+	return f.proto.lineStart
+}
+
+// Push the result of a function call onto the stack. If the function returned
+// multiple values, only the first one is pushed, and all of them are kept
+// so that a subsequent OP_UNPK can push the others.
+func (f *agoraFuncVM) pushResult(v Val) {
+	if vs, ok := v.(*Values); ok {
+		f.rets = vs
+		f.push(vs.Get(0))
+		return
+	}
+	f.rets = nil
+	f.push(v)
+}
+
 // Push a value onto the stack.
 func (f *agoraFuncVM) push(v Val) {
 	// Stack has to grow as needed, StackSz doesn't take into account the loops
@@ -73,12 +122,21 @@ func (f *agoraFuncVM) getVal(ctx context.Context, flg bytecode.Flag, ix uint64)
 	switch flg {
 	case bytecode.FLG_K:
 		return f.proto.kTable[ix]
+	case bytecode.FLG_L:
+		return f.vars[ix]
+	case bytecode.FLG_U:
+		return *f.upval(ix)
 	case bytecode.FLG_V:
-		// Fail if variable cannot be found
-		varNm := f.proto.kTable[ix].String(ctx)
-		v, ok := f.proto.ktx.getVar(varNm, f)
+		// Not a local variable nor an upvalue, look up the globals, and
+		// fail if it is not found.
+		nm := f.proto.kTable[ix]
+		if v, ok := f.proto.ktx.evalScope[nm]; ok {
+			// Variable of the frame in which an expression is evaluated
+			return v
+		}
+		v, ok := f.proto.ktx.globals[nm]
 		if !ok {
-			panic("variable not found: " + varNm)
+			panic("variable not found: " + nm.String(ctx))
 		}
 		return v
 	case bytecode.FLG_N:
@@ -86,6 +144,7 @@ func (f *agoraFuncVM) getVal(ctx context.Context, flg bytecode.Flag, ix uint64)
 	case bytecode.FLG_T:
 		return f.this
 	case bytecode.FLG_F:
+		f.proto.ktx.alloc(funcAllocSize)
 		return newAgoraFuncVal(f.proto.mod.fns[ix], f)
 	case bytecode.FLG_A:
 		return f.args
@@ -101,6 +160,17 @@ func (f *agoraFuncVM) dumpInstrInfo(w io.Writer, i bytecode.Instr) {
 		fmt.Fprintf(w, " ; %s", dumpVal(v))
 	case bytecode.FLG_V:
 		fmt.Fprintf(w, " ; var %s", f.proto.kTable[i.Index()])
+	case bytecode.FLG_L:
+		fmt.Fprintf(w, " ; var %s", f.proto.lTable[i.Index()])
+	case bytecode.FLG_U:
+		depth, slot := bytecode.Upval(i.Index())
+		p := f.proto
+		for ; depth > 0 && p != nil; depth-- {
+			p = p.parent
+		}
+		if p != nil && slot < uint64(len(p.lTable)) {
+			fmt.Fprintf(w, " ; upval %s", p.lTable[slot])
+		}
 	case bytecode.FLG_N:
 		fmt.Fprintf(w, " ; %s", Nil.Dump())
 	case bytecode.FLG_T:
@@ -129,16 +199,8 @@ func (f *agoraFuncVM) dump() string {
 	if f.args != nil {
 		fmt.Fprintf(buf, "    [args] = %s\n", dumpVal(f.args))
 	}
-	// Sort the vars for deterministic output
-	sortedVars := make([]string, len(f.vars))
-	j := 0
-	for k, _ := range f.vars {
-		sortedVars[j] = k
-		j++
-	}
-	sort.Strings(sortedVars)
-	for _, k := range sortedVars {
-		fmt.Fprintf(buf, "    %s = %s\n", k, dumpVal(f.vars[k]))
+	for j, nm := range f.proto.lTable {
+		fmt.Fprintf(buf, "    %s = %s\n", nm, dumpVal(f.vars[j]))
 	}
 	// Stack
 	fmt.Fprintf(buf, "\n  Stack:\n")
@@ -178,175 +240,121 @@ func (f *agoraFuncVM) dump() string {
 	return buf.String()
 }
 
-// Create the reserved identifier `args` value, as an Object.
+// Create the reserved identifier `args` value, as an array.
 func (vm *agoraFuncVM) createArgsVal(args []Val) Val {
 	if len(args) == 0 {
 		return Nil
 	}
-	o := NewObject()
-	for i, v := range args {
-		o.Set(Number(i), v)
-	}
-	return o
+	return NewArray(args...)
 }
 
 // Create the local variables all initialized to nil
 func (vm *agoraFuncVM) createLocals() {
-	for _, s := range vm.proto.lTable {
-		vm.vars[s] = Nil
+	for j := range vm.vars {
+		vm.vars[j] = Nil
 	}
 }
 
-func (vm *agoraFuncVM) pushRange(ctx context.Context, args ...Val) {
-	var coro gocoro.Caller
-	l := len(args)
-	switch t := Type(args[0]); t {
-	case "number":
-		start := int64(0)
-		max := args[0].Int(ctx)
-		inc := int64(1)
-		if l > 1 {
-			start = max
-			max = args[1].Int(ctx)
-		}
-		if l > 2 {
-			inc = args[2].Int(ctx)
-		}
-		coro = gocoro.New(func(y gocoro.Yielder, args ...interface{}) interface{} {
-			if inc >= 0 {
-				for i := start; i < max; i += inc {
-					y.Yield(Number(i))
-				}
-			} else {
-				for i := start; i > max; i += inc {
-					y.Yield(Number(i))
-				}
-			}
-			panic(gocoro.ErrEndOfCoro)
-		})
-
-	case "string":
-		src := args[0].String(ctx)
-		sep := ""
-		if len(args) > 1 && args[1].Bool(ctx) {
-			sep = args[1].String(ctx)
-		}
-		max := int64(-1)
-		if len(args) > 2 {
-			max = args[2].Int(ctx)
-		}
-		coro = gocoro.New(func(y gocoro.Yielder, args ...interface{}) interface{} {
-			if max == 0 {
-				panic(gocoro.ErrEndOfCoro)
-			}
-			if sep == "" {
-				cnt := int64(len(src))
-				if max >= 0 && max < cnt {
-					cnt = max
-				}
-				for i := int64(0); i < cnt; i++ {
-					y.Yield(String(src[i]))
-				}
-			} else {
-				cnt := int64(0)
-				for max < 0 || cnt < max {
-					splits := strings.SplitN(src, sep, 2)
-					if len(splits) == 0 {
-						break
-					}
-					y.Yield(String(splits[0]))
-					cnt++
-					if len(splits) == 1 {
-						break
-					}
-					src = splits[1]
-				}
-			}
-			panic(gocoro.ErrEndOfCoro)
-		})
-
-	case "object":
-		ob := args[0].(Object)
-		coro = gocoro.New(func(y gocoro.Yielder, args ...interface{}) interface{} {
-			ks := ob.Keys(ctx).(Object)
-			for i := int64(0); i < ks.Len(ctx).Int(ctx); i++ {
-				val := NewObject()
-				key := ks.Get(Number(i))
-				val.Set(String("k"), key)
-				val.Set(String("v"), ob.Get(key))
-				y.Yield(val)
-			}
-			panic(gocoro.ErrEndOfCoro)
-		})
-
-	case "func":
-		fn := args[0].(Func)
-		if afn, ok := fn.(*agoraFuncVal); ok {
-			afn.reset()
-			coro = gocoro.New(func(y gocoro.Yielder, _ ...interface{}) interface{} {
-				for v := afn.Call(ctx, Nil, args[1:]...); afn.status() == "suspended"; v = afn.Call(ctx, Nil) {
-					y.Yield(v)
-				}
-				panic(gocoro.ErrEndOfCoro)
-			})
-		} else {
-			panic(NewTypeError("native func", "", "range"))
-		}
-
-	default:
-		panic(NewTypeError(t, "", "range"))
+// Get the address of the upvalue identified by ix, i.e. a local variable of
+// an enclosing function.
+func (vm *agoraFuncVM) upval(ix uint64) *Val {
+	depth, slot := bytecode.Upval(ix)
+	e := vm.val.env
+	for ; depth > 1; depth-- {
+		e = e.parent
 	}
+	return &e.upvals[slot]
+}
+
+// Create the iterator of a `for range` loop and push it on the range stack.
+func (vm *agoraFuncVM) pushRange(ctx context.Context, args ...Val) {
+	it := newIterator(ctx, true, args...)
 	if vm.rsp == len(vm.rstack) {
 		if vm.debug && vm.rsp == cap(vm.rstack) {
 			fmt.Fprintf(vm.proto.ktx.Stdout, "DEBUG expanding range stack of func %s, current size: %d\n", vm.val.name, len(vm.rstack))
 		}
-		vm.rstack = append(vm.rstack, coro)
+		vm.rstack = append(vm.rstack, it)
 	} else {
-		vm.rstack[vm.rsp] = coro
+		vm.rstack[vm.rsp] = it
 	}
 	vm.rsp++
 }
 
+// Release the iterator of the innermost `for range` loop.
 func (vm *agoraFuncVM) popRange() {
 	vm.rsp--
-	coro := vm.rstack[vm.rsp]
 	vm.rstack[vm.rsp] = nil
-	if coro.Status() == gocoro.StSuspended {
-		coro.Cancel()
+}
+
+// Panic with the context's error if it is cancelled or its deadline is exceeded.
+// It is called at the safe points of the execution, i.e. backward jumps and
+// calls, so that a running script can be stopped.
+func checkCtx(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		panic(ctx.Err())
+	default:
+	}
+}
+
+// runDefers executes the deferred calls in reverse order of registration. If
+// a deferred call panics, the remaining ones are still executed.
+func (f *agoraFuncVM) runDefers(ctx context.Context) {
+	for len(f.defers) > 0 {
+		d := f.defers[len(f.defers)-1]
+		f.defers = f.defers[:len(f.defers)-1]
+		func() {
+			defer func() {
+				if len(f.defers) > 0 {
+					if e := recover(); e != nil {
+						f.runDefers(ctx)
+						panic(e)
+					}
+				}
+			}()
+			d.call(ctx)
+		}()
 	}
 }
 
 // run executes the instructions of the function. This is the actual implementation
 // of the Virtual Machine.
-func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
-	// Register the defer to release all `for range` coroutines created
-	// by the VM and possibly still alive from a resume of this VM.
+func (f *agoraFuncVM) run(ctx context.Context, args ...Val) (ret Val) {
+	// Keep reference to the execution context, arithmetic and comparer
+	ktx := f.proto.ktx
+	arith := ktx.Arithmetic
+	cmp := ktx.Comparer
+	countInstrs := ktx.MaxInstructions > 0
+	hook := ktx.Hook
+	if hook != nil {
+		// Registered first, so that the hook is notified once the deferred calls
+		// are executed.
+		defer f.exitHook(ctx, hook, &ret)
+	}
+
+	// Register the defer to release all `for range` iterators created
+	// by the VM and possibly still alive from a resume of this VM, and
+	// to execute the calls registered by the `defer` statement.
 	clearRange := true
 	defer func() {
 		if clearRange {
 			for f.rsp > 0 {
 				f.popRange()
 			}
+			f.runDefers(ctx)
 		}
 	}()
 
-	// Keep reference to arithmetic and comparer
-	arith := f.proto.ktx.Arithmetic
-	cmp := f.proto.ktx.Comparer
-
 	// If the program counter is 0, this is an initial run, not a resume as
 	// a coroutine.
 	if f.pc == 0 {
 		// Create local variables
 		f.createLocals()
 
-		// Expected args are defined in constant table spots 0 to ExpArgs - 1.
-		for j, l := int64(0), int64(len(args)); j < f.proto.expArgs; j++ {
-			if j < l {
-				f.vars[f.proto.kTable[j].String(ctx)] = args[j]
-			} else {
-				f.vars[f.proto.kTable[j].String(ctx)] = Nil
-			}
+		// Expected args are defined in constant table spots and local variable
+		// slots 0 to ExpArgs - 1.
+		for j, l := int64(0), int64(len(args)); j < f.proto.expArgs && j < l; j++ {
+			f.vars[j] = args[j]
 		}
 		// Keep the args array
 		f.args = f.createArgsVal(args)
@@ -358,6 +366,9 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 		}
 		f.push(a0)
 	}
+	if hook != nil {
+		hook.Enter(ctx, f.debugFrame())
+	}
 
 	// Execute the instructions
 	for {
@@ -367,31 +378,67 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 		op, flg, ix := i.Opcode(), i.Flag(), i.Index()
 		// Increment the PC, if a jump requires a different PC delta, it will set it explicitly
 		f.pc++
+		if countInstrs {
+			ktx.instr()
+		}
+		if hook != nil {
+			f.lineHook(ctx, hook, i)
+		}
 		switch op {
 		case bytecode.OP_RET:
-			// End this function call, return the value on top of the stack and remove
-			// the vm if it was set on the value
+			// End this function call, return the value on top of the stack (or the
+			// n values if the Rn flag is set, or all the values returned by the last
+			// call if n is 0) and remove the vm if it was set on the value
 			f.val.coroState = nil
+			if flg == bytecode.FLG_Rn && ix == 0 {
+				v := f.pop()
+				if f.rets != nil {
+					v = f.rets
+					f.rets = nil
+				}
+				return v
+			}
+			if flg == bytecode.FLG_Rn {
+				vals := make([]Val, ix)
+				for j := ix; j > 0; j-- {
+					vals[j-1] = f.pop()
+				}
+				return NewValues(vals...)
+			}
 			return f.pop()
 
 		case bytecode.OP_YLD:
 			// Yield n value(s), save the vm so it can be called back, and return
 			f.val.coroState = f
-			clearRange = false // Keep active range coros, so that they can continue on a resume
+			clearRange = false // Keep active range iterators, so that they can continue on a resume
 			return f.pop()
 
 		case bytecode.OP_PUSH:
 			f.push(f.getVal(ctx, flg, ix))
 
 		case bytecode.OP_POP:
-			if nm, v := f.proto.kTable[ix].String(ctx), f.pop(); !f.proto.ktx.setVar(nm, v, f) {
-				// Not found anywhere, panic
-				panic("unknown variable: " + nm)
+			switch flg {
+			case bytecode.FLG_L:
+				f.vars[ix] = f.pop()
+			case bytecode.FLG_U:
+				*f.upval(ix) = f.pop()
+			default:
+				// Not a local variable nor an upvalue, this is a global variable if
+				// the module was compiled to assign them, otherwise panic
+				if !f.proto.mod.globalVars {
+					panic("unknown variable: " + f.proto.kTable[ix].String(ctx))
+				}
+				f.proto.ktx.globals[f.proto.kTable[ix]] = f.pop()
 			}
 
 		case bytecode.OP_ADD:
 			y, x := f.pop(), f.pop()
-			f.push(arith.Add(ctx, x, y))
+			v := arith.Add(ctx, x, y)
+			if s, ok := v.(String); ok {
+				// A concatenation allocates a new string
+				ktx.alloc(int64(len(s)))
+			}
+			f.push(v)
 
 		case bytecode.OP_SUB:
 			y, x := f.pop(), f.pop()
@@ -451,10 +498,13 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 			if flg == bytecode.FLG_Jf {
 				f.pc += int(ix)
 			} else {
+				// A backward jump is a loop, stop if the context is done
+				checkCtx(ctx)
 				f.pc -= (int(ix) + 1) // +1 because pc is already on next instr
 			}
 
 		case bytecode.OP_NEW:
+			ktx.alloc(objectAllocSize + int64(ix)*fieldAllocSize)
 			ob := NewObject()
 			for j := ix; j > 0; j-- {
 				key, val := f.pop(), f.pop()
@@ -462,9 +512,22 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 			}
 			f.push(ob)
 
+		case bytecode.OP_NEWA:
+			ktx.alloc(objectAllocSize + int64(ix)*fieldAllocSize)
+			// Pop the values in reverse order
+			vals := make([]Val, ix)
+			for j := ix; j > 0; j-- {
+				vals[j-1] = f.pop()
+			}
+			f.push(NewArray(vals...))
+
 		case bytecode.OP_SFLD:
 			vr, k, vl := f.pop(), f.pop(), f.pop()
 			if ob, ok := vr.(Object); ok {
+				if ktx.MaxAllocBytes > 0 && ob.Get(k) == Nil {
+					// A new field is added to the object
+					ktx.alloc(fieldAllocSize)
+				}
 				ob.Set(k, vl)
 			} else {
 				panic(NewTypeError(Type(vr), "", "object"))
@@ -479,6 +542,7 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 			}
 
 		case bytecode.OP_CFLD:
+			checkCtx(ctx)
 			vr, k := f.pop(), f.pop()
 			// Pop the arguments in reverse order
 			args := make([]Val, ix)
@@ -486,14 +550,13 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 				args[j-1] = f.pop()
 			}
 			if ob, ok := vr.(Object); ok {
-				// TODO : Do not push returned value if unused (grow stack for nothing). When multiple return values
-				// are added, add intelligence to know how many are used/discarded.
-				f.push(ob.callMethod(ctx, k, args...))
+				f.pushResult(ob.callMethod(ctx, k, args...))
 			} else {
 				panic(NewTypeError(Type(vr), "", "object"))
 			}
 
 		case bytecode.OP_CALL:
+			checkCtx(ctx)
 			// ix is the number of args
 			// Pop the function itself, ensure it is a function
 			x := f.pop()
@@ -507,9 +570,44 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 				args[j-1] = f.pop()
 			}
 			// Call the function, and store the return value on the stack
-			// TODO : Do not push returned value if unused (grow stack for nothing). When multiple return values
-			// are added, add intelligence to know how many are used/discarded.
-			f.push(fn.Call(ctx, nil, args...))
+			f.pushResult(fn.Call(ctx, nil, args...))
+
+		case bytecode.OP_DFR:
+			// Register the call so that it is executed when the function returns
+			var d deferredCall
+			if flg == bytecode.FLG_Mn {
+				vr, k := f.pop(), f.pop()
+				ob, ok := vr.(Object)
+				if !ok {
+					panic(NewTypeError(Type(vr), "", "object"))
+				}
+				d.ob, d.key = ob, k
+			} else {
+				x := f.pop()
+				fn, ok := x.(Func)
+				if !ok {
+					panic(NewTypeError(Type(x), "", "func"))
+				}
+				d.fn = fn
+			}
+			// Pop the arguments in reverse order
+			d.args = make([]Val, ix)
+			for j := ix; j > 0; j-- {
+				d.args[j-1] = f.pop()
+			}
+			f.defers = append(f.defers, d)
+
+		case bytecode.OP_UNPK:
+			// Replace the value pushed by the last call with the ix values expected
+			// by the caller, unpacking multiple return values or padding with Nil
+			v := f.pop()
+			if f.rets != nil {
+				v = f.rets
+				f.rets = nil
+			}
+			for _, rv := range Unpack(v, int(ix)) {
+				f.push(rv)
+			}
 
 		case bytecode.OP_RNGS:
 			// Pop the arguments in reverse order
@@ -517,35 +615,22 @@ func (f *agoraFuncVM) run(ctx context.Context, args ...Val) Val {
 			for j := ix; j > 0; j-- {
 				args[j-1] = f.pop()
 			}
-			// Create the range coroutine
+			// Create the range iterator
 			f.pushRange(ctx, args...)
 
 		case bytecode.OP_RNGP:
-			coro := f.rstack[f.rsp-1]
-			v, e := coro.Resume()
-			var vals []interface{}
-			if sl, ok := v.([]interface{}); ok {
-				vals = sl
-			} else {
-				vals = []interface{}{v}
-			}
-			// Push the values
-			if e == nil {
-				for j := uint64(0); j < ix; j++ {
-					if j < uint64(len(vals)) {
-						f.push(vals[j].(Val))
-					} else {
-						f.push(Nil)
-					}
+			// Push the ix values of the next iteration, if any
+			v, ok := f.rstack[f.rsp-1].next(ctx)
+			if ok {
+				for _, rv := range Unpack(v, int(ix)) {
+					f.push(rv)
 				}
-			} else if e != gocoro.ErrEndOfCoro {
-				panic(e)
 			}
 			// Push the condition
-			f.push(Bool(e == nil))
+			f.push(Bool(ok))
 
 		case bytecode.OP_RNGE:
-			// Release the range coroutine
+			// Release the range iterator
 			f.popRange()
 
 		case bytecode.OP_DUMP:
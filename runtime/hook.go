@@ -0,0 +1,214 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bobg/agora/bytecode"
+)
+
+// The ID of the modules compiled by DebugFrame.Eval.
+const evalModuleID = "<eval>"
+
+// A Hook is notified by the virtual machine of the execution of agora
+// functions, so that debuggers, tracers and coverage tools can be built
+// on top of the runtime. It is set on the Hook field of the execution context.
+// Native functions do not trigger the hook.
+//
+// The DebugFrame received by the methods is only valid during the call.
+type Hook interface {
+	// Enter is called when a function starts executing, or resumes if it
+	// is a coroutine, once its arguments are set.
+	Enter(ctx context.Context, f *DebugFrame)
+	// Exit is called when a function returns or yields the value v, once its
+	// deferred calls are executed.
+	Exit(ctx context.Context, f *DebugFrame, v Val)
+	// Line is called before the instruction i is executed, if it is on another
+	// line of the source code than the previous instruction of the function.
+	Line(ctx context.Context, f *DebugFrame, i bytecode.Instr)
+	// Panic is called when a function panics with the error err, instead of
+	// Exit.
+	Panic(ctx context.Context, f *DebugFrame, err *Error)
+}
+
+// NopHook is a Hook that does nothing. It can be embedded in a Hook
+// implementation that is only interested in some of the events.
+type NopHook struct{}
+
+// Enter does nothing.
+func (NopHook) Enter(context.Context, *DebugFrame) {}
+
+// Exit does nothing.
+func (NopHook) Exit(context.Context, *DebugFrame, Val) {}
+
+// Line does nothing.
+func (NopHook) Line(context.Context, *DebugFrame, bytecode.Instr) {}
+
+// Panic does nothing.
+func (NopHook) Panic(context.Context, *DebugFrame, *Error) {}
+
+// A DebugFrame gives access to the state of an agora function that is
+// executing.
+type DebugFrame struct {
+	vm *agoraFuncVM
+}
+
+// Name returns the name of the function.
+func (d *DebugFrame) Name() string {
+	return d.vm.proto.name
+}
+
+// Module returns the ID of the module defining the function.
+func (d *DebugFrame) Module() string {
+	return d.vm.proto.mod.ID()
+}
+
+// Line returns the line in the source code of the instruction currently
+// executing.
+func (d *DebugFrame) Line() int64 {
+	return d.vm.line()
+}
+
+// PC returns the index of the instruction currently executing.
+func (d *DebugFrame) PC() int {
+	if d.vm.pc == 0 {
+		return 0
+	}
+	return d.vm.pc - 1
+}
+
+// Instr returns the instruction currently executing.
+func (d *DebugFrame) Instr() bytecode.Instr {
+	return d.vm.proto.code[d.PC()]
+}
+
+// Depth returns the position of the function in the call stack, 0 being the
+// outermost function, or -1 if it is not in the call stack anymore.
+func (d *DebugFrame) Depth() int {
+	c := d.vm.proto.ktx
+	for i := c.frmsp - 1; i >= 0; i-- {
+		if c.frames[i].fvm == d.vm {
+			return i
+		}
+	}
+	return -1
+}
+
+// Locals returns the names of the local variables of the function, in order
+// of definition.
+func (d *DebugFrame) Locals() []string {
+	nms := make([]string, len(d.vm.proto.lTable))
+	copy(nms, d.vm.proto.lTable)
+	return nms
+}
+
+// Local returns the value of the local variable nm, and false if there is no
+// such variable.
+func (d *DebugFrame) Local(nm string) (Val, bool) {
+	for i, l := range d.vm.proto.lTable {
+		if l == nm {
+			return d.vm.vars[i], true
+		}
+	}
+	return Nil, false
+}
+
+// SetLocal sets the value of the local variable nm, and returns false if there
+// is no such variable.
+func (d *DebugFrame) SetLocal(nm string, v Val) bool {
+	for i, l := range d.vm.proto.lTable {
+		if l == nm {
+			d.vm.vars[i] = v
+			return true
+		}
+	}
+	return false
+}
+
+// Eval evaluates the agora expression expr in the scope of the function, i.e.
+// with access to its local variables and those of its enclosing functions, and
+// returns its value. The Compiler of the execution context must implement
+// GlobalsCompiler. The hook is notified of the execution of the expression,
+// as for any other code.
+func (d *DebugFrame) Eval(ctx context.Context, expr string) (Val, error) {
+	c := d.vm.proto.ktx
+	gc, ok := c.Compiler.(GlobalsCompiler)
+	if !ok {
+		return nil, errors.New("eval: the compiler does not accept globals")
+	}
+	scope := d.scope()
+	nms := c.Globals()
+	for k := range scope {
+		nms = append(nms, k.String(ctx))
+	}
+	f, err := gc.CompileWithGlobals(evalModuleID, strings.NewReader("return "+expr), nms)
+	if err != nil {
+		return nil, err
+	}
+	defer func(prev map[Val]Val) {
+		c.evalScope = prev
+	}(c.evalScope)
+	c.evalScope = scope
+	return newAgoraModule(f, c).Run(ctx)
+}
+
+// Get the variables visible by the function, by name.
+func (d *DebugFrame) scope() map[Val]Val {
+	vm := d.vm
+	scope := make(map[Val]Val)
+	for i, nm := range vm.proto.lTable {
+		scope[String(nm)] = vm.vars[i]
+	}
+	// The environment holds the variables of the enclosing functions
+	e := vm.val.env
+	for p := vm.proto.parent; p != nil && e != nil; p = p.parent {
+		for i, nm := range p.lTable {
+			if _, ok := scope[String(nm)]; !ok && i < len(e.upvals) {
+				scope[String(nm)] = e.upvals[i]
+			}
+		}
+		e = e.parent
+	}
+	return scope
+}
+
+// This returns the value of the `this` keyword, nil if the function is not
+// called as a method.
+func (d *DebugFrame) This() Val {
+	return d.vm.this
+}
+
+// Args returns the values of the `args` keyword.
+func (d *DebugFrame) Args() Val {
+	return d.vm.args
+}
+
+// Get the debug frame of the VM.
+func (f *agoraFuncVM) debugFrame() *DebugFrame {
+	if f.dbg == nil {
+		f.dbg = &DebugFrame{f}
+	}
+	return f.dbg
+}
+
+// Notify the hook of the line of the instruction i, if it is not the same
+// as the line of the previous instruction.
+func (f *agoraFuncVM) lineHook(ctx context.Context, h Hook, i bytecode.Instr) {
+	if l := f.line(); l != f.hookLine {
+		f.hookLine = l
+		h.Line(ctx, f.debugFrame(), i)
+	}
+}
+
+// Notify the hook that the function exits, either by returning the value ret,
+// or by panicking. It must be called in a defer statement.
+func (f *agoraFuncVM) exitHook(ctx context.Context, h Hook, ret *Val) {
+	f.hookLine = 0
+	if p := recover(); p != nil {
+		err := f.proto.ktx.newError(ctx, p, nil)
+		h.Panic(ctx, f.debugFrame(), err)
+		panic(err)
+	}
+	h.Exit(ctx, f.debugFrame(), *ret)
+}
@@ -0,0 +1,169 @@
+package runtime
+
+import (
+	"context"
+	"strings"
+)
+
+// An iterator produces the values of a `for range` loop. Its state is held
+// on the range stack of the function instance (VM) executing the loop, so
+// that a loop inside a coroutine can be resumed.
+type iterator interface {
+	// next returns the value of the next iteration, or false if the iteration
+	// is over.
+	next(context.Context) (Val, bool)
+}
+
+// Create the iterator for the range over args[0], the other args being the
+// arguments of the range. If meta is true, an object's `__iter` meta-method is
+// called, if it exists, and the range is over the value it returns instead.
+func newIterator(ctx context.Context, meta bool, args ...Val) iterator {
+	l := len(args)
+	switch t := Type(args[0]); t {
+	case "number":
+		it := &numberIter{
+			max: args[0].Int(ctx),
+			inc: 1,
+		}
+		if l > 1 {
+			it.cur = it.max
+			it.max = args[1].Int(ctx)
+		}
+		if l > 2 {
+			it.inc = args[2].Int(ctx)
+		}
+		return it
+
+	case "string":
+		it := &stringIter{
+			src: args[0].String(ctx),
+			max: -1,
+		}
+		if l > 1 && args[1].Bool(ctx) {
+			it.sep = args[1].String(ctx)
+		}
+		if l > 2 {
+			it.max = args[2].Int(ctx)
+		}
+		return it
+
+	case "object":
+		ob := args[0].(Object)
+		if meta {
+			if v, ok := ob.callMetaMethod(ctx, "__iter", args[1:]...); ok {
+				return newIterator(ctx, false, v)
+			}
+		}
+		ks := ob.Keys(ctx).(Object)
+		return &objectIter{
+			ob: ob,
+			ks: ks,
+			n:  ks.Len(ctx).Int(ctx),
+		}
+
+	case "func":
+		if afn, ok := args[0].(*agoraFuncVal); ok {
+			afn.reset()
+			return &funcIter{
+				fn:   afn,
+				args: args[1:],
+			}
+		}
+		panic(NewTypeError("native func", "", "range"))
+	}
+	panic(NewTypeError(Type(args[0]), "", "range"))
+}
+
+// A numberIter ranges over the numbers from cur to max (excluded), by inc.
+type numberIter struct {
+	cur, max, inc int64
+}
+
+func (it *numberIter) next(context.Context) (Val, bool) {
+	if (it.inc >= 0 && it.cur >= it.max) || (it.inc < 0 && it.cur <= it.max) {
+		return nil, false
+	}
+	v := Number(it.cur)
+	it.cur += it.inc
+	return v, true
+}
+
+// A stringIter ranges over the bytes of a string, or over its parts split by
+// a separator, up to max values (no limit if max is negative).
+type stringIter struct {
+	src  string
+	sep  string
+	max  int64
+	cnt  int64
+	done bool
+}
+
+func (it *stringIter) next(context.Context) (Val, bool) {
+	if it.done || (it.max >= 0 && it.cnt >= it.max) {
+		return nil, false
+	}
+	if it.sep == "" {
+		if it.cnt >= int64(len(it.src)) {
+			return nil, false
+		}
+		v := String(it.src[it.cnt])
+		it.cnt++
+		return v, true
+	}
+	splits := strings.SplitN(it.src, it.sep, 2)
+	it.cnt++
+	if len(splits) == 1 {
+		it.done = true
+	} else {
+		it.src = splits[1]
+	}
+	return String(splits[0]), true
+}
+
+// An objectIter ranges over the keys of an object, as they were when the loop
+// started, producing objects with the `k` and `v` fields.
+type objectIter struct {
+	ob Object
+	ks Object
+	n  int64
+	i  int64
+}
+
+func (it *objectIter) next(context.Context) (Val, bool) {
+	if it.i >= it.n {
+		return nil, false
+	}
+	key := it.ks.Get(Number(it.i))
+	it.i++
+	val := NewObject()
+	val.Set(String("k"), key)
+	val.Set(String("v"), it.ob.Get(key))
+	return val, true
+}
+
+// A funcIter ranges over the values yielded by a coroutine, until it returns.
+// The value returned by the `return` statement is not part of the range.
+type funcIter struct {
+	fn      *agoraFuncVal
+	args    []Val
+	started bool
+	done    bool
+}
+
+func (it *funcIter) next(ctx context.Context) (Val, bool) {
+	if it.done {
+		return nil, false
+	}
+	var v Val
+	if !it.started {
+		it.started = true
+		v = it.fn.Call(ctx, Nil, it.args...)
+	} else {
+		v = it.fn.Call(ctx, Nil)
+	}
+	if it.fn.status() != "suspended" {
+		it.done = true
+		return nil, false
+	}
+	return v, true
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// A Marshaler is a Go value that can convert itself to an agora value. It is
+// used by Marshal and Kontext.ToVal.
+type Marshaler interface {
+	MarshalAgora() (Val, error)
+}
+
+// An Unmarshaler is a Go value that can set itself from an agora value. It is
+// used by Unmarshal and Kontext.FromVal. It is never called with Nil, which
+// always sets the zero value.
+type Unmarshaler interface {
+	UnmarshalAgora(ctx context.Context, v Val) error
+}
+
+// Marshal converts the Go value v to an agora value, following the rules of
+// Kontext.ToVal, except that only data is converted: the methods of structs
+// are not exposed, and it is an error to marshal a func. The fields of a struct
+// with the `omitempty` tag option (i.e. `agora:"name,omitempty"`) are omitted
+// from the object if they are the zero value of their type. It returns a
+// CycleError if v refers to itself.
+func Marshal(v interface{}) (val Val, err error) {
+	defer PanicToError(&err)
+	var c *Kontext
+	return c.ToVal(v), nil
+}
+
+// Unmarshal converts the agora value v to the Go value pointed to by dst, which
+// must be a non-nil pointer, typically to a struct, map or slice. It follows
+// the rules of Kontext.FromVal, and returns a ConversionError that identifies
+// the path of the offending value if v cannot be converted.
+func Unmarshal(ctx context.Context, v Val, dst interface{}) (err error) {
+	defer PanicToError(&err)
+	var c *Kontext
+	return c.FromVal(ctx, v, dst)
+}
+
+// Get the Marshaler implemented by rv or by its address, if any.
+func marshaler(rv reflect.Value) (Marshaler, bool) {
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, false
+	}
+	if rv.Type().Implements(marshalerType) {
+		return rv.Interface().(Marshaler), true
+	}
+	if rv.CanAddr() && rv.Addr().Type().Implements(marshalerType) {
+		return rv.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+// Get the Unmarshaler implemented by the address of rv, if any.
+func unmarshaler(rv reflect.Value) (Unmarshaler, bool) {
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() && rv.Addr().Type().Implements(unmarshalerType) {
+		return rv.Addr().Interface().(Unmarshaler), true
+	}
+	return nil, false
+}
+
+// Check if the comma-separated list of tag options opts contains opt.
+func hasTagOption(opts, opt string) bool {
+	for opts != "" {
+		var o string
+		if ix := strings.Index(opts, ","); ix >= 0 {
+			o, opts = opts[:ix], opts[ix+1:]
+		} else {
+			o, opts = opts, ""
+		}
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
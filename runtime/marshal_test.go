@@ -0,0 +1,150 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testLevel int
+
+func (l testLevel) MarshalAgora() (Val, error) {
+	return String(strings.Repeat("*", int(l))), nil
+}
+
+func (l *testLevel) UnmarshalAgora(ctx context.Context, v Val) error {
+	s, ok := v.(String)
+	if !ok || strings.Trim(string(s), "*") != "" {
+		return fmt.Errorf("invalid level: %s", v.String(ctx))
+	}
+	*l = testLevel(len(s))
+	return nil
+}
+
+type testServer struct {
+	Host    string `agora:"host"`
+	Port    int    `agora:"port,omitempty"`
+	Level   testLevel
+	Servers []*testServer     `agora:"servers,omitempty"`
+	Env     map[string]string `agora:"env,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	ctx := context.Background()
+	cfg := testServer{
+		Host:  "a",
+		Level: 2,
+		Servers: []*testServer{
+			{Host: "b", Port: 8080},
+		},
+	}
+	v, err := Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob := v.(Object)
+	cases := map[string]Val{
+		"host":  String("a"),
+		"port":  Nil,
+		"Level": String("**"),
+		"env":   Nil,
+	}
+	for k, exp := range cases {
+		if got := ob.Get(String(k)); got != exp {
+			t.Errorf("%s: expected %v, got %v", k, exp, got)
+		}
+	}
+	srv := ob.Get(String("servers")).(Object).Get(Number(0)).(Object)
+	if p := srv.Get(String("port")); p != Number(8080) {
+		t.Errorf("expected servers[0].port to be 8080, got %v", p)
+	}
+	// Methods are not exposed
+	if m := ob.Get(String("MarshalAgora")); m != Nil {
+		t.Errorf("expected no method, got %v", m)
+	}
+
+	// Round-trip
+	var got testServer
+	if err := Unmarshal(ctx, v, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("expected %+v, got %+v", cfg, got)
+	}
+
+	// Cycles cannot be marshaled
+	cyclic := &testServer{Host: "c"}
+	cyclic.Servers = []*testServer{cyclic}
+	if _, err := Marshal(cyclic); !errors.As(err, new(CycleError)) {
+		t.Errorf("expected a CycleError when marshaling a cycle, got %v", err)
+	}
+
+	// Funcs cannot be marshaled
+	if _, err := Marshal(map[string]interface{}{"f": strings.ToUpper}); err == nil {
+		t.Errorf("expected an error when marshaling a func")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	ctx := context.Background()
+	child := NewObject()
+	child.Set(String("host"), String("b"))
+	child.Set(String("port"), String("80"))
+	ob := NewObject()
+	ob.Set(String("host"), String("a"))
+	ob.Set(String("servers"), NewArray(child))
+
+	var cfg testServer
+	err := Unmarshal(ctx, ob, &cfg)
+	var ce ConversionError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a ConversionError, got %v", err)
+	}
+	exp := "conversion error: cannot convert string to int for servers[0].port"
+	if err.Error() != exp {
+		t.Errorf("expected error `%s`, got `%s`", exp, err)
+	}
+
+	child.Set(String("port"), Number(80))
+	child.Set(String("Level"), String("x"))
+	if err := Unmarshal(ctx, ob, &cfg); err == nil || err.Error() != "invalid level: x" {
+		t.Errorf("expected the error of UnmarshalAgora, got %v", err)
+	}
+
+	child.Set(String("Level"), String("***"))
+	var m map[string]interface{}
+	if err := Unmarshal(ctx, ob, &m); err != nil {
+		t.Fatal(err)
+	}
+	expm := map[string]interface{}{
+		"host": "a",
+		"servers": []interface{}{
+			map[string]interface{}{"host": "b", "port": float64(80), "Level": "***"},
+		},
+	}
+	if !reflect.DeepEqual(m, expm) {
+		t.Errorf("expected %v, got %v", expm, m)
+	}
+	if err := Unmarshal(ctx, ob, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Servers[0].Level != 3 {
+		t.Errorf("expected level 3, got %d", cfg.Servers[0].Level)
+	}
+
+	// Valid input that cannot be converted returns an error, without panicking
+	var o testOuter
+	ob.Set(String("A"), Number(1))
+	if err := Unmarshal(ctx, ob, &o); !errors.As(err, &ce) {
+		t.Errorf("expected a ConversionError for an unexported embedded pointer, got %v", err)
+	}
+	ob.Set(String("__keys"), NewNativeFunc(nil, "__keys", func(ctx context.Context, args ...Val) Val {
+		return Nil
+	}))
+	if err := Unmarshal(ctx, ob, &m); err == nil {
+		t.Errorf("expected an error for invalid keys")
+	}
+}
@@ -38,17 +38,22 @@ type NativeModule interface {
 
 // An agora module holds its ID, its function table, and the value it returned.
 type agoraModule struct {
-	id  string
-	fns []*agoraFuncDef
-	v   Val
+	id         string
+	fns        []*agoraFuncDef
+	v          Val
+	globalVars bool // assigns global variables, see bytecode.File.GlobalVars
 }
 
 // Create a new agora module from the specified bytecode file and for the specified
 // execution context.
 func newAgoraModule(f *bytecode.File, c *Kontext) *agoraModule {
 	m := &agoraModule{
-		id: f.Name,
+		id:         f.Name,
+		globalVars: f.GlobalVars,
 	}
+	// Variables are resolved to slots by the compiler, but the file may have been
+	// generated by an older version or written by hand in assembly.
+	f.ResolveVars()
 	// Define all functions
 	m.fns = make([]*agoraFuncDef, len(f.Fns))
 	for i, fn := range f.Fns {
@@ -56,7 +61,11 @@ func newAgoraModule(f *bytecode.File, c *Kontext) *agoraModule {
 		af.name = fn.Header.Name
 		af.stackSz = fn.Header.StackSz
 		af.expArgs = fn.Header.ExpArgs
-		// TODO : Ignore LineStart and LineEnd at the moment, unused.
+		af.lineStart = fn.Header.LineStart
+		af.lineEnd = fn.Header.LineEnd
+		if i > 0 && fn.Header.ParentFnIx < int64(i) {
+			af.parent = m.fns[fn.Header.ParentFnIx]
+		}
 		m.fns[i] = af
 		af.kTable = make([]Val, len(fn.Ks))
 		for j, k := range fn.Ks {
@@ -81,11 +90,14 @@ func newAgoraModule(f *bytecode.File, c *Kontext) *agoraModule {
 		for j, ins := range fn.Is {
 			af.code[j] = ins
 		}
+		af.ps = fn.Ps
 	}
 	return m
 }
 
-// Run executes the module and returns its return value, or an error.
+// Run executes the module and returns its return value, or an error. If
+// the module's execution panics, the error is an *Error that holds the
+// agora call stack.
 func (m *agoraModule) Run(ctx context.Context, args ...Val) (v Val, err error) {
 	defer PanicToError(&err)
 	if len(m.fns) == 0 {
@@ -94,6 +106,10 @@ func (m *agoraModule) Run(ctx context.Context, args ...Val) (v Val, err error) {
 	// Do not re-run a module if it has already been imported. Use the cached value.
 	if m.v == nil {
 		fn := m.fns[0]
+		if fn.ktx.frmsp == 0 {
+			// Not imported by another module, this is a new execution
+			fn.ktx.resetBudgets()
+		}
 		fn.ktx.pushModule(m.ID())
 		defer fn.ktx.popModule(m.ID())
 		fv := newAgoraFuncVal(fn, nil)
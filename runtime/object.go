@@ -9,6 +9,10 @@ import (
 type (
 	// This error is raised if a non-existing method is called.
 	NoSuchMethodError string
+
+	// This error is raised if setting a prototype would create a cycle in the
+	// prototype chain.
+	ProtoCycleError string
 )
 
 // Error interface implementation.
@@ -21,28 +25,52 @@ func NewNoSuchMethodError(m string) NoSuchMethodError {
 	return NoSuchMethodError(fmt.Sprintf("no such method: %s", m))
 }
 
+// Error interface implementation.
+func (e ProtoCycleError) Error() string {
+	return string(e)
+}
+
+// Create a new ProtoCycleError.
+func NewProtoCycleError() ProtoCycleError {
+	return ProtoCycleError("prototype cycle")
+}
+
 // The Object interface represents an agora object, which is an associative array.
 // It can get and set keys, retrieve the length, the list of keys, and call methods
-// and meta-methods.
+// and meta-methods. An object may have a prototype, another object in which the
+// keys that are not found on the object itself are looked up.
 type Object interface {
 	Val
 	Get(Val) Val
 	Set(Val, Val)
 	Len(context.Context) Val
 	Keys(context.Context) Val
+	Proto() Object
+	SetProto(Object)
 	callMethod(context.Context, Val, ...Val) Val
 	callMetaMethod(context.Context, string, ...Val) (Val, bool)
 }
 
 // An object is a map of values, an associative array.
 type object struct {
-	m map[Val]Val
+	m     map[Val]Val
+	proto Object
 }
 
 // NewObject returns a new instance of an object.
 func NewObject() Object {
 	return &object{
-		make(map[Val]Val),
+		m: make(map[Val]Val),
+	}
+}
+
+// Panic with a ProtoCycleError if setting the prototype p on the object o
+// would create a cycle in the prototype chain.
+func checkProtoCycle(o, p Object) {
+	for c := p; c != nil; c = c.Proto() {
+		if c == o {
+			panic(NewProtoCycleError())
+		}
 	}
 }
 
@@ -56,10 +84,8 @@ func (o *object) Dump() string {
 }
 
 func (o *object) callMetaMethod(ctx context.Context, nm string, args ...Val) (Val, bool) {
-	if mm, ok := o.m[String(nm)]; ok {
-		if f, ok := mm.(Func); ok {
-			return f.Call(ctx, o, args...), true
-		}
+	if f, ok := o.Get(String(nm)).(Func); ok {
+		return f.Call(ctx, o, args...), true
 	}
 	return nil, false
 }
@@ -89,6 +115,11 @@ func (o *object) String(ctx context.Context) string {
 		return v.String(ctx)
 	}
 	// Otherwise print the object's contents
+	return objectString(ctx, o)
+}
+
+// Print the keys and values of the object.
+func objectString(ctx context.Context, o Object) string {
 	buf := bytes.NewBuffer(nil)
 	buf.WriteByte('{')
 	keys := o.Keys(ctx).(Object)
@@ -125,7 +156,8 @@ func (o *object) Native(ctx context.Context) interface{} {
 	return o.m
 }
 
-// Get the length of the object. The behaviour can be overridden
+// Get the length of the object. Only the keys of the object itself are
+// counted, not those of its prototype. The behaviour can be overridden
 // if a `__len` method is available on the object.
 func (o *object) Len(ctx context.Context) Val {
 	if v, ok := o.callMetaMethod(ctx, "__len"); ok {
@@ -134,33 +166,51 @@ func (o *object) Len(ctx context.Context) Val {
 	return Number(len(o.m))
 }
 
-// Get the keys of the object in an array-like object value,
-// indexed from 0 the the number of keys - 1. It is the responsibility
-// of the object's implementation to return coherent values for Len()
-// and Keys(). The list of keys is unordered.
+// Get the keys of the object in an array value, indexed from 0 the
+// the number of keys - 1. It is the responsibility of the object's
+// implementation to return coherent values for Len() and Keys().
+// The list of keys is unordered, and doesn't include the keys of
+// the prototype.
 func (o *object) Keys(ctx context.Context) Val {
 	if v, ok := o.callMetaMethod(ctx, "__keys"); ok {
 		return v
 	}
-	ob := NewObject()
-	i := 0
+	ks := &array{
+		s: make([]Val, 0, len(o.m)),
+	}
 	for k, _ := range o.m {
-		ob.Set(Number(i), k)
-		i++
+		ks.s = append(ks.s, k)
 	}
-	return ob
+	return ks
 }
 
-// Get returns the value of the field identified by key. It returns Nil
-// if the field does not exist.
+// Get returns the value of the field identified by key. If the field does not
+// exist, it is looked up in the prototype chain, and Nil is returned if it
+// isn't found.
 func (o *object) Get(key Val) Val {
 	if v, ok := o.m[key]; ok {
 		return v
 	}
+	if o.proto != nil {
+		return o.proto.Get(key)
+	}
 	return Nil
 }
 
-// Set assigns the value v to the field identified by key. If the value
+// Proto returns the prototype of the object, or nil if it has none.
+func (o *object) Proto() Object {
+	return o.proto
+}
+
+// SetProto sets the prototype of the object. A nil prototype removes it.
+// It panics if the prototype chain would contain a cycle.
+func (o *object) SetProto(p Object) {
+	checkProtoCycle(o, p)
+	o.proto = p
+}
+
+// Set assigns the value v to the field identified by key. The field is always
+// set on the object itself, never on its prototype. If the value
 // is Nil, set instead removes the key from the object. If the key is nil,
 // an error is raised.
 func (o *object) Set(key Val, v Val) {
@@ -177,8 +227,7 @@ func (o *object) Set(key Val, v Val) {
 // It panics if the field does not hold a function. If the field does not
 // exist and a method named `__noSuchMethod` is defined, it is called instead.
 func (o *object) callMethod(ctx context.Context, nm Val, args ...Val) Val {
-	v, ok := o.m[nm]
-	if ok {
+	if v := o.Get(nm); v != Nil {
 		if f, ok := v.(Func); ok {
 			return f.Call(ctx, o, args...)
 		} else {
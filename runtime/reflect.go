@@ -0,0 +1,691 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	valType     = reflect.TypeOf((*Val)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// A ConversionError is returned when an agora value cannot be converted to
+// the expected Go type.
+type ConversionError struct {
+	Value Val          // The agora value
+	To    reflect.Type // The expected Go type
+	Path  string       // The parameter, field or index of the value, if any
+}
+
+// Error interface implementation.
+func (e ConversionError) Error() string {
+	from := Type(e.Value)
+	if n, ok := e.Value.(Number); ok {
+		from += " " + strconv.FormatFloat(float64(n), 'f', -1, 64)
+	}
+	msg := fmt.Sprintf("conversion error: cannot convert %s to %s", from, e.To)
+	if e.Path != "" {
+		msg += " for " + e.Path
+	}
+	return msg
+}
+
+// A CycleError is raised when a Go value that refers to itself, through
+// pointers, maps or slices, is converted to an agora value.
+type CycleError struct {
+	Type reflect.Type // The type of the value that refers to itself
+}
+
+// Error interface implementation.
+func (e CycleError) Error() string {
+	return fmt.Sprintf("conversion error: cycle in value of type %s", e.Type)
+}
+
+// ToVal converts the Go value v to an agora value:
+//
+//   - nil and nil pointers, slices, maps and funcs are converted to Nil.
+//   - A Val is returned as-is.
+//   - A Marshaler is converted by its MarshalAgora method.
+//   - Booleans, numbers and strings are converted to Bool, Number and String.
+//   - A []byte is converted to a String.
+//   - Slices and arrays are converted to array-like objects.
+//   - Maps are converted to objects, with their keys converted too.
+//   - Structs are converted to objects with the exported fields of the struct,
+//     and its exported methods (those of the pointer, if v is a pointer).
+//   - Funcs are converted to native functions, see NewGoFunc.
+//   - Pointers and interfaces are converted to the value they point to.
+//
+// The fields of a struct are named after the `agora` tag of the field if
+// it is set, i.e. `agora:"name"`, otherwise after the name of the field. A
+// field with the tag `agora:"-"` is ignored, and a field with the `omitempty`
+// option, i.e. `agora:"name,omitempty"`, is ignored if it is the zero value of
+// its type. The fields of an embedded struct without a tag are promoted to the
+// object.
+//
+// The conversion creates new values, so that changes to an object are not
+// reflected on the Go value, but the methods are called on the Go value. It
+// panics if v is of a type that cannot be converted, such as a channel, and
+// with a CycleError if v refers to itself.
+func (c *Kontext) ToVal(v interface{}) Val {
+	if v == nil {
+		return Nil
+	}
+	if vv, ok := v.(Val); ok {
+		return vv
+	}
+	return c.toVal(reflect.ValueOf(v), nil)
+}
+
+// A visit is a pointer, map or slice being converted by toVal. A slice is
+// identified by its length too, as it shares its pointer with its sub-slices.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// Mark the pointer, map or slice rv as being converted in seen, which is
+// allocated if it is nil, and panic with a CycleError if it already is. The
+// visit must be removed from seen once rv is converted.
+func enter(seen map[visit]bool, rv reflect.Value) (map[visit]bool, visit) {
+	k := visit{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		k.len = rv.Len()
+	}
+	if seen[k] {
+		panic(CycleError{rv.Type()})
+	}
+	if seen == nil {
+		seen = make(map[visit]bool)
+	}
+	seen[k] = true
+	return seen, k
+}
+
+// Convert rv to an agora value, seen being the pointers, maps and slices
+// being converted, to detect the cycles.
+func (c *Kontext) toVal(rv reflect.Value, seen map[visit]bool) Val {
+	if !rv.IsValid() {
+		return Nil
+	}
+	if rv.Type().Implements(valType) {
+		if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+			return Nil
+		}
+		return rv.Interface().(Val)
+	}
+	if m, ok := marshaler(rv); ok {
+		v, err := m.MarshalAgora()
+		if err != nil {
+			panic(err)
+		}
+		return v
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return Bool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Number(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return Number(rv.Float())
+	case reflect.String:
+		return String(rv.String())
+	case reflect.Interface:
+		if rv.IsNil() {
+			return Nil
+		}
+		return c.toVal(rv.Elem(), seen)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return Nil
+		}
+		seen, k := enter(seen, rv)
+		defer delete(seen, k)
+		if rv.Elem().Kind() == reflect.Struct {
+			return c.structToVal(rv.Elem(), rv, seen)
+		}
+		return c.toVal(rv.Elem(), seen)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return Nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return String(rv.Bytes())
+		}
+		seen, k := enter(seen, rv)
+		defer delete(seen, k)
+		return c.arrayToVal(rv, seen)
+	case reflect.Array:
+		return c.arrayToVal(rv, seen)
+	case reflect.Map:
+		if rv.IsNil() {
+			return Nil
+		}
+		seen, k := enter(seen, rv)
+		defer delete(seen, k)
+		ob := NewObject()
+		for _, key := range rv.MapKeys() {
+			ob.Set(c.toVal(key, seen), c.toVal(rv.MapIndex(key), seen))
+		}
+		return ob
+	case reflect.Struct:
+		return c.structToVal(rv, rv, seen)
+	case reflect.Func:
+		if rv.IsNil() {
+			return Nil
+		}
+		if c == nil {
+			// Marshal, there is no execution context to call the func
+			break
+		}
+		nm := rv.Type().String()
+		if f := goruntime.FuncForPC(rv.Pointer()); f != nil {
+			nm = f.Name()
+		}
+		return c.newGoFunc(nm, rv, nil)
+	}
+	panic(NewTypeError(rv.Type().String(), "", "conversion to agora value"))
+}
+
+// Convert the slice or array rv to an array-like object.
+func (c *Kontext) arrayToVal(rv reflect.Value, seen map[visit]bool) Val {
+	vals := make([]Val, rv.Len())
+	for i := range vals {
+		vals[i] = c.toVal(rv.Index(i), seen)
+	}
+	return NewArray(vals...)
+}
+
+// Convert the struct sv to an object, with the methods of mv, which is either
+// the struct itself or a pointer to it.
+func (c *Kontext) structToVal(sv, mv reflect.Value, seen map[visit]bool) Val {
+	ob := NewObject()
+	for _, f := range structFields(sv.Type()) {
+		if fv, ok := fieldByIndex(sv, f.index, false); ok && !(f.omitEmpty && fv.IsZero()) {
+			ob.Set(String(f.name), c.toVal(fv, seen))
+		}
+	}
+	if c == nil {
+		// Marshal, only the fields are converted
+		return ob
+	}
+	t := mv.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		ob.Set(String(m.Name), c.newGoFunc(sv.Type().String()+"."+m.Name, mv.Method(i), nil))
+	}
+	return ob
+}
+
+// A structField is an exported field of a struct, named after its tag.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// Get the exported fields of the struct type t, including the promoted fields
+// of embedded structs without a tag.
+func structFields(t reflect.Type) []structField {
+	var fs []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("agora")
+		if tag == "-" {
+			continue
+		}
+		nm, opts := tag, ""
+		if ix := strings.Index(tag, ","); ix >= 0 {
+			nm, opts = tag[:ix], tag[ix+1:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && nm == "" && ft.Kind() == reflect.Struct {
+			for _, ef := range structFields(ft) {
+				ef.index = append([]int{i}, ef.index...)
+				fs = append(fs, ef)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+		if nm == "" {
+			nm = f.Name
+		}
+		fs = append(fs, structField{nm, f.Index, hasTagOption(opts, "omitempty")})
+	}
+	return fs
+}
+
+// Get the field of the struct sv identified by index, going through the embedded
+// structs. If alloc is true, nil pointers to embedded structs are allocated,
+// otherwise false is returned if one of them is nil. False is returned too if
+// a nil pointer cannot be allocated, because its struct type is unexported.
+func fieldByIndex(sv reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && sv.Kind() == reflect.Ptr {
+			if sv.IsNil() {
+				if !alloc || !sv.CanSet() {
+					return reflect.Value{}, false
+				}
+				sv.Set(reflect.New(sv.Type().Elem()))
+			}
+			sv = sv.Elem()
+		}
+		sv = sv.Field(x)
+	}
+	return sv, true
+}
+
+// NewGoFunc returns a native function that calls the Go function fn, which
+// must be a func. The arguments received from agora are converted to the
+// types of the parameters of fn, as described by FromVal, and missing
+// arguments are the zero value of their type. If the first parameter of fn
+// is a context.Context, the context of the call is passed. The names of the
+// parameters may be provided so that conversion errors refer to them,
+// otherwise they are referred to by position.
+//
+// The values returned by fn are converted with ToVal. If the last value returned
+// is an error, it is not returned to agora, the native function panics with it
+// if it is not nil.
+func (c *Kontext) NewGoFunc(nm string, fn interface{}, params ...string) *NativeFunc {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func {
+		panic(NewTypeError(rv.Type().String(), "", "conversion to func"))
+	}
+	return c.newGoFunc(nm, rv, params)
+}
+
+func (c *Kontext) newGoFunc(nm string, fn reflect.Value, params []string) *NativeFunc {
+	t := fn.Type()
+	return NewNativeFunc(c, nm, func(ctx context.Context, args ...Val) Val {
+		n := t.NumIn()
+		in := make([]reflect.Value, 0, n)
+		first := 0
+		if n > 0 && t.In(0) == contextType {
+			in = append(in, reflect.ValueOf(ctx))
+			first = 1
+		}
+		fixed := n
+		if t.IsVariadic() {
+			fixed--
+		}
+		// Name the parameter at position i (ignoring the context)
+		param := func(i int) string {
+			if i < len(params) {
+				return params[i]
+			}
+			return fmt.Sprintf("argument %d", i+1)
+		}
+		for i := first; i < fixed; i++ {
+			var a Val = Nil
+			if j := i - first; j < len(args) {
+				a = args[j]
+			}
+			pv := reflect.New(t.In(i)).Elem()
+			if err := c.fromVal(ctx, a, pv, param(i-first)); err != nil {
+				panic(err)
+			}
+			in = append(in, pv)
+		}
+		if t.IsVariadic() {
+			et := t.In(fixed).Elem()
+			for j := fixed - first; j < len(args); j++ {
+				pv := reflect.New(et).Elem()
+				if err := c.fromVal(ctx, args[j], pv, param(j)); err != nil {
+					panic(err)
+				}
+				in = append(in, pv)
+			}
+		}
+		out := fn.Call(in)
+		if l := len(out); l > 0 && t.Out(l-1) == errorType {
+			if err := out[l-1]; !err.IsNil() {
+				panic(err.Interface().(error))
+			}
+			out = out[:l-1]
+		}
+		vals := make([]Val, len(out))
+		for i, o := range out {
+			vals[i] = c.toVal(o, nil)
+		}
+		return NewValues(vals...)
+	})
+}
+
+// FromVal converts the agora value v to the Go value pointed to by dst, which
+// must be a non-nil pointer. It returns a ConversionError if the value cannot
+// be converted to the Go type:
+//
+//   - Nil is converted to the zero value of the type.
+//   - If the type implements Val, v is stored as-is if it is of this type.
+//   - If a pointer to the type implements Unmarshaler, its UnmarshalAgora
+//     method is called.
+//   - Bool, Number and String are converted to booleans, numbers and strings,
+//     numbers must be integral to be converted to integers, and must fit in
+//     the type.
+//   - A String is converted to a []byte.
+//   - An array-like object is converted to a slice or an array.
+//   - An object is converted to a map, with its keys converted too, or to a
+//     struct, named after the `agora` tag of the fields as described by ToVal.
+//     The keys of the object that are not fields of the struct are ignored.
+//   - A Func is converted to a func, that calls it with the arguments converted
+//     by ToVal, and converts its return values to the return types of the func.
+//     If the last return type is an error, the error raised by the call is
+//     returned, otherwise the func panics. The ctx is used for the calls.
+//   - For an empty interface, numbers, strings and booleans are converted to
+//     float64, string and bool, array-like objects to []interface{}, other
+//     objects to map[string]interface{}, and other values are stored as-is.
+//
+// The panics raised while getting the keys and values of objects, such as in
+// a meta-method, are returned as errors.
+func (c *Kontext) FromVal(ctx context.Context, v Val, dst interface{}) (err error) {
+	defer PanicToError(&err)
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("conversion error: expected a non-nil pointer, got %T", dst)
+	}
+	return c.fromVal(ctx, v, rv.Elem(), "")
+}
+
+func (c *Kontext) fromVal(ctx context.Context, v Val, rv reflect.Value, path string) error {
+	t := rv.Type()
+	if v == nil {
+		v = Nil
+	}
+	if t.Implements(valType) && reflect.TypeOf(v).AssignableTo(t) {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if v == Nil {
+		rv.Set(reflect.Zero(t))
+		return nil
+	}
+	if u, ok := unmarshaler(rv); ok {
+		return u.UnmarshalAgora(ctx, v)
+	}
+	convErr := ConversionError{v, t, path}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := v.(Bool)
+		if !ok {
+			return convErr
+		}
+		rv.SetBool(bool(b))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(Number)
+		if !ok || float64(int64(n)) != float64(n) || rv.OverflowInt(int64(n)) {
+			return convErr
+		}
+		rv.SetInt(int64(n))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := v.(Number)
+		if !ok || n < 0 || float64(uint64(n)) != float64(n) || rv.OverflowUint(uint64(n)) {
+			return convErr
+		}
+		rv.SetUint(uint64(n))
+
+	case reflect.Float32, reflect.Float64:
+		n, ok := v.(Number)
+		if !ok {
+			return convErr
+		}
+		rv.SetFloat(float64(n))
+
+	case reflect.String:
+		s, ok := v.(String)
+		if !ok {
+			return convErr
+		}
+		rv.SetString(string(s))
+
+	case reflect.Slice:
+		if s, ok := v.(String); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		ob, ok := v.(Object)
+		if !ok {
+			return convErr
+		}
+		n := int(ob.Len(ctx).Int(ctx))
+		sl := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			if err := c.fromVal(ctx, ob.Get(Number(i)), sl.Index(i), indexPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		rv.Set(sl)
+
+	case reflect.Array:
+		ob, ok := v.(Object)
+		if !ok {
+			return convErr
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := c.fromVal(ctx, ob.Get(Number(i)), rv.Index(i), indexPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		ob, ok := v.(Object)
+		if !ok {
+			return convErr
+		}
+		m := reflect.MakeMap(t)
+		ks, ok := ob.Keys(ctx).(Object)
+		if !ok {
+			return convErr
+		}
+		for i, n := int64(0), ks.Len(ctx).Int(ctx); i < n; i++ {
+			k := ks.Get(Number(i))
+			kp := indexPath(path, k.String(ctx))
+			kv := reflect.New(t.Key()).Elem()
+			if err := c.fromVal(ctx, k, kv, kp); err != nil {
+				return err
+			}
+			vv := reflect.New(t.Elem()).Elem()
+			if err := c.fromVal(ctx, ob.Get(k), vv, kp); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, vv)
+		}
+		rv.Set(m)
+
+	case reflect.Struct:
+		ob, ok := v.(Object)
+		if !ok {
+			return convErr
+		}
+		for _, f := range structFields(t) {
+			fv := ob.Get(String(f.name))
+			if fv == Nil {
+				continue
+			}
+			fp := f.name
+			if path != "" {
+				fp = path + "." + f.name
+			}
+			sf, ok := fieldByIndex(rv, f.index, true)
+			if !ok {
+				// Through a nil pointer to an unexported embedded struct
+				return ConversionError{fv, t.FieldByIndex(f.index).Type, fp}
+			}
+			if err := c.fromVal(ctx, fv, sf, fp); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return c.fromVal(ctx, v, rv.Elem(), path)
+
+	case reflect.Func:
+		fn, ok := v.(Func)
+		if !ok {
+			return convErr
+		}
+		rv.Set(c.makeFunc(ctx, fn, t))
+
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			if !reflect.TypeOf(v).Implements(t) {
+				return convErr
+			}
+			rv.Set(reflect.ValueOf(v))
+			return nil
+		}
+		rv.Set(reflect.ValueOf(c.toInterface(ctx, v)))
+
+	default:
+		return convErr
+	}
+	return nil
+}
+
+// Get the path of the value at index ix of the value at path.
+func indexPath(path, ix string) string {
+	return path + "[" + ix + "]"
+}
+
+// Convert v to the natural Go value for an empty interface.
+func (c *Kontext) toInterface(ctx context.Context, v Val) interface{} {
+	switch t := v.(type) {
+	case Number:
+		return float64(t)
+	case String:
+		return string(t)
+	case Bool:
+		return bool(t)
+	case *array:
+		vals := make([]interface{}, t.Len(ctx).Int(ctx))
+		for i := range vals {
+			vals[i] = c.toInterface(ctx, t.Get(Number(i)))
+		}
+		return vals
+	case Object:
+		m := make(map[string]interface{})
+		keys := t.Keys(ctx)
+		ks, ok := keys.(Object)
+		if !ok {
+			panic(NewTypeError(Type(keys), "", "keys of an object"))
+		}
+		for i, n := int64(0), ks.Len(ctx).Int(ctx); i < n; i++ {
+			k := ks.Get(Number(i))
+			m[k.String(ctx)] = c.toInterface(ctx, t.Get(k))
+		}
+		return m
+	}
+	if v == Nil {
+		return nil
+	}
+	return v
+}
+
+// Create a Go func of type t that calls the agora function fn.
+func (c *Kontext) makeFunc(ctx context.Context, fn Func, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) (out []reflect.Value) {
+		nOut := t.NumOut()
+		hasErr := nOut > 0 && t.Out(nOut-1) == errorType
+		if hasErr {
+			nOut--
+		}
+		// Return the zero values and the error, if there is an error result
+		fail := func(err error) []reflect.Value {
+			if !hasErr {
+				panic(err)
+			}
+			res := make([]reflect.Value, nOut+1)
+			for i := 0; i < nOut; i++ {
+				res[i] = reflect.Zero(t.Out(i))
+			}
+			res[nOut] = reflect.ValueOf(&err).Elem()
+			return res
+		}
+		if hasErr {
+			defer func() {
+				if p := recover(); p != nil {
+					if err, ok := p.(error); ok {
+						out = fail(err)
+					} else {
+						out = fail(fmt.Errorf("%v", p))
+					}
+				}
+			}()
+		}
+		var args []Val
+		for i, a := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < a.Len(); j++ {
+					args = append(args, c.toVal(a.Index(j), nil))
+				}
+				continue
+			}
+			args = append(args, c.toVal(a, nil))
+		}
+		vals := Unpack(fn.Call(ctx, nil, args...), nOut)
+		out = make([]reflect.Value, 0, t.NumOut())
+		for i, v := range vals {
+			ov := reflect.New(t.Out(i)).Elem()
+			if err := c.fromVal(ctx, v, ov, fmt.Sprintf("result %d", i+1)); err != nil {
+				return fail(err)
+			}
+			out = append(out, ov)
+		}
+		if hasErr {
+			out = append(out, reflect.Zero(errorType))
+		}
+		return out
+	})
+}
+
+// NewGoModule returns a native module identified by id, whose value is the Go
+// value v converted to an agora value with Kontext.ToVal, typically a struct
+// with methods or a map of funcs.
+func NewGoModule(id string, v interface{}) NativeModule {
+	return &goModule{id: id, v: v}
+}
+
+// A goModule is a native module implemented by a Go value.
+type goModule struct {
+	ktx *Kontext
+	id  string
+	v   interface{}
+	val Val
+}
+
+// ID returns the identifier of the module.
+func (m *goModule) ID() string {
+	return m.id
+}
+
+// Run returns the value of the module.
+func (m *goModule) Run(_ context.Context, _ ...Val) (v Val, err error) {
+	defer PanicToError(&err)
+	if m.val == nil {
+		m.val = m.ktx.ToVal(m.v)
+	}
+	return m.val, nil
+}
+
+// SetKtx sets the execution context of the module.
+func (m *goModule) SetKtx(c *Kontext) {
+	m.ktx = c
+}
@@ -0,0 +1,314 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testBase struct {
+	ID int
+}
+
+type testPoint struct {
+	testBase
+	X, Y    float64
+	Label   string `agora:"label"`
+	Hidden  string `agora:"-"`
+	private int
+	Tags    []string
+	Meta    map[string]int
+	Next    *testPoint
+}
+
+func (p *testPoint) Move(dx, dy float64) {
+	p.X += dx
+	p.Y += dy
+}
+
+func (p testPoint) Sum() float64 {
+	return p.X + p.Y
+}
+
+func TestToVal(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+	p := &testPoint{
+		testBase: testBase{ID: 7},
+		X:        1,
+		Y:        2,
+		Label:    "a",
+		Hidden:   "h",
+		Tags:     []string{"x", "y"},
+		Meta:     map[string]int{"k": 3},
+	}
+	ob, ok := ktx.ToVal(p).(Object)
+	if !ok {
+		t.Fatalf("expected an object")
+	}
+	cases := map[string]Val{
+		"ID":     Number(7),
+		"X":      Number(1),
+		"Y":      Number(2),
+		"label":  String("a"),
+		"Label":  Nil,
+		"Hidden": Nil,
+		"Next":   Nil,
+	}
+	for k, exp := range cases {
+		if got := ob.Get(String(k)); got != exp {
+			t.Errorf("%s: expected %v, got %v", k, exp, got)
+		}
+	}
+	if tags := ob.Get(String("Tags")).(Object); tags.Get(Number(1)) != String("y") {
+		t.Errorf("expected Tags[1] to be `y`, got %v", tags.Get(Number(1)))
+	}
+	if meta := ob.Get(String("Meta")).(Object); meta.Get(String("k")) != Number(3) {
+		t.Errorf("expected Meta.k to be 3, got %v", meta.Get(String("k")))
+	}
+	// Methods are called on the Go value
+	ob.callMethod(ctx, String("Move"), Number(1), Number(1))
+	if p.X != 2 || p.Y != 3 {
+		t.Errorf("expected point to be moved to 2,3, got %v,%v", p.X, p.Y)
+	}
+	if s := ob.callMethod(ctx, String("Sum")); s != Number(5) {
+		t.Errorf("expected Sum to return 5, got %v", s)
+	}
+	// Other conversions
+	if v := ktx.ToVal([]byte("abc")); v != String("abc") {
+		t.Errorf("expected []byte to be a String, got %v", v)
+	}
+	if v := ktx.ToVal((*testPoint)(nil)); v != Nil {
+		t.Errorf("expected nil pointer to be Nil, got %v", v)
+	}
+	if v := ktx.ToVal(String("s")); v != String("s") {
+		t.Errorf("expected Val to be returned as-is, got %v", v)
+	}
+}
+
+func TestToValCycle(t *testing.T) {
+	ktx := NewKtx(nil, nil)
+	// A value referred to twice is not a cycle
+	shared := &testPoint{X: 1}
+	ob := ktx.ToVal([]*testPoint{shared, shared}).(Object)
+	if p := ob.Get(Number(1)).(Object); p.Get(String("X")) != Number(1) {
+		t.Errorf("expected the shared point, got %v", p)
+	}
+
+	p := &testPoint{}
+	p.Next = p
+	m := map[string]interface{}{}
+	m["self"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	for i, v := range []interface{}{p, m, s} {
+		func() {
+			defer func() {
+				e, ok := recover().(CycleError)
+				if !ok {
+					t.Errorf("[%d] - expected a CycleError, got %v", i, e)
+				} else if e.Type != reflect.TypeOf(v) {
+					t.Errorf("[%d] - expected a cycle of %T, got %s", i, v, e.Type)
+				}
+			}()
+			ktx.ToVal(v)
+		}()
+	}
+}
+
+func TestFromVal(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+	ob := NewObject()
+	ob.Set(String("ID"), Number(3))
+	ob.Set(String("X"), Number(1.5))
+	ob.Set(String("label"), String("lbl"))
+	ob.Set(String("Hidden"), String("h"))
+	ob.Set(String("Tags"), NewArray(String("a"), String("b")))
+	meta := NewObject()
+	meta.Set(String("k"), Number(4))
+	ob.Set(String("Meta"), meta)
+	next := NewObject()
+	next.Set(String("Y"), Number(9))
+	ob.Set(String("Next"), next)
+
+	var p testPoint
+	if err := ktx.FromVal(ctx, ob, &p); err != nil {
+		t.Fatal(err)
+	}
+	exp := testPoint{
+		testBase: testBase{ID: 3},
+		X:        1.5,
+		Label:    "lbl",
+		Tags:     []string{"a", "b"},
+		Meta:     map[string]int{"k": 4},
+		Next:     &testPoint{Y: 9},
+	}
+	if !reflect.DeepEqual(p, exp) {
+		t.Errorf("expected %+v, got %+v", exp, p)
+	}
+
+	// Errors name the path of the value
+	next.Set(String("Tags"), NewArray(String("a"), Number(2)))
+	err := ktx.FromVal(ctx, ob, &p)
+	var ce ConversionError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a ConversionError, got %v", err)
+	}
+	if ce.Path != "Next.Tags[1]" {
+		t.Errorf("expected path `Next.Tags[1]`, got `%s`", ce.Path)
+	}
+	var n int
+	if err := ktx.FromVal(ctx, Number(1.5), &n); err == nil {
+		t.Errorf("expected an error for a non-integral number")
+	}
+	var i interface{}
+	if err := ktx.FromVal(ctx, NewArray(Number(1), String("a")), &i); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(i, []interface{}{float64(1), "a"}) {
+		t.Errorf("expected []interface{}{1, a}, got %#v", i)
+	}
+	if err := ktx.FromVal(ctx, Number(1), n); err == nil {
+		t.Errorf("expected an error for a non-pointer destination")
+	}
+}
+
+type testInner struct {
+	A int
+}
+
+type testOuter struct {
+	*testInner
+	B int
+}
+
+func TestFromValErrors(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+
+	// A nil pointer to an unexported embedded struct cannot be allocated
+	ob := NewObject()
+	ob.Set(String("A"), Number(1))
+	ob.Set(String("B"), Number(2))
+	var o testOuter
+	var ce ConversionError
+	if err := ktx.FromVal(ctx, ob, &o); !errors.As(err, &ce) || ce.Path != "A" {
+		t.Errorf("expected a ConversionError for A, got %v", err)
+	}
+	o.testInner = new(testInner)
+	if err := ktx.FromVal(ctx, ob, &o); err != nil || o.A != 1 || o.B != 2 {
+		t.Errorf("expected A and B to be set, got %+v (%v)", o, err)
+	}
+
+	// The __keys meta-method does not return an object
+	ob = NewObject()
+	ob.Set(String("__keys"), NewNativeFunc(ktx, "__keys", func(ctx context.Context, args ...Val) Val {
+		return Number(1)
+	}))
+	var m map[string]int
+	if err := ktx.FromVal(ctx, ob, &m); !errors.As(err, &ce) {
+		t.Errorf("expected a ConversionError for the keys, got %v", err)
+	}
+	var i interface{}
+	var te TypeError
+	if err := ktx.FromVal(ctx, ob, &i); !errors.As(err, &te) {
+		t.Errorf("expected a TypeError for the keys, got %v", err)
+	}
+
+	// The __keys meta-method panics
+	ob.Set(String("__keys"), NewNativeFunc(ktx, "__keys", func(ctx context.Context, args ...Val) Val {
+		panic(String("no keys"))
+	}))
+	if err := ktx.FromVal(ctx, ob, &m); err == nil || !strings.Contains(err.Error(), "no keys") {
+		t.Errorf("expected the panic of __keys, got %v", err)
+	}
+}
+
+func TestNewGoFunc(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+
+	rep := ktx.NewGoFunc("strings.Repeat", strings.Repeat, "s", "count")
+	if v := rep.Call(ctx, nil, String("ab"), Number(2)); v != String("abab") {
+		t.Errorf("expected `abab`, got %v", v)
+	}
+	err := callErr(ctx, rep, String("ab"), String("x"))
+	if err == nil || !strings.Contains(err.Error(), "for count") {
+		t.Errorf("expected an error naming the count parameter, got %v", err)
+	}
+
+	sum := ktx.NewGoFunc("sum", func(ctx context.Context, base int, vals ...int) (int, error) {
+		if ctx == nil {
+			return 0, errors.New("no context")
+		}
+		for _, v := range vals {
+			base += v
+		}
+		if base < 0 {
+			return 0, errors.New("negative")
+		}
+		return base, nil
+	})
+	if v := sum.Call(ctx, nil, Number(1), Number(2), Number(3)); v != Number(6) {
+		t.Errorf("expected 6, got %v", v)
+	}
+	if err := callErr(ctx, sum, Number(-1)); err == nil || err.Error() != "negative" {
+		t.Errorf("expected error `negative`, got %v", err)
+	}
+	err = callErr(ctx, sum, Number(1), String("a"))
+	if err == nil || !strings.Contains(err.Error(), "for argument 2") {
+		t.Errorf("expected an error naming the second argument, got %v", err)
+	}
+
+	divmod := ktx.NewGoFunc("divmod", func(a, b int) (int, int) {
+		return a / b, a % b
+	})
+	vals := Unpack(divmod.Call(ctx, nil, Number(7), Number(2)), 2)
+	if vals[0] != Number(3) || vals[1] != Number(1) {
+		t.Errorf("expected 3 and 1, got %v", vals)
+	}
+}
+
+func TestFromValFunc(t *testing.T) {
+	ctx := context.Background()
+	ktx := NewKtx(nil, nil)
+	fn := NewNativeFunc(ktx, "concat", func(ctx context.Context, args ...Val) Val {
+		if len(args) == 0 {
+			panic("no args")
+		}
+		return String(args[0].String(ctx) + args[1].String(ctx))
+	})
+	var concat func(string, int) (string, error)
+	if err := ktx.FromVal(ctx, fn, &concat); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := concat("a", 1); err != nil || s != "a1" {
+		t.Errorf("expected `a1`, got `%s` (%v)", s, err)
+	}
+	var fail func() error
+	if err := ktx.FromVal(ctx, fn, &fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := fail(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// Call fn and return the error it panics with, if any.
+func callErr(ctx context.Context, fn Func, args ...Val) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			if e, ok := p.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", p)
+			}
+		}
+	}()
+	fn.Call(ctx, nil, args...)
+	return nil
+}
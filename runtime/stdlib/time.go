@@ -36,7 +36,14 @@ func (t *TimeMod) SetKtx(c *runtime.Kontext) {
 
 func (t *TimeMod) time_Sleep(ctx context.Context, args ...runtime.Val) runtime.Val {
 	runtime.ExpectAtLeastNArgs(1, args)
-	time.Sleep(time.Duration(args[0].Int(ctx)) * time.Millisecond)
+	// Stop sleeping if the context is done
+	tmr := time.NewTimer(time.Duration(args[0].Int(ctx)) * time.Millisecond)
+	defer tmr.Stop()
+	select {
+	case <-tmr.C:
+	case <-ctx.Done():
+		panic(ctx.Err())
+	}
 	return runtime.Nil
 }
 
@@ -52,6 +52,20 @@ func TestTimeSleep(t *testing.T) {
 	}
 }
 
+func TestTimeSleepCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ktx := runtime.NewKtx(nil, nil)
+	tm := new(TimeMod)
+	tm.SetKtx(ktx)
+	cancel()
+	defer func() {
+		if e := recover(); e != context.Canceled {
+			t.Errorf("expected panic with %v, got %v", context.Canceled, e)
+		}
+	}()
+	tm.time_Sleep(ctx, runtime.Number(60000))
+}
+
 func TestTimeNow(t *testing.T) {
 	ctx := context.Background()
 	ktx := runtime.NewKtx(nil, nil)
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+)
+
+// Values holds the multiple values returned by a function, i.e. by the
+// `return a, b` statement. Native functions can return multiple values by
+// returning NewValues(a, b).
+//
+// Values is never seen as such by agora code: the VM unpacks it into as many
+// values as expected by the caller, and uses the first value otherwise. Go
+// callers of Func.Call can use Unpack to get the individual values. Conversion
+// methods apply to the first value.
+type Values struct {
+	vals []Val
+}
+
+// NewValues returns a Val holding the provided values. If a single value is
+// provided, it is returned as-is, and if no value is provided, Nil is returned.
+func NewValues(vals ...Val) Val {
+	switch len(vals) {
+	case 0:
+		return Nil
+	case 1:
+		return vals[0]
+	}
+	return &Values{vals}
+}
+
+// Unpack returns exactly n values from v. If v holds multiple values, the
+// first n values are returned, padded with Nil if there are not enough values.
+// Otherwise, v is the first value, followed by n-1 Nil values.
+func Unpack(v Val, n int) []Val {
+	var src []Val
+	if vs, ok := v.(*Values); ok {
+		src = vs.vals
+	} else {
+		src = []Val{v}
+	}
+	res := make([]Val, n)
+	for i := range res {
+		if i < len(src) {
+			res[i] = src[i]
+		} else {
+			res[i] = Nil
+		}
+	}
+	return res
+}
+
+// Len returns the number of values.
+func (vs *Values) Len() int {
+	return len(vs.vals)
+}
+
+// Get returns the value at index i, or Nil if there is no such value.
+func (vs *Values) Get(i int) Val {
+	if i < 0 || i >= len(vs.vals) {
+		return Nil
+	}
+	return vs.vals[i]
+}
+
+// Dump pretty-prints the values for debugging purpose.
+func (vs *Values) Dump() string {
+	buf := bytes.NewBuffer(nil)
+	for i, v := range vs.vals {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(dumpVal(v))
+	}
+	return "(" + buf.String() + ") (Values)"
+}
+
+// Int returns the integer value of the first value.
+func (vs *Values) Int(ctx context.Context) int64 {
+	return vs.Get(0).Int(ctx)
+}
+
+// Float returns the float value of the first value.
+func (vs *Values) Float(ctx context.Context) float64 {
+	return vs.Get(0).Float(ctx)
+}
+
+// String returns the string value of the first value.
+func (vs *Values) String(ctx context.Context) string {
+	return vs.Get(0).String(ctx)
+}
+
+// Bool returns the boolean value of the first value.
+func (vs *Values) Bool(ctx context.Context) bool {
+	return vs.Get(0).Bool(ctx)
+}
+
+// Native returns a slice of the Go native values of all values.
+func (vs *Values) Native(ctx context.Context) interface{} {
+	res := make([]interface{}, len(vs.vals))
+	for i, v := range vs.vals {
+		res[i] = v.Native(ctx)
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewValues(t *testing.T) {
+	if v := NewValues(); v != Nil {
+		t.Errorf("no value: expected Nil, got %v", v)
+	}
+	if v := NewValues(Number(1)); v != Number(1) {
+		t.Errorf("single value: expected 1, got %v", v)
+	}
+	v := NewValues(Number(1), String("a"))
+	vs, ok := v.(*Values)
+	if !ok {
+		t.Fatalf("multiple values: expected *Values, got %T", v)
+	}
+	if vs.Len() != 2 {
+		t.Errorf("expected 2 values, got %d", vs.Len())
+	}
+	ctx := context.Background()
+	if s := vs.String(ctx); s != "1" {
+		t.Errorf("expected string of first value '1', got '%s'", s)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	cases := []struct {
+		v   Val
+		n   int
+		exp []Val
+	}{
+		0: {Number(1), 0, []Val{}},
+		1: {Number(1), 1, []Val{Number(1)}},
+		2: {Number(1), 3, []Val{Number(1), Nil, Nil}},
+		3: {NewValues(Number(1), Bool(true)), 1, []Val{Number(1)}},
+		4: {NewValues(Number(1), Bool(true)), 2, []Val{Number(1), Bool(true)}},
+		5: {NewValues(Number(1), Bool(true)), 3, []Val{Number(1), Bool(true), Nil}},
+	}
+	for i, c := range cases {
+		got := Unpack(c.v, c.n)
+		if len(got) != len(c.exp) {
+			t.Errorf("[%d] - expected %d values, got %d", i, len(c.exp), len(got))
+			continue
+		}
+		for j := range got {
+			if got[j] != c.exp[j] {
+				t.Errorf("[%d] - expected value %d to be %v, got %v", i, j, c.exp[j], got[j])
+			}
+		}
+	}
+}